@@ -3,20 +3,18 @@ package main
 import (
 	"bytes"
 	"context"
-	"crypto/sha256"
-	"errors"
 	"fmt"
 	"html/template"
-	"io"
 	"log/slog"
-	"net/http"
 	"os"
 	"os/signal"
-	"strconv"
 	"strings"
 	"syscall"
-	"time"
 
+	"http-from-tcp/internal/compression"
+	"http-from-tcp/internal/fileserver"
+	"http-from-tcp/internal/negotiation"
+	"http-from-tcp/internal/proxy"
 	"http-from-tcp/internal/request"
 	"http-from-tcp/internal/response"
 	"http-from-tcp/internal/server"
@@ -50,7 +48,13 @@ func main() {
 }
 
 func run() error {
-	server, err := server.Serve(port, handler)
+	httpbin, err := proxy.New([]string{"https://httpbin.org"}, proxy.Options{StripPrefix: "/httpbin"})
+	if err != nil {
+		return fmt.Errorf("error creating the httpbin proxy: %w", err)
+	}
+	defer httpbin.Close()
+
+	server, err := server.Serve(port, compression.Middleware(server.DecodeBody(newHandler(httpbin), 0)))
 	if err != nil {
 		return fmt.Errorf("error starting the server: %w", err)
 	}
@@ -71,18 +75,31 @@ func run() error {
 	return nil
 }
 
-func handler(w *response.Writer, req *request.Request) {
-	switch {
-	case strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin/"):
-		proxyHandler(w, req, "https://httpbin.org")
-	case req.RequestLine.RequestTarget == "/video":
-		videoHandler(w)
-	default:
-		serverHandler(w, req.RequestLine.RequestTarget)
+func newHandler(httpbin *proxy.ReverseProxy) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		switch {
+		case strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin/"):
+			httpbin.Serve(w, req)
+		case req.RequestLine.RequestTarget == "/video":
+			videoHandler(w, req)
+		default:
+			serverHandler(w, req)
+		}
 	}
 }
 
-func serverHandler(w *response.Writer, path string) {
+func serverHandler(w *response.Writer, req *request.Request) {
+	offers := negotiation.Offers{
+		ContentTypes: []string{"text/html", "text/plain"},
+		Languages:    nil,
+		Charsets:     nil,
+	}
+
+	result, ok := negotiation.Negotiate(w, req, offers)
+	if !ok {
+		return
+	}
+
 	var (
 		statusCode    response.StatusCode
 		status        string
@@ -90,7 +107,7 @@ func serverHandler(w *response.Writer, path string) {
 		htmlParagraph string
 	)
 
-	switch path {
+	switch req.RequestLine.RequestTarget {
 	case "/yourproblem":
 		statusCode = response.StatusCodeBadRequest
 		status = "Bad Request"
@@ -116,8 +133,6 @@ func serverHandler(w *response.Writer, path string) {
 
 	buf := new(bytes.Buffer)
 
-	tmpl := template.Must(template.New("response").Parse(htmlTemplate))
-
 	data := struct {
 		Title     string
 		Header    string
@@ -128,192 +143,41 @@ func serverHandler(w *response.Writer, path string) {
 		Paragraph: htmlParagraph,
 	}
 
-	if err := tmpl.Execute(buf, data); err != nil {
-		slog.Error("error executing the HTML template", "error", err.Error())
-
-		return
-	}
-
-	headers := response.GetDefaultHeaders(buf.Len())
-	headers.Edit("Content-Type", "text/html")
-
-	if err := w.WriteHeaders(headers); err != nil {
-		slog.Error("error writing the headers", "error", err.Error())
-
-		return
-	}
-
-	_, err := w.WriteBody(buf.Bytes())
-	if err != nil {
-		slog.Error("error writing the response body", "error", err.Error())
-
-		return
-	}
-}
-
-func proxyHandler(w *response.Writer, req *request.Request, baseURL string) {
-	ctx, cancel := context.WithTimeout(
-		context.Background(),
-		time.Duration(60*time.Second),
-	)
-	defer cancel()
-
-	proxyReq, err := http.NewRequestWithContext(
-		ctx,
-		http.MethodGet,
-		baseURL+"/"+strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin/"),
-		nil,
-	)
-	if err != nil {
-		slog.Error("error creating the proxy request", "error", err.Error())
-
-		return
-	}
-
-	client := http.Client{}
-
-	resp, err := client.Do(proxyReq)
-	if err != nil {
-		slog.Error("error getting the response from the server", "error", err.Error())
-
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		slog.Error(
-			"unexpected status code returned from the server",
-			"code",
-			strconv.Itoa(resp.StatusCode),
-			"status",
-			resp.Status,
-		)
+	if result.ContentType == "text/plain" {
+		fmt.Fprintf(buf, "%s\n\n%s\n", data.Header, data.Paragraph)
+	} else {
+		tmpl := template.Must(template.New("response").Parse(htmlTemplate))
 
-		return
-	}
-
-	if err := w.WriteStatusLine(response.StatusCodeOK); err != nil {
-		slog.Error("error writing the status line", "error", err.Error())
-
-		return
-	}
-
-	headers := response.GetDefaultHeaders(0)
-	headers.Delete(response.HeaderContentLength)
-	headers.Delete(response.HeaderConnection)
-	headers.Add(response.HeaderTransferEncoding, "chunked")
-	headers.Add(response.HeaderTrailer, "X-Content-SHA256")
-	headers.Add(response.HeaderTrailer, "X-Content-Length")
-
-	if err := w.WriteHeaders(headers); err != nil {
-		slog.Error("error writing the headers", "error", err.Error())
-
-		return
-	}
-
-	fullResp := make([]byte, 0)
-	buf := make([]byte, 1024, 1024)
-
-ResponseReadLoop:
-	for {
-		n, err := resp.Body.Read(buf)
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				break ResponseReadLoop
-			}
-
-			slog.Error(
-				"error reading the data from the response body",
-				"error",
-				err.Error(),
-			)
+		if err := tmpl.Execute(buf, data); err != nil {
+			slog.Error("error executing the HTML template", "error", err.Error())
 
 			return
 		}
-
-		slog.Info("A chunk of data was read from the response", "size", n)
-
-		chunkSize := strings.ToUpper(strconv.FormatInt(int64(n), 16))
-
-		_, err = w.WriteChunkedBody([]byte(chunkSize))
-		if err != nil {
-			slog.Error(
-				"error writing the chunk size",
-				"error",
-				err.Error(),
-			)
-		}
-
-		_, err = w.WriteChunkedBody(buf[:n])
-		if err != nil {
-			slog.Error(
-				"error writing a chunk of the body",
-				"error",
-				err.Error(),
-			)
-		}
-
-		fullResp = append(fullResp, buf[:n]...)
-	}
-
-	_, err = w.WriteChunkedBodyDone()
-	if err != nil {
-		slog.Error(
-			"error writing the end of the chunked body response body",
-			"error",
-			err.Error(),
-		)
-
-		return
 	}
 
-	contentSum := sha256.Sum256(fullResp)
-
-	headers.Add("X-Content-SHA256", fmt.Sprintf("%x", contentSum))
-	headers.Add("X-Content-Length", strconv.Itoa(len(fullResp)))
-
-	if err := w.WriteTrailers(headers); err != nil {
-		slog.Error(
-			"error writing the trailers",
-			"error",
-			err.Error(),
-		)
-
-		return
-	}
-}
+	headers := response.GetDefaultHeaders(buf.Len())
+	headers.Add(response.HeaderVary, offers.Vary())
 
-func videoHandler(w *response.Writer) {
-	data, err := os.ReadFile("assets/vim.mp4")
-	if err != nil {
-		slog.Error(
-			"error reading the video file",
-			"error",
-			err.Error(),
-		)
+	if err := response.SetContentType(headers, result.ContentType, "utf-8"); err != nil {
+		slog.Error("error setting the content type", "error", err.Error())
 
 		return
 	}
 
-	if err := w.WriteStatusLine(response.StatusCodeOK); err != nil {
-		slog.Error("error writing the status line", "error", err.Error())
-
-		return
-	}
-
-	headers := response.GetDefaultHeaders(len(data))
-	headers.Edit("Content-Type", "video/mp4")
-
 	if err := w.WriteHeaders(headers); err != nil {
 		slog.Error("error writing the headers", "error", err.Error())
 
 		return
 	}
 
-	_, err = w.WriteBody(data)
+	_, err := w.WriteBody(buf.Bytes())
 	if err != nil {
 		slog.Error("error writing the response body", "error", err.Error())
 
 		return
 	}
 }
+
+func videoHandler(w *response.Writer, req *request.Request) {
+	fileserver.ServeFile(w, req, os.DirFS("assets"), "vim.mp4")
+}
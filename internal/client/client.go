@@ -0,0 +1,215 @@
+// Package client implements an HTTP/1.1 client on top of the parsers of the server.
+// It writes the requests itself, reads the responses with their three framings
+// (Content-Length, chunked with trailers, and delimited by closing the connection) and
+// keeps the connections open between the requests.
+//
+// The Client also implements http.RoundTripper so that it can replace the transport
+// of net/http (e.g. in the proxy package).
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"http-from-tcp/internal/response"
+)
+
+const (
+	// defaultDialTimeout is the time to wait for a new connection.
+	defaultDialTimeout = 30 * time.Second
+
+	// defaultIdleTimeout is the time that an idle connection is kept open.
+	defaultIdleTimeout = 90 * time.Second
+
+	// defaultMaxIdleConnsPerHost is the number of idle connections kept per host.
+	defaultMaxIdleConnsPerHost = 2
+
+	// readerSize and writerSize are the sizes of the buffers of a connection.
+	readerSize = 4096
+	writerSize = 4096
+)
+
+var (
+	// ErrResponseHeaderTimeout is returned when the headers of the response do not
+	// arrive within Options.ResponseHeaderTimeout.
+	ErrResponseHeaderTimeout = errors.New("timeout awaiting the response headers")
+
+	// errConnReused is returned when a connection from the pool was closed by the
+	// server before it received the request. The request can be sent again on a new
+	// connection.
+	errConnReused = errors.New("the idle connection was closed by the server")
+)
+
+// Options are the options for the client.
+type Options struct {
+	// DialTimeout is the maximum time to wait for a new connection, including the
+	// TLS handshake. It is 30 seconds if it is zero.
+	DialTimeout time.Duration
+
+	// ResponseHeaderTimeout is the maximum time to wait for the headers of the
+	// response once the request has been written. There is no limit if it is zero.
+	ResponseHeaderTimeout time.Duration
+
+	// IdleTimeout is the time that an idle connection is kept open for the next
+	// request. It is 90 seconds if it is zero.
+	IdleTimeout time.Duration
+
+	// MaxIdleConnsPerHost is the number of idle connections kept open per host. It
+	// is 2 if it is zero and the connections are never reused if it is negative.
+	MaxIdleConnsPerHost int
+
+	// TLSConfig is the configuration of the https connections. The default
+	// configuration is used if it is nil.
+	TLSConfig *tls.Config
+
+	// DialContext opens the TCP connections. A net.Dialer with DialTimeout is used
+	// if it is nil.
+	DialContext func(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// Client sends HTTP/1.1 requests. It is safe for concurrent use and it should be
+// reused so that the connections are kept open between the requests.
+type Client struct {
+	options Options
+	pool    *pool
+}
+
+// New returns a Client with the options.
+func New(options Options) *Client {
+	if options.DialTimeout == 0 {
+		options.DialTimeout = defaultDialTimeout
+	}
+
+	if options.IdleTimeout == 0 {
+		options.IdleTimeout = defaultIdleTimeout
+	}
+
+	if options.MaxIdleConnsPerHost == 0 {
+		options.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+
+	if options.DialContext == nil {
+		options.DialContext = (&net.Dialer{Timeout: options.DialTimeout}).DialContext
+	}
+
+	return &Client{
+		options: options,
+		pool:    newPool(options.MaxIdleConnsPerHost, options.IdleTimeout),
+	}
+}
+
+// CloseIdleConnections closes the connections that are not used by a request.
+func (c *Client) CloseIdleConnections() {
+	c.pool.closeIdle()
+}
+
+// Do sends the request and returns the response once its headers have been read.
+// The body of the response must be closed; the connection is reused once the body
+// has been read to the end. The context applies to the whole exchange, including
+// the reading of the body.
+//
+// A request that is idempotent is sent again on a new connection if the server
+// closed the idle connection that it was sent on.
+func (c *Client) Do(ctx context.Context, req *Request) (*Response, error) {
+	key, err := connKey(req.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		conn, err := c.getConn(ctx, key, req.URL)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := conn.roundTrip(ctx, req, c.options.ResponseHeaderTimeout)
+		if err == nil {
+			return resp, nil
+		}
+
+		conn.close()
+
+		if !errors.Is(err, errConnReused) || !req.idempotent() {
+			return nil, err
+		}
+	}
+}
+
+// getConn returns an idle connection to the host or opens a new one.
+func (c *Client) getConn(ctx context.Context, key string, target *url.URL) (*conn, error) {
+	if pc := c.pool.get(key); pc != nil {
+		return pc, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.options.DialTimeout)
+	defer cancel()
+
+	address := key[len(target.Scheme)+len("://"):]
+
+	netConn, err := c.options.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to %s: %w", address, err)
+	}
+
+	if target.Scheme == "https" {
+		config := &tls.Config{}
+		if c.options.TLSConfig != nil {
+			config = c.options.TLSConfig.Clone()
+		}
+
+		if config.ServerName == "" {
+			config.ServerName = target.Hostname()
+		}
+
+		// Only HTTP/1.1 is spoken on the connection.
+		config.NextProtos = []string{"http/1.1"}
+
+		tlsConn := tls.Client(netConn, config)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			_ = netConn.Close()
+
+			return nil, fmt.Errorf("error performing the TLS handshake with %s: %w", address, err)
+		}
+
+		netConn = tlsConn
+	}
+
+	return &conn{
+		netConn: netConn,
+		reader:  response.NewReader(bufio.NewReaderSize(netConn, readerSize)),
+		writer:  bufio.NewWriterSize(netConn, writerSize),
+		key:     key,
+		pool:    c.pool,
+	}, nil
+}
+
+// connKey returns the key of the connections that can send a request to the URL.
+// It is the scheme followed by the host and the port.
+func connKey(target *url.URL) (string, error) {
+	if target == nil || target.Host == "" {
+		return "", errors.New("the URL of the request must be absolute")
+	}
+
+	port := target.Port()
+
+	switch target.Scheme {
+	case "http":
+		if port == "" {
+			port = "80"
+		}
+	case "https":
+		if port == "" {
+			port = "443"
+		}
+	default:
+		return "", fmt.Errorf("unsupported scheme %q", target.Scheme)
+	}
+
+	return target.Scheme + "://" + net.JoinHostPort(target.Hostname(), port), nil
+}
@@ -0,0 +1,613 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"http-from-tcp/internal/headers"
+	"http-from-tcp/internal/request"
+	"http-from-tcp/internal/response"
+	"http-from-tcp/internal/server"
+)
+
+// startRawServer starts a TCP server that reads the requests on each connection and
+// writes the raw response returned by respond. The connection is closed after the
+// response if respond returns true. It returns the base URL of the server and the
+// number of connections that it has accepted.
+func startRawServer(t *testing.T, respond func(req *request.Request) (string, bool)) (string, *atomic.Int32) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	accepted := &atomic.Int32{}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			accepted.Add(1)
+
+			go func() {
+				defer conn.Close()
+
+				reader := request.NewReader(conn)
+				defer reader.Release()
+
+				for {
+					req, err := reader.ReadRequest()
+					if err != nil {
+						return
+					}
+
+					raw, closeConn := respond(req)
+					if _, err := conn.Write([]byte(raw)); err != nil || closeConn {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	return "http://" + listener.Addr().String(), accepted
+}
+
+// respondWith returns a respond function for startRawServer that always writes the
+// same response.
+func respondWith(raw string, closeConn bool) func(req *request.Request) (string, bool) {
+	return func(req *request.Request) (string, bool) {
+		return raw, closeConn
+	}
+}
+
+// do sends a request and reads the whole body of the response.
+func do(t *testing.T, c *Client, method, target string, body []byte) (*Response, string) {
+	t.Helper()
+
+	req, err := NewRequest(method, target, body)
+	require.NoError(t, err)
+
+	resp, err := c.Do(context.Background(), req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return resp, string(data)
+}
+
+func TestWriteRequest(t *testing.T) {
+	write := func(req *Request) (string, error) {
+		var buf bytes.Buffer
+
+		err := req.write(bufio.NewWriter(&buf))
+
+		return buf.String(), err
+	}
+
+	// Test: The headers are sorted and the framing headers are set by the client
+	req, err := NewRequest("POST", "http://example.com:8080/path?q=1", []byte("hello"))
+	require.NoError(t, err)
+
+	req.Headers["X-B"] = "2"
+	req.Headers["X-A"] = "1"
+	req.Headers["Content-Length"] = "100"
+	req.Headers["Connection"] = "keep-alive"
+
+	raw, err := write(req)
+	require.NoError(t, err)
+	assert.Equal(t, "POST /path?q=1 HTTP/1.1\r\nHost: example.com:8080\r\nX-A: 1\r\nX-B: 2\r\n"+
+		"Content-Length: 5\r\n\r\nhello", raw)
+
+	// Test: The Host header replaces the host of the URL
+	req, err = NewRequest("GET", "http://127.0.0.1/", nil)
+	require.NoError(t, err)
+
+	req.Headers["host"] = "example.com"
+	req.Close = true
+
+	raw, err = write(req)
+	require.NoError(t, err)
+	assert.Equal(t, "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n", raw)
+
+	// Test: An empty body is sent with a Content-Length for the methods that expect one
+	req, err = NewRequest("PUT", "http://example.com/", nil)
+	require.NoError(t, err)
+
+	raw, err = write(req)
+	require.NoError(t, err)
+	assert.Equal(t, "PUT / HTTP/1.1\r\nHost: example.com\r\nContent-Length: 0\r\n\r\n", raw)
+
+	// Test: The body is chunked if the request has trailers
+	req, err = NewRequest("POST", "http://example.com/upload", []byte("data"))
+	require.NoError(t, err)
+
+	req.Trailers["X-Checksum"] = "abc"
+
+	raw, err = write(req)
+	require.NoError(t, err)
+	assert.Equal(t, "POST /upload HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n"+
+		"Trailer: X-Checksum\r\n\r\n4\r\ndata\r\n0\r\nX-Checksum: abc\r\n\r\n", raw)
+
+	// Test: The request written by the client is parsed by the server
+	parsed, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(parsed.Body))
+	assert.Equal(t, "abc", parsed.Trailers.Get("x-checksum"))
+
+	// Test: Nothing is written if a field would inject a new line
+	req, err = NewRequest("GET", "http://example.com/", nil)
+	require.NoError(t, err)
+
+	req.Headers["X-Injected"] = "a\r\nEvil: 1"
+
+	raw, err = write(req)
+	require.Error(t, err)
+	assert.Empty(t, raw)
+
+	req.Headers = headers.NewHeaders()
+	req.Trailers["X-Injected"] = "a\nb"
+
+	_, err = write(req)
+	require.Error(t, err)
+
+	// Test: Invalid methods
+	req, err = NewRequest("get", "http://example.com/", nil)
+	require.NoError(t, err)
+
+	_, err = write(req)
+	require.Error(t, err)
+
+	// Test: Invalid URLs
+	_, err = NewRequest("GET", "/relative", nil)
+	require.Error(t, err)
+
+	_, err = NewRequest("GET", "ftp://example.com/", nil)
+	require.Error(t, err)
+}
+
+func TestFraming(t *testing.T) {
+	c := New(Options{})
+	t.Cleanup(c.CloseIdleConnections)
+
+	tests := []struct {
+		name          string
+		raw           string
+		closeConn     bool
+		statusCode    response.StatusCode
+		reason        string
+		body          string
+		contentLength int64
+		trailers      headers.Headers
+		cookies       []string
+		close         bool
+	}{
+		{
+			name:          "Content-Length",
+			raw:           "HTTP/1.1 200 OK\r\nContent-Length: 5\r\nContent-Type: text/plain\r\n\r\nhello",
+			statusCode:    response.StatusCodeOK,
+			reason:        "OK",
+			body:          "hello",
+			contentLength: 5,
+		},
+		{
+			name: "chunked with trailers",
+			raw: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Checksum\r\n\r\n" +
+				"5\r\nhello\r\n6;ext=1\r\n world\r\n0\r\nX-Checksum: abc\r\n\r\n",
+			statusCode:    response.StatusCodeOK,
+			reason:        "OK",
+			body:          "hello world",
+			contentLength: -1,
+			trailers:      headers.Headers{"x-checksum": "abc"},
+		},
+		{
+			name:          "delimited by the end of the connection",
+			raw:           "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\n\r\nuntil the end",
+			closeConn:     true,
+			statusCode:    response.StatusCodeOK,
+			reason:        "OK",
+			body:          "until the end",
+			contentLength: -1,
+			close:         true,
+		},
+		{
+			name:          "HTTP/1.0",
+			raw:           "HTTP/1.0 200 OK\r\nContent-Length: 2\r\n\r\nok",
+			closeConn:     true,
+			statusCode:    response.StatusCodeOK,
+			reason:        "OK",
+			body:          "ok",
+			contentLength: 2,
+			close:         true,
+		},
+		{
+			name:          "No Content",
+			raw:           "HTTP/1.1 204 No Content\r\n\r\n",
+			statusCode:    204,
+			reason:        "No Content",
+			contentLength: -1,
+		},
+		{
+			name: "interim responses",
+			raw: "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 103 Early Hints\r\nLink: </style.css>\r\n\r\n" +
+				"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok",
+			statusCode:    response.StatusCodeOK,
+			reason:        "OK",
+			body:          "ok",
+			contentLength: 2,
+		},
+		{
+			name: "cookies",
+			raw: "HTTP/1.1 200 OK\r\nSet-Cookie: a=1; Path=/\r\nSet-Cookie: b=2; Expires=Wed, 21 Oct 2015 07:28:00 GMT\r\n" +
+				"Content-Length: 0\r\n\r\n",
+			statusCode:    response.StatusCodeOK,
+			reason:        "OK",
+			contentLength: 0,
+			cookies:       []string{"a=1; Path=/", "b=2; Expires=Wed, 21 Oct 2015 07:28:00 GMT"},
+		},
+		{
+			name:          "empty reason",
+			raw:           "HTTP/1.1 299 \r\nContent-Length: 0\r\n\r\n",
+			statusCode:    299,
+			contentLength: 0,
+		},
+		{
+			name:          "Connection: close",
+			raw:           "HTTP/1.1 200 OK\r\nConnection: close\r\nContent-Length: 2\r\n\r\nok",
+			closeConn:     true,
+			statusCode:    response.StatusCodeOK,
+			reason:        "OK",
+			body:          "ok",
+			contentLength: 2,
+			close:         true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base, _ := startRawServer(t, respondWith(tt.raw, tt.closeConn))
+
+			resp, body := do(t, c, "GET", base+"/", nil)
+			assert.Equal(t, tt.statusCode, resp.StatusCode)
+			assert.Equal(t, tt.reason, resp.Reason)
+			assert.Equal(t, tt.body, body)
+			assert.Equal(t, tt.contentLength, resp.ContentLength)
+			assert.Equal(t, tt.cookies, resp.SetCookies)
+			assert.Equal(t, tt.close, resp.Close)
+
+			if tt.trailers == nil {
+				assert.Empty(t, resp.Trailers)
+			} else {
+				assert.Equal(t, tt.trailers, resp.Trailers)
+			}
+		})
+	}
+}
+
+func TestHead(t *testing.T) {
+	base, accepted := startRawServer(t, func(req *request.Request) (string, bool) {
+		if req.RequestLine.Method == "HEAD" {
+			return "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\n", false
+		}
+
+		return "HTTP/1.1 200 OK\r\nContent-Length: 4\r\n\r\nbody", false
+	})
+
+	c := New(Options{})
+	t.Cleanup(c.CloseIdleConnections)
+
+	// Test: The response to a HEAD request has no body but keeps its length
+	resp, body := do(t, c, "HEAD", base+"/", nil)
+	assert.Equal(t, int64(10), resp.ContentLength)
+	assert.Empty(t, body)
+
+	// Test: The next response is read from the start on the same connection
+	_, body = do(t, c, "GET", base+"/", nil)
+	assert.Equal(t, "body", body)
+	assert.Equal(t, int32(1), accepted.Load())
+}
+
+func TestMalformedResponses(t *testing.T) {
+	c := New(Options{})
+	t.Cleanup(c.CloseIdleConnections)
+
+	tests := []struct {
+		name string
+		raw  string
+	}{
+		{name: "invalid version", raw: "HTTP/2.0 200 OK\r\n\r\n"},
+		{name: "invalid status code", raw: "HTTP/1.1 20x OK\r\n\r\n"},
+		{name: "short status code", raw: "HTTP/1.1 20 OK\r\n\r\n"},
+		{name: "missing CR", raw: "HTTP/1.1 200 OK\nContent-Length: 0\n\n"},
+		{name: "invalid Content-Length", raw: "HTTP/1.1 200 OK\r\nContent-Length: -1\r\n\r\n"},
+		{name: "obsolete line folding", raw: "HTTP/1.1 200 OK\r\nX-A: 1\r\n 2\r\n\r\n"},
+		{name: "incomplete headers", raw: "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n"},
+		{name: "empty response", raw: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base, _ := startRawServer(t, respondWith(tt.raw, true))
+
+			req, err := NewRequest("GET", base+"/", nil)
+			require.NoError(t, err)
+
+			_, err = c.Do(context.Background(), req)
+			require.Error(t, err)
+		})
+	}
+
+	bodies := []struct {
+		name string
+		raw  string
+	}{
+		{name: "incomplete body", raw: "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nshort"},
+		{name: "incomplete chunk", raw: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\na\r\nshort"},
+		{name: "missing last chunk", raw: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n"},
+		{name: "invalid chunk size", raw: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\nhello\r\n0\r\n\r\n"},
+		{name: "chunk without CRLF", raw: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhelloXX0\r\n\r\n"},
+	}
+
+	for _, tt := range bodies {
+		t.Run(tt.name, func(t *testing.T) {
+			base, _ := startRawServer(t, respondWith(tt.raw, true))
+
+			req, err := NewRequest("GET", base+"/", nil)
+			require.NoError(t, err)
+
+			resp, err := c.Do(context.Background(), req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			_, err = io.ReadAll(resp.Body)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	base, accepted := startRawServer(t, func(req *request.Request) (string, bool) {
+		if req.Close {
+			return "HTTP/1.1 200 OK\r\nConnection: close\r\nContent-Length: 2\r\n\r\nok", true
+		}
+
+		return "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok", false
+	})
+
+	c := New(Options{})
+	t.Cleanup(c.CloseIdleConnections)
+
+	// Test: The connection is reused for the next requests
+	for range 3 {
+		_, body := do(t, c, "GET", base+"/", nil)
+		assert.Equal(t, "ok", body)
+	}
+
+	assert.Equal(t, int32(1), accepted.Load())
+
+	// Test: The connection is not reused after a response that closes it
+	req, err := NewRequest("GET", base+"/", nil)
+	require.NoError(t, err)
+
+	req.Close = true
+
+	resp, err := c.Do(context.Background(), req)
+	require.NoError(t, err)
+	assert.True(t, resp.Close)
+
+	_, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	_, body := do(t, c, "GET", base+"/", nil)
+	assert.Equal(t, "ok", body)
+	assert.Equal(t, int32(2), accepted.Load())
+
+	// Test: A body that is closed before its end closes the connection
+	req, err = NewRequest("GET", base+"/", nil)
+	require.NoError(t, err)
+
+	resp, err = c.Do(context.Background(), req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	_, err = resp.Body.Read(make([]byte, 1))
+	require.ErrorIs(t, err, errBodyClosed)
+
+	_, body = do(t, c, "GET", base+"/", nil)
+	assert.Equal(t, "ok", body)
+	assert.Equal(t, int32(3), accepted.Load())
+
+	// Test: The idle connections are closed after the idle timeout
+	c = New(Options{IdleTimeout: 10 * time.Millisecond})
+
+	do(t, c, "GET", base+"/", nil)
+	time.Sleep(50 * time.Millisecond)
+	do(t, c, "GET", base+"/", nil)
+	assert.Equal(t, int32(5), accepted.Load())
+
+	// Test: The connections are not kept if MaxIdleConnsPerHost is negative
+	c = New(Options{MaxIdleConnsPerHost: -1})
+
+	do(t, c, "GET", base+"/", nil)
+	do(t, c, "GET", base+"/", nil)
+	assert.Equal(t, int32(7), accepted.Load())
+}
+
+func TestRetry(t *testing.T) {
+	// The server closes each connection after a response without announcing it, like
+	// a server whose idle timeout is shorter than the one of the client.
+	base, accepted := startRawServer(t, respondWith("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok", true))
+
+	c := New(Options{})
+	t.Cleanup(c.CloseIdleConnections)
+
+	do(t, c, "GET", base+"/", nil)
+
+	// Test: An idempotent request is sent again on a new connection
+	_, body := do(t, c, "GET", base+"/", nil)
+	assert.Equal(t, "ok", body)
+	assert.Equal(t, int32(2), accepted.Load())
+
+	// Test: Other requests are not sent again
+	req, err := NewRequest("POST", base+"/", []byte("data"))
+	require.NoError(t, err)
+
+	_, err = c.Do(context.Background(), req)
+	require.ErrorIs(t, err, errConnReused)
+}
+
+func TestTimeouts(t *testing.T) {
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+
+	base, _ := startRawServer(t, func(req *request.Request) (string, bool) {
+		if req.RequestLine.RequestTarget == "/body" {
+			return "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nshort", false
+		}
+
+		<-release
+
+		return "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n", false
+	})
+
+	// Test: The response headers must arrive within the timeout
+	c := New(Options{ResponseHeaderTimeout: 50 * time.Millisecond})
+	t.Cleanup(c.CloseIdleConnections)
+
+	req, err := NewRequest("GET", base+"/slow", nil)
+	require.NoError(t, err)
+
+	_, err = c.Do(context.Background(), req)
+	require.ErrorIs(t, err, ErrResponseHeaderTimeout)
+
+	// Test: Cancelling the context stops the wait for the response
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = New(Options{}).Do(ctx, req)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Test: Cancelling the context stops the read of the body
+	ctx, cancel = context.WithCancel(context.Background())
+
+	req, err = NewRequest("GET", base+"/body", nil)
+	require.NoError(t, err)
+
+	resp, err := c.Do(ctx, req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	_, err = io.ReadAll(resp.Body)
+	require.Error(t, err)
+
+	// Test: The dial fails if the server cannot be reached
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, listener.Close())
+
+	req, err = NewRequest("GET", "http://"+listener.Addr().String()+"/", nil)
+	require.NoError(t, err)
+
+	_, err = c.Do(context.Background(), req)
+	require.Error(t, err)
+}
+
+func TestRoundTrip(t *testing.T) {
+	srv, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		assert.NoError(t, w.AddSetCookie("a=1"))
+		assert.NoError(t, w.AddSetCookie("b=2"))
+
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+
+		h := response.GetDefaultHeaders(0)
+		delete(h, "Content-Length")
+		h[response.HeaderTransferEncoding] = "chunked"
+		h[response.HeaderTrailer] = "X-Checksum"
+		h["X-Method"] = req.RequestLine.Method
+		_ = w.WriteHeaders(h)
+
+		_, _ = w.WriteChunkedBody(req.Body)
+		_, _ = w.WriteChunkedBody([]byte(" " + req.Trailers.Get("x-request")))
+		_, _ = w.WriteChunkedBodyDone()
+
+		h["X-Checksum"] = "abc"
+		_ = w.WriteTrailers(h)
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = srv.Close() })
+
+	c := New(Options{})
+	t.Cleanup(c.CloseIdleConnections)
+
+	httpClient := &http.Client{Transport: c, Timeout: 5 * time.Second}
+
+	httpReq, err := http.NewRequest("POST", "http://"+srv.Addr().String()+"/", strings.NewReader("body"))
+	require.NoError(t, err)
+
+	httpReq.Trailer = http.Header{"X-Request": []string{"trailer"}}
+
+	resp, err := httpClient.Do(httpReq)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "200 OK", resp.Status)
+	assert.Equal(t, "POST", resp.Header.Get("X-Method"))
+	assert.Equal(t, []string{"a=1", "b=2"}, resp.Header.Values("Set-Cookie"))
+	assert.Len(t, resp.Cookies(), 2)
+
+	// Test: The trailers are declared before the body and set after it
+	assert.Contains(t, resp.Trailer, "X-Checksum")
+	assert.Empty(t, resp.Trailer.Get("X-Checksum"))
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "body trailer", string(body))
+	assert.Equal(t, "abc", resp.Trailer.Get("X-Checksum"))
+}
+
+func TestTLS(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Proto+" "+r.Host)
+	}))
+	t.Cleanup(upstream.Close)
+
+	transport, ok := upstream.Client().Transport.(*http.Transport)
+	require.True(t, ok)
+
+	c := New(Options{TLSConfig: transport.TLSClientConfig})
+	t.Cleanup(c.CloseIdleConnections)
+
+	// Test: The requests are sent over TLS with HTTP/1.1
+	_, body := do(t, c, "GET", upstream.URL+"/", nil)
+	assert.Equal(t, "HTTP/1.1 "+strings.TrimPrefix(upstream.URL, "https://"), body)
+
+	// Test: The handshake fails if the certificate is not trusted
+	req, err := NewRequest("GET", upstream.URL+"/", nil)
+	require.NoError(t, err)
+
+	_, err = New(Options{}).Do(context.Background(), req)
+	require.Error(t, err)
+	assert.False(t, errors.Is(err, errConnReused))
+}
@@ -0,0 +1,129 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"http-from-tcp/internal/response"
+)
+
+// conn is a connection to a server. It is used by one request at a time.
+type conn struct {
+	netConn net.Conn
+	reader  *response.Reader
+	writer  *bufio.Writer
+	key     string
+	pool    *pool
+
+	// reused is true if the connection was taken from the pool.
+	reused bool
+
+	// stop stops the watch of the context of the current request. It returns false
+	// if the context was cancelled, in which case the connection is broken.
+	stop func() bool
+
+	// idleTimer closes the connection once it has been idle for too long.
+	idleTimer *time.Timer
+}
+
+// roundTrip writes the request and reads the headers of the response. The context is
+// watched until the body has been read: cancelling it makes the pending read or write
+// fail.
+func (c *conn) roundTrip(ctx context.Context, req *Request, headerTimeout time.Duration) (*Response, error) {
+	c.stop = context.AfterFunc(ctx, func() {
+		_ = c.netConn.SetDeadline(time.Unix(1, 0))
+	})
+
+	resp, framing, err := c.exchange(req, headerTimeout)
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+
+	if err != nil {
+		c.stop()
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		return nil, err
+	}
+
+	b := &body{conn: c, resp: resp, framing: framing}
+	resp.Body = b
+
+	switch framing {
+	case response.FramingNone:
+		b.finish(true)
+	case response.FramingLength:
+		b.remaining = resp.ContentLength
+	case response.FramingChunked:
+		b.chunked = response.NewChunkedReader(c.reader)
+	}
+
+	return resp, nil
+}
+
+// exchange writes the request and reads the headers of the response.
+func (c *conn) exchange(req *Request, headerTimeout time.Duration) (*Response, response.Framing, error) {
+	if err := req.write(c.writer); err != nil {
+		if c.reused && isConnReset(err) {
+			return nil, 0, errConnReused
+		}
+
+		return nil, 0, fmt.Errorf("error writing the request: %w", err)
+	}
+
+	if headerTimeout > 0 {
+		if err := c.netConn.SetReadDeadline(time.Now().Add(headerTimeout)); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	resp, framing, err := c.readResponse(req)
+
+	var netErr net.Error
+	if headerTimeout > 0 && errors.As(err, &netErr) && netErr.Timeout() {
+		return nil, 0, ErrResponseHeaderTimeout
+	}
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if headerTimeout > 0 {
+		if err := c.netConn.SetReadDeadline(time.Time{}); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return resp, framing, nil
+}
+
+// release hands the connection back to the pool once a response has been read, or
+// closes it if it cannot be reused. A connection that has unexpected data after the
+// response cannot be reused either.
+func (c *conn) release(reusable bool) {
+	// The connection is broken if the context was cancelled.
+	if !c.stop() || !reusable || c.reader.Buffered() > 0 {
+		c.close()
+
+		return
+	}
+
+	c.pool.put(c)
+}
+
+func (c *conn) close() {
+	_ = c.netConn.Close()
+}
+
+// isConnReset returns true if the error shows that the server closed the connection.
+func isConnReset(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
@@ -0,0 +1,107 @@
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+// pool keeps the idle connections for the next requests to the same host. The most
+// recently used connection is reused first as it is the least likely to have been
+// closed by the server.
+type pool struct {
+	mu          sync.Mutex
+	idle        map[string][]*conn
+	maxIdle     int
+	idleTimeout time.Duration
+}
+
+func newPool(maxIdle int, idleTimeout time.Duration) *pool {
+	return &pool{
+		idle:        make(map[string][]*conn),
+		maxIdle:     maxIdle,
+		idleTimeout: idleTimeout,
+	}
+}
+
+// get returns an idle connection for the key, or nil if there is none.
+func (p *pool) get(key string) *conn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	conns := p.idle[key]
+	if len(conns) == 0 {
+		return nil
+	}
+
+	c := conns[len(conns)-1]
+	conns[len(conns)-1] = nil
+	p.idle[key] = conns[:len(conns)-1]
+
+	if len(p.idle[key]) == 0 {
+		delete(p.idle, key)
+	}
+
+	// The timer cannot close the connection once it has been removed from the pool.
+	c.idleTimer.Stop()
+	c.reused = true
+
+	return c
+}
+
+// put adds the connection to the idle connections. It is closed if there are already
+// enough idle connections to its host.
+func (p *pool) put(c *conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.idle[c.key]) >= p.maxIdle {
+		c.close()
+
+		return
+	}
+
+	p.idle[c.key] = append(p.idle[c.key], c)
+
+	c.idleTimer = time.AfterFunc(p.idleTimeout, func() {
+		if p.remove(c) {
+			c.close()
+		}
+	})
+}
+
+// remove removes the connection from the idle connections. It returns false if the
+// connection is not idle.
+func (p *pool) remove(c *conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	conns := p.idle[c.key]
+
+	for idx := range conns {
+		if conns[idx] == c {
+			p.idle[c.key] = append(conns[:idx], conns[idx+1:]...)
+			if len(p.idle[c.key]) == 0 {
+				delete(p.idle, c.key)
+			}
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// closeIdle closes all of the idle connections.
+func (p *pool) closeIdle() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = make(map[string][]*conn)
+	p.mu.Unlock()
+
+	for _, conns := range idle {
+		for _, c := range conns {
+			c.idleTimer.Stop()
+			c.close()
+		}
+	}
+}
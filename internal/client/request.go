@@ -0,0 +1,161 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"http-from-tcp/internal/headers"
+)
+
+// Request is a request to send with a Client.
+type Request struct {
+	Method string
+
+	// URL is the absolute URL of the request. Its path and query are sent in the
+	// request line and its host in the Host header.
+	URL *url.URL
+
+	// Headers are written as they are, except for the headers that frame the message
+	// (Content-Length, Transfer-Encoding, Trailer and Connection), which are set by
+	// the client. A Host header replaces the host of the URL.
+	Headers headers.Headers
+
+	// Body is sent with its Content-Length, or as a single chunk if the request has
+	// trailers.
+	Body []byte
+
+	// Trailers are sent after the body.
+	Trailers headers.Headers
+
+	// Close asks the server to close the connection after the response.
+	Close bool
+}
+
+// NewRequest returns a request for the absolute URL with an empty set of headers.
+func NewRequest(method, target string, body []byte) (*Request, error) {
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL %q: %w", target, err)
+	}
+
+	if _, err := connKey(targetURL); err != nil {
+		return nil, err
+	}
+
+	return &Request{
+		Method:   method,
+		URL:      targetURL,
+		Headers:  headers.NewHeaders(),
+		Body:     body,
+		Trailers: headers.NewHeaders(),
+	}, nil
+}
+
+// idempotent returns true if the request can be sent again without a different
+// effect on the server (RFC 9110, section 9.2.2).
+func (r *Request) idempotent() bool {
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	default:
+		return false
+	}
+}
+
+// expectsBody returns true if the method is usually sent with a body, in which case
+// an empty body is sent with a Content-Length of 0.
+func (r *Request) expectsBody() bool {
+	return r.Method == "POST" || r.Method == "PUT" || r.Method == "PATCH"
+}
+
+// write writes the request. The headers and the trailers are validated before anything
+// is written so that a field cannot inject a new line into the request.
+func (r *Request) write(w *bufio.Writer) error {
+	if r.Method == "" || strings.Trim(r.Method, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return fmt.Errorf("invalid method %q", r.Method)
+	}
+
+	host := r.URL.Host
+	fields := make([]string, 0, len(r.Headers))
+
+	for key, value := range r.Headers {
+		if err := headers.ValidateField(key, value); err != nil {
+			return err
+		}
+
+		switch strings.ToLower(key) {
+		case "host":
+			host = value
+		case "content-length", "transfer-encoding", "trailer", "connection":
+		default:
+			fields = append(fields, key+": "+value+"\r\n")
+		}
+	}
+
+	if err := headers.ValidateField("Host", host); err != nil || host == "" {
+		return fmt.Errorf("invalid host %q", host)
+	}
+
+	for name, value := range r.Trailers {
+		if err := headers.ValidateField(name, value); err != nil {
+			return err
+		}
+	}
+
+	// The headers are sorted so that the requests are written the same way.
+	slices.Sort(fields)
+
+	// The errors of the buffered writes are returned by Flush.
+	_, _ = w.WriteString(r.Method + " " + r.URL.RequestURI() + " HTTP/1.1\r\n")
+	_, _ = w.WriteString("Host: " + host + "\r\n")
+
+	for _, field := range fields {
+		_, _ = w.WriteString(field)
+	}
+
+	if r.Close {
+		_, _ = w.WriteString("Connection: close\r\n")
+	}
+
+	if len(r.Trailers) > 0 {
+		return r.writeChunked(w)
+	}
+
+	if len(r.Body) > 0 || r.expectsBody() {
+		_, _ = w.WriteString("Content-Length: " + strconv.Itoa(len(r.Body)) + "\r\n")
+	}
+
+	_, _ = w.WriteString("\r\n")
+	_, _ = w.Write(r.Body)
+
+	return w.Flush()
+}
+
+// writeChunked writes the body as a single chunk followed by the trailers.
+func (r *Request) writeChunked(w *bufio.Writer) error {
+	names := slices.Sorted(maps.Keys(r.Trailers))
+
+	_, _ = w.WriteString("Transfer-Encoding: chunked\r\n")
+	_, _ = w.WriteString("Trailer: " + strings.Join(names, ", ") + "\r\n\r\n")
+
+	if len(r.Body) > 0 {
+		_, _ = w.WriteString(strconv.FormatInt(int64(len(r.Body)), 16) + "\r\n")
+		_, _ = w.Write(r.Body)
+		_, _ = w.WriteString("\r\n")
+	}
+
+	_, _ = w.WriteString("0\r\n")
+
+	for _, name := range names {
+		_, _ = w.WriteString(name + ": " + r.Trailers[name] + "\r\n")
+	}
+
+	_, _ = w.WriteString("\r\n")
+
+	return w.Flush()
+}
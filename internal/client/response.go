@@ -0,0 +1,224 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+
+	"http-from-tcp/internal/headers"
+	"http-from-tcp/internal/response"
+)
+
+// Response is the response to a request sent with a Client.
+type Response struct {
+	StatusCode response.StatusCode
+	Reason     string
+
+	// HTTPVersion is the version of the response without the HTTP/ prefix (e.g. 1.1).
+	HTTPVersion string
+
+	// Headers have lower case keys and the values of the repeated headers are joined
+	// into a comma separated list, like the headers of a request.
+	Headers headers.Headers
+
+	// SetCookies are the values of the Set-Cookie headers, which cannot be joined into
+	// a list.
+	SetCookies []string
+
+	// ContentLength is the length of the body, or -1 if it is not known in advance.
+	// It is the value of the Content-Length header for a response to a HEAD request.
+	ContentLength int64
+
+	// Body is the body of the response. It must be closed, and it must be read to
+	// the end for the connection to be reused.
+	Body io.ReadCloser
+
+	// Trailers are the trailers that follow a chunked body. They are only available
+	// once the body has been read to the end.
+	Trailers headers.Headers
+
+	// Close is true if the connection is closed after the response.
+	Close bool
+}
+
+// readResponse reads the status line and the headers of the response to the request.
+// The interim responses (1xx) are skipped, except for 101 Switching Protocols which
+// ends the use of the connection.
+func (c *conn) readResponse(req *Request) (*Response, response.Framing, error) {
+	for {
+		statusLine, err := c.reader.ReadStatusLine()
+		if err != nil {
+			// The server closed the idle connection before it received the request.
+			if c.reused && (errors.Is(err, io.EOF) || isConnReset(err)) {
+				return nil, 0, errConnReused
+			}
+
+			return nil, 0, fmt.Errorf("error reading the status line: %w", err)
+		}
+
+		resp := &Response{
+			StatusCode:    statusLine.StatusCode,
+			Reason:        statusLine.Reason,
+			HTTPVersion:   statusLine.HTTPVersion,
+			ContentLength: -1,
+			Trailers:      headers.NewHeaders(),
+		}
+
+		resp.Headers, resp.SetCookies, err = c.reader.ReadHeaders()
+		if err != nil {
+			return nil, 0, fmt.Errorf("error reading the headers: %w", err)
+		}
+
+		if resp.StatusCode >= 100 && resp.StatusCode < 200 && resp.StatusCode != response.StatusCodeSwitchingProtocols {
+			continue
+		}
+
+		framing, err := resp.setFraming(req)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		return resp, framing, nil
+	}
+}
+
+// setFraming sets the length of the body and whether the connection is closed after
+// the response. It returns how the end of the body is found.
+func (r *Response) setFraming(req *Request) (response.Framing, error) {
+	r.Close = req.Close || r.Headers.HasToken("connection", "close") ||
+		(r.HTTPVersion == "1.0" && !r.Headers.HasToken("connection", "keep-alive"))
+
+	framing, contentLength, err := response.BodyFraming(r.StatusCode, r.Headers, req.Method == "HEAD")
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case r.StatusCode == response.StatusCodeSwitchingProtocols:
+		// The connection speaks another protocol, which the client does not support.
+		r.Close = true
+	case framing == response.FramingClose:
+		r.Close = true
+	default:
+		// The Content-Length of the response to a HEAD request is kept even though
+		// the response has no body.
+		r.ContentLength = contentLength
+	}
+
+	return framing, nil
+}
+
+// body is the body of a response. The connection is released once the body has been
+// read to the end, or closed if the body is closed before.
+type body struct {
+	conn    *conn
+	resp    *Response
+	framing response.Framing
+
+	// remaining is the number of bytes left in a body with a Content-Length.
+	remaining int64
+
+	// chunked reads a chunked body.
+	chunked *response.ChunkedReader
+
+	// err is returned by the reads once the body has ended or failed.
+	err error
+}
+
+// errBodyClosed is returned by the reads of a body that has been closed.
+var errBodyClosed = errors.New("read on a closed response body")
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	var (
+		n   int
+		err error
+	)
+
+	switch b.framing {
+	case response.FramingLength:
+		n, err = b.readLength(p)
+	case response.FramingChunked:
+		n, err = b.readChunked(p)
+	case response.FramingClose:
+		n, err = b.conn.reader.Read(p)
+		if errors.Is(err, io.EOF) {
+			b.finish(false)
+		}
+	default:
+		b.finish(true)
+	}
+
+	// The body has either ended (b.err is io.EOF) or failed.
+	if err != nil || b.err != nil {
+		if b.err == nil {
+			b.fail(err)
+		}
+
+		return n, b.err
+	}
+
+	return n, nil
+}
+
+// readLength reads from a body with a Content-Length.
+func (b *body) readLength(p []byte) (int, error) {
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+
+	n, err := b.conn.reader.Read(p)
+	b.remaining -= int64(n)
+
+	switch {
+	case b.remaining == 0:
+		b.finish(true)
+
+		return n, nil
+	case errors.Is(err, io.EOF):
+		return n, io.ErrUnexpectedEOF
+	default:
+		return n, err
+	}
+}
+
+// readChunked reads from a chunked body. The trailers are set once the last chunk
+// has been read.
+func (b *body) readChunked(p []byte) (int, error) {
+	n, err := b.chunked.Read(p)
+	if errors.Is(err, io.EOF) {
+		maps.Copy(b.resp.Trailers, b.chunked.Trailers())
+		b.finish(true)
+
+		return n, nil
+	}
+
+	return n, err
+}
+
+// Close closes the body. The connection is closed if the body has not been read to
+// the end.
+func (b *body) Close() error {
+	if b.err == nil {
+		b.fail(errBodyClosed)
+	}
+
+	return nil
+}
+
+// finish ends the body and releases the connection. It is reused if the response
+// allows it.
+func (b *body) finish(reusable bool) {
+	b.err = io.EOF
+	b.conn.release(reusable && !b.resp.Close)
+}
+
+// fail ends the body with the error and closes the connection.
+func (b *body) fail(err error) {
+	b.err = err
+	b.conn.release(false)
+}
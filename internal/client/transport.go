@@ -0,0 +1,133 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"http-from-tcp/internal/headers"
+)
+
+// RoundTrip implements http.RoundTripper. The body of the request is read before the
+// request is sent and its trailers are sent after it. The trailers of the response
+// are set in its Trailer once its body has been read to the end.
+func (c *Client) RoundTrip(httpReq *http.Request) (*http.Response, error) {
+	req, err := newRequest(httpReq)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.Do(httpReq.Context(), req)
+	if err != nil {
+		return nil, err
+	}
+
+	return newHTTPResponse(httpReq, resp), nil
+}
+
+// newRequest converts a request of net/http. The values of the repeated headers are
+// joined into a comma separated list.
+func newRequest(httpReq *http.Request) (*Request, error) {
+	if httpReq.Body != nil {
+		defer httpReq.Body.Close()
+	}
+
+	req := &Request{
+		Method:   httpReq.Method,
+		URL:      httpReq.URL,
+		Headers:  headers.NewHeaders(),
+		Trailers: headers.NewHeaders(),
+		Close:    httpReq.Close,
+	}
+
+	if httpReq.Body != nil && httpReq.Body != http.NoBody {
+		body, err := io.ReadAll(httpReq.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the request body: %w", err)
+		}
+
+		req.Body = body
+	}
+
+	for key, values := range httpReq.Header {
+		req.Headers[key] = strings.Join(values, ", ")
+	}
+
+	if httpReq.Host != "" {
+		req.Headers["Host"] = httpReq.Host
+	}
+
+	// The trailers of a request of net/http are only set once its body has been read.
+	for key, values := range httpReq.Trailer {
+		if len(values) > 0 {
+			req.Trailers[key] = strings.Join(values, ", ")
+		}
+	}
+
+	return req, nil
+}
+
+// newHTTPResponse converts a response to the request of net/http.
+func newHTTPResponse(httpReq *http.Request, resp *Response) *http.Response {
+	httpResp := &http.Response{
+		Status:        fmt.Sprintf("%d %s", resp.StatusCode, resp.Reason),
+		StatusCode:    int(resp.StatusCode),
+		Proto:         "HTTP/" + resp.HTTPVersion,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header, len(resp.Headers)+1),
+		ContentLength: resp.ContentLength,
+		Close:         resp.Close,
+		Request:       httpReq,
+	}
+
+	if resp.HTTPVersion == "1.0" {
+		httpResp.ProtoMinor = 0
+	}
+
+	for key, value := range resp.Headers {
+		httpResp.Header[http.CanonicalHeaderKey(key)] = []string{value}
+	}
+
+	for _, cookie := range resp.SetCookies {
+		httpResp.Header.Add("Set-Cookie", cookie)
+	}
+
+	// The declared trailers are known before the body is read, like with net/http.
+	if declared, ok := resp.Headers["trailer"]; ok {
+		httpResp.Trailer = make(http.Header)
+
+		for name := range strings.SplitSeq(declared, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				httpResp.Trailer[http.CanonicalHeaderKey(name)] = nil
+			}
+		}
+	}
+
+	httpResp.Body = &httpBody{ReadCloser: resp.Body, resp: resp, httpResp: httpResp}
+
+	return httpResp
+}
+
+// httpBody sets the trailers of the response of net/http at the end of the body.
+type httpBody struct {
+	io.ReadCloser
+	resp     *Response
+	httpResp *http.Response
+}
+
+func (b *httpBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF && len(b.resp.Trailers) > 0 {
+		if b.httpResp.Trailer == nil {
+			b.httpResp.Trailer = make(http.Header, len(b.resp.Trailers))
+		}
+
+		for key, value := range b.resp.Trailers {
+			b.httpResp.Trailer[http.CanonicalHeaderKey(key)] = []string{value}
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,276 @@
+// Package compression compresses the bodies of the responses with the gzip or the
+// deflate content coding (RFC 9110, section 8.4.1) based on the Accept-Encoding
+// header of the request.
+package compression
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+
+	"http-from-tcp/internal/headers"
+	"http-from-tcp/internal/negotiation"
+	"http-from-tcp/internal/request"
+	"http-from-tcp/internal/response"
+	"http-from-tcp/internal/server"
+)
+
+// minSize is the minimum size of a body with a known length that is compressed.
+// Smaller bodies do not get much smaller and can even grow.
+const minSize = 256
+
+// offers are the supported content codings in the order of preference.
+var offers = []string{"gzip", "deflate", "identity"}
+
+// incompressibleTypes are the media types that are already compressed.
+var incompressibleTypes = map[string]struct{}{
+	"application/gzip":             {},
+	"application/x-gzip":           {},
+	"application/zip":              {},
+	"application/zstd":             {},
+	"application/x-bzip2":          {},
+	"application/x-xz":             {},
+	"application/x-7z-compressed":  {},
+	"application/x-rar-compressed": {},
+	"application/pdf":              {},
+	"font/woff":                    {},
+	"font/woff2":                   {},
+}
+
+var (
+	gzipPool = sync.Pool{
+		New: func() any {
+			return gzip.NewWriter(io.Discard)
+		},
+	}
+
+	zlibPool = sync.Pool{
+		New: func() any {
+			return zlib.NewWriter(io.Discard)
+		},
+	}
+)
+
+// Middleware compresses the body of the response if the client accepts a supported
+// content coding and the body is not already compressed. A compressed body is sent
+// with a chunked Transfer-Encoding as its length is not known before it is written.
+func Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		acceptEncoding := req.Headers.Get("accept-encoding")
+		head := req.RequestLine.Method == "HEAD"
+
+		w.SetEncoder(func(h headers.Headers, dst io.Writer) io.WriteCloser {
+			if head {
+				headHeaders(acceptEncoding, w.StatusCode(), h)
+
+				return nil
+			}
+
+			return newEncoder(acceptEncoding, w.StatusCode(), h, dst)
+		})
+
+		next(w, req)
+
+		if err := w.Finish(); err != nil {
+			slog.Error("error finishing the compressed response.", "error", err.Error())
+		}
+	}
+}
+
+// newEncoder returns the encoder for the response with the status code and the
+// headers. nil is returned if the body should not be compressed.
+func newEncoder(acceptEncoding string, statusCode response.StatusCode, h headers.Headers, dst io.Writer) io.WriteCloser {
+	if _, _, ok := lookup(h, response.HeaderContentEncoding); ok {
+		return nil
+	}
+
+	// A 304 response has no body to compress but it must have the Vary header that
+	// the 200 response would have (RFC 9110, section 15.4.5). Its content type is not
+	// known so the header is always added.
+	if statusCode == response.StatusCodeNotModified {
+		addVary(h, "Accept-Encoding")
+
+		return nil
+	}
+
+	_, contentType, _ := lookup(h, response.HeaderContentType)
+
+	// The byte ranges of a partial response refer to the unencoded content so it
+	// cannot be compressed. A multipart/byteranges body has a Content-Range in each
+	// part rather than in the headers.
+	if _, _, ok := lookup(h, response.HeaderContentRange); ok ||
+		statusCode == response.StatusCodePartialContent || isByteRanges(contentType) {
+		return nil
+	}
+
+	if !compressible(contentType) {
+		return nil
+	}
+
+	if _, value, ok := lookup(h, response.HeaderContentLength); ok {
+		if size, err := strconv.Atoi(value); err == nil && size < minSize {
+			return nil
+		}
+	}
+
+	// The response depends on the Accept-Encoding header from here on even if
+	// it is not compressed.
+	addVary(h, "Accept-Encoding")
+
+	encoding, ok := negotiation.Encoding(acceptEncoding, offers)
+	if !ok {
+		return nil
+	}
+
+	if encoding != "identity" {
+		// The ranges of the compressed content cannot be served.
+		if key, _, ok := lookup(h, response.HeaderAcceptRanges); ok {
+			delete(h, key)
+		}
+
+		// The compressed content is not byte-for-byte identical to the content
+		// that a strong ETag refers to but it is semantically equivalent.
+		if key, etag, ok := lookup(h, response.HeaderETag); ok && strings.HasPrefix(etag, `"`) {
+			h[key] = "W/" + etag
+		}
+	}
+
+	switch encoding {
+	case "gzip":
+		writer, _ := gzipPool.Get().(*gzip.Writer)
+		writer.Reset(dst)
+		h[response.HeaderContentEncoding] = encoding
+
+		return &pooledWriter{compressor: writer, pool: &gzipPool}
+	case "deflate":
+		// The deflate content coding is the zlib format (RFC 1950) and not
+		// the raw deflate format.
+		writer, _ := zlibPool.Get().(*zlib.Writer)
+		writer.Reset(dst)
+		h[response.HeaderContentEncoding] = encoding
+
+		return &pooledWriter{compressor: writer, pool: &zlibPool}
+	default:
+		return nil
+	}
+}
+
+// headHeaders changes the headers of the response to a HEAD request like the headers
+// of the response to the same GET request (RFC 9110, section 9.3.2). The response
+// does not have a body so there is nothing to encode. The length of the encoded body
+// is not known so the Content-Length header is removed.
+func headHeaders(acceptEncoding string, statusCode response.StatusCode, h headers.Headers) {
+	encoder := newEncoder(acceptEncoding, statusCode, h, io.Discard)
+	if encoder == nil {
+		return
+	}
+
+	_ = encoder.Close()
+
+	if key, _, ok := lookup(h, response.HeaderContentLength); ok {
+		delete(h, key)
+	}
+}
+
+// compressible returns true if the body with the content type should be compressed.
+// Bodies without a content type are not compressed as their format is unknown.
+func compressible(contentType string) bool {
+	mediaType, err := headers.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	switch mediaType.Type {
+	case "video", "audio":
+		return false
+	case "image":
+		return mediaType.Subtype == "svg+xml"
+	}
+
+	_, incompressible := incompressibleTypes[mediaType.Essence()]
+
+	return !incompressible
+}
+
+// isByteRanges returns true if the body is made of the parts of a multiple range
+// response.
+func isByteRanges(contentType string) bool {
+	mediaType, err := headers.ParseMediaType(contentType)
+
+	return err == nil && mediaType.Essence() == "multipart/byteranges"
+}
+
+// addVary adds the header name to the Vary header of the response.
+func addVary(h headers.Headers, name string) {
+	key, value, ok := lookup(h, response.HeaderVary)
+	if !ok {
+		h[response.HeaderVary] = name
+
+		return
+	}
+
+	for token := range strings.SplitSeq(value, ",") {
+		token = strings.TrimSpace(token)
+		if token == "*" || strings.EqualFold(token, name) {
+			return
+		}
+	}
+
+	if strings.TrimSpace(value) == "" {
+		h[key] = name
+	} else {
+		h[key] = value + ", " + name
+	}
+}
+
+// lookup returns the key and the value of the response header. The response headers
+// are not normalised so the key is compared case-insensitively.
+func lookup(h headers.Headers, name string) (string, string, bool) {
+	for key, value := range h {
+		if strings.EqualFold(key, name) {
+			return key, value, true
+		}
+	}
+
+	return "", "", false
+}
+
+// compressor is implemented by both the gzip and the zlib writers.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// pooledWriter returns the compressor to its pool when it is closed.
+type pooledWriter struct {
+	compressor
+
+	pool *sync.Pool
+}
+
+// Flush writes the compressed data that is held back by the compressor so that a
+// streamed response (e.g. an event stream) reaches the client immediately.
+func (p *pooledWriter) Flush() error {
+	if p.compressor == nil {
+		return nil
+	}
+
+	return p.compressor.Flush()
+}
+
+func (p *pooledWriter) Close() error {
+	if p.compressor == nil {
+		return nil
+	}
+
+	err := p.compressor.Close()
+
+	p.pool.Put(p.compressor)
+	p.compressor = nil
+
+	return err
+}
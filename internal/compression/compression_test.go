@@ -0,0 +1,260 @@
+package compression
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"http-from-tcp/internal/fileserver"
+	"http-from-tcp/internal/headers"
+	"http-from-tcp/internal/request"
+	"http-from-tcp/internal/response"
+)
+
+var testBody = strings.Repeat("The quick brown fox jumps over the lazy dog. ", 100)
+
+// serve runs the handler through the middleware and parses the response.
+func serve(t *testing.T, method, acceptEncoding string, handler func(w *response.Writer)) (*http.Response, *response.Writer) {
+	t.Helper()
+
+	raw := method + " / HTTP/1.1\r\nHost: localhost\r\n"
+	if acceptEncoding != "" {
+		raw += "Accept-Encoding: " + acceptEncoding + "\r\n"
+	}
+
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+
+	buf := new(bytes.Buffer)
+	w := response.NewWriter(buf)
+
+	Middleware(func(w *response.Writer, _ *request.Request) {
+		handler(w)
+	})(w, req)
+
+	resp, err := http.ReadResponse(bufio.NewReader(buf), &http.Request{Method: method})
+	require.NoError(t, err)
+
+	return resp, w
+}
+
+// fixedLengthHandler writes the body with the Content-Length header.
+func fixedLengthHandler(contentType, body string) func(w *response.Writer) {
+	return func(w *response.Writer) {
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+
+		h := response.GetDefaultHeaders(len(body))
+		h[response.HeaderContentType] = contentType
+
+		_ = w.WriteHeaders(h)
+		_, _ = w.WriteBody([]byte(body))
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	// Test: gzip is preferred
+	resp, w := serve(t, "GET", "deflate, gzip", fixedLengthHandler("text/html", testBody))
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	assert.True(t, w.KeepAlive())
+
+	reader, err := gzip.NewReader(resp.Body)
+	require.NoError(t, err)
+
+	body, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, testBody, string(body))
+
+	// Test: deflate
+	resp, _ = serve(t, "GET", "gzip;q=0.5, deflate", fixedLengthHandler("application/json", testBody))
+	assert.Equal(t, "deflate", resp.Header.Get("Content-Encoding"))
+
+	reader2, err := zlib.NewReader(resp.Body)
+	require.NoError(t, err)
+
+	body, err = io.ReadAll(reader2)
+	require.NoError(t, err)
+	assert.Equal(t, testBody, string(body))
+
+	// Test: A strong ETag becomes weak when the body is compressed
+	resp, _ = serve(t, "GET", "gzip", func(w *response.Writer) {
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+
+		h := response.GetDefaultHeaders(len(testBody))
+		response.SetETag(h, `"abc"`)
+		h[response.HeaderAcceptRanges] = "bytes"
+
+		_ = w.WriteHeaders(h)
+		_, _ = w.WriteBody([]byte(testBody))
+	})
+	assert.Equal(t, `W/"abc"`, resp.Header.Get("ETag"))
+	assert.Empty(t, resp.Header.Get("Accept-Ranges"))
+
+	// Test: No compression if the client does not accept it
+	for _, acceptEncoding := range []string{"", "br", "gzip;q=0"} {
+		resp, w = serve(t, "GET", acceptEncoding, fixedLengthHandler("text/html", testBody))
+		assert.Empty(t, resp.Header.Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+		assert.Equal(t, int64(len(testBody)), resp.ContentLength)
+		assert.True(t, w.KeepAlive())
+
+		body, err = io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, testBody, string(body))
+	}
+
+	// Test: Responses that should not be compressed
+	for _, handler := range []func(w *response.Writer){
+		fixedLengthHandler("video/mp4", testBody),
+		fixedLengthHandler("image/png", testBody),
+		fixedLengthHandler("application/zip", testBody),
+		fixedLengthHandler("text/plain", "too small"),
+	} {
+		resp, _ = serve(t, "GET", "gzip", handler)
+		assert.Empty(t, resp.Header.Get("Content-Encoding"))
+		assert.Empty(t, resp.Header.Get("Vary"))
+		assert.NotEqual(t, int64(-1), resp.ContentLength)
+	}
+
+	// Test: A 304 response has the Vary header of the 200 response
+	resp, _ = serve(t, "GET", "gzip", func(w *response.Writer) {
+		_ = w.WriteNotModified(`"abc"`, time.Time{})
+	})
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+
+}
+
+func TestMiddlewareHead(t *testing.T) {
+	handler := func(head bool) func(w *response.Writer) {
+		return func(w *response.Writer) {
+			_ = w.WriteStatusLine(response.StatusCodeOK)
+
+			h := response.GetDefaultHeaders(len(testBody))
+			h[response.HeaderETag] = `"abc"`
+			h[response.HeaderAcceptRanges] = "bytes"
+
+			_ = w.WriteHeaders(h)
+
+			if !head {
+				_, _ = w.WriteBody([]byte(testBody))
+			}
+		}
+	}
+
+	// Test: A HEAD request gets the headers of the compressed GET response
+	get, _ := serve(t, "GET", "gzip", handler(false))
+	head, _ := serve(t, "HEAD", "gzip", handler(true))
+
+	for _, name := range []string{"Content-Encoding", "Vary", "ETag", "Accept-Ranges", "Content-Length"} {
+		assert.Equal(t, get.Header.Values(name), head.Header.Values(name), name)
+	}
+
+	assert.Equal(t, "gzip", head.Header.Get("Content-Encoding"))
+	assert.Equal(t, `W/"abc"`, head.Header.Get("ETag"))
+	assert.Empty(t, head.Header.Get("Content-Length"))
+
+	// Test: A HEAD request that does not accept gzip keeps the Content-Length
+	head, _ = serve(t, "HEAD", "", handler(true))
+	assert.Empty(t, head.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", head.Header.Get("Vary"))
+	assert.Equal(t, `"abc"`, head.Header.Get("ETag"))
+	assert.Equal(t, strconv.Itoa(len(testBody)), head.Header.Get("Content-Length"))
+}
+
+func TestMiddlewareChunkedResponse(t *testing.T) {
+	// Test: A chunked response with trailers is compressed
+	resp, w := serve(t, "GET", "gzip", func(w *response.Writer) {
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+
+		h := headers.NewHeaders()
+		h[response.HeaderContentType] = "text/plain"
+		h[response.HeaderTransferEncoding] = "chunked"
+		h[response.HeaderTrailer] = "X-Checksum"
+
+		_ = w.WriteHeaders(h)
+
+		for range 3 {
+			_, _ = w.WriteChunkedBody([]byte(testBody))
+		}
+
+		_, _ = w.WriteChunkedBodyDone()
+
+		h["X-Checksum"] = "abc"
+		_ = w.WriteTrailers(h)
+	})
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.True(t, w.KeepAlive())
+
+	reader, err := gzip.NewReader(resp.Body)
+	require.NoError(t, err)
+
+	body, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat(testBody, 3), string(body))
+	assert.Equal(t, "abc", resp.Trailer.Get("X-Checksum"))
+}
+
+func TestMiddlewareRanges(t *testing.T) {
+	serveRange := func(rangeHeader string) *http.Response {
+		req, err := request.RequestFromReader(strings.NewReader(
+			"GET /fox.txt HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: gzip\r\nRange: " + rangeHeader + "\r\n\r\n",
+		))
+		require.NoError(t, err)
+
+		buf := new(bytes.Buffer)
+
+		Middleware(func(w *response.Writer, req *request.Request) {
+			fileserver.ServeBytes(w, req, "fox.txt", time.Time{}, []byte(testBody))
+		})(response.NewWriter(buf), req)
+
+		resp, err := http.ReadResponse(bufio.NewReader(buf), &http.Request{Method: "GET"})
+		require.NoError(t, err)
+
+		return resp
+	}
+
+	// Test: A single range is not compressed
+	resp := serveRange("bytes=0-500")
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, testBody[:501], string(body))
+
+	// Test: The parts of a multiple range response are not compressed
+	resp = serveRange("bytes=0-500,1000-1500")
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	assert.Empty(t, resp.TransferEncoding)
+
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/byteranges", mediaType)
+
+	reader := multipart.NewReader(resp.Body, params["boundary"])
+
+	for _, want := range []string{testBody[:501], testBody[1000:1501]} {
+		part, err := reader.NextPart()
+		require.NoError(t, err)
+
+		data, err := io.ReadAll(part)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(data))
+	}
+}
@@ -0,0 +1,503 @@
+// Package fileserver serves static files from a directory or from an fs.FS.
+package fileserver
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"http-from-tcp/internal/headers"
+	"http-from-tcp/internal/request"
+	"http-from-tcp/internal/response"
+	"http-from-tcp/internal/server"
+)
+
+const indexFile = "index.html"
+
+var bufferPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 32<<10)
+
+		return &buf
+	},
+}
+
+// Options are the options for the file server.
+type Options struct {
+	// Prefix is removed from the path of the request before the file is looked
+	// up (e.g. /static/). Requests outside of the prefix are not found.
+	Prefix string
+
+	// ListDirectories enables the HTML listings of the directories that do not
+	// have an index.html file.
+	ListDirectories bool
+}
+
+// New returns a handler that serves the files from fsys. The path of the request is
+// cleaned so that it cannot refer to files outside of fsys. A directory is served
+// with its index.html file if it has one.
+func New(fsys fs.FS, options Options) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		serveRequest(w, req, fsys, options)
+	}
+}
+
+// NewDir returns a handler that serves the files from the directory. Symbolic links
+// that point outside of the directory are not followed.
+func NewDir(dir string, options Options) (server.Handler, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(root.FS(), options), nil
+}
+
+// ServeFile serves the named file from fsys regardless of the request target.
+func ServeFile(w *response.Writer, req *request.Request, fsys fs.FS, name string) {
+	if !allowedMethod(w, req) {
+		return
+	}
+
+	file, err := fsys.Open(name)
+	if err != nil {
+		writeError(w, errorStatusCode(err))
+
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		writeError(w, errorStatusCode(err))
+
+		return
+	}
+
+	if info.IsDir() {
+		writeError(w, response.StatusCodeNotFound)
+
+		return
+	}
+
+	serveFile(w, req, name, file, info)
+}
+
+// ServeContent serves the content with the Content-Type from the extension of the name
+// or, if the extension is not known, from the first bytes of the content. The modTime
+// is sent in the Last-Modified header unless it is the zero time and an ETag is derived
+// from the modTime and the size of the content. The content is read from its current
+// offset to its end. Conditional requests (see checkPreconditions) and range requests
+// (see serveRanges) are supported.
+func ServeContent(w *response.Writer, req *request.Request, name string, modTime time.Time, content io.ReadSeeker) {
+	offset, err := content.Seek(0, io.SeekCurrent)
+	if err != nil {
+		slog.Error("error seeking the content.", "error", err.Error())
+		writeError(w, response.StatusCodeServerError)
+
+		return
+	}
+
+	size, err := remainingSize(content)
+	if err != nil {
+		slog.Error("error seeking the content.", "error", err.Error())
+		writeError(w, response.StatusCodeServerError)
+
+		return
+	}
+
+	serveSection(
+		w,
+		req,
+		name,
+		validators{etag: modTimeETag(modTime, size), modTime: modTime},
+		section{content: content, offset: offset, size: size},
+	)
+}
+
+// ServeBytes serves the data in the same way as ServeContent except that the ETag is
+// derived from the data itself.
+func ServeBytes(w *response.Writer, req *request.Request, name string, modTime time.Time, data []byte) {
+	serveSection(
+		w,
+		req,
+		name,
+		validators{etag: response.ContentETag(data), modTime: modTime},
+		section{content: bytes.NewReader(data), offset: 0, size: int64(len(data))},
+	)
+}
+
+// validators are the values that the conditional headers are evaluated against.
+type validators struct {
+	etag    string
+	modTime time.Time
+}
+
+// modTimeETag returns a strong ETag that is derived from the modification time and the
+// size of the content. No ETag is returned if the modification time is unknown.
+func modTimeETag(modTime time.Time, size int64) string {
+	if modTime.IsZero() {
+		return ""
+	}
+
+	return response.NewETag(
+		strconv.FormatInt(modTime.UnixNano(), 16)+"-"+strconv.FormatInt(size, 16),
+		false,
+	)
+}
+
+func serveSection(w *response.Writer, req *request.Request, name string, v validators, content section) {
+	if !checkPreconditions(w, req, v) {
+		return
+	}
+
+	contentType := contentTypeByExtension(name)
+
+	if contentType == "" {
+		if _, err := content.content.Seek(content.offset, io.SeekStart); err != nil {
+			slog.Error("error seeking the content.", "error", err.Error())
+			writeError(w, response.StatusCodeServerError)
+
+			return
+		}
+
+		sniffed := make([]byte, min(content.size, sniffLen))
+
+		n, err := io.ReadFull(content.content, sniffed)
+		if err != nil {
+			slog.Error("error reading the content.", "error", err.Error())
+			writeError(w, response.StatusCodeServerError)
+
+			return
+		}
+
+		contentType = detectContentType(sniffed[:n])
+	}
+
+	serveRanges(w, req, contentType, v, content)
+}
+
+// checkPreconditions evaluates the conditional headers of the request. It writes a
+// 304 Not Modified or a 412 Precondition Failed response and returns false if the
+// content should not be served.
+func checkPreconditions(w *response.Writer, req *request.Request, v validators) bool {
+	// The content is only served for files that exist.
+	statusCode, ok := response.CheckPreconditions(req, true, v.etag, v.modTime)
+
+	switch {
+	case ok:
+		return true
+	case statusCode == response.StatusCodeNotModified:
+		if err := w.WriteNotModified(v.etag, v.modTime); err != nil {
+			slog.Error("error writing the not modified response.", "error", err.Error())
+		}
+	default:
+		writeError(w, statusCode)
+	}
+
+	return false
+}
+
+func serveRequest(w *response.Writer, req *request.Request, fsys fs.FS, options Options) {
+	if !allowedMethod(w, req) {
+		return
+	}
+
+	urlPath, query, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+	query, _, _ = strings.Cut(query, "#")
+	urlPath, _, _ = strings.Cut(urlPath, "#")
+
+	urlPath, err := url.PathUnescape(urlPath)
+	if err != nil || strings.ContainsRune(urlPath, 0) {
+		writeError(w, response.StatusCodeBadRequest)
+
+		return
+	}
+
+	if !strings.HasPrefix(urlPath, "/") {
+		writeError(w, response.StatusCodeNotFound)
+
+		return
+	}
+
+	relative, ok := strings.CutPrefix(urlPath, strings.TrimSuffix(options.Prefix, "/"))
+	if !ok || (relative != "" && !strings.HasPrefix(relative, "/")) {
+		writeError(w, response.StatusCodeNotFound)
+
+		return
+	}
+
+	name := cleanPath(relative)
+
+	file, err := fsys.Open(name)
+	if err != nil {
+		writeError(w, errorStatusCode(err))
+
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		writeError(w, errorStatusCode(err))
+
+		return
+	}
+
+	// Redirect to the canonical path so that the relative links in the pages
+	// resolve correctly.
+	switch {
+	case info.IsDir() && !strings.HasSuffix(urlPath, "/"):
+		redirect(w, escapeSegment(path.Base(urlPath))+"/", query)
+
+		return
+	case !info.IsDir() && strings.HasSuffix(urlPath, "/"):
+		redirect(w, "../"+url.PathEscape(path.Base(urlPath)), query)
+
+		return
+	case !info.IsDir():
+		serveFile(w, req, name, file, info)
+
+		return
+	}
+
+	indexName := path.Join(name, indexFile)
+
+	if index, err := fsys.Open(indexName); err == nil {
+		defer index.Close()
+
+		if indexInfo, err := index.Stat(); err == nil && !indexInfo.IsDir() {
+			serveFile(w, req, indexName, index, indexInfo)
+
+			return
+		}
+	}
+
+	if !options.ListDirectories {
+		writeError(w, response.StatusCodeForbidden)
+
+		return
+	}
+
+	serveDirectory(w, req, fsys, name, urlPath)
+}
+
+// serveFile serves the opened file. The content is streamed from the file. Range
+// requests are only supported if the file can seek.
+func serveFile(w *response.Writer, req *request.Request, name string, file fs.File, info fs.FileInfo) {
+	if seeker, ok := file.(io.ReadSeeker); ok {
+		ServeContent(w, req, name, info.ModTime(), seeker)
+
+		return
+	}
+
+	v := validators{etag: modTimeETag(info.ModTime(), info.Size()), modTime: info.ModTime()}
+
+	if !checkPreconditions(w, req, v) {
+		return
+	}
+
+	contentType := contentTypeByExtension(name)
+	content := io.Reader(file)
+
+	if contentType == "" {
+		// Sniff the content type from the first bytes of the content and
+		// put the bytes back in front of the rest of the content.
+		sniffed := make([]byte, min(info.Size(), sniffLen))
+
+		n, err := io.ReadFull(file, sniffed)
+		if err != nil {
+			slog.Error("error reading the file.", "error", err.Error())
+			writeError(w, response.StatusCodeServerError)
+
+			return
+		}
+
+		contentType = detectContentType(sniffed[:n])
+		content = io.MultiReader(bytes.NewReader(sniffed[:n]), file)
+	}
+
+	h := contentHeaders(contentType, v)
+	h[response.HeaderContentLength] = strconv.FormatInt(info.Size(), 10)
+
+	writeContent(w, req, response.StatusCodeOK, h, func(dst io.Writer) error {
+		return copyBuffer(dst, io.LimitReader(content, info.Size()))
+	})
+}
+
+// contentHeaders returns the headers that describe the content.
+func contentHeaders(contentType string, v validators) headers.Headers {
+	h := headers.NewHeaders()
+	h[response.HeaderContentType] = contentType
+
+	if v.etag != "" {
+		response.SetETag(h, v.etag)
+	}
+
+	response.SetLastModified(h, v.modTime)
+
+	return h
+}
+
+// writeContent writes the response. The body is written by writeBody and it is
+// omitted for HEAD requests.
+func writeContent(
+	w *response.Writer,
+	req *request.Request,
+	statusCode response.StatusCode,
+	h headers.Headers,
+	writeBody func(dst io.Writer) error,
+) {
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		slog.Error("error writing the status line.", "error", err.Error())
+
+		return
+	}
+
+	if err := w.WriteHeaders(h); err != nil {
+		slog.Error("error writing the headers.", "error", err.Error())
+
+		return
+	}
+
+	if req.RequestLine.Method == "HEAD" {
+		return
+	}
+
+	if err := writeBody(w); err != nil {
+		slog.Error("error writing the content to the response body.", "error", err.Error())
+	}
+}
+
+// copyBuffer copies the content to dst. The buffer is only used if dst cannot read
+// from src itself. The response.Writer can, so a file is sent to a TCP connection
+// with sendfile.
+func copyBuffer(dst io.Writer, src io.Reader) error {
+	buf, _ := bufferPool.Get().(*[]byte)
+	defer bufferPool.Put(buf)
+
+	_, err := io.CopyBuffer(dst, src, *buf)
+
+	return err
+}
+
+// cleanPath returns the name of the file in the fs.FS for the path of the request.
+// Any . and .. elements are resolved against the root so that the path cannot
+// refer to a file outside of the root.
+func cleanPath(urlPath string) string {
+	name := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if name == "" {
+		return "."
+	}
+
+	return name
+}
+
+// remainingSize returns the number of bytes from the current offset to the end of
+// the content and leaves the offset unchanged.
+func remainingSize(content io.Seeker) (int64, error) {
+	offset, err := content.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+
+	end, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := content.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	return end - offset, nil
+}
+
+// allowedMethod writes a 405 Method Not Allowed response and returns false if the
+// method of the request is not GET or HEAD.
+func allowedMethod(w *response.Writer, req *request.Request) bool {
+	switch req.RequestLine.Method {
+	case "GET", "HEAD":
+		return true
+	}
+
+	body := []byte(response.StatusText(response.StatusCodeMethodNotAllowed) + "\n")
+
+	h := response.GetDefaultHeaders(len(body))
+	h[response.HeaderAllow] = "GET, HEAD"
+
+	writeResponse(w, response.StatusCodeMethodNotAllowed, h, body)
+
+	return false
+}
+
+// escapeSegment escapes a file name for a relative URL as the path was decoded. A name
+// with a colon is prefixed with ./ so that it is not read as the scheme of the URL.
+func escapeSegment(name string) string {
+	escaped := url.PathEscape(name)
+	if strings.Contains(escaped, ":") {
+		return "./" + escaped
+	}
+
+	return escaped
+}
+
+func redirect(w *response.Writer, location, query string) {
+	if query != "" {
+		location += "?" + query
+	}
+
+	body := []byte(response.StatusText(response.StatusCodeMovedPermanently) + "\n")
+
+	h := response.GetDefaultHeaders(len(body))
+	h[response.HeaderLocation] = location
+
+	writeResponse(w, response.StatusCodeMovedPermanently, h, body)
+}
+
+func writeResponse(w *response.Writer, statusCode response.StatusCode, h headers.Headers, body []byte) {
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		slog.Error("error writing the status line.", "error", err.Error())
+
+		return
+	}
+
+	if err := w.WriteHeaders(h); err != nil {
+		slog.Error("error writing the headers.", "error", err.Error())
+
+		return
+	}
+
+	if _, err := w.WriteBody(body); err != nil {
+		slog.Error("error writing the response body.", "error", err.Error())
+	}
+}
+
+func writeError(w *response.Writer, statusCode response.StatusCode) {
+	if err := w.WriteError(statusCode, response.StatusText(statusCode)); err != nil {
+		slog.Error("error writing the error response.", "error", err.Error())
+	}
+}
+
+// errorStatusCode returns the status code for an error from opening a file.
+func errorStatusCode(err error) response.StatusCode {
+	switch {
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, fs.ErrInvalid):
+		return response.StatusCodeNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return response.StatusCodeForbidden
+	default:
+		slog.Error("error opening the file.", "error", err.Error())
+
+		return response.StatusCodeServerError
+	}
+}
@@ -0,0 +1,379 @@
+package fileserver
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"http-from-tcp/internal/headers"
+	"http-from-tcp/internal/request"
+	"http-from-tcp/internal/response"
+	"http-from-tcp/internal/server"
+)
+
+var testModTime = time.Date(2025, time.March, 14, 15, 9, 26, 0, time.UTC)
+
+var testFS = fstest.MapFS{
+	"hello.txt":             {Data: []byte("Hello, World!\n"), ModTime: testModTime},
+	"page.html":             {Data: []byte("<p>page</p>")},
+	"noext":                 {Data: []byte("\x89PNG\r\n\x1a\nimage data")},
+	"docs/index.html":       {Data: []byte("<h1>Docs</h1>")},
+	"files/a.txt":           {Data: []byte("a")},
+	"files/sub dir/b.txt":   {Data: []byte("b")},
+	"files/<script>.txt":    {Data: []byte("c")},
+	"files/nested/deep.bin": {Data: []byte{0x00, 0x01, 0x02}},
+}
+
+// serve sends the request to the handler and parses the response.
+func serve(t *testing.T, handler server.Handler, method, target string) (*http.Response, string) {
+	t.Helper()
+
+	return serveWithHeaders(t, handler, method, target, "")
+}
+
+// serveWithHeaders sends the request with the extra header lines to the handler and
+// parses the response.
+func serveWithHeaders(t *testing.T, handler server.Handler, method, target, extraHeaders string) (*http.Response, string) {
+	t.Helper()
+
+	req, err := request.RequestFromReader(strings.NewReader(
+		method + " " + target + " HTTP/1.1\r\nHost: localhost\r\n" + extraHeaders + "\r\n",
+	))
+	require.NoError(t, err)
+
+	buf := new(bytes.Buffer)
+	handler(response.NewWriter(buf), req)
+
+	resp, err := http.ReadResponse(bufio.NewReader(buf), &http.Request{Method: method})
+	require.NoError(t, err)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return resp, string(body)
+}
+
+func TestFileServer(t *testing.T) {
+	handler := New(testFS, Options{Prefix: "", ListDirectories: false})
+
+	// Test: A file is served with its content type and modification time
+	resp, body := serve(t, handler, "GET", "/hello.txt")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "Hello, World!\n", body)
+	assert.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "Fri, 14 Mar 2025 15:09:26 GMT", resp.Header.Get("Last-Modified"))
+	assert.Equal(t, int64(14), resp.ContentLength)
+
+	// Test: The content type is detected from the content
+	resp, _ = serve(t, handler, "GET", "/noext")
+	assert.Equal(t, "image/png", resp.Header.Get("Content-Type"))
+
+	resp, _ = serve(t, handler, "GET", "/page.html")
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+
+	// Test: HEAD requests get the headers without the body
+	resp, body = serve(t, handler, "HEAD", "/hello.txt")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "14", resp.Header.Get("Content-Length"))
+	assert.Empty(t, body)
+
+	// Test: Percent-encoded paths
+	resp, body = serve(t, handler, "GET", "/files/sub%20dir/b.txt?v=1")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "b", body)
+
+	// Test: Paths cannot escape the root
+	for _, target := range []string{"/../hello.txt", "/files/../../hello.txt", "/%2e%2e/hello.txt"} {
+		resp, body = serve(t, handler, "GET", target)
+		assert.Equal(t, http.StatusOK, resp.StatusCode, target)
+		assert.Equal(t, "Hello, World!\n", body, target)
+	}
+
+	// Test: Invalid paths and missing files
+	for target, statusCode := range map[string]int{
+		"/missing.txt": http.StatusNotFound,
+		"/bad%zz":      http.StatusBadRequest,
+		"/nul%00.txt":  http.StatusBadRequest,
+		"/files/":      http.StatusForbidden,
+	} {
+		resp, _ = serve(t, handler, "GET", target)
+		assert.Equal(t, statusCode, resp.StatusCode, target)
+	}
+
+	// Test: Only GET and HEAD are allowed
+	resp, _ = serve(t, handler, "POST", "/hello.txt")
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	assert.Equal(t, "GET, HEAD", resp.Header.Get("Allow"))
+
+	// Test: index.html is served for a directory
+	resp, body = serve(t, handler, "GET", "/docs/")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "<h1>Docs</h1>", body)
+
+	// Test: Redirects to the canonical path
+	resp, _ = serve(t, handler, "GET", "/docs?page=2")
+	assert.Equal(t, http.StatusMovedPermanently, resp.StatusCode)
+	assert.Equal(t, "docs/?page=2", resp.Header.Get("Location"))
+
+	resp, _ = serve(t, handler, "GET", "/files/a.txt/")
+	assert.Equal(t, http.StatusMovedPermanently, resp.StatusCode)
+	assert.Equal(t, "../a.txt", resp.Header.Get("Location"))
+}
+
+func TestRedirectEscaping(t *testing.T) {
+	handler := New(fstest.MapFS{
+		"a?b/index.html":  {Data: []byte("query")},
+		"a#b/index.html":  {Data: []byte("fragment")},
+		"100%/index.html": {Data: []byte("percent")},
+		"a b/index.html":  {Data: []byte("space")},
+		"a:b/index.html":  {Data: []byte("colon")},
+		"c d.txt":         {Data: []byte("file")},
+	}, Options{})
+
+	// Test: The decoded names are escaped again in the Location header
+	for target, location := range map[string]string{
+		"/a%3Fb":      "a%3Fb/",
+		"/a%23b?x=1":  "a%23b/?x=1",
+		"/100%25":     "100%25/",
+		"/a%20b":      "a%20b/",
+		"/a:b":        "./a:b/",
+		"/c%20d.txt/": "../c%20d.txt",
+	} {
+		resp, _ := serve(t, handler, "GET", target)
+		assert.Equal(t, http.StatusMovedPermanently, resp.StatusCode, target)
+		assert.Equal(t, location, resp.Header.Get("Location"), target)
+	}
+
+	// Test: The redirects lead to the directories
+	resp, body := serve(t, handler, "GET", "/a%3Fb/")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "query", body)
+}
+
+func TestDirectoryListing(t *testing.T) {
+	handler := New(testFS, Options{Prefix: "/static/", ListDirectories: true})
+
+	resp, body := serve(t, handler, "GET", "/static/files/")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Contains(t, body, `<a href="../">../</a>`)
+	assert.Contains(t, body, `<a href="./a.txt">a.txt</a>`)
+	assert.Contains(t, body, `<a href="./nested/">nested/</a>`)
+	assert.Contains(t, body, `<a href="./sub%20dir/">sub dir/</a>`)
+
+	// Test: The names are escaped
+	assert.Contains(t, body, `<a href="./%3Cscript%3E.txt">&lt;script&gt;.txt</a>`)
+
+	// Test: Requests outside of the prefix are not found
+	resp, _ = serve(t, handler, "GET", "/hello.txt")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = serve(t, handler, "GET", "/staticfiles/a.txt")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = serve(t, handler, "GET", "/static")
+	assert.Equal(t, http.StatusMovedPermanently, resp.StatusCode)
+	assert.Equal(t, "static/", resp.Header.Get("Location"))
+}
+
+func TestNewDir(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file.txt"), []byte("inside"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0o600))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(dir, "link.txt")))
+
+	handler, err := NewDir(dir, Options{})
+	require.NoError(t, err)
+
+	resp, body := serve(t, handler, "GET", "/file.txt")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "inside", body)
+
+	// Test: Symbolic links cannot escape the directory
+	resp, body = serve(t, handler, "GET", "/link.txt")
+	assert.NotEqual(t, http.StatusOK, resp.StatusCode)
+	assert.NotContains(t, body, "secret")
+}
+
+func TestDetectContentType(t *testing.T) {
+	testCases := map[string]string{
+		"":                           "text/plain; charset=utf-8",
+		"plain text\n":               "text/plain; charset=utf-8",
+		"  <!DOCTYPE html><html>":    "text/html; charset=utf-8",
+		"%PDF-1.7":                   "application/pdf",
+		"\x00\x00\x00\x18ftypmp42":   "video/mp4",
+		"binary\x00data":             "application/octet-stream",
+		"caf\xc3\xa9":                "text/plain; charset=utf-8",
+		"truncated rune at end \xc3": "text/plain; charset=utf-8",
+		"invalid \xff utf-8":         "application/octet-stream",
+	}
+
+	for data, want := range testCases {
+		assert.Equal(t, want, detectContentType([]byte(data)), "data: %q", data)
+	}
+}
+
+func TestRanges(t *testing.T) {
+	const content = "0123456789abcdefghij"
+
+	handler := func(w *response.Writer, req *request.Request) {
+		ServeBytes(w, req, "content.txt", testModTime, []byte(content))
+	}
+
+	// Test: Full content advertises range support
+	resp, body := serve(t, handler, "GET", "/")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "bytes", resp.Header.Get("Accept-Ranges"))
+	assert.Equal(t, content, body)
+
+	// Test: Single ranges
+	for value, want := range map[string]struct {
+		body         string
+		contentRange string
+	}{
+		"bytes=0-4":      {"01234", "bytes 0-4/20"},
+		"bytes=15-":      {"fghij", "bytes 15-19/20"},
+		"bytes=-3":       {"hij", "bytes 17-19/20"},
+		"bytes=-100":     {content, "bytes 0-19/20"},
+		"bytes=18-100":   {"ij", "bytes 18-19/20"},
+		"BYTES = 5-5":    {"5", "bytes 5-5/20"},
+		"bytes=30-, 2-3": {"23", "bytes 2-3/20"},
+	} {
+		resp, body = serveWithHeaders(t, handler, "GET", "/", "Range: "+value+"\r\n")
+		assert.Equal(t, http.StatusPartialContent, resp.StatusCode, value)
+		assert.Equal(t, want.body, body, value)
+		assert.Equal(t, want.contentRange, resp.Header.Get("Content-Range"), value)
+		assert.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"), value)
+	}
+
+	// Test: Multiple ranges
+	resp, body = serveWithHeaders(t, handler, "GET", "/", "Range: bytes=0-1, 10-12, -2\r\n")
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, int64(len(body)), resp.ContentLength)
+
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/byteranges", mediaType)
+
+	reader := multipart.NewReader(strings.NewReader(body), params["boundary"])
+	parts := make(map[string]string)
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+
+		require.NoError(t, err)
+		assert.Equal(t, "text/plain; charset=utf-8", part.Header.Get("Content-Type"))
+
+		data, err := io.ReadAll(part)
+		require.NoError(t, err)
+
+		parts[part.Header.Get("Content-Range")] = string(data)
+	}
+
+	assert.Equal(
+		t,
+		map[string]string{"bytes 0-1/20": "01", "bytes 10-12/20": "abc", "bytes 18-19/20": "ij"},
+		parts,
+	)
+
+	// Test: Unsatisfiable ranges
+	for _, value := range []string{"bytes=20-", "bytes=100-200, 25-", "bytes=-0"} {
+		resp, _ = serveWithHeaders(t, handler, "GET", "/", "Range: "+value+"\r\n")
+		assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, resp.StatusCode, value)
+		assert.Equal(t, "bytes */20", resp.Header.Get("Content-Range"), value)
+	}
+
+	// Test: Invalid and ignored ranges get the full content
+	for _, extraHeaders := range []string{
+		"Range: items=0-5\r\n",
+		"Range: bytes=5-2\r\n",
+		"Range: bytes=a-b\r\n",
+		"Range: bytes=0-15, 5-19\r\n",
+		"Range: bytes=0-4\r\nIf-Range: Thu, 01 Jan 2025 00:00:00 GMT\r\n",
+		"Range: bytes=0-4\r\nIf-Range: \"etag\"\r\n",
+	} {
+		resp, body = serveWithHeaders(t, handler, "GET", "/", extraHeaders)
+		assert.Equal(t, http.StatusOK, resp.StatusCode, extraHeaders)
+		assert.Equal(t, content, body, extraHeaders)
+	}
+
+	// Test: If-Range with the modification time of the content
+	resp, body = serveWithHeaders(
+		t,
+		handler,
+		"GET",
+		"/",
+		"Range: bytes=0-4\r\nIf-Range: "+testModTime.Format(headers.TimeFormat)+"\r\n",
+	)
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, "01234", body)
+
+	// Test: Ranges from a file
+	resp, body = serveWithHeaders(t, New(testFS, Options{}), "GET", "/hello.txt", "Range: bytes=7-11\r\n")
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, "World", body)
+}
+
+func TestConditionalRequests(t *testing.T) {
+	handler := New(testFS, Options{})
+
+	resp, _ := serve(t, handler, "GET", "/hello.txt")
+	etag := resp.Header.Get("ETag")
+	lastModified := resp.Header.Get("Last-Modified")
+	require.NotEmpty(t, etag)
+	assert.False(t, strings.HasPrefix(etag, "W/"))
+
+	// Test: The validators from the previous response
+	for _, extraHeaders := range []string{
+		"If-None-Match: " + etag + "\r\n",
+		"If-Modified-Since: " + lastModified + "\r\n",
+	} {
+		resp, body := serveWithHeaders(t, handler, "GET", "/hello.txt", extraHeaders)
+		assert.Equal(t, http.StatusNotModified, resp.StatusCode, extraHeaders)
+		assert.Equal(t, etag, resp.Header.Get("ETag"), extraHeaders)
+		assert.Empty(t, body, extraHeaders)
+	}
+
+	// Test: A failed If-Match precondition
+	resp, _ = serveWithHeaders(t, handler, "GET", "/hello.txt", "If-Match: \"stale\"\r\n")
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+
+	// Test: If-Range with the ETag
+	resp, body := serveWithHeaders(t, handler, "GET", "/hello.txt", "Range: bytes=0-4\r\nIf-Range: "+etag+"\r\n")
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, "Hello", body)
+
+	// Test: The ETag of a byte slice is derived from the data
+	bytesHandler := func(data string) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			ServeBytes(w, req, "data.txt", time.Time{}, []byte(data))
+		}
+	}
+
+	resp, _ = serve(t, bytesHandler("first"), "GET", "/")
+	etag = resp.Header.Get("ETag")
+	assert.Empty(t, resp.Header.Get("Last-Modified"))
+
+	resp, _ = serveWithHeaders(t, bytesHandler("first"), "GET", "/", "If-None-Match: "+etag+"\r\n")
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	resp, _ = serveWithHeaders(t, bytesHandler("second"), "GET", "/", "If-None-Match: "+etag+"\r\n")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
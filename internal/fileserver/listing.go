@@ -0,0 +1,99 @@
+package fileserver
+
+import (
+	"bytes"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"net/url"
+
+	"http-from-tcp/internal/request"
+	"http-from-tcp/internal/response"
+)
+
+const listingTemplate = `<!DOCTYPE html>
+<html>
+  <head>
+    <meta charset="utf-8">
+    <title>Index of {{ .Path }}</title>
+  </head>
+  <body>
+    <h1>Index of {{ .Path }}</h1>
+    <ul>
+{{- if .Parent }}
+      <li><a href="../">../</a></li>
+{{- end }}
+{{- range .Entries }}
+      <li><a href="{{ .Href }}">{{ .Name }}</a></li>
+{{- end }}
+    </ul>
+  </body>
+</html>
+`
+
+var listing = template.Must(template.New("listing").Parse(listingTemplate))
+
+type listingEntry struct {
+	Name string
+	Href string
+}
+
+// serveDirectory writes an HTML page that lists the entries of the directory.
+func serveDirectory(w *response.Writer, req *request.Request, fsys fs.FS, name, urlPath string) {
+	dirEntries, err := fs.ReadDir(fsys, name)
+	if err != nil {
+		writeError(w, errorStatusCode(err))
+
+		return
+	}
+
+	entries := make([]listingEntry, len(dirEntries))
+
+	for idx, entry := range dirEntries {
+		entryName := entry.Name()
+
+		// The ./ prefix stops a name with a colon being read as a URL scheme.
+		href := "./" + url.PathEscape(entryName)
+
+		if entry.IsDir() {
+			entryName += "/"
+			href += "/"
+		}
+
+		entries[idx] = listingEntry{Name: entryName, Href: href}
+	}
+
+	data := struct {
+		Path    string
+		Parent  bool
+		Entries []listingEntry
+	}{
+		Path:    urlPath,
+		Parent:  urlPath != "/",
+		Entries: entries,
+	}
+
+	buf := new(bytes.Buffer)
+
+	if err := listing.Execute(buf, data); err != nil {
+		slog.Error("error executing the directory listing template.", "error", err.Error())
+		writeError(w, response.StatusCodeServerError)
+
+		return
+	}
+
+	h := response.GetDefaultHeaders(buf.Len())
+	if err := response.SetContentType(h, "text/html", "utf-8"); err != nil {
+		slog.Error("error setting the content type.", "error", err.Error())
+		writeError(w, response.StatusCodeServerError)
+
+		return
+	}
+
+	body := buf.Bytes()
+	if req.RequestLine.Method == "HEAD" {
+		body = nil
+	}
+
+	writeResponse(w, response.StatusCodeOK, h, body)
+}
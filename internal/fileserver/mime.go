@@ -0,0 +1,103 @@
+package fileserver
+
+import (
+	"bytes"
+	"mime"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// sniffLen is the number of bytes that are used to detect the content type.
+const sniffLen int64 = 512
+
+// contentTypes are the content types for the common extensions that are not in
+// the built-in table of the mime package.
+var contentTypes = map[string]string{
+	".txt":   "text/plain; charset=utf-8",
+	".md":    "text/markdown; charset=utf-8",
+	".ico":   "image/x-icon",
+	".mp4":   "video/mp4",
+	".webm":  "video/webm",
+	".mp3":   "audio/mpeg",
+	".ogg":   "audio/ogg",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".zip":   "application/zip",
+	".gz":    "application/gzip",
+}
+
+// signatures are the magic numbers at the start of the common binary formats.
+var signatures = []struct {
+	offset      int
+	magic       []byte
+	contentType string
+}{
+	{0, []byte("\x89PNG\r\n\x1a\n"), "image/png"},
+	{0, []byte("\xff\xd8\xff"), "image/jpeg"},
+	{0, []byte("GIF87a"), "image/gif"},
+	{0, []byte("GIF89a"), "image/gif"},
+	{0, []byte("%PDF-"), "application/pdf"},
+	{0, []byte("\x1f\x8b\x08"), "application/gzip"},
+	{0, []byte("PK\x03\x04"), "application/zip"},
+	{0, []byte("\x00asm"), "application/wasm"},
+	{4, []byte("ftyp"), "video/mp4"},
+}
+
+// contentTypeByExtension returns the content type for the extension of the name.
+// An empty string is returned if the extension is not known.
+func contentTypeByExtension(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if ext == "" {
+		return ""
+	}
+
+	if contentType, ok := contentTypes[ext]; ok {
+		return contentType
+	}
+
+	return mime.TypeByExtension(ext)
+}
+
+// detectContentType detects the content type from the first bytes of the content.
+// Content that is not recognised is text/plain if it is valid UTF-8 text and
+// application/octet-stream otherwise.
+func detectContentType(data []byte) string {
+	for _, signature := range signatures {
+		if len(data) >= signature.offset+len(signature.magic) &&
+			bytes.Equal(data[signature.offset:signature.offset+len(signature.magic)], signature.magic) {
+			return signature.contentType
+		}
+	}
+
+	trimmed := bytes.ToLower(bytes.TrimLeft(data, " \t\r\n"))
+	if bytes.HasPrefix(trimmed, []byte("<!doctype html")) || bytes.HasPrefix(trimmed, []byte("<html")) {
+		return "text/html; charset=utf-8"
+	}
+
+	if isText(data) {
+		return "text/plain; charset=utf-8"
+	}
+
+	return "application/octet-stream"
+}
+
+// isText returns true if the data is UTF-8 text without any control characters other
+// than the whitespace characters. A rune that is cut off at the end of the data is
+// ignored as the data is only the start of the content.
+func isText(data []byte) bool {
+	for len(data) > 0 {
+		char, size := utf8.DecodeRune(data)
+
+		switch {
+		case char == utf8.RuneError && size == 1:
+			return !utf8.FullRune(data)
+		case char < ' ' && char != '\t' && char != '\n' && char != '\r' && char != '\f', char == 0x7F:
+			return false
+		}
+
+		data = data[size:]
+	}
+
+	return true
+}
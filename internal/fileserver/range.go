@@ -0,0 +1,311 @@
+package fileserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"http-from-tcp/internal/headers"
+	"http-from-tcp/internal/request"
+	"http-from-tcp/internal/response"
+)
+
+// maxRanges is the maximum number of ranges in a Range header. A request with more
+// ranges gets the full content.
+const maxRanges int = 100
+
+var (
+	errInvalidRange       = errors.New("invalid range")
+	errUnsatisfiableRange = errors.New("unsatisfiable range")
+)
+
+// section is the part of a seekable content that is served.
+type section struct {
+	content io.ReadSeeker
+	offset  int64
+	size    int64
+}
+
+// copyRange copies the range of the section to dst.
+func (s section) copyRange(dst io.Writer, r byteRange) error {
+	if _, err := s.content.Seek(s.offset+r.start, io.SeekStart); err != nil {
+		return err
+	}
+
+	return copyBuffer(dst, io.LimitReader(s.content, r.length))
+}
+
+// byteRange is a satisfiable range of the content.
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return "bytes " +
+		strconv.FormatInt(r.start, 10) +
+		"-" +
+		strconv.FormatInt(r.start+r.length-1, 10) +
+		"/" +
+		strconv.FormatInt(size, 10)
+}
+
+// serveRanges serves the content or the ranges of the content that the client asked
+// for in the Range header (RFC 9110, section 14). A single range is served as the
+// body of a 206 Partial Content response and multiple ranges are served as a
+// multipart/byteranges body. A 416 Range Not Satisfiable response is written if none
+// of the ranges overlap the content.
+func serveRanges(
+	w *response.Writer,
+	req *request.Request,
+	contentType string,
+	v validators,
+	content section,
+) {
+	h := contentHeaders(contentType, v)
+	h[response.HeaderAcceptRanges] = "bytes"
+
+	ranges, err := requestedRanges(req, v, content.size)
+
+	switch {
+	case errors.Is(err, errUnsatisfiableRange):
+		body := []byte(response.StatusText(response.StatusCodeRangeNotSatisfiable) + "\n")
+
+		h := response.GetDefaultHeaders(len(body))
+		h[response.HeaderContentRange] = "bytes */" + strconv.FormatInt(content.size, 10)
+
+		writeResponse(w, response.StatusCodeRangeNotSatisfiable, h, body)
+	case len(ranges) == 0:
+		h[response.HeaderContentLength] = strconv.FormatInt(content.size, 10)
+
+		writeContent(w, req, response.StatusCodeOK, h, func(dst io.Writer) error {
+			return content.copyRange(dst, byteRange{start: 0, length: content.size})
+		})
+	case len(ranges) == 1:
+		h[response.HeaderContentLength] = strconv.FormatInt(ranges[0].length, 10)
+		h[response.HeaderContentRange] = ranges[0].contentRange(content.size)
+
+		writeContent(w, req, response.StatusCodePartialContent, h, func(dst io.Writer) error {
+			return content.copyRange(dst, ranges[0])
+		})
+	default:
+		serveMultipartRanges(w, req, h, contentType, content, ranges)
+	}
+}
+
+// serveMultipartRanges serves the ranges as a multipart/byteranges body
+// (RFC 9110, section 14.6).
+func serveMultipartRanges(
+	w *response.Writer,
+	req *request.Request,
+	h headers.Headers,
+	contentType string,
+	content section,
+	ranges []byteRange,
+) {
+	boundary, err := newBoundary()
+	if err != nil {
+		writeError(w, response.StatusCodeServerError)
+
+		return
+	}
+
+	// The part headers are prepared in advance so that the length of the body
+	// is known before it is written.
+	partHeaders := make([]string, len(ranges))
+	length := int64(0)
+
+	for idx, r := range ranges {
+		partHeaders[idx] = "\r\n--" + boundary + "\r\n" +
+			response.HeaderContentType + ": " + contentType + "\r\n" +
+			response.HeaderContentRange + ": " + r.contentRange(content.size) + "\r\n" +
+			"\r\n"
+
+		length += int64(len(partHeaders[idx])) + r.length
+	}
+
+	closingDelimiter := "\r\n--" + boundary + "--\r\n"
+	length += int64(len(closingDelimiter))
+
+	h[response.HeaderContentType] = "multipart/byteranges; boundary=" + boundary
+	h[response.HeaderContentLength] = strconv.FormatInt(length, 10)
+
+	writeContent(w, req, response.StatusCodePartialContent, h, func(dst io.Writer) error {
+		for idx, r := range ranges {
+			if _, err := io.WriteString(dst, partHeaders[idx]); err != nil {
+				return err
+			}
+
+			if err := content.copyRange(dst, r); err != nil {
+				return err
+			}
+		}
+
+		_, err := io.WriteString(dst, closingDelimiter)
+
+		return err
+	})
+}
+
+// requestedRanges returns the ranges from the Range header of the request. No ranges
+// are returned if the full content should be served. The Range header is ignored for
+// methods other than GET, if it is invalid or if the If-Range precondition fails.
+func requestedRanges(req *request.Request, v validators, size int64) ([]byteRange, error) {
+	value := req.Headers.Get("range")
+	if value == "" || req.RequestLine.Method != "GET" {
+		return nil, nil
+	}
+
+	if ifRange := req.Headers.Get("if-range"); ifRange != "" && !ifRangeMatches(ifRange, v) {
+		return nil, nil
+	}
+
+	ranges, err := parseRange(value, size)
+	if errors.Is(err, errInvalidRange) {
+		return nil, nil
+	}
+
+	return ranges, err
+}
+
+// ifRangeMatches returns true if the If-Range header matches the content. An entity
+// tag must match the ETag of the content with the strong comparison and a date must
+// exactly match the modification time (RFC 9110, section 13.1.5).
+func ifRangeMatches(ifRange string, v validators) bool {
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		return response.ETagsMatch(ifRange, v.etag, true, true)
+	}
+
+	if v.modTime.IsZero() {
+		return false
+	}
+
+	date, err := headers.ParseTime(ifRange)
+	if err != nil {
+		return false
+	}
+
+	return v.modTime.UTC().Truncate(time.Second).Equal(date)
+}
+
+// parseRange parses the value of the Range header (RFC 9110, section 14.1.2).
+// The ranges that start after the end of the content are dropped and
+// errUnsatisfiableRange is returned if no ranges are left.
+func parseRange(value string, size int64) ([]byteRange, error) {
+	unit, set, found := strings.Cut(value, "=")
+	if !found || !strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+		return nil, errInvalidRange
+	}
+
+	var (
+		ranges    = make([]byteRange, 0, 1)
+		numRanges = 0
+		total     = int64(0)
+	)
+
+	for spec := range strings.SplitSeq(set, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		numRanges++
+		if numRanges > maxRanges {
+			return nil, errInvalidRange
+		}
+
+		first, last, found := strings.Cut(spec, "-")
+		if !found {
+			return nil, errInvalidRange
+		}
+
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+		if first == "" {
+			// A suffix range (e.g. -500 for the last 500 bytes).
+			suffixLength, ok := parseRangeInt(last)
+			if !ok {
+				return nil, errInvalidRange
+			}
+
+			if suffixLength == 0 || size == 0 {
+				continue
+			}
+
+			suffixLength = min(suffixLength, size)
+			ranges = append(ranges, byteRange{start: size - suffixLength, length: suffixLength})
+			total += suffixLength
+
+			continue
+		}
+
+		start, ok := parseRangeInt(first)
+		if !ok {
+			return nil, errInvalidRange
+		}
+
+		end := size - 1
+
+		if last != "" {
+			lastPos, ok := parseRangeInt(last)
+			if !ok || lastPos < start {
+				return nil, errInvalidRange
+			}
+
+			end = min(lastPos, size-1)
+		}
+
+		if start >= size {
+			continue
+		}
+
+		ranges = append(ranges, byteRange{start: start, length: end - start + 1})
+		total += end - start + 1
+	}
+
+	switch {
+	case numRanges == 0:
+		return nil, errInvalidRange
+	case len(ranges) == 0:
+		return nil, errUnsatisfiableRange
+	case len(ranges) > 1 && total > size:
+		// Overlapping ranges that add up to more than the content are served
+		// as the full content rather than amplifying the response.
+		return nil, errInvalidRange
+	}
+
+	return ranges, nil
+}
+
+// parseRangeInt parses a non-negative integer from a range. The length is limited
+// so that the integer cannot overflow.
+func parseRangeInt(value string) (int64, bool) {
+	if value == "" || len(value) > 18 {
+		return 0, false
+	}
+
+	for _, char := range value {
+		if char < '0' || char > '9' {
+			return 0, false
+		}
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+
+	return n, err == nil
+}
+
+// newBoundary returns a random boundary for a multipart body.
+func newBoundary() (string, error) {
+	boundary := make([]byte, 16)
+
+	if _, err := rand.Read(boundary); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(boundary), nil
+}
@@ -0,0 +1,212 @@
+package headers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SameSite is the value of the SameSite attribute of a cookie.
+type SameSite int
+
+const (
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	SameSiteNone
+)
+
+// Cookie is a cookie received in the Cookie header or sent in the Set-Cookie header
+// (RFC 6265). Only the name and the value are set for a received cookie.
+type Cookie struct {
+	Name  string
+	Value string
+
+	Domain string
+	Path   string
+
+	// Expires is omitted if it is the zero time.
+	Expires time.Time
+
+	// MaxAge is omitted if it is 0. A negative value asks the client to
+	// delete the cookie immediately (Max-Age=0).
+	MaxAge int
+
+	Secure      bool
+	HttpOnly    bool
+	Partitioned bool
+	SameSite    SameSite
+}
+
+// ParseCookies parses the value of the Cookie header. Invalid cookies are ignored.
+func ParseCookies(value string) []Cookie {
+	cookies := make([]Cookie, 0)
+
+	for pair := range strings.SplitSeq(value, ";") {
+		name, cookieValue, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || !isValidCookieName(name) {
+			continue
+		}
+
+		cookieValue, ok := parseCookieValue(cookieValue)
+		if !ok {
+			continue
+		}
+
+		cookies = append(cookies, Cookie{Name: name, Value: cookieValue})
+	}
+
+	return cookies
+}
+
+// Validate validates the cookie for use in the Set-Cookie header. This includes the
+// requirements of the SameSite=None, Partitioned and the __Secure- and __Host- prefixes.
+func (c Cookie) Validate() error {
+	if !isValidCookieName(c.Name) {
+		return fmt.Errorf("invalid cookie name: %q", c.Name)
+	}
+
+	if _, ok := parseCookieValue(c.Value); !ok {
+		return fmt.Errorf("invalid value for the cookie %q", c.Name)
+	}
+
+	if c.Domain != "" && !isValidCookieDomain(c.Domain) {
+		return fmt.Errorf("invalid domain for the cookie %q: %q", c.Name, c.Domain)
+	}
+
+	if strings.ContainsFunc(c.Path, func(char rune) bool { return char < ' ' || char == 0x7F || char == ';' }) {
+		return fmt.Errorf("invalid path for the cookie %q: %q", c.Name, c.Path)
+	}
+
+	if !c.Expires.IsZero() && c.Expires.Year() < 1601 {
+		return fmt.Errorf("invalid expiry date for the cookie %q", c.Name)
+	}
+
+	if c.SameSite < SameSiteDefault || c.SameSite > SameSiteNone {
+		return fmt.Errorf("invalid SameSite value for the cookie %q", c.Name)
+	}
+
+	switch {
+	case c.SameSite == SameSiteNone && !c.Secure:
+		return errors.New("a cookie with SameSite=None must be Secure")
+	case c.Partitioned && !c.Secure:
+		return errors.New("a Partitioned cookie must be Secure")
+	case strings.HasPrefix(c.Name, "__Secure-") && !c.Secure:
+		return errors.New("a cookie with the __Secure- prefix must be Secure")
+	case strings.HasPrefix(c.Name, "__Host-") && (!c.Secure || c.Path != "/" || c.Domain != ""):
+		return errors.New("a cookie with the __Host- prefix must be Secure with the path / and without a domain")
+	}
+
+	return nil
+}
+
+// String returns the cookie formatted for the Set-Cookie header. The cookie should
+// be validated with Validate first.
+func (c Cookie) String() string {
+	var builder strings.Builder
+
+	builder.WriteString(c.Name + "=" + c.Value)
+
+	if c.Domain != "" {
+		builder.WriteString("; Domain=" + strings.TrimPrefix(c.Domain, "."))
+	}
+
+	if c.Path != "" {
+		builder.WriteString("; Path=" + c.Path)
+	}
+
+	if !c.Expires.IsZero() {
+		builder.WriteString("; Expires=" + c.Expires.UTC().Format(TimeFormat))
+	}
+
+	switch {
+	case c.MaxAge > 0:
+		builder.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	case c.MaxAge < 0:
+		builder.WriteString("; Max-Age=0")
+	}
+
+	if c.Secure {
+		builder.WriteString("; Secure")
+	}
+
+	if c.HttpOnly {
+		builder.WriteString("; HttpOnly")
+	}
+
+	switch c.SameSite {
+	case SameSiteLax:
+		builder.WriteString("; SameSite=Lax")
+	case SameSiteStrict:
+		builder.WriteString("; SameSite=Strict")
+	case SameSiteNone:
+		builder.WriteString("; SameSite=None")
+	}
+
+	if c.Partitioned {
+		builder.WriteString("; Partitioned")
+	}
+
+	return builder.String()
+}
+
+func isValidCookieName(name string) bool {
+	return name != "" && strings.IndexFunc(name, isNotTokenRune) == -1
+}
+
+// parseCookieValue returns the cookie value without the (optional) surrounding quotes.
+// false is returned if the value contains characters that are not allowed.
+func parseCookieValue(value string) (string, bool) {
+	if len(value) > 1 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+
+	for idx := range len(value) {
+		if !isCookieOctet(value[idx]) {
+			return "", false
+		}
+	}
+
+	return value, true
+}
+
+// isCookieOctet returns true if the character is allowed in a cookie value
+// (RFC 6265, section 4.1.1).
+func isCookieOctet(char byte) bool {
+	return char == 0x21 ||
+		(char >= 0x23 && char <= 0x2B) ||
+		(char >= 0x2D && char <= 0x3A) ||
+		(char >= 0x3C && char <= 0x5B) ||
+		(char >= 0x5D && char <= 0x7E)
+}
+
+// isValidCookieDomain returns true if the domain is a valid host name. A leading dot
+// is allowed as it is ignored by the clients.
+func isValidCookieDomain(domain string) bool {
+	domain = strings.TrimPrefix(domain, ".")
+
+	if domain == "" || len(domain) > 255 {
+		return false
+	}
+
+	for label := range strings.SplitSeq(domain, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+
+		for idx := range len(label) {
+			char := label[idx]
+
+			if (char < 'a' || char > 'z') &&
+				(char < 'A' || char > 'Z') &&
+				(char < '0' || char > '9') &&
+				char != '-' {
+				return false
+			}
+		}
+	}
+
+	return true
+}
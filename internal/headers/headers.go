@@ -1,9 +1,10 @@
 package headers
 
 import (
+	"bytes"
 	"fmt"
-	"regexp"
 	"strings"
+	"time"
 )
 
 type Headers map[string]string
@@ -14,48 +15,60 @@ func NewHeaders() Headers {
 	return Headers(headers)
 }
 
-const (
-	crlf                 string = "\r\n"
-	headerValidationRule string = "^ *[A-z0-9!#$%&'*+.^_`|~-]*: *[^\\s]* *$"
-)
+const crlf string = "\r\n"
+
+// TimeFormat is the format of the dates in the headers (the IMF-fixdate format from
+// RFC 9110, section 5.6.7). The time must be in UTC.
+const TimeFormat string = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// obsoleteTimeFormats are the obsolete date formats that must still be accepted in the
+// received headers (the RFC 850 and the asctime formats).
+var obsoleteTimeFormats = []string{
+	"Monday, 02-Jan-06 15:04:05 GMT",
+	"Mon Jan _2 15:04:05 2006",
+}
 
+// ParseTime parses a date from a header. Both the preferred and the obsolete
+// date formats are accepted.
+func ParseTime(value string) (time.Time, error) {
+	parsed, err := time.Parse(TimeFormat, value)
+	if err == nil {
+		return parsed, nil
+	}
+
+	for _, format := range obsoleteTimeFormats {
+		if parsed, err := time.Parse(format, value); err == nil {
+			return parsed, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid date: %q", value)
+}
+
+// Parse parses a single header line from the start of data. The header line is valid
+// if the field name is a non-empty token immediately followed by the colon and the
+// field value does not contain any control characters other than horizontal tabs.
+// Parse does not keep a reference to data.
 func (h Headers) Parse(data []byte) (n int, done bool, err error) {
-	if !strings.Contains(string(data), crlf) {
+	idx := bytes.Index(data, []byte(crlf))
+	if idx == -1 {
 		// More data required.
 		return 0, false, nil
 	}
 
-	if strings.HasPrefix(string(data), crlf) {
+	if idx == 0 {
 		// We are done parsing the headers in this request.
 		return 0, true, nil
 	}
 
-	parts := strings.SplitN(string(data), crlf, 2)
-	if len(parts) != 2 {
-		return 0, false, fmt.Errorf(
-			"unexpected number of parts found after extracting the header from %q: want 2, got %d",
-			string(data),
-			len(parts),
-		)
-	}
-
-	header := parts[0]
-
-	if err := validateHeader(header); err != nil {
-		return 0, false, fmt.Errorf("header validation error: %w", err)
-	}
-
-	key, value, err := extractHeader(header)
+	key, value, err := parseHeader(data[:idx])
 	if err != nil {
-		return 0, false, fmt.Errorf(
-			"header extraction error: %w",
-			err,
-		)
+		return 0, false, fmt.Errorf("header validation error: %w", err)
 	}
 
 	h.Add(key, value)
 
-	return len([]byte(header)) + len([]byte(crlf)), false, nil
+	return idx + len(crlf), false, nil
 }
 
 func (h Headers) Get(key string) string {
@@ -82,28 +95,143 @@ func (h Headers) Delete(key string) {
 	delete(h, key)
 }
 
-func validateHeader(header string) error {
-	pattern := regexp.MustCompile(headerValidationRule)
+// HasToken returns true if the comma separated list of the header contains the token.
+// The token is compared case-insensitively.
+func (h Headers) HasToken(key, token string) bool {
+	for value := range strings.SplitSeq(h.Get(key), ",") {
+		if strings.EqualFold(strings.TrimSpace(value), token) {
+			return true
+		}
+	}
+
+	return false
+}
 
-	if !pattern.MatchString(header) {
-		return fmt.Errorf("invalid header: %s", header)
+// parseHeader validates the header line and extracts the lower case key and the
+// value. The line is scanned byte by byte so that the only allocations are for
+// the returned strings.
+func parseHeader(header []byte) (key string, value string, err error) {
+	name, fieldValue, err := splitField(header)
+	if err != nil {
+		return "", "", err
+	}
+
+	return lowerKey(name), string(fieldValue), nil
+}
+
+// splitField validates the header line and returns the field name and the value
+// without the surrounding whitespace. Both point into the line.
+func splitField(header []byte) (name []byte, value []byte, err error) {
+	// Leading spaces before the field name are tolerated.
+	start := 0
+	for start < len(header) && header[start] == ' ' {
+		start++
+	}
+
+	colon := bytes.IndexByte(header, ':')
+	if colon <= start {
+		return nil, nil, fmt.Errorf("invalid header: %s", header)
+	}
+
+	name = header[start:colon]
+
+	for idx := range name {
+		if !IsTokenChar(name[idx]) {
+			return nil, nil, fmt.Errorf("invalid header: %s", header)
+		}
+	}
+
+	value = header[colon+1:]
+
+	for idx := range value {
+		if char := value[idx]; (char < ' ' && char != '\t') || char == 0x7F {
+			return nil, nil, fmt.Errorf("invalid header: %s", header)
+		}
+	}
+
+	return name, bytes.Trim(value, " \t"), nil
+}
+
+// ValidateField returns an error if the field cannot be written as a header line. The
+// name must be a non-empty token and the value must not contain any control characters
+// other than horizontal tabs (a CR or a LF would start a new line).
+func ValidateField(name, value string) error {
+	if name == "" {
+		return fmt.Errorf("empty field name")
+	}
+
+	for idx := range len(name) {
+		if !IsTokenChar(name[idx]) {
+			return fmt.Errorf("invalid field name: %q", name)
+		}
+	}
+
+	for idx := range len(value) {
+		if char := value[idx]; (char < ' ' && char != '\t') || char == 0x7F {
+			return fmt.Errorf("invalid value of the field %s: %q", name, value)
+		}
 	}
 
 	return nil
 }
 
-func extractHeader(header string) (key string, value string, err error) {
-	parts := strings.SplitN(header, ":", 2)
+// IsTokenChar returns true if the character is allowed in a token (RFC 9110, section 5.6.2).
+func IsTokenChar(char byte) bool {
+	switch {
+	case char >= 'a' && char <= 'z',
+		char >= 'A' && char <= 'Z',
+		char >= '0' && char <= '9':
+		return true
+	default:
+		return strings.IndexByte("!#$%&'*+-.^_`|~", char) != -1
+	}
+}
+
+// commonKeys contains the lower case names of the most common headers so that
+// these keys can be used without allocating a new string.
+var commonKeys = map[string]string{
+	"accept":            "accept",
+	"accept-encoding":   "accept-encoding",
+	"accept-language":   "accept-language",
+	"authorization":     "authorization",
+	"cache-control":     "cache-control",
+	"connection":        "connection",
+	"content-encoding":  "content-encoding",
+	"content-length":    "content-length",
+	"content-type":      "content-type",
+	"cookie":            "cookie",
+	"host":              "host",
+	"if-modified-since": "if-modified-since",
+	"if-none-match":     "if-none-match",
+	"origin":            "origin",
+	"range":             "range",
+	"referer":           "referer",
+	"transfer-encoding": "transfer-encoding",
+	"upgrade":           "upgrade",
+	"user-agent":        "user-agent",
+}
+
+// lowerKey returns the field name in lower case.
+func lowerKey(name []byte) string {
+	var buf [32]byte
+
+	if len(name) > len(buf) {
+		return strings.ToLower(string(name))
+	}
+
+	lower := buf[:len(name)]
+
+	for idx, char := range name {
+		if char >= 'A' && char <= 'Z' {
+			char += 'a' - 'A'
+		}
 
-	if len(parts) != 2 {
-		return "", "", fmt.Errorf(
-			"unexpected number of parts found after extracting the header: want 2, got %d",
-			len(parts),
-		)
+		lower[idx] = char
 	}
 
-	key = strings.TrimSpace(strings.ToLower(parts[0]))
-	value = strings.TrimSpace(parts[1])
+	if key, ok := commonKeys[string(lower)]; ok {
+		return key
+	}
 
-	return key, value, err
+	return string(lower)
 }
@@ -2,6 +2,7 @@ package headers
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -53,6 +54,31 @@ func TestHeaders(t *testing.T) {
 	assert.Equal(t, 28, n)
 	assert.False(t, done)
 
+	// Test: Valid single header with whitespace inside the value
+	headers = NewHeaders()
+	data = []byte("User-Agent: Mozilla/5.0 (X11; Linux x86_64)\r\n\r\n")
+	n, done, err = headers.Parse(data)
+	require.NoError(t, err)
+	assert.Equal(t, "Mozilla/5.0 (X11; Linux x86_64)", headers.Get("user-agent"))
+	assert.Equal(t, 45, n)
+	assert.False(t, done)
+
+	// Test: Invalid control character in header value
+	headers = NewHeaders()
+	data = []byte("Host: local\x00host\r\n\r\n")
+	n, done, err = headers.Parse(data)
+	require.Error(t, err)
+	assert.Equal(t, 0, n)
+	assert.False(t, done)
+
+	// Test: Invalid empty header key
+	headers = NewHeaders()
+	data = []byte(": localhost:42069\r\n\r\n")
+	n, done, err = headers.Parse(data)
+	require.Error(t, err)
+	assert.Equal(t, 0, n)
+	assert.False(t, done)
+
 	// Test: Invalid spacing header
 	headers = NewHeaders()
 	data = []byte("       Host : localhost:42069       \r\n\r\n")
@@ -69,3 +95,170 @@ func TestHeaders(t *testing.T) {
 	assert.Equal(t, 0, n)
 	assert.False(t, done)
 }
+
+func TestMediaType(t *testing.T) {
+	// Test: Media type without parameters
+	mediaType, err := ParseMediaType("text/html")
+	require.NoError(t, err)
+	assert.Equal(t, "text", mediaType.Type)
+	assert.Equal(t, "html", mediaType.Subtype)
+	assert.Empty(t, mediaType.Params)
+	assert.Equal(t, "text/html", mediaType.String())
+
+	// Test: Media type with parameters is normalised
+	mediaType, err = ParseMediaType("Text/HTML ; Charset=UTF-8;  foo=bar")
+	require.NoError(t, err)
+	assert.Equal(t, "text/html", mediaType.Essence())
+	assert.Equal(t, map[string]string{"charset": "utf-8", "foo": "bar"}, mediaType.Params)
+	assert.Equal(t, "text/html; charset=utf-8; foo=bar", mediaType.String())
+
+	// Test: Quoted parameter values
+	mediaType, err = ParseMediaType(`multipart/form-data; boundary="simple boundary; \"quoted\""`)
+	require.NoError(t, err)
+	assert.Equal(t, `simple boundary; "quoted"`, mediaType.Params["boundary"])
+	assert.Equal(t, `multipart/form-data; boundary="simple boundary; \"quoted\""`, mediaType.String())
+
+	// Test: Building a media type
+	mediaType, err = NewMediaType("application/json", map[string]string{"Charset": "utf-8"})
+	require.NoError(t, err)
+	assert.Equal(t, "application/json; charset=utf-8", mediaType.String())
+
+	_, err = NewMediaType("application/json", map[string]string{"char set": "utf-8"})
+	require.Error(t, err)
+
+	// Test: Invalid media types
+	_, err = ParseMediaType("")
+	require.ErrorIs(t, err, ErrNoMediaType)
+
+	for _, value := range []string{
+		"text",
+		"text/",
+		"/html",
+		"te xt/html",
+		"text/html; charset",
+		"text/html; charset=",
+		"text/html; charset=utf-8; charset=utf-16",
+		`text/html; charset="utf-8`,
+		"text/html; charset=utf 8",
+	} {
+		_, err = ParseMediaType(value)
+		require.Error(t, err, "media type: %q", value)
+	}
+}
+
+func TestCookies(t *testing.T) {
+	// Test: Cookie header
+	cookies := ParseCookies(`session=abc123; theme="dark"; invalid name=1; empty=; bad=a,b;lang=en-GB`)
+	assert.Equal(
+		t,
+		[]Cookie{
+			{Name: "session", Value: "abc123"},
+			{Name: "theme", Value: "dark"},
+			{Name: "empty", Value: ""},
+			{Name: "lang", Value: "en-GB"},
+		},
+		cookies,
+	)
+
+	// Test: Set-Cookie with all attributes
+	cookie := Cookie{
+		Name:        "__Host-session",
+		Value:       "abc123",
+		Path:        "/",
+		Expires:     time.Date(2030, time.January, 2, 15, 4, 5, 0, time.UTC),
+		MaxAge:      3600,
+		Secure:      true,
+		HttpOnly:    true,
+		Partitioned: true,
+		SameSite:    SameSiteNone,
+	}
+	require.NoError(t, cookie.Validate())
+	assert.Equal(
+		t,
+		"__Host-session=abc123; Path=/; Expires=Wed, 02 Jan 2030 15:04:05 GMT; Max-Age=3600; Secure; HttpOnly; SameSite=None; Partitioned",
+		cookie.String(),
+	)
+
+	// Test: Set-Cookie that deletes the cookie
+	cookie = Cookie{Name: "theme", Value: "", Domain: ".example.com", MaxAge: -1, SameSite: SameSiteLax}
+	require.NoError(t, cookie.Validate())
+	assert.Equal(t, "theme=; Domain=example.com; Max-Age=0; SameSite=Lax", cookie.String())
+
+	// Test: Invalid cookies
+	for _, cookie := range []Cookie{
+		{Name: "", Value: "value"},
+		{Name: "na me", Value: "value"},
+		{Name: "name", Value: "semi;colon"},
+		{Name: "name", Value: "space value"},
+		{Name: "name", Value: "value", Domain: "exa_mple.com"},
+		{Name: "name", Value: "value", Domain: "-example.com"},
+		{Name: "name", Value: "value", Path: "/a;b"},
+		{Name: "name", Value: "value", Expires: time.Date(1600, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{Name: "name", Value: "value", SameSite: SameSiteNone},
+		{Name: "name", Value: "value", Partitioned: true},
+		{Name: "__Secure-name", Value: "value"},
+		{Name: "__Host-name", Value: "value", Secure: true, Path: "/", Domain: "example.com"},
+		{Name: "__Host-name", Value: "value", Secure: true, Path: "/admin"},
+	} {
+		require.Error(t, cookie.Validate(), "cookie: %+v", cookie)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	want := time.Date(1994, time.November, 6, 8, 49, 37, 0, time.UTC)
+
+	for _, value := range []string{
+		"Sun, 06 Nov 1994 08:49:37 GMT",
+		"Sunday, 06-Nov-94 08:49:37 GMT",
+		"Sun Nov  6 08:49:37 1994",
+	} {
+		parsed, err := ParseTime(value)
+		require.NoError(t, err, value)
+		assert.True(t, want.Equal(parsed), value)
+	}
+
+	_, err := ParseTime("06/11/1994")
+	require.Error(t, err)
+}
+
+func TestValidateField(t *testing.T) {
+	// Test: Valid fields
+	assert.NoError(t, ValidateField("Content-Type", "text/plain; charset=utf-8"))
+	assert.NoError(t, ValidateField("X-Empty", ""))
+	assert.NoError(t, ValidateField("X-Tab", "a\tb"))
+
+	// Test: Invalid names
+	assert.Error(t, ValidateField("", "value"))
+	assert.Error(t, ValidateField("X Space", "value"))
+	assert.Error(t, ValidateField("X-Colon:", "value"))
+
+	// Test: A value cannot start a new line
+	assert.Error(t, ValidateField("X-Injected", "a\r\nSet-Cookie: b=1"))
+	assert.Error(t, ValidateField("X-Injected", "a\nb"))
+	assert.Error(t, ValidateField("X-Null", "a\x00b"))
+}
+
+func TestParser(t *testing.T) {
+	parser := &Parser{}
+
+	// Test: The lines of a section are parsed into the headers
+	require.NoError(t, parser.ParseLine([]byte("Host: localhost:42069")))
+	require.NoError(t, parser.ParseLine([]byte("X-Custom-Name:   value  ")))
+	require.NoError(t, parser.ParseLine([]byte("Accept: text/html")))
+	require.NoError(t, parser.ParseLine([]byte("ACCEPT: application/json")))
+
+	h := parser.Headers()
+	assert.Equal(t, Headers{
+		"host":          "localhost:42069",
+		"x-custom-name": "value",
+		"accept":        "text/html, application/json",
+	}, h)
+
+	// Test: The Parser is reset for the next section
+	assert.Empty(t, parser.Headers())
+
+	// Test: Invalid lines are rejected like with Headers.Parse
+	require.Error(t, parser.ParseLine([]byte("Host : localhost")))
+	require.Error(t, parser.ParseLine([]byte("Host: local\x00host")))
+	require.Error(t, parser.ParseLine([]byte(": localhost")))
+}
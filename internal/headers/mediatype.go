@@ -0,0 +1,225 @@
+package headers
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ErrNoMediaType is returned when parsing an empty media type.
+var ErrNoMediaType = errors.New("no media type")
+
+// MediaType is a media type such as the value of the Content-Type header
+// (e.g. text/html; charset=utf-8). The type, the subtype and the parameter
+// names are always in lower case.
+type MediaType struct {
+	Type    string
+	Subtype string
+	Params  map[string]string
+}
+
+// NewMediaType returns the MediaType for the type/subtype string with the
+// given parameters. The parameters can be nil.
+func NewMediaType(mediaType string, params map[string]string) (MediaType, error) {
+	parsed, err := ParseMediaType(mediaType)
+	if err != nil {
+		return MediaType{}, err
+	}
+
+	for name, value := range params {
+		if name == "" || strings.IndexFunc(name, isNotTokenRune) != -1 {
+			return MediaType{}, fmt.Errorf("invalid media type parameter name: %q", name)
+		}
+
+		parsed.Params[strings.ToLower(name)] = value
+	}
+
+	return parsed, nil
+}
+
+// ParseMediaType parses a media type with its (optional) parameters
+// (RFC 9110, section 8.3.1). The value of the charset parameter is
+// converted to lower case as it is case-insensitive.
+func ParseMediaType(value string) (MediaType, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return MediaType{}, ErrNoMediaType
+	}
+
+	essence, rest, _ := strings.Cut(value, ";")
+	essence = strings.TrimRight(essence, " \t")
+
+	mediaType, subtype, found := strings.Cut(essence, "/")
+	if !found ||
+		mediaType == "" ||
+		subtype == "" ||
+		strings.IndexFunc(mediaType, isNotTokenRune) != -1 ||
+		strings.IndexFunc(subtype, isNotTokenRune) != -1 {
+		return MediaType{}, fmt.Errorf("invalid media type: %q", value)
+	}
+
+	params, err := ParseParameters(rest)
+	if err != nil {
+		return MediaType{}, fmt.Errorf("invalid media type %q: %w", value, err)
+	}
+
+	if charset, ok := params["charset"]; ok {
+		params["charset"] = strings.ToLower(charset)
+	}
+
+	return MediaType{
+		Type:    strings.ToLower(mediaType),
+		Subtype: strings.ToLower(subtype),
+		Params:  params,
+	}, nil
+}
+
+// Essence returns the media type without the parameters (e.g. text/html).
+func (m MediaType) Essence() string {
+	return m.Type + "/" + m.Subtype
+}
+
+// String returns the media type formatted for use as a header value. The
+// parameters are sorted by name and the values are quoted where necessary.
+func (m MediaType) String() string {
+	var builder strings.Builder
+
+	builder.WriteString(m.Essence())
+
+	names := make([]string, 0, len(m.Params))
+	for name := range m.Params {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	for _, name := range names {
+		builder.WriteString("; ")
+		builder.WriteString(name)
+		builder.WriteString("=")
+		builder.WriteString(QuoteValue(m.Params[name]))
+	}
+
+	return builder.String()
+}
+
+// QuoteValue returns the parameter value as a token if possible or as a
+// quoted string otherwise.
+func QuoteValue(value string) string {
+	if value != "" && strings.IndexFunc(value, isNotTokenRune) == -1 {
+		return value
+	}
+
+	var builder strings.Builder
+
+	builder.WriteByte('"')
+
+	for idx := range len(value) {
+		if value[idx] == '"' || value[idx] == '\\' {
+			builder.WriteByte('\\')
+		}
+
+		builder.WriteByte(value[idx])
+	}
+
+	builder.WriteByte('"')
+
+	return builder.String()
+}
+
+// ParseParameters parses a list of semicolon separated parameters. Each
+// value is either a token or a quoted string. The parameter names are
+// converted to lower case.
+func ParseParameters(value string) (map[string]string, error) {
+	params := make(map[string]string)
+
+	for {
+		value = strings.TrimLeft(value, " \t")
+		if value == "" {
+			return params, nil
+		}
+
+		// Tolerate empty parameters (e.g. text/html;;charset=utf-8).
+		if value[0] == ';' {
+			value = value[1:]
+
+			continue
+		}
+
+		name, rest, found := strings.Cut(value, "=")
+		if !found || name == "" || strings.IndexFunc(name, isNotTokenRune) != -1 {
+			return nil, fmt.Errorf("invalid parameter: %q", value)
+		}
+
+		name = strings.ToLower(name)
+
+		var (
+			paramValue string
+			err        error
+		)
+
+		if strings.HasPrefix(rest, `"`) {
+			paramValue, rest, err = unquote(rest)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			end := strings.IndexAny(rest, "; \t")
+			if end == -1 {
+				end = len(rest)
+			}
+
+			paramValue, rest = rest[:end], rest[end:]
+
+			if paramValue == "" || strings.IndexFunc(paramValue, isNotTokenRune) != -1 {
+				return nil, fmt.Errorf("invalid value for the parameter %q", name)
+			}
+		}
+
+		if _, exists := params[name]; exists {
+			return nil, fmt.Errorf("duplicate parameter: %q", name)
+		}
+
+		params[name] = paramValue
+
+		rest = strings.TrimLeft(rest, " \t")
+		if rest != "" && rest[0] != ';' {
+			return nil, fmt.Errorf("unexpected data after the parameter %q", name)
+		}
+
+		value = rest
+	}
+}
+
+// unquote reads the quoted string at the start of value and returns the unquoted
+// string and the remaining data after the closing quote.
+func unquote(value string) (string, string, error) {
+	var builder strings.Builder
+
+	for idx := 1; idx < len(value); idx++ {
+		switch char := value[idx]; char {
+		case '"':
+			return builder.String(), value[idx+1:], nil
+		case '\\':
+			if idx+1 == len(value) {
+				return "", "", errors.New("unterminated quoted string")
+			}
+
+			idx++
+			builder.WriteByte(value[idx])
+		default:
+			if (char < ' ' && char != '\t') || char == 0x7F {
+				return "", "", errors.New("invalid character in quoted string")
+			}
+
+			builder.WriteByte(char)
+		}
+	}
+
+	return "", "", errors.New("unterminated quoted string")
+}
+
+func isNotTokenRune(char rune) bool {
+	return char > 0x7F || !IsTokenChar(byte(char))
+}
@@ -0,0 +1,93 @@
+package headers
+
+import (
+	"fmt"
+)
+
+// maxRetainedParserBytes is the largest buffer that a Parser keeps for the next
+// section so that a single large section does not hold on to its memory.
+const maxRetainedParserBytes = 64 << 10
+
+// Parser parses the lines of a header section. The names and the values of all of
+// the lines are copied into a single string once the section is complete, so that a
+// section costs two allocations (the map and the string) instead of one or two per
+// line. A Parser can be reused for the next section.
+type Parser struct {
+	buf    []byte
+	fields []parsedField
+}
+
+// parsedField is the position of a field in the buffer of the Parser. key is set
+// instead of the position of the name for the common header names.
+type parsedField struct {
+	key        string
+	nameStart  int
+	nameEnd    int
+	valueStart int
+	valueEnd   int
+}
+
+// ParseLine parses a single header line without the CRLF. The line is validated like
+// with Headers.Parse. ParseLine does not keep a reference to the line.
+func (p *Parser) ParseLine(line []byte) error {
+	name, value, err := splitField(line)
+	if err != nil {
+		return fmt.Errorf("header validation error: %w", err)
+	}
+
+	field := parsedField{key: "", nameStart: len(p.buf), nameEnd: 0, valueStart: 0, valueEnd: 0}
+
+	for _, char := range name {
+		if char >= 'A' && char <= 'Z' {
+			char += 'a' - 'A'
+		}
+
+		p.buf = append(p.buf, char)
+	}
+
+	field.nameEnd = len(p.buf)
+
+	// The common names are used as is so only the value is copied.
+	if key, ok := commonKeys[string(p.buf[field.nameStart:])]; ok {
+		field.key = key
+		p.buf = p.buf[:field.nameStart]
+	}
+
+	field.valueStart = len(p.buf)
+	p.buf = append(p.buf, value...)
+	field.valueEnd = len(p.buf)
+
+	p.fields = append(p.fields, field)
+
+	return nil
+}
+
+// Headers returns the headers of the lines that have been parsed and resets the
+// Parser for the next section.
+func (p *Parser) Headers() Headers {
+	data := string(p.buf)
+	h := make(Headers, len(p.fields))
+
+	for _, field := range p.fields {
+		key := field.key
+		if key == "" {
+			key = data[field.nameStart:field.nameEnd]
+		}
+
+		h.Add(key, data[field.valueStart:field.valueEnd])
+	}
+
+	p.Reset()
+
+	return h
+}
+
+// Reset discards the lines that have been parsed.
+func (p *Parser) Reset() {
+	if cap(p.buf) > maxRetainedParserBytes {
+		p.buf = nil
+	}
+
+	p.buf = p.buf[:0]
+	p.fields = p.fields[:0]
+}
@@ -0,0 +1,998 @@
+package http2
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"http-from-tcp/internal/http2/hpack"
+	"http-from-tcp/internal/request"
+	"http-from-tcp/internal/response"
+)
+
+// lingerTimeout is the maximum amount of time to wait for the client to close the
+// connection after the server has finished writing to it.
+const lingerTimeout = 500 * time.Millisecond
+
+// serverConn is the server side of an HTTP/2 connection. A single goroutine reads
+// and processes the frames while each request is handled in its own goroutine. The
+// frames can be written by any goroutine while holding writeMu.
+type serverConn struct {
+	server *Server
+	conn   net.Conn
+	reader *bufio.Reader
+
+	// maxBodySize is the largest request body of the server.Server that accepted
+	// the connection, or 0 if the size is not limited. The whole body is read before
+	// the handler is called so it bounds the memory used by a stream.
+	maxBodySize int
+
+	// ctx is cancelled when the connection is closed. The contexts of the requests
+	// are derived from it.
+	ctx      context.Context
+	cancel   context.CancelFunc
+	handlers sync.WaitGroup
+
+	// lastStreamID is the highest stream ID that the client has used. It is written
+	// by the reading goroutine and read when the server sends GOAWAY.
+	lastStreamID atomic.Uint32
+
+	// The fields below are only used by the goroutine that reads the frames.
+	decoder          *hpack.Decoder
+	headerBuf        []byte
+	readBuf          []byte
+	recvWindow       int64
+	recvUnacked      int64
+	settingsReceived bool
+	pending          *headerBlock
+
+	// writeMu serialises the frames and the use of the HPACK encoder as the header
+	// blocks must be decoded by the client in the order that they are encoded.
+	writeMu    sync.Mutex
+	writer     *bufio.Writer
+	encoder    *hpack.Encoder
+	writeBuf   []byte
+	encodedBuf []byte
+
+	// mu guards the streams and the send side of the flow control. cond is signalled
+	// when a send window grows or when a stream or the connection is closed.
+	mu                sync.Mutex
+	cond              sync.Cond
+	streams           map[uint32]*stream
+	sendWindow        int64
+	initialWindowSize int64
+	maxFrameSize      uint32
+	closed            bool
+	goingAway         bool
+}
+
+// headerBlock is a header block that continues in CONTINUATION frames.
+type headerBlock struct {
+	streamID       uint32
+	endStream      bool
+	selfDependency bool
+	data           []byte
+}
+
+// serve reads the frames until the connection is closed. The request that switched
+// the connection to h2c is served on the first stream if it is not nil.
+func (sc *serverConn) serve(upgrade *upgradeRequest) {
+	defer sc.close()
+
+	stop := context.AfterFunc(sc.ctx, sc.shutdown)
+	defer stop()
+
+	// The server preface is sent without waiting for the client (RFC 9113, section 3.4).
+	if err := sc.writeServerPreface(); err != nil {
+		return
+	}
+
+	if upgrade != nil {
+		if err := sc.serveUpgrade(upgrade); err != nil {
+			sc.fail(err)
+
+			return
+		}
+	}
+
+	preface := make([]byte, len(clientPreface))
+	if _, err := io.ReadFull(sc.reader, preface); err != nil || string(preface) != clientPreface {
+		sc.fail(connectionError{errCodeProtocol, "invalid client preface"})
+
+		return
+	}
+
+	for {
+		err := sc.readFrame()
+		if err == nil {
+			continue
+		}
+
+		var streamErr streamError
+		if errors.As(err, &streamErr) {
+			sc.resetStream(streamErr)
+
+			continue
+		}
+
+		sc.fail(err)
+
+		return
+	}
+}
+
+// fail ends the connection after an error. The client is told about the errors that
+// it caused with a GOAWAY frame.
+func (sc *serverConn) fail(err error) {
+	var connErr connectionError
+	if !errors.As(err, &connErr) {
+		return
+	}
+
+	slog.Error("HTTP/2 connection error.", "error", connErr.Error())
+
+	sc.writeGoAway(connErr.code)
+}
+
+// close closes the connection and waits for the handlers to return. The handlers
+// that are still writing fail as the connection is closed first.
+func (sc *serverConn) close() {
+	sc.mu.Lock()
+	sc.closed = true
+	sc.cond.Broadcast()
+	sc.mu.Unlock()
+
+	sc.cancel()
+	closeConnection(sc.conn)
+
+	sc.handlers.Wait()
+}
+
+// closeConnection closes the connection after giving the client a chance to read the
+// last frames (e.g. a GOAWAY frame). Closing a TCP connection that still has unread
+// data from the client causes a reset which can discard the frames before the client
+// reads them.
+func closeConnection(conn net.Conn) {
+	defer conn.Close()
+
+	writeCloser, ok := conn.(interface{ CloseWrite() error })
+	if !ok {
+		return
+	}
+
+	if err := writeCloser.CloseWrite(); err != nil {
+		return
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(lingerTimeout)); err != nil {
+		return
+	}
+
+	_, _ = io.Copy(io.Discard, conn)
+}
+
+// shutdown tells the client to stop opening streams. The connection is closed once
+// the responses of the open streams have been sent.
+func (sc *serverConn) shutdown() {
+	sc.mu.Lock()
+	if sc.closed {
+		sc.mu.Unlock()
+
+		return
+	}
+
+	sc.goingAway = true
+	sc.mu.Unlock()
+
+	sc.writeGoAway(errCodeNo)
+	sc.closeIfDrained()
+}
+
+// closeIfDrained closes the connection if it is going away and all of its streams
+// are done.
+func (sc *serverConn) closeIfDrained() {
+	sc.mu.Lock()
+	drained := sc.goingAway && len(sc.streams) == 0
+	sc.mu.Unlock()
+
+	if drained {
+		_ = sc.conn.Close()
+	}
+}
+
+func (sc *serverConn) writeServerPreface() error {
+	settings := appendSettings(nil, []setting{
+		{settingMaxConcurrentStreams, uint32(maxConcurrentStreams)},
+		{settingInitialWindowSize, uint32(streamWindowSize)},
+		{settingMaxHeaderListSize, uint32(maxHeaderListSize)},
+		// The server never pushes but the setting only applies to the client.
+	})
+
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+
+	if err := sc.writeFrameLocked(frameSettings, 0, 0, settings); err != nil {
+		return err
+	}
+
+	increment := binary.BigEndian.AppendUint32(nil, uint32(connWindowSize-defaultWindowSize))
+	if err := sc.writeFrameLocked(frameWindowUpdate, 0, 0, increment); err != nil {
+		return err
+	}
+
+	return sc.flushLocked()
+}
+
+// serveUpgrade applies the settings from the HTTP2-Settings header and starts handling
+// the upgrade request on the first stream, which is half-closed by the client.
+func (sc *serverConn) serveUpgrade(upgrade *upgradeRequest) error {
+	if err := sc.applySettings(upgrade.settings); err != nil {
+		return err
+	}
+
+	sc.lastStreamID.Store(1)
+
+	st := sc.newStream(1)
+	st.remoteClosed = true
+	st.head = upgrade.request.RequestLine.Method == "HEAD"
+
+	sc.mu.Lock()
+	sc.streams[st.id] = st
+	sc.mu.Unlock()
+
+	sc.runHandler(st, func(w *response.Writer) {
+		sc.server.handler(w, upgrade.request.WithContext(st.ctx))
+	})
+
+	return nil
+}
+
+// readFrame reads and processes the next frame.
+func (sc *serverConn) readFrame() error {
+	h, err := readFrameHeader(sc.reader, sc.headerBuf)
+	if err != nil {
+		return err
+	}
+
+	if h.length > defaultMaxFrameSize {
+		return connectionError{errCodeFrameSize, fmt.Sprintf("the %s frame is larger than %d bytes", h.typ, defaultMaxFrameSize)}
+	}
+
+	payload := sc.readBuf[:h.length]
+	if _, err := io.ReadFull(sc.reader, payload); err != nil {
+		return err
+	}
+
+	if !sc.settingsReceived {
+		if h.typ != frameSettings || h.has(flagAck) {
+			return connectionError{errCodeProtocol, "the client preface must end with a SETTINGS frame"}
+		}
+
+		sc.settingsReceived = true
+	}
+
+	// A header block must not be interleaved with other frames (RFC 9113, section 4.3).
+	if sc.pending != nil && h.typ != frameContinuation {
+		return connectionError{errCodeProtocol, "expected a CONTINUATION frame"}
+	}
+
+	switch h.typ {
+	case frameData:
+		return sc.processData(h, payload)
+	case frameHeaders:
+		return sc.processHeaders(h, payload)
+	case framePriority:
+		return sc.processPriority(h, payload)
+	case frameRSTStream:
+		return sc.processRSTStream(h, payload)
+	case frameSettings:
+		return sc.processSettings(h, payload)
+	case framePushPromise:
+		return connectionError{errCodeProtocol, "a client cannot push"}
+	case framePing:
+		return sc.processPing(h, payload)
+	case frameGoAway:
+		return sc.processGoAway(h, payload)
+	case frameWindowUpdate:
+		return sc.processWindowUpdate(h, payload)
+	case frameContinuation:
+		return sc.processContinuation(h, payload)
+	default:
+		// Frames of unknown types are ignored (RFC 9113, section 4.1).
+		return nil
+	}
+}
+
+// checkStreamID returns a connection error if a frame that needs a stream does not
+// have one or if it refers to a stream that the client has not opened yet.
+func (sc *serverConn) checkStreamID(h frameHeader) error {
+	switch {
+	case h.streamID == 0:
+		return connectionError{errCodeProtocol, fmt.Sprintf("%s frame without a stream", h.typ)}
+	case h.streamID > sc.lastStreamID.Load():
+		return connectionError{errCodeProtocol, fmt.Sprintf("%s frame on the idle stream %d", h.typ, h.streamID)}
+	default:
+		return nil
+	}
+}
+
+func (sc *serverConn) stream(id uint32) *stream {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	return sc.streams[id]
+}
+
+func (sc *serverConn) processData(h frameHeader, payload []byte) error {
+	if err := sc.checkStreamID(h); err != nil {
+		return err
+	}
+
+	data, ok := stripPadding(h, payload)
+	if !ok {
+		return connectionError{errCodeProtocol, "the padding is longer than the DATA frame"}
+	}
+
+	// The whole frame counts against the flow control windows, including the padding.
+	length := int64(h.length)
+	if length > sc.recvWindow {
+		return connectionError{errCodeFlowControl, "the DATA frame exceeds the connection window"}
+	}
+
+	sc.recvWindow -= length
+	sc.consumed(length)
+
+	st := sc.stream(h.streamID)
+	if st == nil || st.isRemoteClosed() {
+		return streamError{h.streamID, errCodeStreamClosed, "DATA frame on a closed stream"}
+	}
+
+	if length > st.recvWindow {
+		return streamError{h.streamID, errCodeFlowControl, "the DATA frame exceeds the stream window"}
+	}
+
+	st.recvWindow -= length
+	endStream := h.has(flagEndStream)
+
+	if !endStream {
+		st.consumed(length)
+	}
+
+	st.received += len(data)
+
+	switch {
+	case st.discarding:
+	case st.contentLength >= 0 && st.received > st.contentLength:
+		return streamError{h.streamID, errCodeProtocol, "the body is longer than the Content-Length"}
+	case sc.maxBodySize > 0 && len(st.body)+len(data) > sc.maxBodySize:
+		// The handler is not called but the client still gets a response.
+		st.discarding = true
+		st.body = nil
+
+		sc.runHandler(st, func(w *response.Writer) {
+			statusCode := response.StatusCodeContentTooLarge
+			_ = w.WriteError(statusCode, response.StatusText(statusCode))
+		})
+	default:
+		st.body = append(st.body, data...)
+	}
+
+	if endStream {
+		return sc.endRequest(st)
+	}
+
+	return nil
+}
+
+// consumed returns the credit of the received data to the connection window. The
+// window is updated once half of it has been used so that the client does not stall
+// and the updates are not sent for every frame.
+func (sc *serverConn) consumed(length int64) {
+	sc.recvUnacked += length
+	if sc.recvUnacked < connWindowSize/2 {
+		return
+	}
+
+	sc.writeWindowUpdate(0, sc.recvUnacked)
+	sc.recvWindow += sc.recvUnacked
+	sc.recvUnacked = 0
+}
+
+func (sc *serverConn) processHeaders(h frameHeader, payload []byte) error {
+	if h.streamID == 0 {
+		return connectionError{errCodeProtocol, "HEADERS frame without a stream"}
+	}
+
+	if h.streamID%2 == 0 {
+		return connectionError{errCodeProtocol, fmt.Sprintf("the client opened the even stream %d", h.streamID)}
+	}
+
+	fragment, ok := stripPadding(h, payload)
+	if !ok {
+		return connectionError{errCodeProtocol, "the padding is longer than the HEADERS frame"}
+	}
+
+	block := &headerBlock{
+		streamID:  h.streamID,
+		endStream: h.has(flagEndStream),
+	}
+
+	// The priority signals are deprecated and ignored (RFC 9113, section 5.3.2).
+	if h.has(flagPriority) {
+		if len(fragment) < 5 {
+			return connectionError{errCodeFrameSize, "the HEADERS frame is too short for the priority"}
+		}
+
+		block.selfDependency = binary.BigEndian.Uint32(fragment)&(1<<31-1) == h.streamID
+		fragment = fragment[5:]
+	}
+
+	block.data = append([]byte(nil), fragment...)
+	sc.pending = block
+
+	if h.has(flagEndHeaders) {
+		return sc.endHeaderBlock()
+	}
+
+	return nil
+}
+
+func (sc *serverConn) processContinuation(h frameHeader, payload []byte) error {
+	if sc.pending == nil || sc.pending.streamID != h.streamID {
+		return connectionError{errCodeProtocol, "unexpected CONTINUATION frame"}
+	}
+
+	// The compressed block is limited as well as the decoded list so that a client
+	// cannot make the server buffer an endless stream of CONTINUATION frames.
+	if len(sc.pending.data)+len(payload) > maxHeaderListSize {
+		return connectionError{errCodeEnhanceYourCalm, "the header block is too large"}
+	}
+
+	sc.pending.data = append(sc.pending.data, payload...)
+
+	if h.has(flagEndHeaders) {
+		return sc.endHeaderBlock()
+	}
+
+	return nil
+}
+
+// endHeaderBlock decodes a complete header block, which either opens a stream with
+// the headers of a request or ends a stream with the trailers of a request. The block
+// is always decoded to keep the HPACK decoder in sync with the client.
+func (sc *serverConn) endHeaderBlock() error {
+	block := sc.pending
+	sc.pending = nil
+
+	fields, err := sc.decoder.Decode(block.data, maxHeaderListSize)
+
+	tooLarge := errors.Is(err, hpack.ErrHeaderListTooLarge)
+	if err != nil && !tooLarge {
+		return connectionError{errCodeCompression, err.Error()}
+	}
+
+	if block.selfDependency {
+		return streamError{block.streamID, errCodeProtocol, "the stream depends on itself"}
+	}
+
+	if st := sc.stream(block.streamID); st != nil {
+		return sc.processTrailers(st, block, fields, tooLarge)
+	}
+
+	if block.streamID <= sc.lastStreamID.Load() {
+		return connectionError{errCodeStreamClosed, fmt.Sprintf("HEADERS frame on the closed stream %d", block.streamID)}
+	}
+
+	sc.lastStreamID.Store(block.streamID)
+
+	sc.mu.Lock()
+	goingAway := sc.goingAway
+	refused := len(sc.streams) >= maxConcurrentStreams
+	sc.mu.Unlock()
+
+	// The streams opened after GOAWAY are ignored as the client will retry them on
+	// another connection.
+	if goingAway {
+		return nil
+	}
+
+	if refused {
+		return streamError{block.streamID, errCodeRefusedStream, "too many concurrent streams"}
+	}
+
+	st := sc.newStream(block.streamID)
+
+	if tooLarge {
+		// The request gets the same response as an HTTP/1.1 request with a header
+		// section that is too large.
+		st.discarding = true
+	} else {
+		st.requestLine, st.headers, st.contentLength, err = parseRequestHeaders(fields)
+		if err != nil {
+			st.cancel()
+
+			return streamError{block.streamID, errCodeProtocol, err.Error()}
+		}
+
+		st.head = st.requestLine.Method == "HEAD"
+	}
+
+	sc.mu.Lock()
+	sc.streams[st.id] = st
+	sc.mu.Unlock()
+
+	if tooLarge {
+		sc.runHandler(st, func(w *response.Writer) {
+			statusCode := response.StatusCodeBadRequest
+			_ = w.WriteError(statusCode, response.StatusText(statusCode))
+		})
+	}
+
+	if block.endStream {
+		return sc.endRequest(st)
+	}
+
+	return nil
+}
+
+// processTrailers handles a header block on an open stream, which can only be the
+// trailers that end the request.
+func (sc *serverConn) processTrailers(st *stream, block *headerBlock, fields []hpack.HeaderField, tooLarge bool) error {
+	if st.isRemoteClosed() {
+		return streamError{st.id, errCodeStreamClosed, "HEADERS frame on a half-closed stream"}
+	}
+
+	if !block.endStream {
+		return streamError{st.id, errCodeProtocol, "the trailers do not end the stream"}
+	}
+
+	if tooLarge {
+		return streamError{st.id, errCodeProtocol, "the trailers are too large"}
+	}
+
+	trailers, err := parseTrailers(fields)
+	if err != nil {
+		return streamError{st.id, errCodeProtocol, err.Error()}
+	}
+
+	st.trailers = trailers
+
+	return sc.endRequest(st)
+}
+
+// endRequest is called when the client has sent the whole request. The handler is
+// called with the request unless the stream already has a response.
+func (sc *serverConn) endRequest(st *stream) error {
+	sc.mu.Lock()
+	st.remoteClosed = true
+	sc.mu.Unlock()
+
+	if st.discarding {
+		return nil
+	}
+
+	if st.contentLength >= 0 && st.received != st.contentLength {
+		return streamError{st.id, errCodeProtocol, "the body does not match the Content-Length"}
+	}
+
+	req, err := request.NewRequest(st.requestLine, st.headers, st.body)
+	if err != nil {
+		slog.Error("error parsing the request.", "error", err.Error())
+
+		sc.runHandler(st, func(w *response.Writer) {
+			statusCode := response.StatusCode(request.StatusCode(err))
+			_ = w.WriteError(statusCode, response.StatusText(statusCode))
+		})
+
+		return nil
+	}
+
+	req.Trailers = st.trailers
+	req.RemoteAddr = sc.conn.RemoteAddr().String()
+
+	sc.runHandler(st, func(w *response.Writer) {
+		sc.server.handler(w, req.WithContext(st.ctx))
+	})
+
+	return nil
+}
+
+// runHandler writes the response of the stream in a new goroutine.
+func (sc *serverConn) runHandler(st *stream, handle func(w *response.Writer)) {
+	sc.handlers.Add(1)
+
+	go func() {
+		defer sc.handlers.Done()
+
+		handle(response.NewStreamWriter(st))
+		st.finish()
+	}()
+}
+
+func (sc *serverConn) processPriority(h frameHeader, payload []byte) error {
+	if h.streamID == 0 {
+		return connectionError{errCodeProtocol, "PRIORITY frame without a stream"}
+	}
+
+	if len(payload) != 5 {
+		return streamError{h.streamID, errCodeFrameSize, "invalid PRIORITY frame"}
+	}
+
+	if binary.BigEndian.Uint32(payload)&(1<<31-1) == h.streamID {
+		return streamError{h.streamID, errCodeProtocol, "the stream depends on itself"}
+	}
+
+	return nil
+}
+
+func (sc *serverConn) processRSTStream(h frameHeader, payload []byte) error {
+	if err := sc.checkStreamID(h); err != nil {
+		return err
+	}
+
+	if len(payload) != 4 {
+		return connectionError{errCodeFrameSize, "invalid RST_STREAM frame"}
+	}
+
+	sc.mu.Lock()
+	st := sc.streams[h.streamID]
+
+	if st != nil {
+		st.closed = true
+		delete(sc.streams, st.id)
+		sc.cond.Broadcast()
+	}
+	sc.mu.Unlock()
+
+	if st != nil {
+		st.cancel()
+		sc.closeIfDrained()
+	}
+
+	return nil
+}
+
+func (sc *serverConn) processSettings(h frameHeader, payload []byte) error {
+	if h.streamID != 0 {
+		return connectionError{errCodeProtocol, "SETTINGS frame on a stream"}
+	}
+
+	if h.has(flagAck) {
+		if len(payload) != 0 {
+			return connectionError{errCodeFrameSize, "SETTINGS acknowledgement with a payload"}
+		}
+
+		return nil
+	}
+
+	if len(payload)%settingSize != 0 {
+		return connectionError{errCodeFrameSize, "invalid SETTINGS frame"}
+	}
+
+	if err := sc.applySettings(parseSettings(payload)); err != nil {
+		return err
+	}
+
+	return sc.writeFrame(frameSettings, flagAck, 0, nil)
+}
+
+// applySettings applies the settings of the client (RFC 9113, section 6.5.2). The
+// settings that only limit what the server sends to the client are applied, the rest
+// do not affect a server that never pushes.
+func (sc *serverConn) applySettings(settings []setting) error {
+	for _, s := range settings {
+		switch s.id {
+		case settingHeaderTableSize:
+			// The encoder does not need a larger table than the default one.
+			sc.writeMu.Lock()
+			sc.encoder.SetMaxTableSize(min(int(s.value), hpack.DefaultTableSize))
+			sc.writeMu.Unlock()
+		case settingEnablePush:
+			if s.value > 1 {
+				return connectionError{errCodeProtocol, "invalid SETTINGS_ENABLE_PUSH"}
+			}
+		case settingInitialWindowSize:
+			if int64(s.value) > maxWindowSize {
+				return connectionError{errCodeFlowControl, "invalid SETTINGS_INITIAL_WINDOW_SIZE"}
+			}
+
+			if err := sc.setInitialWindowSize(int64(s.value)); err != nil {
+				return err
+			}
+		case settingMaxFrameSize:
+			if s.value < defaultMaxFrameSize || s.value > maxFrameSizeLimit {
+				return connectionError{errCodeProtocol, "invalid SETTINGS_MAX_FRAME_SIZE"}
+			}
+
+			sc.mu.Lock()
+			sc.maxFrameSize = s.value
+			sc.mu.Unlock()
+		}
+	}
+
+	return nil
+}
+
+// setInitialWindowSize changes the send windows of all the streams by the difference
+// between the new and the old initial window size (RFC 9113, section 6.9.2).
+func (sc *serverConn) setInitialWindowSize(size int64) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	delta := size - sc.initialWindowSize
+	sc.initialWindowSize = size
+
+	for _, st := range sc.streams {
+		st.sendWindow += delta
+		if st.sendWindow > maxWindowSize {
+			return connectionError{errCodeFlowControl, "SETTINGS_INITIAL_WINDOW_SIZE overflows a stream window"}
+		}
+	}
+
+	sc.cond.Broadcast()
+
+	return nil
+}
+
+func (sc *serverConn) processPing(h frameHeader, payload []byte) error {
+	if h.streamID != 0 {
+		return connectionError{errCodeProtocol, "PING frame on a stream"}
+	}
+
+	if len(payload) != 8 {
+		return connectionError{errCodeFrameSize, "invalid PING frame"}
+	}
+
+	if h.has(flagAck) {
+		return nil
+	}
+
+	return sc.writeFrame(framePing, flagAck, 0, payload)
+}
+
+func (sc *serverConn) processGoAway(h frameHeader, payload []byte) error {
+	if h.streamID != 0 {
+		return connectionError{errCodeProtocol, "GOAWAY frame on a stream"}
+	}
+
+	if len(payload) < 8 {
+		return connectionError{errCodeFrameSize, "invalid GOAWAY frame"}
+	}
+
+	// The client does not open new streams but the open streams are still served
+	// until the client closes the connection.
+	code := errCode(binary.BigEndian.Uint32(payload[4:]))
+	if code != errCodeNo {
+		slog.Info("the HTTP/2 client is going away.", "code", code.String(), "debug", string(payload[8:]))
+	}
+
+	return nil
+}
+
+func (sc *serverConn) processWindowUpdate(h frameHeader, payload []byte) error {
+	if len(payload) != 4 {
+		return connectionError{errCodeFrameSize, "invalid WINDOW_UPDATE frame"}
+	}
+
+	increment := int64(binary.BigEndian.Uint32(payload) & (1<<31 - 1))
+
+	if h.streamID == 0 {
+		if increment == 0 {
+			return connectionError{errCodeProtocol, "WINDOW_UPDATE frame without an increment"}
+		}
+
+		sc.mu.Lock()
+		defer sc.mu.Unlock()
+
+		sc.sendWindow += increment
+		if sc.sendWindow > maxWindowSize {
+			return connectionError{errCodeFlowControl, "the connection window is too large"}
+		}
+
+		sc.cond.Broadcast()
+
+		return nil
+	}
+
+	if err := sc.checkStreamID(h); err != nil {
+		return err
+	}
+
+	if increment == 0 {
+		return streamError{h.streamID, errCodeProtocol, "WINDOW_UPDATE frame without an increment"}
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	// The stream can have been closed while the client was sending the frame.
+	st := sc.streams[h.streamID]
+	if st == nil {
+		return nil
+	}
+
+	st.sendWindow += increment
+	if st.sendWindow > maxWindowSize {
+		return streamError{h.streamID, errCodeFlowControl, "the stream window is too large"}
+	}
+
+	sc.cond.Broadcast()
+
+	return nil
+}
+
+// reserveWindow waits until both the stream and the connection windows allow some
+// data to be sent and returns how many bytes, up to want, can be sent in a DATA frame.
+func (sc *serverConn) reserveWindow(st *stream, want int) (int, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	for {
+		switch {
+		case sc.closed:
+			return 0, errConnClosed
+		case st.closed:
+			return 0, errStreamReset
+		}
+
+		n := min(int64(want), st.sendWindow, sc.sendWindow, int64(sc.maxFrameSize))
+		if n > 0 {
+			st.sendWindow -= n
+			sc.sendWindow -= n
+
+			return int(n), nil
+		}
+
+		sc.cond.Wait()
+	}
+}
+
+// resetStream resets the stream after a stream error.
+func (sc *serverConn) resetStream(err streamError) {
+	slog.Debug("resetting the HTTP/2 stream.", "error", err.Error())
+
+	sc.mu.Lock()
+	st := sc.streams[err.streamID]
+
+	if st != nil {
+		st.closed = true
+		delete(sc.streams, st.id)
+		sc.cond.Broadcast()
+	}
+	sc.mu.Unlock()
+
+	if st != nil {
+		st.cancel()
+	}
+
+	sc.writeRSTStream(err.streamID, err.code)
+	sc.closeIfDrained()
+}
+
+// removeStream removes the stream once its response is complete. false is returned if
+// the stream has already been closed.
+func (sc *serverConn) removeStream(st *stream) bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if st.closed {
+		return false
+	}
+
+	st.closed = true
+	delete(sc.streams, st.id)
+	sc.cond.Broadcast()
+
+	return true
+}
+
+func (sc *serverConn) writeRSTStream(streamID uint32, code errCode) {
+	_ = sc.writeFrame(frameRSTStream, 0, streamID, binary.BigEndian.AppendUint32(nil, uint32(code)))
+}
+
+func (sc *serverConn) writeGoAway(code errCode) {
+	payload := binary.BigEndian.AppendUint32(nil, sc.lastStreamID.Load())
+	payload = binary.BigEndian.AppendUint32(payload, uint32(code))
+
+	_ = sc.writeFrame(frameGoAway, 0, 0, payload)
+}
+
+func (sc *serverConn) writeWindowUpdate(streamID uint32, increment int64) {
+	_ = sc.writeFrame(frameWindowUpdate, 0, streamID, binary.BigEndian.AppendUint32(nil, uint32(increment)))
+}
+
+// writeFrame writes a single frame and sends it to the client.
+func (sc *serverConn) writeFrame(typ frameType, flags uint8, streamID uint32, payload []byte) error {
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+
+	if err := sc.writeFrameLocked(typ, flags, streamID, payload); err != nil {
+		return err
+	}
+
+	return sc.flushLocked()
+}
+
+// writeHeaderBlock encodes the fields and writes them in a HEADERS frame followed by
+// as many CONTINUATION frames as needed.
+func (sc *serverConn) writeHeaderBlock(streamID uint32, fields []hpack.HeaderField, endStream bool) error {
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+
+	sc.mu.Lock()
+	maxFrameSize := int(sc.maxFrameSize)
+	sc.mu.Unlock()
+
+	sc.encodedBuf = sc.encoder.Encode(sc.encodedBuf[:0], fields)
+	block := sc.encodedBuf
+
+	typ := frameHeaders
+	flags := uint8(0)
+
+	if endStream {
+		flags |= flagEndStream
+	}
+
+	for {
+		fragment := block[:min(len(block), maxFrameSize)]
+		block = block[len(fragment):]
+
+		if len(block) == 0 {
+			flags |= flagEndHeaders
+		}
+
+		if err := sc.writeFrameLocked(typ, flags, streamID, fragment); err != nil {
+			return err
+		}
+
+		if len(block) == 0 {
+			return sc.flushLocked()
+		}
+
+		typ = frameContinuation
+		flags = 0
+	}
+}
+
+func (sc *serverConn) writeFrameLocked(typ frameType, flags uint8, streamID uint32, payload []byte) error {
+	sc.writeBuf = appendFrameHeader(sc.writeBuf[:0], frameHeader{
+		length:   uint32(len(payload)),
+		typ:      typ,
+		flags:    flags,
+		streamID: streamID,
+	})
+
+	if _, err := sc.writer.Write(sc.writeBuf); err != nil {
+		return sc.writeFailed(err)
+	}
+
+	if _, err := sc.writer.Write(payload); err != nil {
+		return sc.writeFailed(err)
+	}
+
+	return nil
+}
+
+func (sc *serverConn) flushLocked() error {
+	if err := sc.writer.Flush(); err != nil {
+		return sc.writeFailed(err)
+	}
+
+	return nil
+}
+
+// writeFailed closes the connection after a failed write so that the goroutine that
+// reads the frames stops as well.
+func (sc *serverConn) writeFailed(err error) error {
+	_ = sc.conn.Close()
+
+	return fmt.Errorf("error writing the HTTP/2 frame: %w", err)
+}
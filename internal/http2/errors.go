@@ -0,0 +1,79 @@
+package http2
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The error codes of the RST_STREAM and GOAWAY frames (RFC 9113, section 7).
+type errCode uint32
+
+const (
+	errCodeNo                 errCode = 0x0
+	errCodeProtocol           errCode = 0x1
+	errCodeInternal           errCode = 0x2
+	errCodeFlowControl        errCode = 0x3
+	errCodeSettingsTimeout    errCode = 0x4
+	errCodeStreamClosed       errCode = 0x5
+	errCodeFrameSize          errCode = 0x6
+	errCodeRefusedStream      errCode = 0x7
+	errCodeCancel             errCode = 0x8
+	errCodeCompression        errCode = 0x9
+	errCodeConnect            errCode = 0xa
+	errCodeEnhanceYourCalm    errCode = 0xb
+	errCodeInadequateSecurity errCode = 0xc
+	errCodeHTTP11Required     errCode = 0xd
+)
+
+var errCodeNames = map[errCode]string{
+	errCodeNo:                 "NO_ERROR",
+	errCodeProtocol:           "PROTOCOL_ERROR",
+	errCodeInternal:           "INTERNAL_ERROR",
+	errCodeFlowControl:        "FLOW_CONTROL_ERROR",
+	errCodeSettingsTimeout:    "SETTINGS_TIMEOUT",
+	errCodeStreamClosed:       "STREAM_CLOSED",
+	errCodeFrameSize:          "FRAME_SIZE_ERROR",
+	errCodeRefusedStream:      "REFUSED_STREAM",
+	errCodeCancel:             "CANCEL",
+	errCodeCompression:        "COMPRESSION_ERROR",
+	errCodeConnect:            "CONNECT_ERROR",
+	errCodeEnhanceYourCalm:    "ENHANCE_YOUR_CALM",
+	errCodeInadequateSecurity: "INADEQUATE_SECURITY",
+	errCodeHTTP11Required:     "HTTP_1_1_REQUIRED",
+}
+
+func (c errCode) String() string {
+	if name, ok := errCodeNames[c]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("UNKNOWN_ERROR_0x%x", uint32(c))
+}
+
+var (
+	errStreamReset = errors.New("the HTTP/2 stream was reset")
+	errConnClosed  = errors.New("the HTTP/2 connection is closed")
+)
+
+// connectionError is an error that fails the whole connection. The server sends a
+// GOAWAY frame with the code and closes the connection (RFC 9113, section 5.4.1).
+type connectionError struct {
+	code   errCode
+	reason string
+}
+
+func (e connectionError) Error() string {
+	return fmt.Sprintf("HTTP/2 connection error (%s): %s", e.code, e.reason)
+}
+
+// streamError is an error that only fails one stream. The server resets the stream
+// with a RST_STREAM frame with the code (RFC 9113, section 5.4.2).
+type streamError struct {
+	streamID uint32
+	code     errCode
+	reason   string
+}
+
+func (e streamError) Error() string {
+	return fmt.Sprintf("HTTP/2 stream error on the stream %d (%s): %s", e.streamID, e.code, e.reason)
+}
@@ -0,0 +1,169 @@
+package http2
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// The frame types (RFC 9113, section 6).
+type frameType uint8
+
+const (
+	frameData         frameType = 0x0
+	frameHeaders      frameType = 0x1
+	framePriority     frameType = 0x2
+	frameRSTStream    frameType = 0x3
+	frameSettings     frameType = 0x4
+	framePushPromise  frameType = 0x5
+	framePing         frameType = 0x6
+	frameGoAway       frameType = 0x7
+	frameWindowUpdate frameType = 0x8
+	frameContinuation frameType = 0x9
+)
+
+var frameTypeNames = map[frameType]string{
+	frameData:         "DATA",
+	frameHeaders:      "HEADERS",
+	framePriority:     "PRIORITY",
+	frameRSTStream:    "RST_STREAM",
+	frameSettings:     "SETTINGS",
+	framePushPromise:  "PUSH_PROMISE",
+	framePing:         "PING",
+	frameGoAway:       "GOAWAY",
+	frameWindowUpdate: "WINDOW_UPDATE",
+	frameContinuation: "CONTINUATION",
+}
+
+func (t frameType) String() string {
+	if name, ok := frameTypeNames[t]; ok {
+		return name
+	}
+
+	return "UNKNOWN"
+}
+
+// The frame flags. The same bit has a different meaning for different frame types.
+const (
+	flagEndStream  uint8 = 0x1
+	flagAck        uint8 = 0x1
+	flagEndHeaders uint8 = 0x4
+	flagPadded     uint8 = 0x8
+	flagPriority   uint8 = 0x20
+)
+
+const (
+	// frameHeaderSize is the size of the header of every frame.
+	frameHeaderSize int = 9
+
+	// defaultMaxFrameSize is the initial value of SETTINGS_MAX_FRAME_SIZE and the
+	// largest frame payload that the server accepts.
+	defaultMaxFrameSize uint32 = 1 << 14
+
+	// maxFrameSizeLimit is the largest value allowed for SETTINGS_MAX_FRAME_SIZE.
+	maxFrameSizeLimit uint32 = 1<<24 - 1
+
+	// defaultWindowSize is the initial size of the flow control windows.
+	defaultWindowSize int64 = 65535
+
+	// maxWindowSize is the largest size of a flow control window.
+	maxWindowSize int64 = 1<<31 - 1
+)
+
+// frameHeader is the 9 byte header of a frame (RFC 9113, section 4.1).
+type frameHeader struct {
+	length   uint32
+	typ      frameType
+	flags    uint8
+	streamID uint32
+}
+
+func (h frameHeader) has(flag uint8) bool {
+	return h.flags&flag != 0
+}
+
+// readFrameHeader reads the frame header into buf, which must hold at least
+// frameHeaderSize bytes.
+func readFrameHeader(r io.Reader, buf []byte) (frameHeader, error) {
+	if _, err := io.ReadFull(r, buf[:frameHeaderSize]); err != nil {
+		return frameHeader{}, err
+	}
+
+	return frameHeader{
+		length:   uint32(buf[0])<<16 | uint32(buf[1])<<8 | uint32(buf[2]),
+		typ:      frameType(buf[3]),
+		flags:    buf[4],
+		streamID: binary.BigEndian.Uint32(buf[5:9]) & (1<<31 - 1),
+	}, nil
+}
+
+func appendFrameHeader(dst []byte, h frameHeader) []byte {
+	dst = append(dst, byte(h.length>>16), byte(h.length>>8), byte(h.length), byte(h.typ), h.flags)
+
+	return binary.BigEndian.AppendUint32(dst, h.streamID)
+}
+
+// The settings parameters (RFC 9113, section 6.5.2).
+type settingID uint16
+
+const (
+	settingHeaderTableSize      settingID = 0x1
+	settingEnablePush           settingID = 0x2
+	settingMaxConcurrentStreams settingID = 0x3
+	settingInitialWindowSize    settingID = 0x4
+	settingMaxFrameSize         settingID = 0x5
+	settingMaxHeaderListSize    settingID = 0x6
+)
+
+// settingSize is the size of a parameter in the payload of a SETTINGS frame.
+const settingSize int = 6
+
+type setting struct {
+	id    settingID
+	value uint32
+}
+
+// parseSettings parses the payload of a SETTINGS frame. The length of the payload
+// must be a multiple of settingSize.
+func parseSettings(payload []byte) []setting {
+	settings := make([]setting, 0, len(payload)/settingSize)
+
+	for len(payload) >= settingSize {
+		settings = append(settings, setting{
+			id:    settingID(binary.BigEndian.Uint16(payload)),
+			value: binary.BigEndian.Uint32(payload[2:]),
+		})
+		payload = payload[settingSize:]
+	}
+
+	return settings
+}
+
+func appendSettings(dst []byte, settings []setting) []byte {
+	for _, s := range settings {
+		dst = binary.BigEndian.AppendUint16(dst, uint16(s.id))
+		dst = binary.BigEndian.AppendUint32(dst, s.value)
+	}
+
+	return dst
+}
+
+// stripPadding removes the padding of a DATA or HEADERS frame with the PADDED flag
+// (RFC 9113, section 6.1). false is returned if the padding is longer than the payload.
+func stripPadding(h frameHeader, payload []byte) ([]byte, bool) {
+	if !h.has(flagPadded) {
+		return payload, true
+	}
+
+	if len(payload) == 0 {
+		return nil, false
+	}
+
+	padLength := int(payload[0])
+	payload = payload[1:]
+
+	if padLength > len(payload) {
+		return nil, false
+	}
+
+	return payload[:len(payload)-padLength], true
+}
@@ -0,0 +1,164 @@
+package http2
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"http-from-tcp/internal/headers"
+	"http-from-tcp/internal/http2/hpack"
+	"http-from-tcp/internal/request"
+)
+
+// parseRequestHeaders validates the header list of a request (RFC 9113, section 8.3)
+// and converts it to the request line and the headers of an HTTP/1.1 request. The
+// :authority pseudo-header becomes the Host header. The Content-Length of the request
+// is -1 if it does not have one.
+func parseRequestHeaders(fields []hpack.HeaderField) (request.RequestLine, headers.Headers, int, error) {
+	var (
+		pseudo  = make(map[string]string, 4)
+		regular = headers.NewHeaders()
+		ended   bool
+	)
+
+	for _, field := range fields {
+		if err := validateField(field); err != nil {
+			return request.RequestLine{}, nil, 0, err
+		}
+
+		if !strings.HasPrefix(field.Name, ":") {
+			ended = true
+
+			if err := addField(regular, field); err != nil {
+				return request.RequestLine{}, nil, 0, err
+			}
+
+			continue
+		}
+
+		switch {
+		case ended:
+			return request.RequestLine{}, nil, 0, fmt.Errorf("the pseudo-header %s follows a regular header", field.Name)
+		case field.Name != ":method" && field.Name != ":scheme" && field.Name != ":authority" && field.Name != ":path":
+			return request.RequestLine{}, nil, 0, fmt.Errorf("invalid pseudo-header %s", field.Name)
+		}
+
+		if _, ok := pseudo[field.Name]; ok {
+			return request.RequestLine{}, nil, 0, fmt.Errorf("duplicate pseudo-header %s", field.Name)
+		}
+
+		pseudo[field.Name] = field.Value
+	}
+
+	method := pseudo[":method"]
+	if method == "" {
+		return request.RequestLine{}, nil, 0, errors.New("missing :method")
+	}
+
+	requestLine := request.RequestLine{
+		Method:        method,
+		RequestTarget: pseudo[":path"],
+		HTTPVersion:   "2",
+	}
+
+	// A CONNECT request only has the authority of the tunnel (RFC 9113, section 8.5).
+	if method == "CONNECT" {
+		_, hasScheme := pseudo[":scheme"]
+		_, hasPath := pseudo[":path"]
+
+		if hasScheme || hasPath || pseudo[":authority"] == "" {
+			return request.RequestLine{}, nil, 0, errors.New("invalid CONNECT request")
+		}
+
+		requestLine.RequestTarget = pseudo[":authority"]
+	} else if pseudo[":scheme"] == "" || requestLine.RequestTarget == "" {
+		return request.RequestLine{}, nil, 0, errors.New("missing :scheme or :path")
+	}
+
+	if authority, ok := pseudo[":authority"]; ok {
+		regular["host"] = authority
+	}
+
+	contentLength := -1
+
+	if value, ok := regular["content-length"]; ok {
+		length, err := strconv.Atoi(value)
+		if err != nil || length < 0 || strings.ContainsAny(value, "+-") {
+			return request.RequestLine{}, nil, 0, fmt.Errorf("invalid Content-Length %q", value)
+		}
+
+		contentLength = length
+	}
+
+	return requestLine, regular, contentLength, nil
+}
+
+// parseTrailers validates the header list of the trailers of a request.
+func parseTrailers(fields []hpack.HeaderField) (headers.Headers, error) {
+	trailers := headers.NewHeaders()
+
+	for _, field := range fields {
+		if err := validateField(field); err != nil {
+			return nil, err
+		}
+
+		if strings.HasPrefix(field.Name, ":") {
+			return nil, fmt.Errorf("the trailers contain the pseudo-header %s", field.Name)
+		}
+
+		if err := addField(trailers, field); err != nil {
+			return nil, err
+		}
+	}
+
+	return trailers, nil
+}
+
+// validateField checks that the name of the field is a lower case token and that the
+// value does not contain characters that could split it into several lines when the
+// request is forwarded over HTTP/1.1 (RFC 9113, section 8.2.1).
+func validateField(field hpack.HeaderField) error {
+	name := strings.TrimPrefix(field.Name, ":")
+	if name == "" {
+		return errors.New("empty field name")
+	}
+
+	for idx := range len(name) {
+		char := name[idx]
+		if char >= 'A' && char <= 'Z' || !headers.IsTokenChar(char) {
+			return fmt.Errorf("invalid field name %q", field.Name)
+		}
+	}
+
+	if strings.ContainsAny(field.Value, "\r\n\x00") || field.Value != strings.Trim(field.Value, " \t") {
+		return fmt.Errorf("invalid value of the field %s", field.Name)
+	}
+
+	return nil
+}
+
+// addField adds a regular field to the headers. The connection-specific headers are
+// rejected and the cookie fields, which HTTP/2 can split into several fields for a
+// better compression, are joined into a single Cookie header (RFC 9113, section 8.2.3).
+func addField(h headers.Headers, field hpack.HeaderField) error {
+	switch {
+	case connectionHeaders[field.Name]:
+		return fmt.Errorf("connection-specific header %s", field.Name)
+	case field.Name == "te" && field.Value != "trailers":
+		return errors.New("the TE header can only be trailers")
+	}
+
+	existing, ok := h[field.Name]
+
+	switch {
+	case !ok:
+		h[field.Name] = field.Value
+	case field.Name == "cookie":
+		h[field.Name] = existing + "; " + field.Value
+	default:
+		h[field.Name] = existing + ", " + field.Value
+	}
+
+	return nil
+}
@@ -0,0 +1,155 @@
+package hpack
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Decoder decodes header blocks into header lists.
+type Decoder struct {
+	table dynamicTable
+
+	// maxSizeLimit is the limit of the dynamic table size updates, i.e. the value of
+	// SETTINGS_HEADER_TABLE_SIZE advertised to the peer.
+	maxSizeLimit int
+}
+
+// NewDecoder returns a decoder with the maximum size of the dynamic table.
+func NewDecoder(maxTableSize int) *Decoder {
+	return &Decoder{
+		table:        dynamicTable{maxSize: maxTableSize},
+		maxSizeLimit: maxTableSize,
+	}
+}
+
+// Decode decodes a complete header block. The size of the header list (the sum of the
+// sizes of the fields as in SETTINGS_MAX_HEADER_LIST_SIZE) must not exceed maxListSize,
+// otherwise ErrHeaderListTooLarge is returned after the whole block was decoded. Any
+// other error is a DecodingError.
+func (d *Decoder) Decode(block []byte, maxListSize int) ([]HeaderField, error) {
+	var (
+		fields   []HeaderField
+		listSize int
+		tooLarge bool
+	)
+
+	start := true
+
+	for len(block) > 0 {
+		var (
+			field HeaderField
+			err   error
+		)
+
+		first := block[0]
+
+		switch {
+		case first&0x80 != 0:
+			field, block, err = d.decodeIndexed(block)
+		case first&0xC0 == 0x40:
+			field, block, err = d.decodeLiteral(block, 6)
+			if err == nil {
+				d.table.add(field)
+			}
+		case first&0xE0 == 0x20:
+			if !start {
+				return nil, DecodingError{errors.New("dynamic table size update after the first field")}
+			}
+
+			block, err = d.decodeSizeUpdate(block)
+		default:
+			field, block, err = d.decodeLiteral(block, 4)
+			field.Sensitive = first&0x10 != 0
+		}
+
+		if err != nil {
+			return nil, DecodingError{err}
+		}
+
+		if first&0xE0 == 0x20 {
+			continue
+		}
+
+		start = false
+
+		listSize += field.size()
+		if listSize > maxListSize {
+			// Keep decoding so the dynamic table stays in sync but drop the fields.
+			tooLarge = true
+			fields = nil
+		}
+
+		if !tooLarge {
+			fields = append(fields, field)
+		}
+	}
+
+	if tooLarge {
+		return nil, ErrHeaderListTooLarge
+	}
+
+	return fields, nil
+}
+
+func (d *Decoder) decodeIndexed(block []byte) (HeaderField, []byte, error) {
+	index, block, err := readInteger(block, 7)
+	if err != nil {
+		return HeaderField{}, nil, err
+	}
+
+	field, ok := d.table.field(index)
+	if !ok {
+		return HeaderField{}, nil, fmt.Errorf("invalid index %d", index)
+	}
+
+	return field, block, nil
+}
+
+func (d *Decoder) decodeLiteral(block []byte, prefixBits uint8) (HeaderField, []byte, error) {
+	index, block, err := readInteger(block, prefixBits)
+	if err != nil {
+		return HeaderField{}, nil, err
+	}
+
+	var field HeaderField
+
+	// A string cannot be longer than the header list that would hold it, it is only
+	// bounded here so a single literal cannot make the decoder allocate a lot.
+	maxLen := len(block) * 8 / 5
+
+	if index == 0 {
+		field.Name, block, err = readString(block, maxLen)
+		if err != nil {
+			return HeaderField{}, nil, err
+		}
+	} else {
+		indexed, ok := d.table.field(index)
+		if !ok {
+			return HeaderField{}, nil, fmt.Errorf("invalid index %d", index)
+		}
+
+		field.Name = indexed.Name
+	}
+
+	field.Value, block, err = readString(block, maxLen)
+	if err != nil {
+		return HeaderField{}, nil, err
+	}
+
+	return field, block, nil
+}
+
+func (d *Decoder) decodeSizeUpdate(block []byte) ([]byte, error) {
+	size, block, err := readInteger(block, 5)
+	if err != nil {
+		return nil, err
+	}
+
+	if size > d.maxSizeLimit {
+		return nil, fmt.Errorf("dynamic table size update to %d exceeds the limit of %d", size, d.maxSizeLimit)
+	}
+
+	d.table.setMaxSize(size)
+
+	return block, nil
+}
@@ -0,0 +1,80 @@
+package hpack
+
+// Encoder encodes header lists into header blocks. The fields are added to the
+// dynamic table so the following blocks can refer to them by index.
+type Encoder struct {
+	table dynamicTable
+
+	// minSize and pendingSize are the smallest and the last maximum size of the table
+	// since the last block. Both are signalled at the start of the next block so the
+	// decoder evicts the same entries (RFC 7541, section 4.2).
+	minSize     int
+	pendingSize int
+	pending     bool
+}
+
+// NewEncoder returns an encoder with the maximum size of the dynamic table.
+func NewEncoder(maxTableSize int) *Encoder {
+	return &Encoder{table: dynamicTable{maxSize: maxTableSize}}
+}
+
+// SetMaxTableSize changes the maximum size of the dynamic table, e.g. after the peer
+// changed SETTINGS_HEADER_TABLE_SIZE.
+func (e *Encoder) SetMaxTableSize(maxTableSize int) {
+	if !e.pending || maxTableSize < e.minSize {
+		e.minSize = maxTableSize
+	}
+
+	e.pendingSize = maxTableSize
+	e.pending = true
+
+	e.table.setMaxSize(min(e.minSize, maxTableSize))
+}
+
+// Encode appends the header block of the fields to dst.
+func (e *Encoder) Encode(dst []byte, fields []HeaderField) []byte {
+	if e.pending {
+		if e.minSize < e.pendingSize {
+			dst = appendInteger(dst, 0x20, 5, e.minSize)
+		}
+
+		dst = appendInteger(dst, 0x20, 5, e.pendingSize)
+		e.table.setMaxSize(e.pendingSize)
+		e.pending = false
+	}
+
+	for _, field := range fields {
+		dst = e.encodeField(dst, field)
+	}
+
+	return dst
+}
+
+func (e *Encoder) encodeField(dst []byte, field HeaderField) []byte {
+	index, match := e.table.search(field)
+
+	// Indexed header field representation (RFC 7541, section 6.1)
+	if match {
+		return appendInteger(dst, 0x80, 7, index)
+	}
+
+	switch {
+	case field.Sensitive:
+		// Literal header field never indexed (RFC 7541, section 6.2.3)
+		dst = appendInteger(dst, 0x10, 4, index)
+	case field.size() > e.table.maxSize:
+		// Literal header field without indexing (RFC 7541, section 6.2.2) as the field
+		// would only empty the table
+		dst = appendInteger(dst, 0, 4, index)
+	default:
+		// Literal header field with incremental indexing (RFC 7541, section 6.2.1)
+		dst = appendInteger(dst, 0x40, 6, index)
+		e.table.add(field)
+	}
+
+	if index == 0 {
+		dst = appendString(dst, field.Name)
+	}
+
+	return appendString(dst, field.Value)
+}
@@ -0,0 +1,146 @@
+// Package hpack implements HPACK (RFC 7541), the compression of the header lists of
+// HTTP/2. An Encoder and a Decoder keep the dynamic table of one direction of a
+// connection so each one must only be used for one connection.
+package hpack
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DefaultTableSize is the initial size of the dynamic table (the initial value of
+// SETTINGS_HEADER_TABLE_SIZE).
+const DefaultTableSize int = 4096
+
+var (
+	// ErrHeaderListTooLarge is returned by Decode when the decoded header list is
+	// larger than the limit. The whole block is still decoded so that the dynamic
+	// table stays in sync with the encoder of the peer.
+	ErrHeaderListTooLarge = errors.New("the header list is too large")
+
+	errTruncated       = errors.New("truncated header block")
+	errIntegerOverflow = errors.New("integer overflow")
+)
+
+// DecodingError is an invalid header block. The connection must be closed with
+// a COMPRESSION_ERROR as the dynamic tables can no longer be trusted.
+type DecodingError struct {
+	Err error
+}
+
+func (e DecodingError) Error() string {
+	return "invalid HPACK header block: " + e.Err.Error()
+}
+
+func (e DecodingError) Unwrap() error {
+	return e.Err
+}
+
+// appendInteger appends the integer with the N-bit prefix (RFC 7541, section 5.1).
+// first holds the bits of the first byte that are not part of the prefix.
+func appendInteger(dst []byte, first byte, prefixBits uint8, value int) []byte {
+	limit := 1<<prefixBits - 1
+
+	if value < limit {
+		return append(dst, first|byte(value))
+	}
+
+	dst = append(dst, first|byte(limit))
+	value -= limit
+
+	for value >= 0x80 {
+		dst = append(dst, byte(value&0x7F)|0x80)
+		value >>= 7
+	}
+
+	return append(dst, byte(value))
+}
+
+// readInteger reads the integer with the N-bit prefix from the start of data and
+// returns the integer and the rest of the data.
+func readInteger(data []byte, prefixBits uint8) (int, []byte, error) {
+	if len(data) == 0 {
+		return 0, nil, errTruncated
+	}
+
+	limit := 1<<prefixBits - 1
+
+	value := int(data[0]) & limit
+	if value < limit {
+		return value, data[1:], nil
+	}
+
+	data = data[1:]
+	shift := uint(0)
+
+	for idx, b := range data {
+		// The integers in a header block never need more than 28 bits so longer
+		// encodings are rejected before they can overflow.
+		if shift > 21 {
+			return 0, nil, errIntegerOverflow
+		}
+
+		value += int(b&0x7F) << shift
+		shift += 7
+
+		if b&0x80 == 0 {
+			return value, data[idx+1:], nil
+		}
+	}
+
+	return 0, nil, errTruncated
+}
+
+// appendString appends the string literal (RFC 7541, section 5.2). The string is
+// Huffman encoded if that makes it shorter.
+func appendString(dst []byte, s string) []byte {
+	if encodedLen := huffmanEncodedLen(s); encodedLen < len(s) {
+		dst = appendInteger(dst, 0x80, 7, encodedLen)
+
+		return huffmanEncode(dst, s)
+	}
+
+	dst = appendInteger(dst, 0, 7, len(s))
+
+	return append(dst, s...)
+}
+
+// readString reads the string literal from the start of data and returns the string
+// and the rest of the data. A string longer than maxLen is rejected.
+func readString(data []byte, maxLen int) (string, []byte, error) {
+	if len(data) == 0 {
+		return "", nil, errTruncated
+	}
+
+	huffman := data[0]&0x80 != 0
+
+	length, data, err := readInteger(data, 7)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if length > len(data) {
+		return "", nil, errTruncated
+	}
+
+	if length > maxLen {
+		return "", nil, fmt.Errorf("the string literal is longer than %d bytes", maxLen)
+	}
+
+	raw, rest := data[:length], data[length:]
+
+	if !huffman {
+		return string(raw), rest, nil
+	}
+
+	decoded, err := huffmanDecode(make([]byte, 0, length*8/5), raw)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(decoded) > maxLen {
+		return "", nil, fmt.Errorf("the string literal is longer than %d bytes", maxLen)
+	}
+
+	return string(decoded), rest, nil
+}
@@ -0,0 +1,239 @@
+package hpack
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	data, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	require.NoError(t, err)
+
+	return data
+}
+
+func TestIntegers(t *testing.T) {
+	// Test: Examples from RFC 7541, Appendix C.1
+	assert.Equal(t, []byte{0x0A}, appendInteger(nil, 0, 5, 10))
+	assert.Equal(t, []byte{0x1F, 0x9A, 0x0A}, appendInteger(nil, 0, 5, 1337))
+	assert.Equal(t, []byte{0x2A}, appendInteger(nil, 0, 8, 42))
+
+	value, rest, err := readInteger([]byte{0xFF, 0x9A, 0x0A, 0x42}, 5)
+	require.NoError(t, err)
+	assert.Equal(t, 1337, value)
+	assert.Equal(t, []byte{0x42}, rest)
+
+	// Test: Round trip around the prefix limits
+	for _, value := range []int{0, 14, 15, 16, 127, 128, 255, 1 << 20} {
+		encoded := appendInteger(nil, 0x10, 4, value)
+
+		decoded, rest, err := readInteger(encoded, 4)
+		require.NoError(t, err)
+		assert.Equal(t, value, decoded)
+		assert.Empty(t, rest)
+	}
+
+	// Test: Truncated and overflowing integers
+	_, _, err = readInteger([]byte{0x1F, 0x9A}, 5)
+	require.ErrorIs(t, err, errTruncated)
+
+	_, _, err = readInteger([]byte{0x1F, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x01}, 5)
+	require.ErrorIs(t, err, errIntegerOverflow)
+}
+
+func TestHuffman(t *testing.T) {
+	// Test: Example from RFC 7541, Appendix C.4.1
+	encoded := huffmanEncode(nil, "www.example.com")
+	assert.Equal(t, decodeHex(t, "f1e3 c2e5 f23a 6ba0 ab90 f4ff"), encoded)
+	assert.Equal(t, len(encoded), huffmanEncodedLen("www.example.com"))
+
+	decoded, err := huffmanDecode(nil, encoded)
+	require.NoError(t, err)
+	assert.Equal(t, "www.example.com", string(decoded))
+
+	// Test: Every byte survives a round trip
+	var all strings.Builder
+	for b := range 256 {
+		all.WriteByte(byte(b))
+	}
+
+	decoded, err = huffmanDecode(nil, huffmanEncode(nil, all.String()))
+	require.NoError(t, err)
+	assert.Equal(t, all.String(), string(decoded))
+
+	// Test: Invalid padding
+	for _, data := range [][]byte{
+		{0xF1, 0xE3, 0xC2, 0xE5, 0xF2, 0x3A, 0x6B, 0xA0, 0xAB, 0x90, 0xF4, 0xFF, 0xFF},
+		{0x00},
+		{0xFF, 0xFF, 0xFF, 0xFF},
+	} {
+		_, err := huffmanDecode(nil, data)
+		require.ErrorIs(t, err, errInvalidHuffman)
+	}
+}
+
+// requestExamples are the requests of RFC 7541, Appendix C.4, encoded with Huffman
+// coding and a dynamic table that is shared by the blocks.
+var requestExamples = []struct {
+	fields []HeaderField
+	block  string
+}{
+	{
+		fields: []HeaderField{
+			{Name: ":method", Value: "GET"},
+			{Name: ":scheme", Value: "http"},
+			{Name: ":path", Value: "/"},
+			{Name: ":authority", Value: "www.example.com"},
+		},
+		block: "8286 8441 8cf1 e3c2 e5f2 3a6b a0ab 90f4 ff",
+	},
+	{
+		fields: []HeaderField{
+			{Name: ":method", Value: "GET"},
+			{Name: ":scheme", Value: "http"},
+			{Name: ":path", Value: "/"},
+			{Name: ":authority", Value: "www.example.com"},
+			{Name: "cache-control", Value: "no-cache"},
+		},
+		block: "8286 84be 5886 a8eb 1064 9cbf",
+	},
+	{
+		fields: []HeaderField{
+			{Name: ":method", Value: "GET"},
+			{Name: ":scheme", Value: "https"},
+			{Name: ":path", Value: "/index.html"},
+			{Name: ":authority", Value: "www.example.com"},
+			{Name: "custom-key", Value: "custom-value"},
+		},
+		block: "8287 85bf 4088 25a8 49e9 5ba9 7d7f 8925 a849 e95b b8e8 b4bf",
+	},
+}
+
+func TestEncoder(t *testing.T) {
+	encoder := NewEncoder(DefaultTableSize)
+
+	for _, example := range requestExamples {
+		assert.Equal(t, decodeHex(t, example.block), encoder.Encode(nil, example.fields))
+	}
+
+	// Test: The dynamic table holds the literals of the examples
+	assert.Equal(t, 164, encoder.table.size)
+
+	// Test: Sensitive fields are never indexed
+	block := encoder.Encode(nil, []HeaderField{{Name: ":authority", Value: "www.example.com", Sensitive: true}})
+	assert.Equal(t, byte(0x11), block[0])
+	assert.Len(t, encoder.table.entries, 3)
+
+	// Test: Fields larger than the table are not indexed
+	small := NewEncoder(64)
+	block = small.Encode(nil, []HeaderField{{Name: "x-large", Value: strings.Repeat("a", 64)}})
+	assert.Equal(t, byte(0x00), block[0])
+	assert.Empty(t, small.table.entries)
+
+	// Test: The smallest and the last table size are signalled before the next block
+	encoder.SetMaxTableSize(0)
+	encoder.SetMaxTableSize(1024)
+	assert.Empty(t, encoder.table.entries)
+
+	block = encoder.Encode(nil, []HeaderField{{Name: ":method", Value: "GET"}})
+	assert.Equal(t, []byte{0x20, 0x3F, 0xE1, 0x07, 0x82}, block)
+	assert.Equal(t, 1024, encoder.table.maxSize)
+
+	// Test: A single size update when the size only grows
+	encoder.SetMaxTableSize(2048)
+
+	block = encoder.Encode(nil, nil)
+	assert.Equal(t, []byte{0x3F, 0xE1, 0x0F}, block)
+}
+
+func TestDecoder(t *testing.T) {
+	decoder := NewDecoder(DefaultTableSize)
+
+	// Test: Requests from RFC 7541, Appendix C.4
+	for _, example := range requestExamples {
+		fields, err := decoder.Decode(decodeHex(t, example.block), 1<<20)
+		require.NoError(t, err)
+		assert.Equal(t, example.fields, fields)
+	}
+
+	assert.Equal(t, 164, decoder.table.size)
+
+	// Test: Literals without Huffman coding from RFC 7541, Appendix C.2
+	fields, err := NewDecoder(DefaultTableSize).Decode(decodeHex(t,
+		"400a 6375 7374 6f6d 2d6b 6579 0d63 7573 746f 6d2d 6865 6164 6572"+
+			"040c 2f73 616d 706c 652f 7061 7468"+
+			"1008 7061 7373 776f 7264 0673 6563 7265 74"), 1<<20)
+	require.NoError(t, err)
+	assert.Equal(t, []HeaderField{
+		{Name: "custom-key", Value: "custom-header"},
+		{Name: ":path", Value: "/sample/path"},
+		{Name: "password", Value: "secret", Sensitive: true},
+	}, fields)
+
+	// Test: Invalid header blocks
+	for name, block := range map[string]string{
+		"index 0":                       "80",
+		"index beyond the tables":       "be",
+		"truncated string":              "4005 6375",
+		"size update after a field":     "82 20",
+		"size update beyond the limit":  "3f e2 1f",
+		"invalid Huffman padding":       "4081 00 0161",
+		"literal with an invalid index": "7f 00 0161",
+	} {
+		_, err := NewDecoder(DefaultTableSize).Decode(decodeHex(t, block), 1<<20)
+
+		var decodingErr DecodingError
+		require.ErrorAs(t, err, &decodingErr, name)
+	}
+
+	// Test: A size update at the start of a block evicts the entries
+	fields, err = decoder.Decode([]byte{0x20, 0x3F, 0xE1, 0x1F, 0x82}, 1<<20)
+	require.NoError(t, err)
+	assert.Equal(t, []HeaderField{{Name: ":method", Value: "GET"}}, fields)
+	assert.Empty(t, decoder.table.entries)
+}
+
+func TestHeaderListTooLarge(t *testing.T) {
+	encoder := NewEncoder(DefaultTableSize)
+	decoder := NewDecoder(DefaultTableSize)
+
+	large := []HeaderField{
+		{Name: "x-first", Value: strings.Repeat("a", 100)},
+		{Name: "x-second", Value: strings.Repeat("b", 100)},
+	}
+
+	// Test: The header list is rejected
+	_, err := decoder.Decode(encoder.Encode(nil, large), 200)
+	require.ErrorIs(t, err, ErrHeaderListTooLarge)
+
+	// Test: The dynamic table is still in sync with the encoder
+	fields, err := decoder.Decode(encoder.Encode(nil, large), 1<<20)
+	require.NoError(t, err)
+	assert.Equal(t, large, fields)
+}
+
+func TestRoundTrip(t *testing.T) {
+	encoder := NewEncoder(256)
+	decoder := NewDecoder(256)
+
+	// Test: Blocks that evict the entries of the previous ones
+	for idx := range 50 {
+		fields := []HeaderField{
+			{Name: ":status", Value: "200"},
+			{Name: "content-type", Value: "text/plain"},
+			{Name: "x-request-id", Value: strings.Repeat("r", idx)},
+			{Name: "set-cookie", Value: "id=" + strings.Repeat("c", idx%7), Sensitive: idx%2 == 0},
+		}
+
+		decoded, err := decoder.Decode(encoder.Encode(nil, fields), 1<<20)
+		require.NoError(t, err)
+		assert.Equal(t, fields, decoded)
+		assert.Equal(t, encoder.table.entries, decoder.table.entries)
+	}
+}
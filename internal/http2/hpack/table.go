@@ -0,0 +1,187 @@
+package hpack
+
+// HeaderField is a field of a header list. A sensitive field is never added to the
+// dynamic table and tells the intermediaries not to add it to theirs either (e.g. for
+// a value that is easy to guess such as a short password).
+type HeaderField struct {
+	Name      string
+	Value     string
+	Sensitive bool
+}
+
+// entryOverhead is the overhead of an entry in the dynamic table
+// (RFC 7541, section 4.1).
+const entryOverhead int = 32
+
+func (f HeaderField) size() int {
+	return len(f.Name) + len(f.Value) + entryOverhead
+}
+
+// staticTable is the static table from RFC 7541, Appendix A. The index of an entry
+// is its position in the slice plus one.
+var staticTable = []HeaderField{
+	{Name: ":authority"},
+	{Name: ":method", Value: "GET"},
+	{Name: ":method", Value: "POST"},
+	{Name: ":path", Value: "/"},
+	{Name: ":path", Value: "/index.html"},
+	{Name: ":scheme", Value: "http"},
+	{Name: ":scheme", Value: "https"},
+	{Name: ":status", Value: "200"},
+	{Name: ":status", Value: "204"},
+	{Name: ":status", Value: "206"},
+	{Name: ":status", Value: "304"},
+	{Name: ":status", Value: "400"},
+	{Name: ":status", Value: "404"},
+	{Name: ":status", Value: "500"},
+	{Name: "accept-charset"},
+	{Name: "accept-encoding", Value: "gzip, deflate"},
+	{Name: "accept-language"},
+	{Name: "accept-ranges"},
+	{Name: "accept"},
+	{Name: "access-control-allow-origin"},
+	{Name: "age"},
+	{Name: "allow"},
+	{Name: "authorization"},
+	{Name: "cache-control"},
+	{Name: "content-disposition"},
+	{Name: "content-encoding"},
+	{Name: "content-language"},
+	{Name: "content-length"},
+	{Name: "content-location"},
+	{Name: "content-range"},
+	{Name: "content-type"},
+	{Name: "cookie"},
+	{Name: "date"},
+	{Name: "etag"},
+	{Name: "expect"},
+	{Name: "expires"},
+	{Name: "from"},
+	{Name: "host"},
+	{Name: "if-match"},
+	{Name: "if-modified-since"},
+	{Name: "if-none-match"},
+	{Name: "if-range"},
+	{Name: "if-unmodified-since"},
+	{Name: "last-modified"},
+	{Name: "link"},
+	{Name: "location"},
+	{Name: "max-forwards"},
+	{Name: "proxy-authenticate"},
+	{Name: "proxy-authorization"},
+	{Name: "range"},
+	{Name: "referer"},
+	{Name: "refresh"},
+	{Name: "retry-after"},
+	{Name: "server"},
+	{Name: "set-cookie"},
+	{Name: "strict-transport-security"},
+	{Name: "transfer-encoding"},
+	{Name: "user-agent"},
+	{Name: "vary"},
+	{Name: "via"},
+	{Name: "www-authenticate"},
+}
+
+// staticNames and staticPairs map the names and the name-value pairs of the static
+// table to the lowest index with the name or the pair.
+var staticNames, staticPairs = indexStaticTable()
+
+func indexStaticTable() (map[string]int, map[[2]string]int) {
+	names := make(map[string]int)
+	pairs := make(map[[2]string]int)
+
+	for idx, field := range staticTable {
+		if _, ok := names[field.Name]; !ok {
+			names[field.Name] = idx + 1
+		}
+
+		pairs[[2]string{field.Name, field.Value}] = idx + 1
+	}
+
+	return names, pairs
+}
+
+// dynamicTable is the dynamic table of an encoder or a decoder (RFC 7541, section
+// 2.3.2). The newest entry is at the end of the slice but it has the lowest index.
+type dynamicTable struct {
+	entries []HeaderField
+	size    int
+	maxSize int
+}
+
+// add adds the field to the table. The oldest entries are evicted to make room for
+// the field. The table is emptied if the field is larger than the table.
+func (t *dynamicTable) add(field HeaderField) {
+	t.evict(t.maxSize - field.size())
+
+	if field.size() <= t.maxSize {
+		t.entries = append(t.entries, field)
+		t.size += field.size()
+	}
+}
+
+func (t *dynamicTable) setMaxSize(maxSize int) {
+	t.maxSize = maxSize
+	t.evict(maxSize)
+}
+
+// evict evicts the oldest entries until the size of the table is at most maxSize.
+func (t *dynamicTable) evict(maxSize int) {
+	evicted := 0
+
+	for evicted < len(t.entries) && t.size > maxSize {
+		t.size -= t.entries[evicted].size()
+		evicted++
+	}
+
+	if evicted > 0 {
+		count := copy(t.entries, t.entries[evicted:])
+		clear(t.entries[count:])
+		t.entries = t.entries[:count]
+	}
+}
+
+// field returns the field with the index from the address space of the static and
+// the dynamic table (RFC 7541, section 2.3.3).
+func (t *dynamicTable) field(index int) (HeaderField, bool) {
+	switch {
+	case index <= 0:
+		return HeaderField{}, false
+	case index <= len(staticTable):
+		return staticTable[index-1], true
+	case index-len(staticTable) <= len(t.entries):
+		return t.entries[len(t.entries)-(index-len(staticTable))], true
+	default:
+		return HeaderField{}, false
+	}
+}
+
+// search returns the index of the field and true if both the name and the value match
+// or the index of an entry with the name and false if only the name matches. The index
+// is 0 if the name does not match any entry.
+func (t *dynamicTable) search(field HeaderField) (int, bool) {
+	if idx, ok := staticPairs[[2]string{field.Name, field.Value}]; ok && !field.Sensitive {
+		return idx, true
+	}
+
+	nameIndex := staticNames[field.Name]
+
+	for idx := len(t.entries) - 1; idx >= 0; idx-- {
+		entry := t.entries[idx]
+		if entry.Name != field.Name {
+			continue
+		}
+
+		index := len(staticTable) + len(t.entries) - idx
+		if entry.Value == field.Value && !field.Sensitive {
+			return index, true
+		}
+
+		if nameIndex == 0 {
+			nameIndex = index
+		}
+	}
+
+	return nameIndex, false
+}
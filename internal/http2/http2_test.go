@@ -0,0 +1,795 @@
+package http2
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"http-from-tcp/internal/headers"
+	"http-from-tcp/internal/http2/hpack"
+	"http-from-tcp/internal/request"
+	"http-from-tcp/internal/response"
+	"http-from-tcp/internal/server"
+)
+
+// largeBody is larger than the initial flow control windows of the client.
+var largeBody = bytes.Repeat([]byte("0123456789abcdef"), 64<<10)
+
+// testHandler serves the requests of the tests with the same API as HTTP/1.1.
+func testHandler(w *response.Writer, req *request.Request) {
+	switch req.RequestLine.RequestTarget {
+	case "/chunked":
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+		_ = w.WriteHeaders(headers.Headers{
+			response.HeaderTransferEncoding: "chunked",
+			response.HeaderTrailer:          "X-Checksum",
+		})
+		_, _ = w.WriteChunkedBody([]byte("hello "))
+		_, _ = w.WriteChunkedBody([]byte("world"))
+		_, _ = w.WriteChunkedBodyDone()
+		_ = w.WriteTrailers(headers.Headers{response.HeaderTrailer: "X-Checksum", "X-Checksum": "42"})
+	case "/large":
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+		_ = w.WriteHeaders(response.GetDefaultHeaders(len(largeBody)))
+		_, _ = w.ReadFrom(bytes.NewReader(largeBody))
+	case "/cookies":
+		_ = w.AddCookie(headers.Cookie{Name: "a", Value: "1"})
+		_ = w.AddCookie(headers.Cookie{Name: "b", Value: "2"})
+		_ = w.WriteError(response.StatusCodeOK, "cookies")
+	case "/incomplete":
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+		_ = w.WriteHeaders(response.GetDefaultHeaders(10))
+		_, _ = w.WriteBody([]byte("short"))
+	case "/hijack":
+		_, _, err := w.Hijack()
+		_ = w.WriteError(response.StatusCodeServerError, err.Error())
+	default:
+		body := fmt.Sprintf("%s %s HTTP/%s host=%s:%d cookie=%s\n%s",
+			req.RequestLine.Method, req.RequestLine.RequestTarget, req.RequestLine.HTTPVersion,
+			req.Host, req.Port, req.Headers.Get("cookie"), req.Body)
+		if value := req.Trailers.Get("x-trailer"); value != "" {
+			body += "\ntrailer=" + value
+		}
+
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+		_ = w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		_, _ = w.WriteBody([]byte(body))
+	}
+}
+
+// startServer starts a server with HTTP/2 by prior knowledge, by h2c upgrade and, if
+// the TLS config is not nil, by ALPN.
+func startServer(t *testing.T, tlsConfig *tls.Config) string {
+	t.Helper()
+
+	h2 := NewServer(testHandler)
+
+	mux := server.NewUpgradeMux(testHandler)
+	require.NoError(t, mux.Handle("h2c", h2.Upgrade))
+
+	srv, err := server.ServeWithOptions(0, mux.Dispatch, server.Options{
+		PriorKnowledge: h2.ServeConn,
+		TLSConfig:      tlsConfig,
+		NextProtos:     map[string]server.ConnHandler{"h2": h2.ServeConn},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = srv.Close() })
+
+	return srv.Addr().String()
+}
+
+func newClient(t *testing.T, protocols func(p *http.Protocols), tlsConfig *tls.Config) *http.Client {
+	t.Helper()
+
+	var p http.Protocols
+	protocols(&p)
+
+	transport := &http.Transport{Protocols: &p, TLSClientConfig: tlsConfig}
+	t.Cleanup(transport.CloseIdleConnections)
+
+	return &http.Client{Transport: transport, Timeout: 10 * time.Second}
+}
+
+func get(t *testing.T, client *http.Client, url string) (*http.Response, string) {
+	t.Helper()
+
+	resp, err := client.Get(url)
+	require.NoError(t, err)
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return resp, string(body)
+}
+
+func TestPriorKnowledge(t *testing.T) {
+	address := startServer(t, nil)
+	client := newClient(t, func(p *http.Protocols) { p.SetUnencryptedHTTP2(true) }, nil)
+	base := "http://" + address
+
+	// Test: A request is mapped onto the request of the handler
+	req, err := http.NewRequest(http.MethodPost, base+"/echo?q=1", strings.NewReader("hello"))
+	require.NoError(t, err)
+	req.AddCookie(&http.Cookie{Name: "a", Value: "1"})
+	req.AddCookie(&http.Cookie{Name: "b", Value: "2"})
+
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	host, port, _ := net.SplitHostPort(address)
+	assert.Equal(t, "HTTP/2.0", resp.Proto)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "POST /echo?q=1 HTTP/2 host="+host+":"+port+" cookie=a=1; b=2\nhello", string(body))
+
+	// Test: A chunked body is sent as DATA frames followed by the trailers
+	resp, body2 := get(t, client, base+"/chunked")
+	assert.Equal(t, "hello world", body2)
+	assert.Equal(t, "42", resp.Trailer.Get("X-Checksum"))
+	assert.Empty(t, resp.TransferEncoding)
+
+	// Test: Each cookie is sent as a Set-Cookie field
+	resp, _ = get(t, client, base+"/cookies")
+	assert.Equal(t, []string{"a=1", "b=2"}, resp.Header.Values("Set-Cookie"))
+
+	// Test: A body larger than the flow control windows
+	resp, body2 = get(t, client, base+"/large")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, string(largeBody), body2)
+
+	// Test: A HEAD response does not have a body
+	resp, err = client.Head(base + "/large")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, int64(len(largeBody)), resp.ContentLength)
+
+	// Test: A connection cannot be hijacked from a stream
+	resp, body2 = get(t, client, base+"/hijack")
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Contains(t, body2, "network connection")
+
+	// Test: An incomplete response resets the stream
+	resp, err = client.Get(base + "/incomplete")
+	require.NoError(t, err)
+
+	_, err = io.ReadAll(resp.Body)
+	require.Error(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	// Test: Concurrent streams on the same connection
+	var wg sync.WaitGroup
+
+	for idx := range 20 {
+		wg.Go(func() {
+			target := "/concurrent/" + strconv.Itoa(idx)
+
+			resp, err := client.Get(base + target)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.True(t, strings.HasPrefix(string(body), "GET "+target+" HTTP/2"))
+		})
+	}
+
+	wg.Wait()
+}
+
+func TestTLS(t *testing.T) {
+	serverConfig, clientConfig := newTLSConfigs(t)
+	address := startServer(t, serverConfig)
+
+	// Test: HTTP/2 is negotiated with ALPN
+	client := newClient(t, func(p *http.Protocols) { p.SetHTTP2(true) }, clientConfig.Clone())
+
+	resp, body := get(t, client, "https://"+address+"/tls")
+	assert.Equal(t, "HTTP/2.0", resp.Proto)
+	assert.True(t, strings.HasPrefix(body, "GET /tls HTTP/2"))
+
+	// Test: HTTP/1.1 over TLS when the client does not support HTTP/2
+	client = newClient(t, func(p *http.Protocols) { p.SetHTTP1(true) }, clientConfig.Clone())
+
+	resp, body = get(t, client, "https://"+address+"/tls")
+	assert.Equal(t, "HTTP/1.1", resp.Proto)
+	assert.True(t, strings.HasPrefix(body, "GET /tls HTTP/1.1"))
+}
+
+// newTLSConfigs returns the configs of a server with a self-signed certificate and
+// of a client that trusts it.
+func newTLSConfigs(t *testing.T) (*tls.Config, *tls.Config) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	serverConfig := &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	return serverConfig, &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+}
+
+// testConn is the client side of a raw HTTP/2 connection.
+type testConn struct {
+	t       *testing.T
+	conn    net.Conn
+	reader  *bufio.Reader
+	encoder *hpack.Encoder
+	decoder *hpack.Decoder
+}
+
+type testFrame struct {
+	frameHeader
+	payload []byte
+}
+
+func dialRaw(t *testing.T, address string) *testConn {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	return &testConn{
+		t:       t,
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		encoder: hpack.NewEncoder(hpack.DefaultTableSize),
+		decoder: hpack.NewDecoder(hpack.DefaultTableSize),
+	}
+}
+
+// handshake sends the client preface and reads the server preface.
+func (c *testConn) handshake() {
+	c.t.Helper()
+
+	_, err := c.conn.Write([]byte(clientPreface))
+	require.NoError(c.t, err)
+
+	c.writeFrame(frameSettings, 0, 0, nil)
+	c.expectServerPreface()
+}
+
+func (c *testConn) expectServerPreface() {
+	c.t.Helper()
+
+	settings := c.readFrame()
+	require.Equal(c.t, frameSettings, settings.typ)
+	assert.Contains(c.t, parseSettings(settings.payload), setting{settingMaxConcurrentStreams, 100})
+
+	update := c.readFrame()
+	require.Equal(c.t, frameWindowUpdate, update.typ)
+}
+
+func (c *testConn) writeFrame(typ frameType, flags uint8, streamID uint32, payload []byte) {
+	c.t.Helper()
+
+	frame := appendFrameHeader(nil, frameHeader{length: uint32(len(payload)), typ: typ, flags: flags, streamID: streamID})
+
+	_, err := c.conn.Write(append(frame, payload...))
+	require.NoError(c.t, err)
+}
+
+func (c *testConn) writeHeaders(streamID uint32, flags uint8, fields ...hpack.HeaderField) {
+	c.t.Helper()
+
+	c.writeFrame(frameHeaders, flags|flagEndHeaders, streamID, c.encoder.Encode(nil, fields))
+}
+
+func (c *testConn) readFrame() testFrame {
+	c.t.Helper()
+
+	h, err := readFrameHeader(c.reader, make([]byte, frameHeaderSize))
+	require.NoError(c.t, err)
+
+	payload := make([]byte, h.length)
+	_, err = io.ReadFull(c.reader, payload)
+	require.NoError(c.t, err)
+
+	return testFrame{h, payload}
+}
+
+// readUntil reads the frames until a frame of the type arrives. The SETTINGS
+// acknowledgements and WINDOW_UPDATE frames are skipped.
+func (c *testConn) readUntil(typ frameType) testFrame {
+	c.t.Helper()
+
+	for {
+		frame := c.readFrame()
+		if frame.typ == typ {
+			return frame
+		}
+
+		require.Contains(c.t, []frameType{frameSettings, frameWindowUpdate}, frame.typ, "unexpected %s frame", frame.typ)
+	}
+}
+
+// readResponse reads the headers and the body of the response on the stream.
+func (c *testConn) readResponse(streamID uint32) ([]hpack.HeaderField, string) {
+	c.t.Helper()
+
+	var (
+		fields []hpack.HeaderField
+		body   bytes.Buffer
+	)
+
+	for {
+		frame := c.readFrame()
+
+		switch frame.typ {
+		case frameHeaders:
+			require.Equal(c.t, streamID, frame.streamID)
+
+			decoded, err := c.decoder.Decode(frame.payload, 1<<20)
+			require.NoError(c.t, err)
+
+			fields = append(fields, decoded...)
+		case frameData:
+			require.Equal(c.t, streamID, frame.streamID)
+			body.Write(frame.payload)
+		case frameSettings, frameWindowUpdate:
+			continue
+		default:
+			c.t.Fatalf("unexpected %s frame", frame.typ)
+		}
+
+		if frame.has(flagEndStream) {
+			return fields, body.String()
+		}
+	}
+}
+
+// expectGoAway reads the frames until a GOAWAY frame and returns its error code.
+func (c *testConn) expectGoAway() errCode {
+	c.t.Helper()
+
+	frame := c.readUntil(frameGoAway)
+
+	return errCode(binary.BigEndian.Uint32(frame.payload[4:]))
+}
+
+// expectReset reads the frames until a RST_STREAM frame and returns its error code.
+func (c *testConn) expectReset(streamID uint32) errCode {
+	c.t.Helper()
+
+	frame := c.readUntil(frameRSTStream)
+	require.Equal(c.t, streamID, frame.streamID)
+
+	return errCode(binary.BigEndian.Uint32(frame.payload))
+}
+
+func requestFields(method, path string, extra ...hpack.HeaderField) []hpack.HeaderField {
+	return append([]hpack.HeaderField{
+		{Name: ":method", Value: method},
+		{Name: ":scheme", Value: "http"},
+		{Name: ":authority", Value: "localhost"},
+		{Name: ":path", Value: path},
+	}, extra...)
+}
+
+func TestUpgrade(t *testing.T) {
+	address := startServer(t, nil)
+
+	// Test: An HTTP/1.1 request is switched to h2c and answered on the first stream
+	c := dialRaw(t, address)
+
+	settings := base64.RawURLEncoding.EncodeToString(appendSettings(nil, []setting{{settingInitialWindowSize, 1 << 20}}))
+
+	_, err := c.conn.Write([]byte("POST /upgraded HTTP/1.1\r\nHost: localhost\r\nContent-Length: 4\r\n" +
+		"Connection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: " + settings + "\r\n\r\nbody" +
+		clientPreface))
+	require.NoError(t, err)
+
+	resp, err := http.ReadResponse(c.reader, &http.Request{Method: http.MethodPost})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	assert.Equal(t, "h2c", resp.Header.Get("Upgrade"))
+
+	c.writeFrame(frameSettings, 0, 0, nil)
+	c.expectServerPreface()
+
+	fields, body := c.readResponse(1)
+	assert.Contains(t, fields, hpack.HeaderField{Name: ":status", Value: "200"})
+	assert.Equal(t, "POST /upgraded HTTP/1.1 host=localhost:80 cookie=\nbody", body)
+
+	// Test: The next request is a normal HTTP/2 stream
+	c.writeHeaders(3, flagEndStream, requestFields("GET", "/next")...)
+
+	_, body = c.readResponse(3)
+	assert.Equal(t, "GET /next HTTP/2 host=localhost:80 cookie=\n", body)
+
+	// Test: An upgrade without the settings is refused
+	c = dialRaw(t, address)
+
+	_, err = c.conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n"))
+	require.NoError(t, err)
+
+	resp, err = http.ReadResponse(c.reader, &http.Request{Method: http.MethodGet})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestStreams(t *testing.T) {
+	address := startServer(t, nil)
+
+	// Test: A body in several DATA frames with padding and trailers
+	c := dialRaw(t, address)
+	c.handshake()
+
+	c.writeHeaders(1, 0, requestFields("POST", "/echo", hpack.HeaderField{Name: "cookie", Value: "a=1"},
+		hpack.HeaderField{Name: "cookie", Value: "b=2"})...)
+	c.writeFrame(frameData, flagPadded, 1, append([]byte{3}, "hel\x00\x00\x00"...))
+	c.writeFrame(frameData, 0, 1, []byte("lo"))
+	c.writeHeaders(1, flagEndStream, hpack.HeaderField{Name: "x-trailer", Value: "done"})
+
+	fields, body := c.readResponse(1)
+	assert.Contains(t, fields, hpack.HeaderField{Name: "content-length", Value: strconv.Itoa(len(body))})
+	assert.Equal(t, "POST /echo HTTP/2 host=localhost:80 cookie=a=1; b=2\nhello\ntrailer=done", body)
+
+	// Test: A header block split into CONTINUATION frames
+	block := c.encoder.Encode(nil, requestFields("GET", "/continued"))
+	c.writeFrame(frameHeaders, flagEndStream, 3, block[:3])
+	c.writeFrame(frameContinuation, 0, 3, block[3:6])
+	c.writeFrame(frameContinuation, flagEndHeaders, 3, block[6:])
+
+	_, body = c.readResponse(3)
+	assert.Equal(t, "GET /continued HTTP/2 host=localhost:80 cookie=\n", body)
+
+	// Test: PING is acknowledged
+	c.writeFrame(framePing, 0, 0, []byte("12345678"))
+
+	ping := c.readUntil(framePing)
+	assert.True(t, ping.has(flagAck))
+	assert.Equal(t, "12345678", string(ping.payload))
+
+	// Test: Malformed requests reset the stream
+	for idx, fields := range [][]hpack.HeaderField{
+		{{Name: ":method", Value: "GET"}, {Name: ":path", Value: "/"}},
+		requestFields("GET", "/", hpack.HeaderField{Name: "Upper", Value: "case"}),
+		requestFields("GET", "/", hpack.HeaderField{Name: "connection", Value: "close"}),
+		requestFields("GET", "/", hpack.HeaderField{Name: ":status", Value: "200"}),
+		append([]hpack.HeaderField{{Name: "accept", Value: "*/*"}}, requestFields("GET", "/")...),
+		requestFields("GET", "/", hpack.HeaderField{Name: "x-value", Value: "a\nb"}),
+	} {
+		streamID := uint32(5 + 2*idx)
+		c.writeHeaders(streamID, flagEndStream, fields...)
+		assert.Equal(t, errCodeProtocol, c.expectReset(streamID), "request %d", idx)
+	}
+
+	// Test: A body that does not match the Content-Length
+	c.writeHeaders(17, 0, requestFields("POST", "/", hpack.HeaderField{Name: "content-length", Value: "2"})...)
+	c.writeFrame(frameData, flagEndStream, 17, []byte("abc"))
+	assert.Equal(t, errCodeProtocol, c.expectReset(17))
+
+	// Test: The connection still works after the stream errors
+	c.writeHeaders(19, flagEndStream, requestFields("GET", "/after")...)
+
+	_, body = c.readResponse(19)
+	assert.Equal(t, "GET /after HTTP/2 host=localhost:80 cookie=\n", body)
+}
+
+func TestFlowControl(t *testing.T) {
+	address := startServer(t, nil)
+
+	c := dialRaw(t, address)
+
+	_, err := c.conn.Write([]byte(clientPreface))
+	require.NoError(t, err)
+
+	// Test: The response waits for the windows of the client
+	c.writeFrame(frameSettings, 0, 0, appendSettings(nil, []setting{{settingInitialWindowSize, 10}}))
+	c.expectServerPreface()
+	c.writeHeaders(1, flagEndStream, requestFields("GET", "/window")...)
+
+	fields, _ := c.readUntilHeaders(1)
+	assert.Contains(t, fields, hpack.HeaderField{Name: ":status", Value: "200"})
+
+	data := c.readUntil(frameData)
+	assert.Len(t, data.payload, 10)
+
+	c.writeFrame(frameWindowUpdate, 0, 1, binary.BigEndian.AppendUint32(nil, 5))
+
+	data = c.readUntil(frameData)
+	assert.Len(t, data.payload, 5)
+
+	// Test: A larger initial window size applies to the open streams
+	c.writeFrame(frameSettings, 0, 0, appendSettings(nil, []setting{{settingInitialWindowSize, 1 << 16}}))
+
+	var rest bytes.Buffer
+
+	for {
+		data = c.readUntil(frameData)
+		rest.Write(data.payload)
+
+		if data.has(flagEndStream) {
+			break
+		}
+	}
+
+	assert.Equal(t, "GET /window HTTP/2 host=localhost:80 cookie=\n"[15:], rest.String())
+
+	// Test: A window that overflows fails the connection
+	c.writeFrame(frameWindowUpdate, 0, 0, binary.BigEndian.AppendUint32(nil, 1<<31-1))
+	assert.Equal(t, errCodeFlowControl, c.expectGoAway())
+}
+
+// readUntilHeaders reads the frames until the HEADERS frame of the stream.
+func (c *testConn) readUntilHeaders(streamID uint32) ([]hpack.HeaderField, testFrame) {
+	c.t.Helper()
+
+	frame := c.readUntil(frameHeaders)
+	require.Equal(c.t, streamID, frame.streamID)
+
+	fields, err := c.decoder.Decode(frame.payload, 1<<20)
+	require.NoError(c.t, err)
+
+	return fields, frame
+}
+
+func TestConnectionErrors(t *testing.T) {
+	address := startServer(t, nil)
+
+	testCases := []struct {
+		name  string
+		write func(c *testConn)
+		want  errCode
+	}{
+		{
+			name:  "DATA frame without a stream",
+			write: func(c *testConn) { c.writeFrame(frameData, 0, 0, []byte("x")) },
+			want:  errCodeProtocol,
+		},
+		{
+			name:  "Frame on an idle stream",
+			write: func(c *testConn) { c.writeFrame(frameRSTStream, 0, 7, []byte{0, 0, 0, 0}) },
+			want:  errCodeProtocol,
+		},
+		{
+			name:  "Even stream",
+			write: func(c *testConn) { c.writeHeaders(2, flagEndStream, requestFields("GET", "/")...) },
+			want:  errCodeProtocol,
+		},
+		{
+			name: "Frame between HEADERS and CONTINUATION",
+			write: func(c *testConn) {
+				c.writeFrame(frameHeaders, 0, 1, c.encoder.Encode(nil, requestFields("GET", "/")))
+				c.writeFrame(framePing, 0, 0, []byte("12345678"))
+			},
+			want: errCodeProtocol,
+		},
+		{
+			name:  "Invalid SETTINGS frame",
+			write: func(c *testConn) { c.writeFrame(frameSettings, 0, 0, []byte{0, 1, 0}) },
+			want:  errCodeFrameSize,
+		},
+		{
+			name: "Invalid SETTINGS_MAX_FRAME_SIZE",
+			write: func(c *testConn) {
+				c.writeFrame(frameSettings, 0, 0, appendSettings(nil, []setting{{settingMaxFrameSize, 100}}))
+			},
+			want: errCodeProtocol,
+		},
+		{
+			name:  "Frame larger than SETTINGS_MAX_FRAME_SIZE",
+			write: func(c *testConn) { c.writeFrame(frameData, 0, 1, make([]byte, defaultMaxFrameSize+1)) },
+			want:  errCodeFrameSize,
+		},
+		{
+			name:  "Invalid HPACK block",
+			write: func(c *testConn) { c.writeFrame(frameHeaders, flagEndHeaders, 1, []byte{0x80}) },
+			want:  errCodeCompression,
+		},
+		{
+			name: "Reused stream",
+			write: func(c *testConn) {
+				c.writeHeaders(3, flagEndStream, requestFields("GET", "/")...)
+				c.readResponse(3)
+				c.writeHeaders(1, flagEndStream, requestFields("GET", "/")...)
+			},
+			want: errCodeStreamClosed,
+		},
+		{
+			name:  "PUSH_PROMISE from the client",
+			write: func(c *testConn) { c.writeFrame(framePushPromise, flagEndHeaders, 1, []byte{0, 0, 0, 2}) },
+			want:  errCodeProtocol,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := dialRaw(t, address)
+			c.handshake()
+
+			tc.write(c)
+			assert.Equal(t, tc.want, c.expectGoAway())
+
+			// The server closes the connection after GOAWAY.
+			_, err := io.ReadAll(c.reader)
+			require.NoError(t, err)
+		})
+	}
+
+	// Test: An invalid client preface
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			NewServer(testHandler).ServeConn(context.Background(), conn, nil)
+		}
+	}()
+
+	c := dialRaw(t, listener.Addr().String())
+
+	_, err = c.conn.Write([]byte("PRI * HTTP/2.0\r\n\r\nXX\r\n\r\n"))
+	require.NoError(t, err)
+
+	c.expectServerPreface()
+	assert.Equal(t, errCodeProtocol, c.expectGoAway())
+}
+
+func TestShutdown(t *testing.T) {
+	started := make(chan struct{})
+
+	h2 := NewServer(func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-req.Context().Done()
+
+		_ = w.WriteError(response.StatusCodeOK, "stopped")
+	})
+
+	srv, err := server.ServeWithOptions(0, testHandler, server.Options{PriorKnowledge: h2.ServeConn})
+	require.NoError(t, err)
+
+	c := dialRaw(t, srv.Addr().String())
+	c.handshake()
+	c.writeHeaders(1, flagEndStream, requestFields("GET", "/")...)
+
+	<-started
+
+	// Test: The open stream is completed and the connection is closed. The handler and
+	// the GOAWAY frame are both triggered by the shutdown so the frames can arrive in
+	// any order.
+	require.NoError(t, srv.Close())
+
+	var (
+		goAway *errCode
+		body   bytes.Buffer
+		ended  bool
+	)
+
+	for goAway == nil || !ended {
+		frame := c.readFrame()
+
+		switch frame.typ {
+		case frameGoAway:
+			code := errCode(binary.BigEndian.Uint32(frame.payload[4:]))
+			goAway = &code
+		case frameHeaders:
+			_, err := c.decoder.Decode(frame.payload, 1<<20)
+			require.NoError(t, err)
+		case frameData:
+			body.Write(frame.payload)
+		}
+
+		ended = ended || frame.streamID == 1 && frame.has(flagEndStream)
+	}
+
+	assert.Equal(t, errCodeNo, *goAway)
+	assert.Equal(t, "stopped\n", body.String())
+
+	_, err = io.ReadAll(c.reader)
+	require.NoError(t, err)
+}
+
+func TestRequestBodyTooLarge(t *testing.T) {
+	const maxBodySize = 100 << 10
+
+	h2 := NewServer(testHandler)
+
+	srv, err := server.ServeWithOptions(0, testHandler, server.Options{
+		PriorKnowledge: h2.ServeConn,
+		MaxBodySize:    maxBodySize,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = srv.Close() })
+
+	c := dialRaw(t, srv.Addr().String())
+	c.handshake()
+
+	// Test: The request gets a response once its body exceeds the limit of the server
+	c.writeHeaders(1, 0, requestFields("POST", "/")...)
+
+	chunk := make([]byte, defaultMaxFrameSize)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		// The body is sent until the server gives up on it.
+		for sent := 0; sent <= maxBodySize && ctx.Err() == nil; sent += len(chunk) {
+			frame := appendFrameHeader(nil, frameHeader{length: uint32(len(chunk)), typ: frameData, streamID: 1})
+			if _, err := c.conn.Write(append(frame, chunk...)); err != nil {
+				return
+			}
+		}
+	}()
+
+	// The DATA frames that arrive after the response can be reset as the stream is
+	// closed.
+	gotResponse := false
+
+	for {
+		frame := c.readFrame()
+
+		switch frame.typ {
+		case frameHeaders:
+			fields, err := c.decoder.Decode(frame.payload, 1<<20)
+			require.NoError(t, err)
+			assert.Contains(t, fields, hpack.HeaderField{Name: ":status", Value: "413"})
+
+			gotResponse = true
+		case frameRSTStream:
+			if code := errCode(binary.BigEndian.Uint32(frame.payload)); code == errCodeNo {
+				assert.True(t, gotResponse)
+
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Package http2 serves HTTP/2 (RFC 9113) with the same handlers as HTTP/1.1. Each
+// stream of a connection is mapped onto a request.Request and a response.Writer so
+// that the handlers do not know which protocol they are serving.
+//
+// A Server takes over the connections that have been accepted by a server.Server:
+// with prior knowledge (the connection starts with the HTTP/2 preface), after TLS
+// negotiated "h2" with ALPN, or after an HTTP/1.1 request was upgraded to "h2c".
+package http2
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"strings"
+
+	"http-from-tcp/internal/http2/hpack"
+	"http-from-tcp/internal/request"
+	"http-from-tcp/internal/response"
+	"http-from-tcp/internal/server"
+)
+
+const (
+	// clientPreface is the start of every HTTP/2 connection (RFC 9113, section 3.4).
+	clientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+	// maxConcurrentStreams is the number of streams that a client can open at the
+	// same time. A stream is open until its response has been sent.
+	maxConcurrentStreams int = 100
+
+	// maxHeaderListSize is the largest header list of a request. It is the same as
+	// the limit of the header section of an HTTP/1.1 request.
+	maxHeaderListSize int = 1 << 20
+
+	// streamWindowSize and connWindowSize are the flow control windows that the
+	// server gives the client to send the request bodies.
+	streamWindowSize int64 = 1 << 20
+	connWindowSize   int64 = 1 << 20
+
+	// readerSize is the size of the buffer used to read the frames.
+	readerSize int = 16 << 10
+
+	// writerSize is the size of the buffer used to write the frames.
+	writerSize int = 16 << 10
+)
+
+// Server serves HTTP/2 connections with a handler.
+type Server struct {
+	handler server.Handler
+}
+
+// NewServer returns a Server that calls the handler for each request.
+func NewServer(handler server.Handler) *Server {
+	return &Server{handler: handler}
+}
+
+// ServeConn serves HTTP/2 on the connection until it is closed. buffered is the data
+// that has already been read from the connection (e.g. the preface that was used to
+// detect HTTP/2). The connection is closed when ServeConn returns. When the context
+// is cancelled the client is told to stop opening streams and the connection is closed
+// once the open streams are done. ServeConn matches server.ConnHandler so it can be
+// used as Options.PriorKnowledge and in Options.NextProtos.
+func (s *Server) ServeConn(ctx context.Context, conn net.Conn, buffered []byte) {
+	s.newConn(ctx, conn, buffered).serve(nil)
+}
+
+// Upgrade switches an HTTP/1.1 connection to HTTP/2 over cleartext TCP (h2c). The
+// request must have the HTTP2-Settings header with the settings of the client and
+// it is answered on the first stream of the new connection (RFC 7540, section 3.2).
+// It is meant to be registered as the handler of "h2c" with a server.UpgradeMux.
+func (s *Server) Upgrade(w *response.Writer, req *request.Request) {
+	// The header must be sent once and it is only meant for the next hop.
+	value, ok := req.Headers["http2-settings"]
+	if !ok || strings.Contains(value, ",") || !req.Headers.HasToken("connection", "http2-settings") {
+		_ = w.WriteError(response.StatusCodeBadRequest, "Invalid HTTP2-Settings header")
+
+		return
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(strings.TrimSpace(value), "="))
+	if err != nil || len(payload)%settingSize != 0 {
+		_ = w.WriteError(response.StatusCodeBadRequest, "Invalid HTTP2-Settings header")
+
+		return
+	}
+
+	conn, buffered, err := w.SwitchProtocols("h2c", nil)
+	if err != nil {
+		slog.Error("error switching to HTTP/2.", "error", err.Error())
+
+		return
+	}
+
+	// The headers of the upgrade are not part of the request that is served on the
+	// first stream.
+	upgraded := *req
+	upgraded.Headers = maps.Clone(req.Headers)
+
+	for _, name := range []string{"connection", "upgrade", "http2-settings"} {
+		delete(upgraded.Headers, name)
+	}
+
+	s.newConn(req.Context(), conn, buffered).serve(&upgradeRequest{
+		settings: parseSettings(payload),
+		request:  &upgraded,
+	})
+}
+
+// upgradeRequest is the HTTP/1.1 request that switched the connection to h2c.
+type upgradeRequest struct {
+	settings []setting
+	request  *request.Request
+}
+
+func (s *Server) newConn(ctx context.Context, conn net.Conn, buffered []byte) *serverConn {
+	ctx, cancel := context.WithCancel(ctx)
+
+	sc := &serverConn{
+		server:            s,
+		conn:              conn,
+		reader:            bufio.NewReaderSize(io.MultiReader(bytes.NewReader(buffered), conn), readerSize),
+		ctx:               ctx,
+		cancel:            cancel,
+		decoder:           hpack.NewDecoder(hpack.DefaultTableSize),
+		headerBuf:         make([]byte, frameHeaderSize),
+		readBuf:           make([]byte, defaultMaxFrameSize),
+		recvWindow:        connWindowSize,
+		writer:            bufio.NewWriterSize(conn, writerSize),
+		encoder:           hpack.NewEncoder(hpack.DefaultTableSize),
+		streams:           make(map[uint32]*stream),
+		sendWindow:        defaultWindowSize,
+		initialWindowSize: defaultWindowSize,
+		maxFrameSize:      defaultMaxFrameSize,
+		maxBodySize:       server.MaxBodySize(ctx),
+	}
+	sc.cond.L = &sc.mu
+
+	return sc
+}
@@ -0,0 +1,259 @@
+package http2
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"http-from-tcp/internal/headers"
+	"http-from-tcp/internal/http2/hpack"
+	"http-from-tcp/internal/request"
+	"http-from-tcp/internal/response"
+)
+
+// stream is a request and its response on a connection. It implements
+// response.Stream so the handler writes the response with a response.Writer.
+type stream struct {
+	sc *serverConn
+	id uint32
+
+	// ctx is the context of the request. It is cancelled when the stream is reset
+	// or when the handler returns.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// The fields below are only used by the goroutine that reads the frames.
+	requestLine   request.RequestLine
+	headers       headers.Headers
+	trailers      headers.Headers
+	body          []byte
+	contentLength int
+	received      int
+	recvWindow    int64
+	recvUnacked   int64
+
+	// discarding is true if the stream already has a response (e.g. because the body
+	// is too large) and the rest of the request is ignored.
+	discarding bool
+
+	// The fields below are guarded by the mutex of the connection. remoteClosed is true
+	// once the client has sent the whole request and closed is true once the stream
+	// has been removed from the connection.
+	sendWindow   int64
+	remoteClosed bool
+	closed       bool
+
+	// The fields below are only used by the goroutine of the handler, except head
+	// which is set before the goroutine starts.
+	head         bool
+	statusCode   response.StatusCode
+	headersSent  bool
+	ended        bool
+	declaredLen  int
+	bytesWritten int
+}
+
+func (sc *serverConn) newStream(id uint32) *stream {
+	ctx, cancel := context.WithCancel(sc.ctx)
+
+	sc.mu.Lock()
+	sendWindow := sc.initialWindowSize
+	sc.mu.Unlock()
+
+	return &stream{
+		sc:            sc,
+		id:            id,
+		ctx:           ctx,
+		cancel:        cancel,
+		contentLength: -1,
+		recvWindow:    streamWindowSize,
+		sendWindow:    sendWindow,
+		declaredLen:   -1,
+	}
+}
+
+func (st *stream) isRemoteClosed() bool {
+	st.sc.mu.Lock()
+	defer st.sc.mu.Unlock()
+
+	return st.remoteClosed
+}
+
+// consumed returns the credit of the received data to the stream window once half of
+// the window has been used.
+func (st *stream) consumed(length int64) {
+	st.recvUnacked += length
+	if st.recvUnacked < streamWindowSize/2 {
+		return
+	}
+
+	st.sc.writeWindowUpdate(st.id, st.recvUnacked)
+	st.recvWindow += st.recvUnacked
+	st.recvUnacked = 0
+}
+
+// connectionHeaders are the headers that are specific to an HTTP/1.1 connection.
+// They must not be sent in an HTTP/2 message (RFC 9113, section 8.2.2).
+var connectionHeaders = map[string]bool{
+	"connection":        true,
+	"keep-alive":        true,
+	"proxy-connection":  true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+}
+
+// WriteHeaders sends the status code and the headers in a HEADERS frame. The headers
+// that are specific to HTTP/1.1 (e.g. a chunked Transfer-Encoding) are dropped.
+func (st *stream) WriteHeaders(statusCode response.StatusCode, h headers.Headers, cookies []string) error {
+	if st.headersSent {
+		return errors.New("the headers have already been sent")
+	}
+
+	fields := make([]hpack.HeaderField, 0, len(h)+len(cookies)+1)
+	fields = append(fields, hpack.HeaderField{Name: ":status", Value: strconv.Itoa(int(statusCode))})
+
+	// The headers are sorted so that the same headers are encoded the same way.
+	for _, key := range slices.Sorted(maps.Keys(h)) {
+		name := strings.ToLower(key)
+		if connectionHeaders[name] {
+			continue
+		}
+
+		if name == "content-length" {
+			if length, err := strconv.Atoi(h[key]); err == nil && length >= 0 {
+				st.declaredLen = length
+			}
+		}
+
+		fields = append(fields, hpack.HeaderField{Name: name, Value: h[key]})
+	}
+
+	for _, cookie := range cookies {
+		fields = append(fields, hpack.HeaderField{Name: "set-cookie", Value: cookie})
+	}
+
+	if st.isClosed() {
+		return errStreamReset
+	}
+
+	st.statusCode = statusCode
+	st.headersSent = true
+
+	return st.sc.writeHeaderBlock(st.id, fields, false)
+}
+
+// Write sends the data in DATA frames as the flow control windows allow. The data is
+// dropped if the request is a HEAD request.
+func (st *stream) Write(p []byte) (int, error) {
+	switch {
+	case !st.headersSent:
+		return 0, errors.New("the headers have not been sent")
+	case st.ended:
+		return 0, errors.New("the stream has already ended")
+	case st.head:
+		return len(p), nil
+	case st.declaredLen >= 0 && st.bytesWritten+len(p) > st.declaredLen:
+		return 0, errors.New("the body is longer than the Content-Length")
+	}
+
+	written := 0
+
+	for written < len(p) {
+		n, err := st.sc.reserveWindow(st, len(p)-written)
+		if err != nil {
+			return written, err
+		}
+
+		if err := st.sc.writeFrame(frameData, 0, st.id, p[written:written+n]); err != nil {
+			return written, err
+		}
+
+		written += n
+		st.bytesWritten += n
+	}
+
+	return written, nil
+}
+
+// WriteTrailers ends the stream with the trailers in a HEADERS frame, or with an empty
+// DATA frame if there are no trailers.
+func (st *stream) WriteTrailers(h headers.Headers) error {
+	if !st.headersSent || st.ended {
+		return errors.New("the stream is not in the correct state to write the trailers")
+	}
+
+	st.ended = true
+
+	fields := make([]hpack.HeaderField, 0, len(h))
+
+	for _, key := range slices.Sorted(maps.Keys(h)) {
+		name := strings.ToLower(key)
+		if !connectionHeaders[name] {
+			fields = append(fields, hpack.HeaderField{Name: name, Value: h[key]})
+		}
+	}
+
+	return st.end(fields)
+}
+
+// end removes the stream from the connection and sends the frame that ends it. The
+// stream is removed first so that the client can open a new stream as soon as it
+// receives the end of this one without exceeding the limit of concurrent streams.
+func (st *stream) end(trailers []hpack.HeaderField) error {
+	if !st.sc.removeStream(st) {
+		return errStreamReset
+	}
+
+	if len(trailers) > 0 {
+		return st.sc.writeHeaderBlock(st.id, trailers, true)
+	}
+
+	return st.sc.writeFrame(frameData, flagEndStream, st.id, nil)
+}
+
+// finish completes the stream after the handler has returned. The stream is reset if
+// the response is incomplete, as the HTTP/1.1 server would close the connection.
+func (st *stream) finish() {
+	defer st.sc.closeIfDrained()
+	defer st.cancel()
+
+	incomplete := !st.headersSent ||
+		(st.declaredLen >= 0 && st.bytesWritten < st.declaredLen && !st.head && bodyAllowed(st.statusCode))
+
+	switch {
+	case st.ended:
+	case incomplete:
+		if !st.isClosed() {
+			st.sc.resetStream(streamError{st.id, errCodeInternal, "the handler did not write a complete response"})
+		}
+
+		return
+	default:
+		st.ended = true
+		if err := st.end(nil); err != nil {
+			return
+		}
+	}
+
+	// The rest of the request is not needed once the response is complete (e.g.
+	// after a request body that is too large).
+	if !st.isRemoteClosed() {
+		st.sc.writeRSTStream(st.id, errCodeNo)
+	}
+}
+
+func (st *stream) isClosed() bool {
+	st.sc.mu.Lock()
+	defer st.sc.mu.Unlock()
+
+	return st.closed
+}
+
+// bodyAllowed returns false for the status codes of the responses that never have a
+// body.
+func bodyAllowed(statusCode response.StatusCode) bool {
+	return statusCode >= 200 && statusCode != 204 && statusCode != response.StatusCodeNotModified
+}
@@ -0,0 +1,94 @@
+package negotiation
+
+import (
+	"log/slog"
+	"strings"
+
+	"http-from-tcp/internal/request"
+	"http-from-tcp/internal/response"
+)
+
+// Offers are the representations that a handler can produce in the order of the
+// handler's preference. An empty list means that the handler does not negotiate
+// on the corresponding header.
+type Offers struct {
+	ContentTypes []string
+	Languages    []string
+	Charsets     []string
+}
+
+// Result is the representation selected for the request.
+type Result struct {
+	ContentType string
+	Language    string
+	Charset     string
+}
+
+// Vary returns the value for the Vary header of a response that was selected with
+// these offers.
+func (o Offers) Vary() string {
+	negotiated := make([]string, 0, 3)
+
+	if len(o.ContentTypes) > 0 {
+		negotiated = append(negotiated, "Accept")
+	}
+
+	if len(o.Languages) > 0 {
+		negotiated = append(negotiated, "Accept-Language")
+	}
+
+	if len(o.Charsets) > 0 {
+		negotiated = append(negotiated, "Accept-Charset")
+	}
+
+	return strings.Join(negotiated, ", ")
+}
+
+// Negotiate selects the best representation for the request from the offers. If
+// none of the offers are acceptable then Negotiate writes a 406 Not Acceptable
+// response and returns false.
+func Negotiate(w *response.Writer, req *request.Request, offers Offers) (Result, bool) {
+	var (
+		result = Result{}
+		ok     = true
+	)
+
+	if len(offers.ContentTypes) > 0 {
+		result.ContentType, ok = ContentType(req.Headers.Get("accept"), offers.ContentTypes)
+	}
+
+	if ok && len(offers.Languages) > 0 {
+		result.Language, ok = Language(req.Headers.Get("accept-language"), offers.Languages)
+	}
+
+	if ok && len(offers.Charsets) > 0 {
+		result.Charset, ok = Charset(req.Headers.Get("accept-charset"), offers.Charsets)
+	}
+
+	if !ok {
+		writeNotAcceptable(w, offers)
+
+		return Result{}, false
+	}
+
+	return result, true
+}
+
+// writeNotAcceptable writes a 406 Not Acceptable response that lists the
+// available representations.
+func writeNotAcceptable(w *response.Writer, offers Offers) {
+	message := response.StatusText(response.StatusCodeNotAcceptable)
+
+	available := make([]string, 0)
+	available = append(available, offers.ContentTypes...)
+	available = append(available, offers.Languages...)
+	available = append(available, offers.Charsets...)
+
+	if len(available) > 0 {
+		message += "\nAvailable representations: " + strings.Join(available, ", ")
+	}
+
+	if err := w.WriteError(response.StatusCodeNotAcceptable, message); err != nil {
+		slog.Error("error writing the error response.", "error", err.Error())
+	}
+}
@@ -0,0 +1,253 @@
+// Package negotiation implements proactive content negotiation with the Accept,
+// Accept-Language, Accept-Charset and Accept-Encoding headers (RFC 9110, section 12).
+package negotiation
+
+import (
+	"strconv"
+	"strings"
+
+	"http-from-tcp/internal/headers"
+)
+
+// Spec is a single entry from an Accept style header (e.g. text/html;q=0.8).
+type Spec struct {
+	// Value is the media range, language range, charset or content coding
+	// in lower case.
+	Value string
+
+	// Params are the parameters of a media range excluding the quality value.
+	Params map[string]string
+
+	// Quality is the quality value from 0 to 1000 (i.e. q=0.8 is 800).
+	Quality int
+}
+
+const maxQuality int = 1000
+
+// ParseAccept parses an Accept style header into its entries. Invalid entries
+// are ignored.
+func ParseAccept(value string) []Spec {
+	specs := make([]Spec, 0)
+
+	for entry := range strings.SplitSeq(value, ",") {
+		name, rest, _ := strings.Cut(entry, ";")
+
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		spec := Spec{
+			Value:   name,
+			Params:  make(map[string]string),
+			Quality: maxQuality,
+		}
+
+		valid := true
+
+		for param := range strings.SplitSeq(rest, ";") {
+			key, paramValue, _ := strings.Cut(param, "=")
+
+			key = strings.ToLower(strings.TrimSpace(key))
+			paramValue = strings.Trim(strings.TrimSpace(paramValue), `"`)
+
+			switch key {
+			case "":
+				continue
+			case "q":
+				quality, ok := parseQuality(paramValue)
+				if !ok {
+					valid = false
+				}
+
+				spec.Quality = quality
+			default:
+				spec.Params[key] = paramValue
+			}
+		}
+
+		if valid {
+			specs = append(specs, spec)
+		}
+	}
+
+	return specs
+}
+
+// parseQuality parses a quality value (RFC 9110, section 12.4.2) into an integer
+// from 0 to 1000.
+func parseQuality(value string) (int, bool) {
+	whole, fraction, _ := strings.Cut(value, ".")
+
+	if (whole != "0" && whole != "1") || len(fraction) > 3 {
+		return 0, false
+	}
+
+	for _, char := range fraction {
+		if char < '0' || char > '9' {
+			return 0, false
+		}
+	}
+
+	quality, _ := strconv.Atoi(whole + (fraction + "000")[:3])
+	if quality > maxQuality {
+		return 0, false
+	}
+
+	return quality, true
+}
+
+// matcher returns the specificity of the match between the spec and the offer
+// or -1 if the spec does not match the offer. A higher value is more specific.
+type matcher func(spec Spec, offer string) int
+
+// best returns the offer with the highest quality. The order of the offers is
+// used to break ties so the offers should be in the order of the server's
+// preference. false is returned if none of the offers are acceptable.
+func best(specs []Spec, offers []string, match matcher) (string, bool) {
+	var (
+		bestOffer   string
+		bestQuality int
+	)
+
+	for _, offer := range offers {
+		specificity, quality := -1, 0
+
+		for _, spec := range specs {
+			if s := match(spec, offer); s > specificity {
+				specificity, quality = s, spec.Quality
+			}
+		}
+
+		if specificity >= 0 && quality > bestQuality {
+			bestOffer, bestQuality = offer, quality
+		}
+	}
+
+	return bestOffer, bestQuality > 0
+}
+
+// ContentType returns the offered media type that best matches the Accept header.
+// Any offer is acceptable if the header is empty.
+func ContentType(accept string, offers []string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return firstOffer(offers)
+	}
+
+	return best(ParseAccept(accept), offers, matchMediaType)
+}
+
+func matchMediaType(spec Spec, offer string) int {
+	offerType, err := headers.ParseMediaType(offer)
+	if err != nil {
+		return -1
+	}
+
+	specType, specSubtype, _ := strings.Cut(spec.Value, "/")
+
+	switch {
+	case spec.Value == "*/*":
+		return 0
+	case specType != offerType.Type:
+		return -1
+	case specSubtype == "*":
+		return 1
+	case specSubtype != offerType.Subtype:
+		return -1
+	}
+
+	// The media range parameters must all be present in the offer.
+	for key, value := range spec.Params {
+		if !strings.EqualFold(offerType.Params[key], value) {
+			return -1
+		}
+	}
+
+	return 2 + len(spec.Params)
+}
+
+// Language returns the offered language tag that best matches the Accept-Language
+// header using the basic filtering scheme (RFC 4647, section 3.3.1). Any offer is
+// acceptable if the header is empty.
+func Language(acceptLanguage string, offers []string) (string, bool) {
+	if strings.TrimSpace(acceptLanguage) == "" {
+		return firstOffer(offers)
+	}
+
+	return best(ParseAccept(acceptLanguage), offers, matchLanguage)
+}
+
+func matchLanguage(spec Spec, offer string) int {
+	offer = strings.ToLower(offer)
+
+	switch {
+	case spec.Value == "*":
+		return 0
+	case offer == spec.Value, strings.HasPrefix(offer, spec.Value+"-"):
+		return len(spec.Value)
+	default:
+		return -1
+	}
+}
+
+// Charset returns the offered charset that best matches the Accept-Charset header.
+// Any offer is acceptable if the header is empty.
+func Charset(acceptCharset string, offers []string) (string, bool) {
+	if strings.TrimSpace(acceptCharset) == "" {
+		return firstOffer(offers)
+	}
+
+	return best(ParseAccept(acceptCharset), offers, matchToken)
+}
+
+func matchToken(spec Spec, offer string) int {
+	switch {
+	case spec.Value == "*":
+		return 0
+	case strings.EqualFold(spec.Value, offer):
+		return 1
+	default:
+		return -1
+	}
+}
+
+// Encoding returns the offered content coding that best matches the Accept-Encoding
+// header. The identity coding is acceptable unless it is explicitly excluded and it
+// is preferred when the header is empty.
+func Encoding(acceptEncoding string, offers []string) (string, bool) {
+	if strings.TrimSpace(acceptEncoding) == "" {
+		for _, offer := range offers {
+			if offer == "identity" {
+				return offer, true
+			}
+		}
+
+		return firstOffer(offers)
+	}
+
+	specs := ParseAccept(acceptEncoding)
+
+	// identity is acceptable with the lowest quality unless it is listed
+	// (directly or with *) in the header.
+	listed := false
+
+	for _, spec := range specs {
+		if spec.Value == "identity" || spec.Value == "*" {
+			listed = true
+		}
+	}
+
+	if !listed {
+		specs = append(specs, Spec{Value: "identity", Params: nil, Quality: 1})
+	}
+
+	return best(specs, offers, matchToken)
+}
+
+func firstOffer(offers []string) (string, bool) {
+	if len(offers) == 0 {
+		return "", false
+	}
+
+	return offers[0], true
+}
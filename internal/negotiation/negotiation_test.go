@@ -0,0 +1,123 @@
+package negotiation
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"http-from-tcp/internal/headers"
+	"http-from-tcp/internal/request"
+	"http-from-tcp/internal/response"
+)
+
+func TestParseAccept(t *testing.T) {
+	specs := ParseAccept("text/html;level=1, text/*;q=0.3, */*;q=0.001, application/json;q=2, , image/png;q=abc")
+	require.Len(t, specs, 3)
+	assert.Equal(t, Spec{Value: "text/html", Params: map[string]string{"level": "1"}, Quality: 1000}, specs[0])
+	assert.Equal(t, Spec{Value: "text/*", Params: map[string]string{}, Quality: 300}, specs[1])
+	assert.Equal(t, Spec{Value: "*/*", Params: map[string]string{}, Quality: 1}, specs[2])
+}
+
+func TestContentType(t *testing.T) {
+	testCases := []struct {
+		accept string
+		offers []string
+		want   string
+		wantOK bool
+	}{
+		{"", []string{"text/html", "text/plain"}, "text/html", true},
+		{"text/plain", []string{"text/html", "text/plain"}, "text/plain", true},
+		{"text/*;q=0.5, application/json", []string{"text/html", "application/json"}, "application/json", true},
+		{"text/html;q=0.1, text/*;q=0.8", []string{"text/html", "text/plain"}, "text/plain", true},
+		{"*/*;q=0.1, text/html;q=0", []string{"text/html", "image/png"}, "image/png", true},
+		{"text/html;level=1, text/html;q=0.2", []string{"text/html", "text/html;level=1"}, "text/html;level=1", true},
+		{"TEXT/HTML", []string{"text/html"}, "text/html", true},
+		{"application/json", []string{"text/html", "text/plain"}, "", false},
+		{"text/html;q=0", []string{"text/html"}, "", false},
+	}
+
+	for _, tc := range testCases {
+		got, ok := ContentType(tc.accept, tc.offers)
+		assert.Equal(t, tc.wantOK, ok, "Accept: %s", tc.accept)
+		assert.Equal(t, tc.want, got, "Accept: %s", tc.accept)
+	}
+}
+
+func TestLanguage(t *testing.T) {
+	testCases := []struct {
+		acceptLanguage string
+		offers         []string
+		want           string
+		wantOK         bool
+	}{
+		{"", []string{"en-GB", "fr"}, "en-GB", true},
+		{"fr-CH, fr;q=0.9, en;q=0.8, *;q=0.5", []string{"en-GB", "fr"}, "fr", true},
+		{"en-US, en;q=0.5", []string{"en-GB", "en-US"}, "en-US", true},
+		{"en", []string{"de", "en-GB"}, "en-GB", true},
+		{"*;q=0.1, de;q=0", []string{"de", "es"}, "es", true},
+		{"ja", []string{"en-GB", "fr"}, "", false},
+	}
+
+	for _, tc := range testCases {
+		got, ok := Language(tc.acceptLanguage, tc.offers)
+		assert.Equal(t, tc.wantOK, ok, "Accept-Language: %s", tc.acceptLanguage)
+		assert.Equal(t, tc.want, got, "Accept-Language: %s", tc.acceptLanguage)
+	}
+}
+
+func TestCharsetAndEncoding(t *testing.T) {
+	// Test: Charset
+	got, ok := Charset("iso-8859-5, UTF-8;q=0.8", []string{"utf-8", "iso-8859-5"})
+	assert.True(t, ok)
+	assert.Equal(t, "iso-8859-5", got)
+
+	_, ok = Charset("iso-8859-5", []string{"utf-8"})
+	assert.False(t, ok)
+
+	// Test: Encoding
+	got, ok = Encoding("", []string{"gzip", "identity"})
+	assert.True(t, ok)
+	assert.Equal(t, "identity", got)
+
+	got, ok = Encoding("gzip;q=0.5, deflate", []string{"gzip", "deflate", "identity"})
+	assert.True(t, ok)
+	assert.Equal(t, "deflate", got)
+
+	got, ok = Encoding("br", []string{"gzip", "identity"})
+	assert.True(t, ok)
+	assert.Equal(t, "identity", got)
+
+	_, ok = Encoding("br, identity;q=0", []string{"gzip", "identity"})
+	assert.False(t, ok)
+
+	_, ok = Encoding("*;q=0", []string{"gzip", "identity"})
+	assert.False(t, ok)
+}
+
+func TestNegotiate(t *testing.T) {
+	offers := Offers{
+		ContentTypes: []string{"text/html", "text/plain"},
+		Languages:    []string{"en"},
+		Charsets:     nil,
+	}
+
+	assert.Equal(t, "Accept, Accept-Language", offers.Vary())
+
+	// Test: Acceptable representation
+	req := &request.Request{Headers: headers.Headers{"accept": "text/plain", "accept-language": "en-GB, en"}}
+	buf := new(bytes.Buffer)
+	result, ok := Negotiate(response.NewWriter(buf), req, offers)
+	require.True(t, ok)
+	assert.Equal(t, Result{ContentType: "text/plain", Language: "en", Charset: ""}, result)
+	assert.Empty(t, buf.String())
+
+	// Test: Not acceptable
+	req = &request.Request{Headers: headers.Headers{"accept": "text/plain", "accept-language": "de"}}
+	buf = new(bytes.Buffer)
+	_, ok = Negotiate(response.NewWriter(buf), req, offers)
+	require.False(t, ok)
+	assert.Contains(t, buf.String(), "HTTP/1.1 406 Not Acceptable\r\n")
+	assert.Contains(t, buf.String(), "Available representations: text/html, text/plain, en")
+}
@@ -0,0 +1,299 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"http-from-tcp/internal/client"
+	"http-from-tcp/internal/request"
+	"http-from-tcp/internal/response"
+)
+
+// defaultDialTimeout is the time to wait for the connection to the destination of a
+// tunnel.
+const defaultDialTimeout = 10 * time.Second
+
+// ForwardOptions are the options for the forward proxy.
+type ForwardOptions struct {
+	// Allow lists the destinations that the clients can reach (see below for the
+	// syntax). Every destination that is not denied is allowed if it is empty.
+	Allow []string
+
+	// Deny lists the destinations that the clients cannot reach. It takes precedence
+	// over Allow. The rules with an IP address or a network are also checked against
+	// the address that a host name resolves to.
+	//
+	// A rule is written as host, host:port or [ipv6]:port. The host can be * (any
+	// host), a wildcard such as *.example.com (any subdomain of example.com), an IP
+	// address or a network in CIDR notation (e.g. 10.0.0.0/8). The port can be * or
+	// left out to match any port.
+	Deny []string
+
+	// Timeout is the maximum time to wait for the headers of a response. It is 30
+	// seconds if it is zero.
+	Timeout time.Duration
+
+	// DialTimeout is the maximum time to wait for the connection to the destination
+	// of a tunnel. It is 10 seconds if it is zero.
+	DialTimeout time.Duration
+
+	// OnTunnelClosed is called with the accounting of each tunnel once it has closed.
+	OnTunnelClosed func(Tunnel)
+}
+
+// Tunnel is the accounting of a tunnel that was opened with a CONNECT request.
+type Tunnel struct {
+	// Client is the address of the client and Target is the authority that the
+	// client asked for.
+	Client string
+	Target string
+
+	// Sent is the number of bytes sent by the client to the target and Received is
+	// the number of bytes received by the client from the target.
+	Sent     int64
+	Received int64
+
+	// Duration is the time that the tunnel was open.
+	Duration time.Duration
+}
+
+// ForwardProxy is an outbound proxy. It forwards the requests that have an absolute-form
+// target (e.g. GET http://example.com/ HTTP/1.1) and it opens a TCP tunnel to the
+// destination of a CONNECT request (e.g. CONNECT example.com:443 HTTP/1.1).
+type ForwardProxy struct {
+	rules     rules
+	options   ForwardOptions
+	dialer    *net.Dialer
+	transport *client.Client
+}
+
+// NewForward returns a forward proxy. An error is returned if a rule is invalid.
+func NewForward(options ForwardOptions) (*ForwardProxy, error) {
+	rules, err := parseRules(options.Allow, options.Deny)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Timeout == 0 {
+		options.Timeout = defaultTimeout
+	}
+
+	if options.DialTimeout == 0 {
+		options.DialTimeout = defaultDialTimeout
+	}
+
+	dialer := &net.Dialer{Timeout: options.DialTimeout, Control: rules.control}
+
+	return &ForwardProxy{
+		rules:     rules,
+		options:   options,
+		dialer:    dialer,
+		transport: client.New(client.Options{DialContext: dialer.DialContext}),
+	}, nil
+}
+
+// Close closes the idle connections to the destinations.
+func (p *ForwardProxy) Close() {
+	p.transport.CloseIdleConnections()
+}
+
+// Serve handles a proxy request. A 403 Forbidden response is sent if the destination
+// is not allowed and a 400 Bad Request response is sent if the request is not meant
+// for a proxy.
+func (p *ForwardProxy) Serve(w *response.Writer, req *request.Request) {
+	if req.RequestLine.Method == "CONNECT" {
+		p.serveConnect(w, req)
+
+		return
+	}
+
+	target, err := url.Parse(req.RequestLine.RequestTarget)
+	if err != nil || target.Scheme != "http" || target.Host == "" {
+		writeError(w, response.StatusCodeBadRequest)
+
+		return
+	}
+
+	port := 80
+	if target.Port() != "" {
+		if port, err = strconv.Atoi(target.Port()); err != nil {
+			writeError(w, response.StatusCodeBadRequest)
+
+			return
+		}
+	}
+
+	if !p.rules.allowed(target.Hostname(), port) {
+		writeError(w, response.StatusCodeForbidden)
+
+		return
+	}
+
+	ctx, cancel := context.WithCancelCause(req.Context())
+	defer cancel(nil)
+
+	outReq, err := newOutgoingRequest(ctx, req, target.String())
+	if err != nil {
+		slog.Error("error creating the proxy request.", "error", err.Error())
+		writeError(w, response.StatusCodeBadRequest)
+
+		return
+	}
+
+	resp, err := roundTrip(p.transport, outReq, cancel, p.options.Timeout)
+	if errors.Is(err, errDeniedAddress) {
+		writeError(w, response.StatusCodeForbidden)
+
+		return
+	}
+
+	if err != nil {
+		writeRoundTripError(w, req, err)
+
+		return
+	}
+	defer resp.Body.Close()
+
+	writeResponse(w, req, resp)
+}
+
+// serveConnect opens a tunnel to the authority of the CONNECT request and copies the
+// data in both directions until both sides have closed their connection.
+func (p *ForwardProxy) serveConnect(w *response.Writer, req *request.Request) {
+	authority := req.RequestLine.RequestTarget
+
+	host, portStr, err := net.SplitHostPort(authority)
+
+	port, portErr := strconv.Atoi(portStr)
+	if err != nil || portErr != nil || host == "" || port < 1 || port > 65535 {
+		writeError(w, response.StatusCodeBadRequest)
+
+		return
+	}
+
+	if !p.rules.allowed(host, port) {
+		writeError(w, response.StatusCodeForbidden)
+
+		return
+	}
+
+	target, err := p.dialer.DialContext(req.Context(), "tcp", authority)
+	if err != nil {
+		var netErr net.Error
+
+		switch {
+		case errors.Is(err, errDeniedAddress):
+			writeError(w, response.StatusCodeForbidden)
+		case errors.As(err, &netErr) && netErr.Timeout():
+			writeError(w, response.StatusCodeGatewayTimeout)
+		default:
+			slog.Error("error connecting to the tunnel target.", "target", authority, "error", err.Error())
+			writeError(w, response.StatusCodeBadGateway)
+		}
+
+		return
+	}
+
+	// The tunnel needs the raw connection, which an HTTP/2 stream does not have.
+	client, buffered, err := w.Hijack()
+	if err != nil {
+		_ = target.Close()
+
+		writeError(w, response.StatusCodeNotImplemented)
+
+		return
+	}
+
+	tunnel := Tunnel{Client: req.RemoteAddr, Target: authority}
+	start := time.Now()
+
+	if err := openTunnel(client, buffered, target, &tunnel); err != nil {
+		slog.Error("error opening the tunnel.", "target", authority, "error", err.Error())
+	} else {
+		relay(req.Context(), client, target, &tunnel)
+	}
+
+	_ = client.Close()
+	_ = target.Close()
+
+	tunnel.Duration = time.Since(start)
+
+	slog.Info(
+		"Tunnel closed.",
+		"client", tunnel.Client,
+		"target", tunnel.Target,
+		"sent", tunnel.Sent,
+		"received", tunnel.Received,
+		"duration", tunnel.Duration,
+	)
+
+	if p.options.OnTunnelClosed != nil {
+		p.options.OnTunnelClosed(tunnel)
+	}
+}
+
+// openTunnel tells the client that the tunnel is established and passes on the data
+// that the client sent after the CONNECT request (e.g. the start of a TLS handshake).
+func openTunnel(client net.Conn, buffered []byte, target net.Conn, tunnel *Tunnel) error {
+	if _, err := client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		return fmt.Errorf("error writing the response: %w", err)
+	}
+
+	n, err := target.Write(buffered)
+	tunnel.Sent += int64(n)
+
+	if err != nil {
+		return fmt.Errorf("error writing the buffered data: %w", err)
+	}
+
+	return nil
+}
+
+// relay copies the data in both directions. The connections are closed when the
+// context is cancelled (e.g. when the server is closed).
+func relay(ctx context.Context, client, target net.Conn, tunnel *Tunnel) {
+	stop := context.AfterFunc(ctx, func() {
+		_ = client.Close()
+		_ = target.Close()
+	})
+	defer stop()
+
+	var wg sync.WaitGroup
+
+	wg.Go(func() { tunnel.Received += pipe(client, target) })
+
+	sent := pipe(target, client)
+
+	wg.Wait()
+
+	tunnel.Sent += sent
+}
+
+// pipe copies the data from src to dst until src reaches EOF and then closes the
+// writing side of dst so that the end of the data is passed on. Both connections are
+// closed if the copy fails so that the other direction stops too.
+func pipe(dst, src net.Conn) int64 {
+	n, err := io.Copy(dst, src)
+	if err != nil {
+		_ = dst.Close()
+		_ = src.Close()
+
+		return n
+	}
+
+	if writeCloser, ok := dst.(interface{ CloseWrite() error }); ok {
+		_ = writeCloser.CloseWrite()
+	} else {
+		_ = dst.Close()
+	}
+
+	return n
+}
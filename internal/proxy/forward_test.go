@@ -0,0 +1,321 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"http-from-tcp/internal/request"
+	"http-from-tcp/internal/response"
+)
+
+// startForwardProxy starts a server with a forward proxy and returns its address.
+func startForwardProxy(t *testing.T, options ForwardOptions) string {
+	t.Helper()
+
+	p, err := NewForward(options)
+	require.NoError(t, err)
+	t.Cleanup(p.Close)
+
+	return strings.TrimPrefix(startServer(t, p.Serve), "http://")
+}
+
+// newProxyClient returns a client that sends its requests through the proxy.
+func newProxyClient(t *testing.T, proxyAddr string) *http.Client {
+	t.Helper()
+
+	transport := &http.Transport{Proxy: http.ProxyURL(&url.URL{Scheme: "http", Host: proxyAddr})}
+	t.Cleanup(transport.CloseIdleConnections)
+
+	return &http.Client{Transport: transport, Timeout: 5 * time.Second}
+}
+
+// startEchoServer starts a TCP server that sends back the data that it receives and
+// returns its address.
+func startEchoServer(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+// dialProxy sends a CONNECT request to the proxy followed by the data and returns the
+// connection.
+func dialProxy(t *testing.T, proxyAddr, target, data string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", proxyAddr)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	_, err = conn.Write([]byte("CONNECT " + target + " HTTP/1.1\r\nHost: " + target + "\r\n\r\n" + data))
+	require.NoError(t, err)
+
+	return conn, bufio.NewReader(conn)
+}
+
+func TestForwardHTTP(t *testing.T) {
+	received := make(chan *request.Request, 1)
+
+	upstream := startServer(t, func(w *response.Writer, req *request.Request) {
+		received <- req
+
+		writeText(w, response.StatusCodeOK, "from upstream")
+	})
+
+	client := newProxyClient(t, startForwardProxy(t, ForwardOptions{}))
+
+	req, err := http.NewRequest(http.MethodGet, upstream+"/path?x=1", nil)
+	require.NoError(t, err)
+	req.Header.Set("Proxy-Authorization", "Basic dXNlcjpwYXNz")
+
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	// Test: The absolute-form request is forwarded in origin-form to its destination
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "from upstream", string(body))
+
+	upstreamReq := <-received
+	assert.Equal(t, "/path?x=1", upstreamReq.RequestLine.RequestTarget)
+	assert.Equal(t, strings.TrimPrefix(upstream, "http://"), upstreamReq.Headers.Get("host"))
+	assert.NotContains(t, upstreamReq.Headers, "proxy-authorization")
+
+	// Test: The Host header of an absolute-form request is replaced by the authority
+	// of its target
+	conn, err := net.Dial("tcp", startForwardProxy(t, ForwardOptions{}))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	authority := strings.TrimPrefix(upstream, "http://")
+
+	_, err = conn.Write([]byte("GET " + upstream + "/ HTTP/1.1\r\nHost: b.example\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+
+	_, err = io.ReadAll(conn)
+	require.NoError(t, err)
+
+	upstreamReq = <-received
+	assert.Equal(t, authority, upstreamReq.Headers.Get("host"))
+	assert.Equal(t, authority, upstreamReq.Headers.Get("x-forwarded-host"))
+	assert.Contains(t, upstreamReq.Headers.Get("forwarded"), `host="`+authority+`"`)
+}
+
+func TestForwardRules(t *testing.T) {
+	upstream := startServer(t, func(w *response.Writer, req *request.Request) {
+		writeText(w, response.StatusCodeOK, "from upstream")
+	})
+
+	_, port, err := net.SplitHostPort(strings.TrimPrefix(upstream, "http://"))
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name       string
+		options    ForwardOptions
+		target     string
+		statusCode int
+	}{
+		{
+			name:       "Allowed destination",
+			options:    ForwardOptions{Allow: []string{"127.0.0.1:" + port}},
+			target:     "http://127.0.0.1:" + port + "/",
+			statusCode: 200,
+		},
+		{
+			name:       "Destination missing from the allow list",
+			options:    ForwardOptions{Allow: []string{"*.example.com"}},
+			target:     "http://127.0.0.1:" + port + "/",
+			statusCode: 403,
+		},
+		{
+			name:       "Denied network",
+			options:    ForwardOptions{Deny: []string{"127.0.0.0/8"}},
+			target:     "http://127.0.0.1:" + port + "/",
+			statusCode: 403,
+		},
+		{
+			name:       "Denied port",
+			options:    ForwardOptions{Deny: []string{"*:" + port}},
+			target:     "http://127.0.0.1:" + port + "/",
+			statusCode: 403,
+		},
+		{
+			name:       "Host name that resolves to a denied address",
+			options:    ForwardOptions{Deny: []string{"127.0.0.0/8", "::1"}},
+			target:     "http://localhost:" + port + "/",
+			statusCode: 403,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newProxyClient(t, startForwardProxy(t, tc.options))
+
+			resp, err := client.Get(tc.target)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+
+			assert.Equal(t, tc.statusCode, resp.StatusCode)
+		})
+	}
+
+	// Test: A request in origin-form is not a proxy request
+	statusCode, _ := get(t, "http://"+startForwardProxy(t, ForwardOptions{})+"/")
+	assert.Equal(t, 400, statusCode)
+
+	// Test: Invalid rules
+	_, err = NewForward(ForwardOptions{Allow: []string{"example.com:http"}})
+	require.Error(t, err)
+
+	_, err = NewForward(ForwardOptions{Deny: []string{"10.0.0.0/33"}})
+	require.Error(t, err)
+
+	_, err = NewForward(ForwardOptions{Deny: []string{"a.*.example.com"}})
+	require.Error(t, err)
+}
+
+func TestConnect(t *testing.T) {
+	echo := startEchoServer(t)
+	tunnels := make(chan Tunnel, 1)
+
+	proxyAddr := startForwardProxy(t, ForwardOptions{
+		Allow:          []string{echo},
+		OnTunnelClosed: func(tunnel Tunnel) { tunnels <- tunnel },
+	})
+
+	// Test: The data sent with the CONNECT request and afterwards goes through the tunnel
+	conn, reader := dialProxy(t, proxyAddr, echo, "ping")
+
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 Connection Established\r\n", line)
+
+	line, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "\r\n", line)
+
+	_, err = conn.Write([]byte("pong!"))
+	require.NoError(t, err)
+
+	buf := make([]byte, len("pingpong!"))
+	_, err = io.ReadFull(reader, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "pingpong!", string(buf))
+
+	// Test: Closing the writing side of the client closes the tunnel
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+
+	rest, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+
+	// Test: The bytes of the tunnel are accounted for
+	tunnel := <-tunnels
+	assert.Equal(t, echo, tunnel.Target)
+	assert.Equal(t, conn.LocalAddr().String(), tunnel.Client)
+	assert.Equal(t, int64(9), tunnel.Sent)
+	assert.Equal(t, int64(9), tunnel.Received)
+	assert.Positive(t, tunnel.Duration)
+}
+
+func TestConnectErrors(t *testing.T) {
+	echo := startEchoServer(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	closed := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	proxyAddr := startForwardProxy(t, ForwardOptions{Deny: []string{"*:1"}})
+
+	testCases := []struct {
+		name       string
+		target     string
+		statusLine string
+	}{
+		{"Denied destination", "127.0.0.1:1", "HTTP/1.1 403 Forbidden\r\n"},
+		{"Missing port", "127.0.0.1", "HTTP/1.1 400 Bad Request\r\n"},
+		{"Invalid port", "127.0.0.1:70000", "HTTP/1.1 400 Bad Request\r\n"},
+		{"Unreachable destination", closed, "HTTP/1.1 502 Bad Gateway\r\n"},
+		{"Allowed destination", echo, "HTTP/1.1 200 Connection Established\r\n"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, reader := dialProxy(t, proxyAddr, tc.target, "")
+
+			line, err := reader.ReadString('\n')
+			require.NoError(t, err)
+			assert.Equal(t, tc.statusLine, line)
+		})
+	}
+}
+
+func TestRules(t *testing.T) {
+	testCases := []struct {
+		pattern string
+		host    string
+		port    int
+		matches bool
+	}{
+		{"example.com", "example.com", 443, true},
+		{"example.com", "EXAMPLE.com.", 80, true},
+		{"example.com", "www.example.com", 443, false},
+		{"example.com:443", "example.com", 80, false},
+		{"*.example.com", "www.example.com", 443, true},
+		{"*.example.com", "example.com", 443, false},
+		{"*.example.com:*", "a.b.example.com", 8080, true},
+		{"*:22", "example.org", 22, true},
+		{"*:22", "example.org", 23, false},
+		{"10.0.0.0/8", "10.1.2.3", 80, true},
+		{"10.0.0.0/8", "11.1.2.3", 80, false},
+		{"10.0.0.0/8", "example.com", 80, false},
+		{"::1", "[::1]", 80, true},
+		{"[::1]:443", "::1", 443, true},
+		{"[::1]:443", "::1", 80, false},
+		{"fd00::/8", "fd12::1", 80, true},
+		{"127.0.0.1", "::ffff:127.0.0.1", 80, true},
+	}
+
+	for _, tc := range testCases {
+		r, err := parseRule(tc.pattern)
+		require.NoError(t, err, tc.pattern)
+
+		assert.Equal(t, tc.matches, r.matches(normaliseHost(tc.host), tc.port), "%s %s:%d", tc.pattern, tc.host, tc.port)
+	}
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// checkHealth checks the upstream every HealthCheckInterval until the context is
+// cancelled. The first check is done immediately.
+func (p *ReverseProxy) checkHealth(ctx context.Context, up *upstream) {
+	ticker := time.NewTicker(p.options.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		healthy := p.probe(ctx, up)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if up.healthy.Swap(healthy) != healthy {
+			slog.Info("The health of an upstream changed.", "upstream", up.url.String(), "healthy", healthy)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe requests the health check path from the upstream. The upstream is healthy if
+// it answers with a 2xx or 3xx status code before the next check is due.
+func (p *ReverseProxy) probe(ctx context.Context, up *upstream) bool {
+	ctx, cancel := context.WithTimeout(ctx, p.options.HealthCheckInterval)
+	defer cancel()
+
+	target := up.url.Scheme + "://" + up.url.Host + strings.TrimSuffix(up.url.EscapedPath(), "/") +
+		"/" + strings.TrimPrefix(p.options.HealthCheckPath, "/")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return false
+	}
+
+	resp, err := p.options.Transport.RoundTrip(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	// The body is read so that the connection can be reused for the next check.
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, bufferSize))
+
+	return resp.StatusCode >= 200 && resp.StatusCode < 400
+}
+
+// markUnhealthy takes an upstream that could not be reached out of the rotation until
+// it passes a health check. Nothing is done if the health checks are disabled as the
+// upstream would never be used again.
+func (p *ReverseProxy) markUnhealthy(up *upstream) {
+	if p.options.HealthCheckPath == "" {
+		return
+	}
+
+	if up.healthy.Swap(false) {
+		slog.Info("The health of an upstream changed.", "upstream", up.url.String(), "healthy", false)
+	}
+}
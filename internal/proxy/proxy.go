@@ -0,0 +1,265 @@
+// Package proxy implements a reverse proxy that forwards the requests that it receives
+// to one or more upstream servers and relays their responses, and a forward proxy that
+// forwards the requests of the clients to any allowed destination and tunnels their
+// CONNECT requests. The bodies of the responses are streamed to the client as they
+// arrive.
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"http-from-tcp/internal/client"
+	"http-from-tcp/internal/request"
+	"http-from-tcp/internal/response"
+)
+
+const (
+	// defaultTimeout is the time to wait for the headers of an upstream response.
+	defaultTimeout = 30 * time.Second
+
+	// defaultHealthCheckInterval is the time between two health checks of an upstream.
+	defaultHealthCheckInterval = 10 * time.Second
+
+	// bufferSize is the size of the buffer used to copy the response bodies.
+	bufferSize = 32 << 10
+)
+
+// errUpstreamTimeout is the cause of the cancellation of an upstream request that
+// did not get the headers of the response in time.
+var errUpstreamTimeout = errors.New("the upstream server did not respond in time")
+
+// Balancing is the way that the proxy chooses the upstream of a request.
+type Balancing int
+
+const (
+	// RoundRobin sends the requests to the healthy upstreams in turn.
+	RoundRobin Balancing = iota
+
+	// LeastConnections sends each request to the healthy upstream with the fewest
+	// requests in progress.
+	LeastConnections
+)
+
+// Options are the options for the reverse proxy.
+type Options struct {
+	// Balancing chooses the upstream of each request. It is RoundRobin by default.
+	Balancing Balancing
+
+	// StripPrefix is removed from the path of the request before it is forwarded
+	// (e.g. "/api" forwards /api/users to /users).
+	StripPrefix string
+
+	// PreserveHost forwards the Host header of the request. The host of the upstream
+	// is used by default.
+	PreserveHost bool
+
+	// Timeout is the maximum time to wait for the headers of an upstream response.
+	// A 504 Gateway Timeout response is sent when it expires. It is 30 seconds if
+	// it is zero.
+	Timeout time.Duration
+
+	// HealthCheckPath is requested from each upstream every HealthCheckInterval. An
+	// upstream that does not answer with a 2xx or 3xx status code does not get any
+	// requests until it passes a health check again. The health checks are disabled
+	// if it is empty.
+	HealthCheckPath string
+
+	// HealthCheckInterval is the time between two health checks. It is 10 seconds
+	// if it is zero.
+	HealthCheckInterval time.Duration
+
+	// Transport sends the requests to the upstreams. A client.Client is used if it
+	// is nil.
+	Transport http.RoundTripper
+}
+
+// ReverseProxy forwards the requests to the upstreams.
+type ReverseProxy struct {
+	upstreams []*upstream
+	options   Options
+	next      atomic.Uint64
+
+	// stop stops the health checks and wg waits for them to return.
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+// upstream is a server that the requests are forwarded to.
+type upstream struct {
+	url     *url.URL
+	healthy atomic.Bool
+	active  atomic.Int64
+}
+
+// New returns a reverse proxy that forwards the requests to the upstreams. Each target
+// is the absolute URL of an upstream, which can have a path that is prepended to the
+// path of the requests. The health checks start immediately if they are enabled and
+// they run until Close is called.
+func New(targets []string, options Options) (*ReverseProxy, error) {
+	if len(targets) == 0 {
+		return nil, errors.New("no upstream specified")
+	}
+
+	if options.Balancing != RoundRobin && options.Balancing != LeastConnections {
+		return nil, fmt.Errorf("unknown balancing %d", options.Balancing)
+	}
+
+	if options.Timeout == 0 {
+		options.Timeout = defaultTimeout
+	}
+
+	if options.HealthCheckInterval == 0 {
+		options.HealthCheckInterval = defaultHealthCheckInterval
+	}
+
+	if options.Transport == nil {
+		// The client of the project relays the responses as they are, without
+		// changing their encoding.
+		options.Transport = client.New(client.Options{})
+	}
+
+	p := &ReverseProxy{
+		upstreams: make([]*upstream, 0, len(targets)),
+		options:   options,
+		stop:      func() {},
+	}
+
+	for _, target := range targets {
+		targetURL, err := url.Parse(target)
+		if err != nil || (targetURL.Scheme != "http" && targetURL.Scheme != "https") || targetURL.Host == "" {
+			return nil, fmt.Errorf("invalid upstream URL %q", target)
+		}
+
+		up := &upstream{url: targetURL}
+		up.healthy.Store(true)
+
+		p.upstreams = append(p.upstreams, up)
+	}
+
+	if options.HealthCheckPath != "" {
+		ctx, cancel := context.WithCancel(context.Background())
+		p.stop = cancel
+
+		for _, up := range p.upstreams {
+			p.wg.Go(func() { p.checkHealth(ctx, up) })
+		}
+	}
+
+	return p, nil
+}
+
+// Close stops the health checks.
+func (p *ReverseProxy) Close() {
+	p.stop()
+	p.wg.Wait()
+}
+
+// Serve forwards the request to an upstream and writes its response. A 503 Service
+// Unavailable response is sent if none of the upstreams is healthy and a 502 Bad
+// Gateway response is sent if the upstream cannot be reached.
+func (p *ReverseProxy) Serve(w *response.Writer, req *request.Request) {
+	up, ok := p.pick()
+	if !ok {
+		writeError(w, response.StatusCodeServiceUnavailable)
+
+		return
+	}
+
+	up.active.Add(1)
+	defer up.active.Add(-1)
+
+	ctx, cancel := context.WithCancelCause(req.Context())
+	defer cancel(nil)
+
+	outReq, err := p.newUpstreamRequest(ctx, req, up)
+	if err != nil {
+		slog.Error("error creating the upstream request.", "error", err.Error())
+		writeError(w, response.StatusCodeBadRequest)
+
+		return
+	}
+
+	resp, err := roundTrip(p.options.Transport, outReq, cancel, p.options.Timeout)
+	if err != nil {
+		if writeRoundTripError(w, req, err) {
+			p.markUnhealthy(up)
+		}
+
+		return
+	}
+	defer resp.Body.Close()
+
+	writeResponse(w, req, resp)
+}
+
+// pick chooses the upstream of a request among the healthy upstreams.
+func (p *ReverseProxy) pick() (*upstream, bool) {
+	healthy := make([]*upstream, 0, len(p.upstreams))
+
+	for _, up := range p.upstreams {
+		if up.healthy.Load() {
+			healthy = append(healthy, up)
+		}
+	}
+
+	if len(healthy) == 0 {
+		return nil, false
+	}
+
+	// The search starts at a different upstream each time so that the ties are
+	// spread over the upstreams.
+	start := int((p.next.Add(1) - 1) % uint64(len(healthy)))
+
+	if p.options.Balancing == RoundRobin {
+		return healthy[start], true
+	}
+
+	chosen := healthy[start]
+
+	for idx := range healthy {
+		up := healthy[(start+idx)%len(healthy)]
+		if up.active.Load() < chosen.active.Load() {
+			chosen = up
+		}
+	}
+
+	return chosen, true
+}
+
+// newUpstreamRequest returns the request that is sent to the upstream.
+func (p *ReverseProxy) newUpstreamRequest(ctx context.Context, req *request.Request, up *upstream) (*http.Request, error) {
+	path, query, hasQuery := strings.Cut(req.RequestLine.RequestTarget, "?")
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("the request target %q is not in origin-form", req.RequestLine.RequestTarget)
+	}
+
+	path = strings.TrimPrefix(path, p.options.StripPrefix)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	target := up.url.Scheme + "://" + up.url.Host + strings.TrimSuffix(up.url.EscapedPath(), "/") + path
+	if hasQuery {
+		target += "?" + query
+	}
+
+	outReq, err := newOutgoingRequest(ctx, req, target)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.options.PreserveHost {
+		outReq.Host = req.Headers.Get("host")
+	}
+
+	return outReq, nil
+}
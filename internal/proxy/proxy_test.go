@@ -0,0 +1,373 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"http-from-tcp/internal/headers"
+	"http-from-tcp/internal/request"
+	"http-from-tcp/internal/response"
+	"http-from-tcp/internal/server"
+)
+
+// startServer starts a server with the handler on a random port and returns its
+// base URL.
+func startServer(t *testing.T, handler server.Handler) string {
+	t.Helper()
+
+	srv, err := server.Serve(0, handler)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = srv.Close() })
+
+	return "http://" + srv.Addr().String()
+}
+
+// startProxy starts a server that proxies the requests to the upstreams and returns
+// its base URL.
+func startProxy(t *testing.T, upstreams []string, options Options) string {
+	t.Helper()
+
+	p, err := New(upstreams, options)
+	require.NoError(t, err)
+	t.Cleanup(p.Close)
+
+	return startServer(t, p.Serve)
+}
+
+// writeText writes a complete response with the text as the body.
+func writeText(w *response.Writer, statusCode response.StatusCode, text string) {
+	_ = w.WriteStatusLine(statusCode)
+	_ = w.WriteHeaders(response.GetDefaultHeaders(len(text)))
+	_, _ = w.WriteBody([]byte(text))
+}
+
+// nameHandler responds with the name of the upstream.
+func nameHandler(name string) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		writeText(w, response.StatusCodeOK, name)
+	}
+}
+
+func get(t *testing.T, url string) (int, string) {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return resp.StatusCode, string(body)
+}
+
+func TestForward(t *testing.T) {
+	received := make(chan *request.Request, 1)
+
+	upstream := startServer(t, func(w *response.Writer, req *request.Request) {
+		received <- req
+
+		assert.NoError(t, w.AddSetCookie("a=1; Path=/"))
+		assert.NoError(t, w.AddSetCookie("b=2; HttpOnly"))
+
+		_ = w.WriteStatusLine(201)
+
+		h := response.GetDefaultHeaders(len(req.Body))
+		h["X-Upstream"] = "yes"
+		h["Keep-Alive"] = "timeout=5"
+
+		_ = w.WriteHeaders(h)
+		_, _ = w.WriteBody(req.Body)
+	})
+
+	base := startProxy(t, []string{upstream + "/v1/"}, Options{StripPrefix: "/api"})
+
+	req, err := http.NewRequest(http.MethodPost, base+"/api/items?q=1", strings.NewReader("hello"))
+	require.NoError(t, err)
+	req.Header.Set("X-Custom", "value")
+	req.Header.Set("Connection", "X-Hop")
+	req.Header.Set("X-Hop", "dropped")
+	req.Header.Set("X-Forwarded-For", "192.0.2.1")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	// Test: The request is forwarded with its method, body and end-to-end headers
+	upstreamReq := <-received
+	assert.Equal(t, "POST", upstreamReq.RequestLine.Method)
+	assert.Equal(t, "/v1/items?q=1", upstreamReq.RequestLine.RequestTarget)
+	assert.Equal(t, "hello", string(upstreamReq.Body))
+	assert.Equal(t, "value", upstreamReq.Headers.Get("x-custom"))
+	assert.NotContains(t, upstreamReq.Headers, "x-hop")
+	assert.Equal(t, strings.TrimPrefix(upstream, "http://"), upstreamReq.Headers.Get("host"))
+
+	// Test: The client is added to the forwarding headers
+	proxyHost := strings.TrimPrefix(base, "http://")
+	assert.Equal(t, "192.0.2.1, 127.0.0.1", upstreamReq.Headers.Get("x-forwarded-for"))
+	assert.Equal(t, proxyHost, upstreamReq.Headers.Get("x-forwarded-host"))
+	assert.Equal(t, `for=127.0.0.1;host="`+proxyHost+`"`, upstreamReq.Headers.Get("forwarded"))
+
+	// Test: The response is relayed with its status code, headers and cookies
+	assert.Equal(t, 201, resp.StatusCode)
+	assert.Equal(t, "hello", string(body))
+	assert.Equal(t, "yes", resp.Header.Get("X-Upstream"))
+	assert.Empty(t, resp.Header.Get("Keep-Alive"))
+	assert.Equal(t, []string{"a=1; Path=/", "b=2; HttpOnly"}, resp.Header.Values("Set-Cookie"))
+}
+
+func TestPreserveHost(t *testing.T) {
+	upstream := startServer(t, func(w *response.Writer, req *request.Request) {
+		writeText(w, response.StatusCodeOK, req.Headers.Get("host"))
+	})
+
+	base := startProxy(t, []string{upstream}, Options{PreserveHost: true})
+
+	// Test: The Host header of the client is forwarded
+	_, body := get(t, base+"/")
+	assert.Equal(t, strings.TrimPrefix(base, "http://"), body)
+}
+
+func TestStreaming(t *testing.T) {
+	release := make(chan struct{})
+
+	upstream := startServer(t, func(w *response.Writer, req *request.Request) {
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+
+		h := headers.NewHeaders()
+		h[response.HeaderTransferEncoding] = "chunked"
+		h[response.HeaderTrailer] = "X-Checksum"
+
+		_ = w.WriteHeaders(h)
+		_, _ = w.WriteChunkedBody([]byte("first"))
+
+		<-release
+
+		_, _ = w.WriteChunkedBody([]byte("second"))
+		_, _ = w.WriteChunkedBodyDone()
+
+		h["X-Checksum"] = "abc"
+		_ = w.WriteTrailers(h)
+	})
+
+	base := startProxy(t, []string{upstream}, Options{})
+
+	resp, err := http.Get(base + "/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	// Test: The body is relayed before the upstream has finished it
+	buf := make([]byte, len("first"))
+	_, err = io.ReadFull(resp.Body, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "first", string(buf))
+
+	close(release)
+
+	rest, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(rest))
+
+	// Test: The trailers are relayed after the body
+	assert.Equal(t, "abc", resp.Trailer.Get("X-Checksum"))
+}
+
+func TestRequestTrailers(t *testing.T) {
+	upstream := startServer(t, func(w *response.Writer, req *request.Request) {
+		writeText(w, response.StatusCodeOK, string(req.Body)+" "+req.Trailers.Get("x-checksum"))
+	})
+
+	base := startProxy(t, []string{upstream}, Options{})
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(base, "http://"))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	_, err = conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n" +
+		"Trailer: X-Checksum\r\nConnection: close\r\n\r\n4\r\nbody\r\n0\r\nX-Checksum: abc\r\n\r\n"))
+	require.NoError(t, err)
+
+	// Test: The trailers of the request are forwarded
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(string(data), "\r\n\r\nbody abc"), string(data))
+}
+
+func TestHead(t *testing.T) {
+	upstream := startServer(t, func(w *response.Writer, req *request.Request) {
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+		_ = w.WriteHeaders(response.GetDefaultHeaders(42))
+	})
+
+	base := startProxy(t, []string{upstream}, Options{})
+
+	// Test: The Content-Length of a response to a HEAD request is relayed without a body
+	resp, err := http.Head(base + "/")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, int64(42), resp.ContentLength)
+}
+
+func TestErrors(t *testing.T) {
+	// Test: A status code of the upstream that is not known is relayed
+	upstream := startServer(t, func(w *response.Writer, req *request.Request) {
+		writeText(w, 418, "teapot")
+	})
+
+	statusCode, body := get(t, startProxy(t, []string{upstream}, Options{})+"/")
+	assert.Equal(t, 418, statusCode)
+	assert.Equal(t, "teapot", body)
+
+	// Test: An upstream that cannot be reached gets a 502
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+
+	closed := "http://" + listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	statusCode, _ = get(t, startProxy(t, []string{closed}, Options{})+"/")
+	assert.Equal(t, 502, statusCode)
+
+	// Test: An upstream that does not respond in time gets a 504
+	release := make(chan struct{})
+	defer close(release)
+
+	slow := startServer(t, func(w *response.Writer, req *request.Request) {
+		<-release
+	})
+
+	statusCode, _ = get(t, startProxy(t, []string{slow}, Options{Timeout: 50 * time.Millisecond})+"/")
+	assert.Equal(t, 504, statusCode)
+
+	// Test: Invalid options
+	_, err = New(nil, Options{})
+	require.Error(t, err)
+
+	_, err = New([]string{"localhost:8080"}, Options{})
+	require.Error(t, err)
+
+	_, err = New([]string{upstream}, Options{Balancing: 5})
+	require.Error(t, err)
+}
+
+func TestBalancing(t *testing.T) {
+	upstreams := []string{
+		startServer(t, nameHandler("a")),
+		startServer(t, nameHandler("b")),
+	}
+
+	// Test: Round robin alternates between the upstreams
+	base := startProxy(t, upstreams, Options{})
+
+	names := make([]string, 0, 4)
+	for range 4 {
+		_, body := get(t, base+"/")
+		names = append(names, body)
+	}
+
+	assert.Equal(t, []string{"a", "b", "a", "b"}, names)
+
+	// Test: The upstream with the fewest requests in progress gets the request
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	busy := startServer(t, func(w *response.Writer, req *request.Request) {
+		started <- struct{}{}
+		<-release
+		writeText(w, response.StatusCodeOK, "busy")
+	})
+
+	base = startProxy(t, []string{busy, upstreams[0]}, Options{Balancing: LeastConnections})
+
+	done := make(chan string)
+
+	go func() {
+		_, body := get(t, base+"/")
+		done <- body
+	}()
+
+	<-started
+
+	for range 3 {
+		_, body := get(t, base+"/")
+		assert.Equal(t, "a", body)
+	}
+
+	close(release)
+	assert.Equal(t, "busy", <-done)
+}
+
+func TestHealthChecks(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+
+	flaky := startServer(t, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/health" {
+			statusCode := response.StatusCodeOK
+			if !healthy.Load() {
+				statusCode = response.StatusCodeServiceUnavailable
+			}
+
+			writeText(w, statusCode, strconv.FormatBool(healthy.Load()))
+
+			return
+		}
+
+		writeText(w, response.StatusCodeOK, "flaky")
+	})
+
+	stable := startServer(t, nameHandler("stable"))
+
+	options := Options{HealthCheckPath: "/health", HealthCheckInterval: 10 * time.Millisecond}
+	base := startProxy(t, []string{flaky, stable}, options)
+
+	// Test: An unhealthy upstream does not get any requests
+	healthy.Store(false)
+	time.Sleep(100 * time.Millisecond)
+
+	for range 4 {
+		_, body := get(t, base+"/")
+		assert.Equal(t, "stable", body)
+	}
+
+	// Test: The upstream gets the requests again once it is healthy
+	healthy.Store(true)
+	time.Sleep(100 * time.Millisecond)
+
+	names := make(map[string]bool)
+	for range 4 {
+		_, body := get(t, base+"/")
+		names[body] = true
+	}
+
+	assert.Equal(t, map[string]bool{"flaky": true, "stable": true}, names)
+
+	// Test: A 503 is sent when no upstream is healthy
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+
+	closed := "http://" + listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	base = startProxy(t, []string{closed}, options)
+	time.Sleep(100 * time.Millisecond)
+
+	statusCode, _ := get(t, base+"/")
+	assert.Equal(t, 503, statusCode)
+}
@@ -0,0 +1,305 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"http-from-tcp/internal/headers"
+	"http-from-tcp/internal/request"
+	"http-from-tcp/internal/response"
+)
+
+// hopByHopHeaders are the headers that only apply to a single connection and are not
+// forwarded (RFC 9110, section 7.6.1).
+var hopByHopHeaders = map[string]bool{
+	"connection":          true,
+	"keep-alive":          true,
+	"proxy-connection":    true,
+	"proxy-authenticate":  true,
+	"proxy-authorization": true,
+	"te":                  true,
+	"trailer":             true,
+	"transfer-encoding":   true,
+	"upgrade":             true,
+}
+
+// isHopByHop returns true if the header is a hop-by-hop header or if it is listed in
+// the Connection header.
+func isHopByHop(name string, connection string) bool {
+	name = strings.ToLower(name)
+	if hopByHopHeaders[name] {
+		return true
+	}
+
+	for token := range strings.SplitSeq(connection, ",") {
+		if strings.EqualFold(strings.TrimSpace(token), name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newOutgoingRequest returns the request that is sent to the target URL. The
+// hop-by-hop headers are removed and the Forwarded and X-Forwarded-* headers are
+// added. The Host header is the host of the target URL.
+func newOutgoingRequest(ctx context.Context, req *request.Request, target string) (*http.Request, error) {
+	outReq, err := http.NewRequestWithContext(ctx, req.RequestLine.Method, target, bytes.NewReader(req.Body))
+	if err != nil {
+		return nil, err
+	}
+
+	connection := req.Headers.Get("connection")
+
+	for key, value := range req.Headers {
+		if isHopByHop(key, connection) || key == "host" || key == "content-length" {
+			continue
+		}
+
+		outReq.Header[http.CanonicalHeaderKey(key)] = []string{value}
+	}
+
+	// The client asked for the trailers so the upstream can send them.
+	if req.Headers.HasToken("te", "trailers") {
+		outReq.Header.Set("Te", "trailers")
+	}
+
+	if len(req.Trailers) > 0 {
+		outReq.Trailer = make(http.Header, len(req.Trailers))
+		for key, value := range req.Trailers {
+			outReq.Trailer.Set(key, value)
+		}
+
+		// The trailers can only be sent with a chunked body.
+		outReq.ContentLength = -1
+	}
+
+	addForwardedHeaders(outReq.Header, req)
+
+	return outReq, nil
+}
+
+// roundTrip sends the request and waits at most the timeout for the headers of the
+// response. The request is cancelled with errUpstreamTimeout when the timeout expires.
+func roundTrip(transport http.RoundTripper, outReq *http.Request, cancel context.CancelCauseFunc, timeout time.Duration) (*http.Response, error) {
+	timer := time.AfterFunc(timeout, func() { cancel(errUpstreamTimeout) })
+	defer timer.Stop()
+
+	resp, err := transport.RoundTrip(outReq)
+	if err != nil && errors.Is(context.Cause(outReq.Context()), errUpstreamTimeout) {
+		return nil, errUpstreamTimeout
+	}
+
+	return resp, err
+}
+
+// writeRoundTripError writes the response for a request that could not be sent. It
+// returns true if the upstream is to blame for the error.
+func writeRoundTripError(w *response.Writer, req *request.Request, err error) bool {
+	switch {
+	case errors.Is(err, errUpstreamTimeout):
+		slog.Error("the upstream server timed out.", "target", req.RequestLine.RequestTarget)
+		writeError(w, response.StatusCodeGatewayTimeout)
+	case req.Context().Err() != nil:
+		// The server is shutting down so the upstream is not to blame.
+		return false
+	default:
+		slog.Error("error sending the request upstream.", "target", req.RequestLine.RequestTarget, "error", err.Error())
+		writeError(w, response.StatusCodeBadGateway)
+	}
+
+	return true
+}
+
+// addForwardedHeaders adds the client and the host of the request to the Forwarded
+// header (RFC 7239) and to the X-Forwarded-For and X-Forwarded-Host headers. The
+// values from the previous proxies are kept.
+func addForwardedHeaders(h http.Header, req *request.Request) {
+	node := "unknown"
+
+	clientIP, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err == nil {
+		node = clientIP
+		if strings.Contains(clientIP, ":") {
+			node = `"[` + clientIP + `]"`
+		}
+
+		if prior := h.Get("X-Forwarded-For"); prior != "" {
+			clientIP = prior + ", " + clientIP
+		}
+
+		h.Set("X-Forwarded-For", clientIP)
+	}
+
+	element := "for=" + node
+
+	if host := req.Headers.Get("host"); host != "" {
+		element += ";host=" + quoteForwarded(host)
+
+		if h.Get("X-Forwarded-Host") == "" {
+			h.Set("X-Forwarded-Host", host)
+		}
+	}
+
+	if prior := h.Get("Forwarded"); prior != "" {
+		element = prior + ", " + element
+	}
+
+	h.Set("Forwarded", element)
+}
+
+// quoteForwarded returns the value as a token or as a quoted string if it is not a
+// valid token.
+func quoteForwarded(value string) string {
+	for idx := range len(value) {
+		if !headers.IsTokenChar(value[idx]) {
+			return strconv.Quote(value)
+		}
+	}
+
+	return value
+}
+
+// writeResponse relays the response of the upstream. The body is sent with its
+// Content-Length when it is known and without trailers, and it is chunked otherwise.
+func writeResponse(w *response.Writer, req *request.Request, resp *http.Response) {
+	statusCode := response.StatusCode(resp.StatusCode)
+
+	h := headers.NewHeaders()
+	connection := strings.Join(resp.Header.Values("Connection"), ", ")
+
+	for key, values := range resp.Header {
+		if isHopByHop(key, connection) {
+			continue
+		}
+
+		// The values of the Set-Cookie header cannot be joined into a list.
+		if key == response.HeaderSetCookie {
+			for _, value := range values {
+				if err := w.AddSetCookie(value); err != nil {
+					slog.Error("error relaying a cookie.", "error", err.Error())
+				}
+			}
+
+			continue
+		}
+
+		h[key] = strings.Join(values, ", ")
+	}
+
+	bodyAllowed := req.RequestLine.Method != "HEAD" &&
+		statusCode >= 200 && statusCode != 204 && statusCode != response.StatusCodeNotModified
+
+	trailers := slices.Sorted(maps.Keys(resp.Trailer))
+	chunked := bodyAllowed && (resp.ContentLength < 0 || len(trailers) > 0)
+
+	switch {
+	case chunked:
+		delete(h, response.HeaderContentLength)
+		h[response.HeaderTransferEncoding] = "chunked"
+
+		if len(trailers) > 0 {
+			h[response.HeaderTrailer] = strings.Join(trailers, ", ")
+		}
+	case bodyAllowed:
+		h[response.HeaderContentLength] = strconv.FormatInt(resp.ContentLength, 10)
+	}
+
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		slog.Error("error writing the status line.", "error", err.Error())
+
+		return
+	}
+
+	if err := w.WriteHeaders(h); err != nil {
+		slog.Error("error writing the headers.", "error", err.Error())
+
+		return
+	}
+
+	if !bodyAllowed {
+		return
+	}
+
+	if err := copyBody(w, resp.Body, chunked); err != nil {
+		// The response is incomplete so the server closes the connection.
+		slog.Error("error relaying the response body.", "error", err.Error())
+
+		return
+	}
+
+	if !chunked {
+		return
+	}
+
+	if _, err := w.WriteChunkedBodyDone(); err != nil {
+		slog.Error("error writing the end of the chunked body.", "error", err.Error())
+
+		return
+	}
+
+	// The values of the trailers are only known once the body has been read.
+	t := headers.NewHeaders()
+	if len(trailers) > 0 {
+		t[response.HeaderTrailer] = h[response.HeaderTrailer]
+	}
+
+	for _, name := range trailers {
+		t[name] = strings.Join(resp.Trailer.Values(name), ", ")
+	}
+
+	if err := w.WriteTrailers(t); err != nil {
+		slog.Error("error writing the trailers.", "error", err.Error())
+	}
+}
+
+// copyBody streams the body to the client. The data is flushed as soon as it is
+// received so that the responses that are sent gradually (e.g. server-sent events)
+// are not held back.
+func copyBody(w *response.Writer, body io.Reader, chunked bool) error {
+	buf := make([]byte, bufferSize)
+
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			var writeErr error
+			if chunked {
+				_, writeErr = w.WriteChunkedBody(buf[:n])
+			} else {
+				_, writeErr = w.WriteBody(buf[:n])
+			}
+
+			if writeErr != nil {
+				return fmt.Errorf("error writing the body: %w", writeErr)
+			}
+
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+
+		switch {
+		case errors.Is(err, io.EOF):
+			return nil
+		case err != nil:
+			return fmt.Errorf("error reading the upstream body: %w", err)
+		}
+	}
+}
+
+func writeError(w *response.Writer, statusCode response.StatusCode) {
+	if err := w.WriteError(statusCode, response.StatusText(statusCode)); err != nil {
+		slog.Error("error writing the error response.", "error", err.Error())
+	}
+}
@@ -0,0 +1,211 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// errDeniedAddress is returned by the dialer of the forward proxy when a host name
+// resolves to a denied address.
+var errDeniedAddress = errors.New("the destination address is denied")
+
+// rule matches the destinations of the forward proxy. A rule is written as host,
+// host:port or [ipv6]:port. The host can be * (any host), a wildcard such as
+// *.example.com (any subdomain of example.com), an IP address or a network in CIDR
+// notation (e.g. 10.0.0.0/8). The port can be * or left out to match any port.
+type rule struct {
+	any    bool
+	host   string
+	suffix string
+	prefix netip.Prefix
+	port   int
+}
+
+func parseRule(pattern string) (rule, error) {
+	host, portStr := pattern, ""
+
+	// The CIDR notation of an IPv6 network does not use brackets so a colon is only
+	// the start of the port if the host is not an IPv6 address.
+	if _, err := netip.ParsePrefix(pattern); err != nil {
+		if _, err := netip.ParseAddr(pattern); err != nil {
+			if h, p, err := net.SplitHostPort(pattern); err == nil {
+				host, portStr = h, p
+			}
+		}
+	}
+
+	host = normaliseHost(host)
+
+	var r rule
+
+	switch {
+	case host == "*":
+		r.any = true
+	case strings.HasPrefix(host, "*."):
+		r.suffix = host[1:]
+		if r.suffix == "." || strings.Contains(r.suffix, "*") {
+			return rule{}, fmt.Errorf("invalid wildcard in the rule %q", pattern)
+		}
+	case strings.Contains(host, "/"):
+		prefix, err := netip.ParsePrefix(host)
+		if err != nil {
+			return rule{}, fmt.Errorf("invalid network in the rule %q", pattern)
+		}
+
+		r.prefix = prefix.Masked()
+	case host == "" || strings.Contains(host, "*"):
+		return rule{}, fmt.Errorf("invalid host in the rule %q", pattern)
+	default:
+		r.host = host
+	}
+
+	if portStr != "" && portStr != "*" {
+		port, err := strconv.Atoi(portStr)
+		if err != nil || port < 1 || port > 65535 {
+			return rule{}, fmt.Errorf("invalid port in the rule %q", pattern)
+		}
+
+		r.port = port
+	}
+
+	return r, nil
+}
+
+// matches returns true if the destination matches the rule. The host must be
+// normalised.
+func (r rule) matches(host string, port int) bool {
+	if r.port != 0 && r.port != port {
+		return false
+	}
+
+	switch {
+	case r.any:
+		return true
+	case r.suffix != "":
+		return strings.HasSuffix(host, r.suffix)
+	case r.prefix.IsValid():
+		addr, err := netip.ParseAddr(host)
+
+		return err == nil && r.prefix.Contains(addr.Unmap())
+	default:
+		if addr, err := netip.ParseAddr(host); err == nil {
+			ruleAddr, err := netip.ParseAddr(r.host)
+
+			return err == nil && ruleAddr.Unmap() == addr.Unmap()
+		}
+
+		return r.host == host
+	}
+}
+
+// isAddress returns true if the rule only matches IP addresses. These rules are also
+// checked against the address that a host name resolves to.
+func (r rule) isAddress() bool {
+	if r.prefix.IsValid() {
+		return true
+	}
+
+	_, err := netip.ParseAddr(r.host)
+
+	return err == nil
+}
+
+// rules are the allow and deny lists of the forward proxy.
+type rules struct {
+	allow []rule
+	deny  []rule
+}
+
+func parseRules(allow, deny []string) (rules, error) {
+	var (
+		r   rules
+		err error
+	)
+
+	if r.allow, err = parseRuleList(allow); err != nil {
+		return rules{}, err
+	}
+
+	if r.deny, err = parseRuleList(deny); err != nil {
+		return rules{}, err
+	}
+
+	return r, nil
+}
+
+func parseRuleList(patterns []string) ([]rule, error) {
+	list := make([]rule, 0, len(patterns))
+
+	for _, pattern := range patterns {
+		r, err := parseRule(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, r)
+	}
+
+	return list, nil
+}
+
+// allowed returns true if the destination is not denied and if it is allowed. Any
+// destination is allowed if the allow list is empty.
+func (r rules) allowed(host string, port int) bool {
+	host = normaliseHost(host)
+
+	for _, deny := range r.deny {
+		if deny.matches(host, port) {
+			return false
+		}
+	}
+
+	if len(r.allow) == 0 {
+		return true
+	}
+
+	for _, allow := range r.allow {
+		if allow.matches(host, port) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// control is the Control function of the dialer. It checks the resolved address
+// against the deny rules so that a host name that resolves to a denied address (e.g.
+// a loopback address) cannot be used to get around them.
+func (r rules) control(network, address string, c syscall.RawConn) error {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return err
+	}
+
+	host = normaliseHost(host)
+
+	for _, deny := range r.deny {
+		if deny.isAddress() && deny.matches(host, port) {
+			return fmt.Errorf("%w: %s", errDeniedAddress, address)
+		}
+	}
+
+	return nil
+}
+
+// normaliseHost returns the host in lower case without the brackets of an IPv6
+// address and without the trailing dot of a fully qualified domain name.
+func normaliseHost(host string) string {
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
@@ -0,0 +1,113 @@
+package request
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// DefaultMaxDecodedBodySize is the default maximum size of a decoded request body.
+const DefaultMaxDecodedBodySize int = 10 << 20
+
+// DecodeBody decodes a body that was compressed with the gzip or the deflate content
+// coding (RFC 9110, section 8.4.1). The codings are removed in the reverse order that
+// they are listed in the Content-Encoding header. After decoding, Body contains the
+// decoded body, the Content-Encoding header is removed and the Content-Length header is
+// set to the decoded size. maxSize is the maximum size of the decoded body which guards
+// against small bodies that decompress to a very large size (zip bombs).
+//
+// An unsupported content coding maps to the 415 Unsupported Media Type status code and
+// a decoded body that exceeds maxSize maps to the 413 Content Too Large status code. The
+// 415 response must list the supported codings in the Accept-Encoding header that is
+// returned by ErrorHeaders.
+func (r *Request) DecodeBody(maxSize int) error {
+	value := r.Headers.Get("content-encoding")
+	if value == "" {
+		return nil
+	}
+
+	codings := make([]string, 0, 1)
+
+	for coding := range strings.SplitSeq(value, ",") {
+		coding = strings.ToLower(strings.TrimSpace(coding))
+
+		switch coding {
+		case "", "identity":
+			continue
+		case "gzip", "x-gzip", "deflate":
+			codings = append(codings, coding)
+		default:
+			return unsupportedContentEncodingError{coding}
+		}
+	}
+
+	body := r.Body
+
+	for idx := len(codings) - 1; idx >= 0; idx-- {
+		decoded, err := decode(codings[idx], body, maxSize)
+		if err != nil {
+			return err
+		}
+
+		body = decoded
+	}
+
+	r.Body = body
+	r.Headers.Delete("content-encoding")
+	r.Headers["content-length"] = strconv.Itoa(len(body))
+
+	return nil
+}
+
+// decode removes a single content coding from the body.
+func decode(coding string, body []byte, maxSize int) ([]byte, error) {
+	var (
+		decoder io.ReadCloser
+		err     error
+	)
+
+	switch coding {
+	case "gzip", "x-gzip":
+		decoder, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		decoder, err = newDeflateReader(body)
+	}
+
+	if err != nil {
+		return nil, invalidEncodedBodyError{coding, err}
+	}
+	defer decoder.Close()
+
+	// Read one byte more than the limit to detect a body that exceeds it.
+	decoded, err := io.ReadAll(io.LimitReader(decoder, int64(maxSize)+1))
+	if err != nil {
+		return nil, invalidEncodedBodyError{coding, err}
+	}
+
+	if len(decoded) > maxSize {
+		return nil, decodedBodyTooLargeError{maxSize}
+	}
+
+	return decoded, nil
+}
+
+// newDeflateReader returns the reader for a deflate coded body. The deflate content
+// coding is the zlib format but some clients send the raw deflate format so it is
+// accepted as well.
+func newDeflateReader(body []byte) (io.ReadCloser, error) {
+	reader, err := zlib.NewReader(bytes.NewReader(body))
+	if err == nil {
+		return reader, nil
+	}
+
+	if !errors.Is(err, zlib.ErrHeader) {
+		return nil, err
+	}
+
+	return flate.NewReader(bytes.NewReader(body)), nil
+}
@@ -1,17 +1,11 @@
 package request
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
 
-type requestPartsError struct {
-	numParts int
-}
-
-func (e requestPartsError) Error() string {
-	return fmt.Sprintf(
-		"received an unexpected number of parts after splitting the REQUEST: want: 2, got: %d",
-		e.numParts,
-	)
-}
+	"http-from-tcp/internal/headers"
+)
 
 type requestLinePartsError struct {
 	numparts int
@@ -63,3 +57,265 @@ type incompleteBodyError struct{}
 func (e incompleteBodyError) Error() string {
 	return "the BODY of the request appears to be incomplete or missing"
 }
+
+type bareLineFeedError struct{}
+
+func (e bareLineFeedError) Error() string {
+	return "received a bare LF or CR that is not part of a CRLF line ending"
+}
+
+type obsoleteLineFoldingError struct{}
+
+func (e obsoleteLineFoldingError) Error() string {
+	return "received a header line using the obsolete line folding"
+}
+
+type invalidContentLengthError struct {
+	value string
+}
+
+func (e invalidContentLengthError) Error() string {
+	return "received an invalid Content-Length header: " + e.value
+}
+
+type conflictingContentLengthError struct {
+	values string
+}
+
+func (e conflictingContentLengthError) Error() string {
+	return "received conflicting Content-Length values: " + e.values
+}
+
+type conflictingFramingError struct{}
+
+func (e conflictingFramingError) Error() string {
+	return "received both the Transfer-Encoding and the Content-Length headers"
+}
+
+type unsupportedTransferEncodingError struct {
+	encoding string
+}
+
+func (e unsupportedTransferEncodingError) Error() string {
+	return "received an unsupported Transfer-Encoding: " + e.encoding
+}
+
+type invalidChunkSizeError struct {
+	line string
+}
+
+func (e invalidChunkSizeError) Error() string {
+	return "received an invalid chunk size line: " + e.line
+}
+
+type malformedChunkError struct{}
+
+func (e malformedChunkError) Error() string {
+	return "the chunk data is not terminated by a CRLF"
+}
+
+type missingHostError struct{}
+
+func (e missingHostError) Error() string {
+	return "the request does not contain the Host header"
+}
+
+type duplicateHostError struct {
+	hosts string
+}
+
+func (e duplicateHostError) Error() string {
+	return "the request contains more than one Host header: " + e.hosts
+}
+
+type invalidHostError struct {
+	host string
+}
+
+func (e invalidHostError) Error() string {
+	return "the request contains an invalid Host header: " + e.host
+}
+
+type headerSectionTooLargeError struct {
+	limit int
+}
+
+func (e headerSectionTooLargeError) Error() string {
+	return fmt.Sprintf(
+		"the REQUEST LINE and the HEADERS LINE exceed the limit of %d bytes",
+		e.limit,
+	)
+}
+
+// statusCoder is implemented by the errors that map to a specific HTTP status code.
+type statusCoder interface {
+	StatusCode() int
+}
+
+// StatusCode returns the HTTP status code that the error maps to. The 400 Bad Request
+// status code is returned for errors that do not map to a specific status code.
+func StatusCode(err error) int {
+	var coder statusCoder
+
+	if errors.As(err, &coder) {
+		return coder.StatusCode()
+	}
+
+	return statusCodeBadRequest
+}
+
+const statusCodeBadRequest int = 400
+
+// headerer is implemented by the errors whose response must include specific headers.
+type headerer interface {
+	Headers() headers.Headers
+}
+
+// ErrorHeaders returns the headers that the error response must include, or nil if
+// the error does not require any. The keys are in canonical form like the keys of the
+// response headers.
+func ErrorHeaders(err error) headers.Headers {
+	var h headerer
+
+	if errors.As(err, &h) {
+		return h.Headers()
+	}
+
+	return nil
+}
+
+type invalidPercentEncodingError struct {
+	value string
+}
+
+func (e invalidPercentEncodingError) Error() string {
+	return "received an invalid percent-encoded value: " + e.value
+}
+
+func (e invalidPercentEncodingError) StatusCode() int {
+	return statusCodeBadRequest
+}
+
+type formTooLargeError struct {
+	limit int
+}
+
+func (e formTooLargeError) Error() string {
+	return fmt.Sprintf("the form exceeds the limit of %d bytes", e.limit)
+}
+
+func (e formTooLargeError) StatusCode() int {
+	return statusCodeBadRequest
+}
+
+type tooManyFormFieldsError struct {
+	limit int
+}
+
+func (e tooManyFormFieldsError) Error() string {
+	return fmt.Sprintf("the form exceeds the limit of %d fields", e.limit)
+}
+
+func (e tooManyFormFieldsError) StatusCode() int {
+	return statusCodeBadRequest
+}
+
+const (
+	statusCodeContentTooLarge      int = 413
+	statusCodeUnsupportedMediaType int = 415
+)
+
+type bodyTooLargeError struct {
+	limit int
+}
+
+func (e bodyTooLargeError) Error() string {
+	return fmt.Sprintf("the body exceeds the limit of %d bytes", e.limit)
+}
+
+func (e bodyTooLargeError) StatusCode() int {
+	return statusCodeContentTooLarge
+}
+
+type notMultipartError struct {
+	contentType string
+}
+
+func (e notMultipartError) Error() string {
+	return "the request body is not multipart/form-data: " + e.contentType
+}
+
+func (e notMultipartError) StatusCode() int {
+	return statusCodeUnsupportedMediaType
+}
+
+type invalidMultipartError struct {
+	reason string
+}
+
+func (e invalidMultipartError) Error() string {
+	return "received an invalid multipart body: " + e.reason
+}
+
+func (e invalidMultipartError) StatusCode() int {
+	return statusCodeBadRequest
+}
+
+type multipartTooLargeError struct {
+	reason string
+}
+
+func (e multipartTooLargeError) Error() string {
+	return "the multipart body exceeds the limits: " + e.reason
+}
+
+func (e multipartTooLargeError) StatusCode() int {
+	return statusCodeContentTooLarge
+}
+
+type unsupportedContentEncodingError struct {
+	coding string
+}
+
+func (e unsupportedContentEncodingError) Error() string {
+	return "received an unsupported Content-Encoding: " + e.coding
+}
+
+func (e unsupportedContentEncodingError) StatusCode() int {
+	return statusCodeUnsupportedMediaType
+}
+
+// Headers lists the supported content codings in the Accept-Encoding header of the
+// response (RFC 9110, section 15.5.16).
+func (e unsupportedContentEncodingError) Headers() headers.Headers {
+	return headers.Headers{"Accept-Encoding": "gzip, deflate"}
+}
+
+type invalidEncodedBodyError struct {
+	coding string
+	err    error
+}
+
+func (e invalidEncodedBodyError) Error() string {
+	return "error decoding the " + e.coding + " body: " + e.err.Error()
+}
+
+func (e invalidEncodedBodyError) Unwrap() error {
+	return e.err
+}
+
+func (e invalidEncodedBodyError) StatusCode() int {
+	return statusCodeBadRequest
+}
+
+type decodedBodyTooLargeError struct {
+	limit int
+}
+
+func (e decodedBodyTooLargeError) Error() string {
+	return fmt.Sprintf("the decoded body exceeds the limit of %d bytes", e.limit)
+}
+
+func (e decodedBodyTooLargeError) StatusCode() int {
+	return statusCodeContentTooLarge
+}
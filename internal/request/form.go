@@ -0,0 +1,189 @@
+package request
+
+import (
+	"strings"
+)
+
+const (
+	// DefaultMaxFormSize is the default maximum size of an URL-encoded form body.
+	DefaultMaxFormSize int = 10 << 20
+
+	// maxFormFields is the maximum number of fields in a query string or an
+	// URL-encoded form body.
+	maxFormFields int = 1000
+
+	formURLEncoded string = "application/x-www-form-urlencoded"
+)
+
+// Values maps a form field or a query parameter to its values.
+type Values map[string][]string
+
+// Get returns the first value for the key or an empty string if there are
+// no values for the key.
+func (v Values) Get(key string) string {
+	if values := v[key]; len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
+
+// Add adds the value to the key.
+func (v Values) Add(key, value string) {
+	v[key] = append(v[key], value)
+}
+
+// Has returns true if the key is set.
+func (v Values) Has(key string) bool {
+	_, ok := v[key]
+
+	return ok
+}
+
+// Query parses the query string from the request target.
+func (r *Request) Query() (Values, error) {
+	_, query, _ := strings.Cut(r.RequestLine.RequestTarget, "?")
+
+	// Ignore the fragment if the client sent one.
+	query, _, _ = strings.Cut(query, "#")
+
+	return ParseQuery(query)
+}
+
+// ParseForm parses the query string and, for POST, PUT and PATCH requests with the
+// application/x-www-form-urlencoded Content-Type, the body of the request. The values
+// from the body are stored in PostForm and the values from both the body and the query
+// string are stored in Form with the body values listed first. maxSize is the maximum
+// size of the form body, on top of the maximum body size that the Reader enforces
+// while the body is received (see Reader.SetMaxBodySize). The returned errors map to
+// the 400 Bad Request status code.
+func (r *Request) ParseForm(maxSize int) error {
+	if r.Form != nil {
+		return nil
+	}
+
+	postForm := make(Values)
+
+	switch r.RequestLine.Method {
+	case "POST", "PUT", "PATCH":
+		contentType, err := r.ContentType()
+		if err == nil && contentType.Essence() == formURLEncoded {
+			if len(r.Body) > maxSize {
+				return formTooLargeError{maxSize}
+			}
+
+			postForm, err = ParseQuery(string(r.Body))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	query, err := r.Query()
+	if err != nil {
+		return err
+	}
+
+	if len(postForm)+len(query) > maxFormFields {
+		return tooManyFormFieldsError{maxFormFields}
+	}
+
+	form := make(Values)
+
+	for key, values := range postForm {
+		form[key] = append(form[key], values...)
+	}
+
+	for key, values := range query {
+		form[key] = append(form[key], values...)
+	}
+
+	r.PostForm = postForm
+	r.Form = form
+
+	return nil
+}
+
+// ParseQuery parses an URL-encoded query string such as a=1&b=2&b=3.
+func ParseQuery(query string) (Values, error) {
+	values := make(Values)
+	numFields := 0
+
+	for field := range strings.SplitSeq(query, "&") {
+		if field == "" {
+			continue
+		}
+
+		numFields++
+		if numFields > maxFormFields {
+			return nil, tooManyFormFieldsError{maxFormFields}
+		}
+
+		key, value, _ := strings.Cut(field, "=")
+
+		decodedKey, err := unescape(key)
+		if err != nil {
+			return nil, err
+		}
+
+		decodedValue, err := unescape(value)
+		if err != nil {
+			return nil, err
+		}
+
+		values.Add(decodedKey, decodedValue)
+	}
+
+	return values, nil
+}
+
+// unescape decodes the percent-encoded octets in the string and converts '+'
+// to a space.
+func unescape(value string) (string, error) {
+	if !strings.ContainsAny(value, "%+") {
+		return value, nil
+	}
+
+	var builder strings.Builder
+
+	builder.Grow(len(value))
+
+	for idx := 0; idx < len(value); idx++ {
+		switch char := value[idx]; char {
+		case '+':
+			builder.WriteByte(' ')
+		case '%':
+			if idx+2 >= len(value) {
+				return "", invalidPercentEncodingError{value}
+			}
+
+			high, okHigh := unhex(value[idx+1])
+			low, okLow := unhex(value[idx+2])
+
+			if !okHigh || !okLow {
+				return "", invalidPercentEncodingError{value}
+			}
+
+			builder.WriteByte(high<<4 | low)
+
+			idx += 2
+		default:
+			builder.WriteByte(char)
+		}
+	}
+
+	return builder.String(), nil
+}
+
+func unhex(char byte) (byte, bool) {
+	switch {
+	case char >= '0' && char <= '9':
+		return char - '0', true
+	case char >= 'a' && char <= 'f':
+		return char - 'a' + 10, true
+	case char >= 'A' && char <= 'F':
+		return char - 'A' + 10, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,155 @@
+package request
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPort    int = 80
+	defaultTLSPort int = 443
+)
+
+// setHost validates the Host header of the request and sets the normalised host
+// and port. An HTTP/1.1 request must contain exactly one Host header, which is empty
+// if the target URI does not have a host (RFC 9112, section 3.2). The host of a
+// request with an absolute-form target (e.g. GET http://example.com/ HTTP/1.1) is
+// the authority of the target, which replaces the Host header (RFC 9112, section
+// 3.2.2) so that every handler sees the same host.
+func (r *Request) setHost() error {
+	value, ok := r.Headers["host"]
+	if !ok {
+		return missingHostError{}
+	}
+
+	// Duplicate Host headers are merged into a comma separated list by the
+	// header parser. A comma is not valid in a host so it can only appear
+	// when the header was sent more than once.
+	if strings.Contains(value, ",") {
+		return duplicateHostError{value}
+	}
+
+	port := defaultPort
+
+	if scheme, authority, ok := targetAuthority(r.RequestLine.RequestTarget); ok {
+		// An http or https URI must not have an empty host (RFC 9110, section 4.2).
+		if authority == "" {
+			return invalidHostError{authority}
+		}
+
+		value = authority
+		r.Headers["host"] = authority
+
+		if scheme == "https" {
+			port = defaultTLSPort
+		}
+	}
+
+	host, port, err := parseHost(value, port)
+	if err != nil {
+		return err
+	}
+
+	r.Host = host
+	r.Port = port
+
+	return nil
+}
+
+// targetAuthority returns the lower case scheme and the authority of an absolute-form
+// request target. ok is false for the other forms of request targets.
+func targetAuthority(target string) (scheme string, authority string, ok bool) {
+	scheme, rest, found := strings.Cut(target, "://")
+	if !found || scheme == "" || strings.ContainsAny(scheme, "/?#") {
+		return "", "", false
+	}
+
+	if end := strings.IndexAny(rest, "/?#"); end != -1 {
+		rest = rest[:end]
+	}
+
+	return strings.ToLower(scheme), rest, true
+}
+
+// parseHost parses the value of the Host header into a host and a port. The host
+// is normalised to lower case without the trailing dot of a fully qualified
+// domain name and without the brackets of an IPv6 address. port is the port used
+// when the value does not have one. An empty value gives an empty host.
+func parseHost(value string, port int) (string, int, error) {
+	if value == "" {
+		return "", port, nil
+	}
+
+	var host, portStr string
+
+	if strings.HasPrefix(value, "[") {
+		// The host is an IP literal such as [::1]:8080.
+		end := strings.Index(value, "]")
+		if end == -1 {
+			return "", 0, invalidHostError{value}
+		}
+
+		host = value[1:end]
+		rest := value[end+1:]
+
+		if rest != "" {
+			if !strings.HasPrefix(rest, ":") {
+				return "", 0, invalidHostError{value}
+			}
+
+			portStr = rest[1:]
+		}
+
+		if host == "" || strings.Trim(host, "0123456789abcdefABCDEF:.") != "" {
+			return "", 0, invalidHostError{value}
+		}
+	} else {
+		var found bool
+
+		host, portStr, found = strings.Cut(value, ":")
+		if found && portStr == "" {
+			return "", 0, invalidHostError{value}
+		}
+
+		host = strings.TrimSuffix(host, ".")
+
+		if host == "" || !isRegName(host) {
+			return "", 0, invalidHostError{value}
+		}
+	}
+
+	if portStr != "" {
+		for _, char := range portStr {
+			if char < '0' || char > '9' {
+				return "", 0, invalidHostError{value}
+			}
+		}
+
+		var err error
+
+		port, err = strconv.Atoi(portStr)
+		if err != nil || port < 1 || port > 65535 {
+			return "", 0, invalidHostError{value}
+		}
+	}
+
+	return strings.ToLower(host), port, nil
+}
+
+// isRegName returns true if the host only contains the characters allowed in a
+// registered name (RFC 3986, section 3.2.2).
+func isRegName(host string) bool {
+	for _, char := range host {
+		switch {
+		case char >= 'a' && char <= 'z',
+			char >= 'A' && char <= 'Z',
+			char >= '0' && char <= '9',
+			strings.ContainsRune("-._~!$&'()*+;=%", char):
+			continue
+		default:
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,471 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"http-from-tcp/internal/headers"
+)
+
+const (
+	formMultipart string = "multipart/form-data"
+
+	// maxBoundaryLength is the maximum length of a boundary (RFC 2046, section 5.1.1).
+	maxBoundaryLength int = 70
+
+	// maxPartHeaderBytes is the maximum size of the header section of a part.
+	maxPartHeaderBytes int = 16 << 10
+)
+
+// MultipartReader reads the parts of a multipart body one at a time. The content
+// of each part is read from the underlying reader as the part is read, so the parts
+// are never all held in memory by the MultipartReader itself.
+type MultipartReader struct {
+	reader *bufio.Reader
+
+	// dashBoundary is the boundary delimiter at the start of a line (--boundary).
+	dashBoundary []byte
+
+	// nlDashBoundary is the boundary delimiter including the CRLF that ends the
+	// content of the previous part (\r\n--boundary).
+	nlDashBoundary []byte
+
+	current *Part
+	started bool
+	done    bool
+}
+
+// Part is a single part of a multipart body. The content of the part is read with Read.
+type Part struct {
+	Headers headers.Headers
+
+	reader *MultipartReader
+	eof    bool
+}
+
+// NewMultipartReader returns a MultipartReader that reads the parts of the multipart
+// body delimited by the boundary.
+func NewMultipartReader(body io.Reader, boundary string) (*MultipartReader, error) {
+	if boundary == "" || len(boundary) > maxBoundaryLength {
+		return nil, invalidMultipartError{"invalid boundary: " + boundary}
+	}
+
+	return &MultipartReader{
+		reader:         bufio.NewReaderSize(body, bufferSize),
+		dashBoundary:   []byte("--" + boundary),
+		nlDashBoundary: []byte(crlf + "--" + boundary),
+		current:        nil,
+		started:        false,
+		done:           false,
+	}, nil
+}
+
+// MultipartReader returns a MultipartReader for the body of a multipart/form-data
+// request. The body has already been received in full by the Reader, which limits
+// its size (see Reader.SetMaxBodySize), so the parts are read from memory.
+func (r *Request) MultipartReader() (*MultipartReader, error) {
+	contentType, err := r.ContentType()
+	if err != nil || contentType.Essence() != formMultipart {
+		return nil, notMultipartError{r.Headers.Get("content-type")}
+	}
+
+	return NewMultipartReader(bytes.NewReader(r.Body), contentType.Params["boundary"])
+}
+
+// NextPart returns the next part of the body. Any unread content of the previous part
+// is discarded. io.EOF is returned when there are no more parts.
+func (m *MultipartReader) NextPart() (*Part, error) {
+	if m.current != nil {
+		if _, err := io.Copy(io.Discard, m.current); err != nil {
+			return nil, err
+		}
+
+		m.current = nil
+	}
+
+	if m.done {
+		return nil, io.EOF
+	}
+
+	var err error
+
+	if !m.started {
+		err = m.skipPreamble()
+	} else {
+		err = m.readDelimiter()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if m.done {
+		return nil, io.EOF
+	}
+
+	partHeaders, err := m.readPartHeaders()
+	if err != nil {
+		return nil, err
+	}
+
+	m.current = &Part{
+		Headers: partHeaders,
+		reader:  m,
+		eof:     false,
+	}
+
+	return m.current, nil
+}
+
+// skipPreamble discards everything up to and including the first boundary delimiter
+// line.
+func (m *MultipartReader) skipPreamble() error {
+	m.started = true
+
+	for {
+		line, err := m.readLine()
+		if err != nil {
+			return unexpectedEndOfMultipart(err)
+		}
+
+		if rest, ok := bytes.CutPrefix(line, m.dashBoundary); ok {
+			return m.checkDelimiterEnd(rest)
+		}
+	}
+}
+
+// readDelimiter reads the boundary delimiter at the end of the current part.
+func (m *MultipartReader) readDelimiter() error {
+	if _, err := m.reader.Discard(len(m.nlDashBoundary)); err != nil {
+		return unexpectedEndOfMultipart(err)
+	}
+
+	line, err := m.readLine()
+
+	// The final delimiter does not have to be followed by a CRLF.
+	if errors.Is(err, io.EOF) && bytes.HasPrefix(line, []byte("--")) {
+		err = nil
+	}
+
+	if err != nil {
+		return unexpectedEndOfMultipart(err)
+	}
+
+	return m.checkDelimiterEnd(line)
+}
+
+// checkDelimiterEnd checks the remainder of a boundary delimiter line. The line
+// either marks the end of the body (--) or is followed by the next part. Anything
+// after the final delimiter (the epilogue) is ignored.
+func (m *MultipartReader) checkDelimiterEnd(rest []byte) error {
+	if bytes.HasPrefix(rest, []byte("--")) {
+		m.done = true
+
+		return nil
+	}
+
+	// The delimiter may be followed by transport padding.
+	if len(bytes.TrimRight(rest, " \t\r\n")) != 0 {
+		return invalidMultipartError{"unexpected data after the boundary delimiter"}
+	}
+
+	return nil
+}
+
+// readPartHeaders reads the header section of a part.
+func (m *MultipartReader) readPartHeaders() (headers.Headers, error) {
+	partHeaders := headers.NewHeaders()
+	headerBytes := 0
+
+	for {
+		line, err := m.reader.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			return nil, invalidMultipartError{"a part header line is too long"}
+		}
+
+		if err != nil {
+			return nil, unexpectedEndOfMultipart(err)
+		}
+
+		headerBytes += len(line)
+		if headerBytes > maxPartHeaderBytes {
+			return nil, invalidMultipartError{"the part headers are too large"}
+		}
+
+		if !bytes.HasSuffix(line, []byte(crlf)) {
+			return nil, invalidMultipartError{"a part header line does not end with a CRLF"}
+		}
+
+		if string(line) == crlf {
+			return partHeaders, nil
+		}
+
+		if line[0] == ' ' || line[0] == '\t' {
+			return nil, invalidMultipartError{"obsolete line folding in the part headers"}
+		}
+
+		if _, _, err := partHeaders.Parse(line); err != nil {
+			return nil, invalidMultipartError{err.Error()}
+		}
+	}
+}
+
+// readLine reads the next line up to and including the LF. A line that is longer
+// than the buffer is returned in pieces.
+func (m *MultipartReader) readLine() ([]byte, error) {
+	line, err := m.reader.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return line, nil
+	}
+
+	return line, err
+}
+
+// Read reads the content of the part. io.EOF is returned at the end of the content.
+func (p *Part) Read(data []byte) (int, error) {
+	if p.eof {
+		return 0, io.EOF
+	}
+
+	reader := p.reader.reader
+	delimiter := p.reader.nlDashBoundary
+
+	peeked, peekErr := reader.Peek(bufferSize)
+
+	idx := bytes.Index(peeked, delimiter)
+
+	switch {
+	case idx == 0:
+		p.eof = true
+
+		return 0, io.EOF
+	case idx > 0:
+		peeked = peeked[:idx]
+	case peekErr != nil:
+		// The body ended without the final boundary delimiter.
+		if errors.Is(peekErr, io.EOF) {
+			return 0, unexpectedEndOfMultipart(io.ErrUnexpectedEOF)
+		}
+
+		return 0, peekErr
+	default:
+		// The end of the peeked data could be the start of the delimiter so it
+		// is kept in the buffer until more data is available.
+		peeked = peeked[:len(peeked)-len(delimiter)+1]
+	}
+
+	n := copy(data, peeked)
+
+	if _, err := reader.Discard(n); err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// FormName returns the value of the name parameter of the Content-Disposition
+// header or an empty string if the part is not a form-data part.
+func (p *Part) FormName() string {
+	disposition, params := p.disposition()
+	if disposition != "form-data" {
+		return ""
+	}
+
+	return params["name"]
+}
+
+// FileName returns the base name from the filename parameter of the
+// Content-Disposition header. The directories are removed to prevent the name
+// from being used to write outside of the intended directory.
+func (p *Part) FileName() string {
+	_, params := p.disposition()
+
+	filename, ok := params["filename"]
+	if !ok {
+		return ""
+	}
+
+	filename = filepath.Base(strings.ReplaceAll(filename, "\\", "/"))
+	if filename == "." || filename == "/" {
+		return ""
+	}
+
+	return filename
+}
+
+func (p *Part) disposition() (string, map[string]string) {
+	disposition, rest, _ := strings.Cut(p.Headers.Get("content-disposition"), ";")
+
+	params, err := headers.ParseParameters(rest)
+	if err != nil {
+		return "", nil
+	}
+
+	return strings.ToLower(strings.TrimSpace(disposition)), params
+}
+
+// MultipartLimits are the limits applied by ParseMultipartForm.
+type MultipartLimits struct {
+	// MaxPartSize is the maximum size of the content of a single part.
+	MaxPartSize int64
+
+	// MaxTotalSize is the maximum size of the content of all of the parts. The body
+	// is also limited by the maximum body size of the Reader.
+	MaxTotalSize int64
+
+	// MaxParts is the maximum number of parts.
+	MaxParts int
+}
+
+// DefaultMultipartLimits returns the default limits for ParseMultipartForm.
+func DefaultMultipartLimits() MultipartLimits {
+	return MultipartLimits{
+		MaxPartSize:  64 << 20,
+		MaxTotalSize: 256 << 20,
+		MaxParts:     1000,
+	}
+}
+
+// MultipartForm is a parsed multipart/form-data body.
+type MultipartForm struct {
+	Value Values
+	File  map[string][]*FileHeader
+}
+
+// FileHeader describes a file uploaded in a multipart/form-data body.
+type FileHeader struct {
+	Filename string
+	Headers  headers.Headers
+	Size     int64
+
+	content []byte
+}
+
+// File is an uploaded file opened with FileHeader.Open.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+}
+
+type memoryFile struct {
+	*bytes.Reader
+}
+
+func (f memoryFile) Close() error {
+	return nil
+}
+
+// Open opens the uploaded file.
+func (f *FileHeader) Open() (File, error) {
+	return memoryFile{bytes.NewReader(f.content)}, nil
+}
+
+// ParseMultipartForm parses a multipart/form-data body into MultipartForm. The body
+// has been received in full by the Reader, up to its maximum body size, and the
+// values and the files are kept in memory so uploads are bounded by the maximum body
+// size. The errors for the parts that exceed the limits map to the 413 Content Too
+// Large status code.
+func (r *Request) ParseMultipartForm(limits MultipartLimits) error {
+	if r.MultipartForm != nil {
+		return nil
+	}
+
+	multipartReader, err := r.MultipartReader()
+	if err != nil {
+		return err
+	}
+
+	form := &MultipartForm{
+		Value: make(Values),
+		File:  make(map[string][]*FileHeader),
+	}
+
+	var (
+		totalLeft = limits.MaxTotalSize
+		numParts  = 0
+	)
+
+	for {
+		part, err := multipartReader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		numParts++
+		if numParts > limits.MaxParts {
+			return multipartTooLargeError{fmt.Sprintf("the body has more than %d parts", limits.MaxParts)}
+		}
+
+		name := part.FormName()
+		if name == "" {
+			continue
+		}
+
+		limit := min(limits.MaxPartSize, totalLeft)
+
+		if part.FileName() == "" {
+			value, err := readPart(part, limit)
+			if err != nil {
+				return err
+			}
+
+			totalLeft -= int64(len(value))
+			form.Value.Add(name, string(value))
+
+			continue
+		}
+
+		content, err := readPart(part, limit)
+		if err != nil {
+			return err
+		}
+
+		totalLeft -= int64(len(content))
+		form.File[name] = append(form.File[name], &FileHeader{
+			Filename: part.FileName(),
+			Headers:  part.Headers,
+			Size:     int64(len(content)),
+			content:  content,
+		})
+	}
+
+	r.MultipartForm = form
+
+	return nil
+}
+
+// readPart reads the content of the part into memory. An error is returned if the
+// content is larger than the limit.
+func readPart(part *Part, limit int64) ([]byte, error) {
+	content, err := io.ReadAll(io.LimitReader(part, limit+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(content)) > limit {
+		return nil, multipartTooLargeError{fmt.Sprintf("a part exceeds the limit of %d bytes", limit)}
+	}
+
+	return content, nil
+}
+
+// unexpectedEndOfMultipart returns the error to use when the body ends before the
+// final boundary delimiter.
+func unexpectedEndOfMultipart(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return invalidMultipartError{"the body ended before the final boundary delimiter"}
+	}
+
+	return err
+}
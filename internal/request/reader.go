@@ -0,0 +1,370 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+
+	"http-from-tcp/internal/headers"
+)
+
+const (
+	// DefaultMaxBodySize is the default maximum size of a request body.
+	DefaultMaxBodySize int = 32 << 20
+
+	// bufferSize is the size of the buffer used to read from the connection.
+	// A line that does not fit in the buffer is copied to a new slice.
+	bufferSize int = 4096
+
+	// maxHeaderBytes is the maximum size of the request line and the header section.
+	maxHeaderBytes int = 1 << 20
+
+	// preallocatedBodySize is the largest body that is allocated in full as soon as the
+	// Content-Length is known. Larger bodies grow as the data arrives so that a client
+	// cannot make the server allocate a large amount of memory by sending a large
+	// Content-Length without the body.
+	preallocatedBodySize int = 1 << 16
+)
+
+var bufferPool = sync.Pool{
+	New: func() any {
+		return bufio.NewReaderSize(nil, bufferSize)
+	},
+}
+
+var parserPool = sync.Pool{
+	New: func() any {
+		return &headers.Parser{}
+	},
+}
+
+// Reader reads consecutive requests from a connection. Any data read beyond the
+// end of a request is kept in the buffer so that pipelined requests are not lost.
+//
+// The buffers are reused across requests. A typical request with a small body and a
+// few headers costs 6 allocations (about 600 bytes): the Request, the request target,
+// the header map (2 allocations), a single string for all of the header names and
+// values, and the body (see BenchmarkReaderPipelined).
+type Reader struct {
+	reader *bufio.Reader
+
+	// headerBytes is the number of bytes of the current request line and header
+	// section that have been read.
+	headerBytes int
+
+	// maxBodySize is the maximum size of a body, or 0 if the size is not limited.
+	maxBodySize int
+
+	// parser is reused for the header sections of the requests.
+	parser *headers.Parser
+}
+
+// NewReader returns a new Reader that reads requests from the reader. The buffers of
+// the Reader are taken from a pool and should be returned with Release once the Reader
+// is no longer needed.
+func NewReader(reader io.Reader) *Reader {
+	bufReader, _ := bufferPool.Get().(*bufio.Reader)
+	bufReader.Reset(reader)
+
+	parser, _ := parserPool.Get().(*headers.Parser)
+
+	return &Reader{
+		reader:      bufReader,
+		headerBytes: 0,
+		maxBodySize: DefaultMaxBodySize,
+		parser:      parser,
+	}
+}
+
+// SetMaxBodySize sets the maximum size of the bodies of the next requests, which is
+// DefaultMaxBodySize by default. The size is checked while the body is read so that
+// a larger body is rejected before it is buffered, with an error that maps to the 413
+// Content Too Large status code. A size of 0 or less removes the limit.
+func (r *Reader) SetMaxBodySize(size int) {
+	r.maxBodySize = max(size, 0)
+}
+
+// Release returns the buffers of the Reader to the pool. Any buffered data is discarded
+// and the Reader must not be used afterwards.
+func (r *Reader) Release() {
+	if r.reader == nil {
+		return
+	}
+
+	r.reader.Reset(nil)
+	bufferPool.Put(r.reader)
+	r.reader = nil
+
+	r.parser.Reset()
+	parserPool.Put(r.parser)
+	r.parser = nil
+}
+
+// Buffered returns a copy of the data that has been read from the connection but
+// not yet consumed by a request (e.g. the start of a pipelined request or the first
+// data of another protocol after an upgrade).
+func (r *Reader) Buffered() []byte {
+	if r.reader == nil {
+		return nil
+	}
+
+	buffered, _ := r.reader.Peek(r.reader.Buffered())
+
+	return bytes.Clone(buffered)
+}
+
+// Peek returns the next n bytes from the connection without consuming them. It waits
+// until n bytes have arrived and returns fewer bytes with an error if the connection
+// is closed before. The bytes are only valid until the next read.
+func (r *Reader) Peek(n int) ([]byte, error) {
+	return r.reader.Peek(n)
+}
+
+// ReadRequest reads and parses the next request. io.EOF is returned if the
+// connection was closed before any data of the next request was received.
+func (r *Reader) ReadRequest() (*Request, error) {
+	r.headerBytes = 0
+
+	requestLine, err := r.readRequestLine()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+
+		return nil, fmt.Errorf("error parsing the request line from the request: %w", err)
+	}
+
+	reqHeaders, err := r.readHeaders(incompleteHeadersLineError{})
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the headers from the request: %w", err)
+	}
+
+	request := Request{
+		RequestLine: requestLine,
+		Headers:     reqHeaders,
+		Body:        make([]byte, 0),
+	}
+
+	if err := request.setHost(); err != nil {
+		return nil, fmt.Errorf("error validating the Host header: %w", err)
+	}
+
+	request.Close = request.Headers.HasToken("connection", "close")
+
+	chunked, contentLength, err := bodyFraming(request.Headers)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"error parsing the body: error retrieving the message framing: %w",
+			err,
+		)
+	}
+
+	switch {
+	case chunked:
+		request.Body, request.Trailers, err = r.readChunkedBody()
+		if err != nil {
+			return nil, fmt.Errorf("error parsing the chunked body: %w", err)
+		}
+	case contentLength > 0:
+		if r.maxBodySize > 0 && contentLength > r.maxBodySize {
+			return nil, bodyTooLargeError{r.maxBodySize}
+		}
+
+		request.Body, err = r.readBody(request.Body, contentLength)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing the body: %w", err)
+		}
+	}
+
+	return &request, nil
+}
+
+// readRequestLine reads and parses the request line. Any empty lines received before
+// the request line are ignored (RFC 9112, section 2.2).
+func (r *Reader) readRequestLine() (RequestLine, error) {
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			if errors.Is(err, io.EOF) && len(line) > 0 {
+				return RequestLine{}, incompleteRequestLineError{}
+			}
+
+			return RequestLine{}, err
+		}
+
+		if len(line) == len(crlf) {
+			continue
+		}
+
+		return parseRequestLine(line[:len(line)-len(crlf)])
+	}
+}
+
+// readHeaders reads and parses a header section up to and including the empty line at
+// the end of the section. incompleteErr is returned if the connection is closed before
+// the end of the section.
+func (r *Reader) readHeaders(incompleteErr error) (headers.Headers, error) {
+	r.parser.Reset()
+
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, incompleteErr
+			}
+
+			return nil, err
+		}
+
+		if len(line) == len(crlf) {
+			return r.parser.Headers(), nil
+		}
+
+		// A header line starting with whitespace is either the continuation of
+		// the previous line (obsolete line folding) or whitespace between the
+		// request line and the first header. Both are rejected.
+		if line[0] == ' ' || line[0] == '\t' {
+			return nil, obsoleteLineFoldingError{}
+		}
+
+		if err := r.parser.ParseLine(line[:len(line)-len(crlf)]); err != nil {
+			return nil, fmt.Errorf("header parsing error: %w", err)
+		}
+	}
+}
+
+// readBody reads size bytes of the body and appends them to body.
+func (r *Reader) readBody(body []byte, size int) ([]byte, error) {
+	if size <= preallocatedBodySize {
+		body = slices.Grow(body, size)
+
+		if _, err := io.ReadFull(r.reader, body[len(body):len(body)+size]); err != nil {
+			return nil, bodyReadError(err)
+		}
+
+		return body[:len(body)+size], nil
+	}
+
+	body = slices.Grow(body, preallocatedBodySize)
+	want := len(body) + size
+	buf := bytes.NewBuffer(body)
+
+	if _, err := buf.ReadFrom(io.LimitReader(r.reader, int64(size))); err != nil {
+		return nil, bodyReadError(err)
+	}
+
+	if buf.Len() < want {
+		return nil, incompleteBodyError{}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// readChunkedBody reads the chunks of a chunked body followed by the trailers.
+func (r *Reader) readChunkedBody() ([]byte, headers.Headers, error) {
+	body := make([]byte, 0)
+
+	for {
+		// The chunk size lines do not count towards the size of the header section.
+		r.headerBytes = 0
+
+		line, err := r.readLine()
+		if err != nil {
+			return nil, nil, bodyReadError(err)
+		}
+
+		chunkSize, err := ParseChunkSize(line[:len(line)-len(crlf)])
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// The last chunk is followed by the (optional) trailers.
+		if chunkSize == 0 {
+			break
+		}
+
+		if r.maxBodySize > 0 && chunkSize > r.maxBodySize-len(body) {
+			return nil, nil, bodyTooLargeError{r.maxBodySize}
+		}
+
+		body, err = r.readBody(body, chunkSize)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		end, err := r.reader.Peek(len(crlf))
+		if err != nil {
+			return nil, nil, bodyReadError(err)
+		}
+
+		if string(end) != crlf {
+			return nil, nil, malformedChunkError{}
+		}
+
+		if _, err := r.reader.Discard(len(crlf)); err != nil {
+			return nil, nil, bodyReadError(err)
+		}
+	}
+
+	r.headerBytes = 0
+
+	trailers, err := r.readHeaders(incompleteBodyError{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing the trailers from the request: %w", err)
+	}
+
+	return body, trailers, nil
+}
+
+// readLine reads the next line up to and including the LF. The returned line points into
+// the buffer of the Reader where possible so it is only valid until the next read. Every
+// line must end with a CRLF. A bare CR or LF could be interpreted differently by other
+// servers in the request chain.
+func (r *Reader) readLine() ([]byte, error) {
+	line, err := r.reader.ReadSlice('\n')
+
+	if errors.Is(err, bufio.ErrBufferFull) {
+		// The line is longer than the buffer so it is copied to a new slice.
+		longLine := append([]byte(nil), line...)
+
+		for errors.Is(err, bufio.ErrBufferFull) && r.headerBytes+len(longLine) <= maxHeaderBytes {
+			line, err = r.reader.ReadSlice('\n')
+			longLine = append(longLine, line...)
+		}
+
+		line = longLine
+	}
+
+	r.headerBytes += len(line)
+
+	if r.headerBytes > maxHeaderBytes {
+		return nil, headerSectionTooLargeError{maxHeaderBytes}
+	}
+
+	if err != nil {
+		return line, err
+	}
+
+	if len(line) < len(crlf) || line[len(line)-len(crlf)] != '\r' {
+		return nil, bareLineFeedError{}
+	}
+
+	if bytes.IndexByte(line[:len(line)-len(crlf)], '\r') != -1 {
+		return nil, bareLineFeedError{}
+	}
+
+	return line, nil
+}
+
+// bodyReadError returns the error to use when the body could not be read.
+func bodyReadError(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return incompleteBodyError{}
+	}
+
+	return fmt.Errorf("error reading the data: %w", err)
+}
@@ -1,12 +1,13 @@
 package request
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"strconv"
 	"strings"
-	"unicode"
 
 	"http-from-tcp/internal/headers"
 )
@@ -14,179 +15,107 @@ import (
 const (
 	supportedHttpVersion string = "HTTP/1.1"
 	crlf                 string = "\r\n"
-	endOfHeaders         string = crlf + crlf
-	bufferSize           int    = 8
-)
-
-type requestState int
-
-const (
-	requestStateInitialiased = iota
-	requestStateParsingHeaders
-	requestStateParsingBody
-	requestStateDone
 )
 
 type Request struct {
-	RequestLine   RequestLine
-	Headers       headers.Headers
-	Body          []byte
-	state         requestState
-	contentLength int
-}
-
-func RequestFromReader(reader io.Reader) (*Request, error) {
-	buf := make([]byte, bufferSize, bufferSize)
-	readToIndex := 0
-
-	request := Request{
-		Body:  make([]byte, 0),
-		state: requestStateInitialiased,
-	}
+	RequestLine RequestLine
+	Headers     headers.Headers
+	Body        []byte
+	Trailers    headers.Headers
 
-ProcessRequest:
-	for request.state != requestStateDone {
-		// Increase the size of the buffer if it is full.
-		if readToIndex >= cap(buf) {
-			buf = increaseBufferSize(buf)
-		}
+	// Host is the normalised host name from the Host header, or from the target
+	// if it is in absolute form. It is empty if the Host header is empty.
+	Host string
 
-		// Read the data from the reader and note the size of the data that
-		// has been read.
-		sizeOfRead, err := reader.Read(buf[readToIndex:])
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				switch request.state {
-				case requestStateInitialiased:
-					return nil, incompleteRequestLineError{}
-				case requestStateParsingHeaders:
-					return nil, incompleteHeadersLineError{}
-				case requestStateParsingBody:
-					return nil, incompleteBodyError{}
-				default:
-					break ProcessRequest
-				}
-			}
-
-			return nil, fmt.Errorf("error reading the data: %w", err)
-		}
+	// Port is the port from the Host header or the target. The default port of
+	// the scheme is used if they do not specify a port.
+	Port int
 
-		// Update the readToIndex
-		readToIndex = readToIndex + sizeOfRead
+	// Close is true if the client asked for the connection to be closed
+	// after the response is sent.
+	Close bool
 
-		// Now try and parse the data and note the size of the data that has been parsed (if parsed).
-		sizeOfParsed, err := request.parse(buf[:readToIndex])
-		if err != nil {
-			return nil, fmt.Errorf("error parsing the data: %w", err)
-		}
+	// RemoteAddr is the network address of the client that sent the request. It
+	// is set by the server and is empty if the request was not read from a
+	// connection.
+	RemoteAddr string
 
-		// If the data has not been parsed, re-loop so that we can read more data into the buffer
-		// if the state has not been changed to DONE.
-		if sizeOfParsed == 0 {
-			continue
-		}
+	// Form contains the values from the query string and the URL-encoded body.
+	// It is only available after ParseForm is called.
+	Form Values
 
-		buf = clearParsedData(buf, sizeOfParsed)
-		readToIndex = readToIndex - sizeOfParsed
-	}
+	// PostForm contains the values from the URL-encoded body. It is only
+	// available after ParseForm is called.
+	PostForm Values
 
-	fmt.Println("The entire length of the data has been consumed!")
+	// MultipartForm contains the parsed multipart/form-data body. It is only
+	// available after ParseMultipartForm is called.
+	MultipartForm *MultipartForm
 
-	return &request, nil
+	ctx context.Context
 }
 
-func (r *Request) parse(data []byte) (int, error) {
-	switch r.state {
-	case requestStateInitialiased:
-		parsed, sizeOfParsed, err := parseRequestLine(string(data))
-		if err != nil {
-			return 0, fmt.Errorf(
-				"error parsing the request line from the request: %w",
-				err,
-			)
-		}
-
-		// More data is needed from the requester.
-		if sizeOfParsed == 0 {
-			return 0, nil
-		}
-
-		// Add the parsed request line to r and
-		// update the state to indicate that the next thing to parse are
-		// the headers.
-		r.RequestLine = parsed
-		r.state = requestStateParsingHeaders
+// Context returns the context of the request. The background context is returned
+// if the request does not have a context.
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
 
-		// Return the size (in bytes) of the original request line that was parsed.
-		return sizeOfParsed, nil
-	case requestStateParsingHeaders:
-		headers, sizeOfParsed, err := parseHeaders(data)
-		if err != nil {
-			return 0, fmt.Errorf(
-				"error parsing the headers from the request: %w",
-				err,
-			)
-		}
+	return r.ctx
+}
 
-		// More data is needed from the requester.
-		if sizeOfParsed == 0 {
-			return 0, nil
-		}
+// WithContext returns a shallow copy of the request with its context changed to ctx.
+// Middleware use it to pass values to the next handler.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	if ctx == nil {
+		panic("nil context")
+	}
 
-		// Add the parsed headers to r.
-		r.Headers = headers
+	request := *r
+	request.ctx = ctx
 
-		// Update the state depending on the content-length header.
-		contentLengthStr := r.Headers.Get("Content-Length")
-		if contentLengthStr == "" {
-			r.state = requestStateDone
+	return &request
+}
 
-			return sizeOfParsed, nil
-		}
+// RequestFromReader reads and parses a single request from the reader. Any data
+// read beyond the end of the request is discarded. Use a Reader to read
+// consecutive requests from the same connection.
+func RequestFromReader(reader io.Reader) (*Request, error) {
+	requestReader := NewReader(reader)
+	defer requestReader.Release()
 
-		contentLength, err := strconv.Atoi(contentLengthStr)
-		if err != nil {
-			return 0, fmt.Errorf(
-				"error parsing the body: error retrieving the content length: %w",
-				err,
-			)
+	request, err := requestReader.ReadRequest()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, incompleteRequestLineError{}
 		}
 
-		if contentLength < 1 {
-			r.state = requestStateDone
+		return nil, err
+	}
 
-			return sizeOfParsed, nil
-		}
+	return request, nil
+}
 
-		r.state = requestStateParsingBody
-		r.contentLength = contentLength
+// NewRequest returns a request that was received without a Reader (e.g. on an HTTP/2
+// stream). The names of the headers must be in lower case. The Host header must be set
+// and it is validated as for the requests read by a Reader.
+func NewRequest(requestLine RequestLine, h headers.Headers, body []byte) (*Request, error) {
+	if body == nil {
+		body = make([]byte, 0)
+	}
 
-		// Return the size (in bytes) of the original headers line that was parsed.
-		return sizeOfParsed, nil
-	case requestStateParsingBody:
-		sizeOfData := len(data)
+	request := Request{
+		RequestLine: requestLine,
+		Headers:     h,
+		Body:        body,
+	}
 
-		switch {
-		case sizeOfData > r.contentLength:
-			return 0, fmt.Errorf(
-				"unexpected size of the request body found: want %d, got %d",
-				r.contentLength,
-				sizeOfData,
-			)
-		case sizeOfData == r.contentLength:
-			r.Body = data
-			r.state = requestStateDone
-
-			return sizeOfData, nil
-		default:
-			// More data is needed for the request body
-			return 0, nil
-		}
-	case requestStateDone:
-		return 0, errors.New("request parsing error: attempt to read data in a done state")
-	default:
-		return 0, errors.New("request parsing error: unknown state")
+	if err := request.setHost(); err != nil {
+		return nil, fmt.Errorf("error validating the Host header: %w", err)
 	}
+
+	return &request, nil
 }
 
 type RequestLine struct {
@@ -195,95 +124,188 @@ type RequestLine struct {
 	HTTPVersion   string
 }
 
-// parseRequestLine parses the request line of the request. If successful, parseRequestLine
-// returns the parsed request line and the size (in bytes) of the original request line that
-// was parsed.
-func parseRequestLine(req string) (RequestLine, int, error) {
-	if !strings.Contains(req, crlf) {
-		return RequestLine{}, 0, nil
+// parseRequestLine parses the request line of the request. The line must not
+// include the CRLF at the end of the line.
+func parseRequestLine(line []byte) (RequestLine, error) {
+	if numParts := bytes.Count(line, []byte(" ")) + 1; numParts != 3 {
+		return RequestLine{}, requestLinePartsError{numParts}
 	}
 
-	parts := strings.SplitN(req, crlf, 2)
-	if len(parts) != 2 {
-		return RequestLine{}, 0, requestPartsError{len(parts)}
-	}
-
-	reqLine := parts[0]
+	method, rest, _ := bytes.Cut(line, []byte(" "))
+	requestTarget, httpVersion, _ := bytes.Cut(rest, []byte(" "))
 
-	parts = strings.Split(reqLine, " ")
-	if len(parts) != 3 {
-		return RequestLine{}, 0, requestLinePartsError{len(parts)}
+	// Verify that the method is all caps
+	if len(method) == 0 {
+		return RequestLine{}, methodFormatError{string(method)}
 	}
 
-	method, requestTarget, httpVersion := parts[0], parts[1], parts[2]
-
-	// Verify that the method is all caps
 	for _, letter := range method {
-		if !unicode.IsUpper(letter) {
-			return RequestLine{}, 0, methodFormatError{method}
+		if letter < 'A' || letter > 'Z' {
+			return RequestLine{}, methodFormatError{string(method)}
 		}
 	}
 
 	// Verify that the HTTP Version is literally HTTP/1.1
-	if httpVersion != supportedHttpVersion {
-		return RequestLine{}, 0, unsupportedHTTPVersionError{
+	if string(httpVersion) != supportedHttpVersion {
+		return RequestLine{}, unsupportedHTTPVersionError{
 			supportedVersion: supportedHttpVersion,
-			gotVersion:       httpVersion,
+			gotVersion:       string(httpVersion),
 		}
 	}
 
 	return RequestLine{
-			Method:        method,
-			RequestTarget: requestTarget,
-			HTTPVersion:   "1.1",
-		},
-		len([]byte(reqLine)) + len([]byte(crlf)),
-		nil
+		Method:        methodString(method),
+		RequestTarget: string(requestTarget),
+		HTTPVersion:   "1.1",
+	}, nil
 }
 
-func parseHeaders(data []byte) (headers.Headers, int, error) {
-	if !strings.Contains(string(data), endOfHeaders) {
-		// More data required.
-		return headers.Headers{}, 0, nil
+// methodString returns the method as a string without allocating a new
+// string for the standard methods.
+func methodString(method []byte) string {
+	switch string(method) {
+	case "GET":
+		return "GET"
+	case "HEAD":
+		return "HEAD"
+	case "POST":
+		return "POST"
+	case "PUT":
+		return "PUT"
+	case "DELETE":
+		return "DELETE"
+	case "CONNECT":
+		return "CONNECT"
+	case "OPTIONS":
+		return "OPTIONS"
+	case "TRACE":
+		return "TRACE"
+	case "PATCH":
+		return "PATCH"
+	default:
+		return string(method)
 	}
+}
 
-	var (
-		reqHeaders        = headers.NewHeaders()
-		totalSizeOfParsed = 0
-	)
+// bodyFraming returns how the body of the request is framed. A request with both
+// the Transfer-Encoding and the Content-Length headers is rejected as the two
+// headers can be used to smuggle a request past another server in the request chain.
+func bodyFraming(h headers.Headers) (chunked bool, contentLength int, err error) {
+	transferEncoding, hasTransferEncoding := h["transfer-encoding"]
+	contentLengthStr, hasContentLength := h["content-length"]
+
+	switch {
+	case hasTransferEncoding && hasContentLength:
+		return false, 0, conflictingFramingError{}
+	case hasTransferEncoding:
+		// Chunked is the only transfer coding that is supported.
+		if !strings.EqualFold(transferEncoding, "chunked") {
+			return false, 0, unsupportedTransferEncodingError{transferEncoding}
+		}
 
-	for {
-		sizeOfParsed, done, err := reqHeaders.Parse(data[totalSizeOfParsed:])
+		return true, 0, nil
+	case hasContentLength:
+		contentLength, err := ParseContentLength(contentLengthStr)
 		if err != nil {
-			return headers.Headers{}, 0, fmt.Errorf("header parsing error: %w", err)
+			return false, 0, err
+		}
+
+		return false, contentLength, nil
+	default:
+		return false, 0, nil
+	}
+}
+
+// ParseContentLength parses the value of the Content-Length header. Duplicate
+// Content-Length headers are merged into a comma separated list by the header
+// parser so the list is only accepted if all of its values are identical. The
+// response Reader uses it to frame the bodies of the responses in the same way.
+func ParseContentLength(value string) (int, error) {
+	first, rest, found := strings.Cut(value, ",")
+	contentLengthStr := strings.TrimSpace(first)
+
+	for found {
+		var next string
+
+		next, rest, found = strings.Cut(rest, ",")
+		if strings.TrimSpace(next) != contentLengthStr {
+			return 0, conflictingContentLengthError{value}
+		}
+	}
+
+	if contentLengthStr == "" {
+		return 0, invalidContentLengthError{value}
+	}
+
+	// Only digits are allowed. strconv.Atoi would otherwise accept a leading sign.
+	for _, char := range contentLengthStr {
+		if char < '0' || char > '9' {
+			return 0, invalidContentLengthError{value}
 		}
+	}
+
+	contentLength, err := strconv.Atoi(contentLengthStr)
+	if err != nil {
+		return 0, invalidContentLengthError{value}
+	}
+
+	return contentLength, nil
+}
+
+// ParseChunkSize parses the chunk size from the chunk size line of a chunked body.
+// The line must not include the CRLF at the end of the line. Any chunk extensions
+// are ignored. The response Reader uses it to read the chunked bodies of the
+// responses.
+func ParseChunkSize(line []byte) (int, error) {
+	size, _, _ := bytes.Cut(line, []byte(";"))
+	size = bytes.TrimRight(size, " \t")
+
+	// Limit the size so that the parsed size cannot overflow.
+	if len(size) == 0 || len(size) > 8 {
+		return 0, invalidChunkSizeError{string(line)}
+	}
+
+	chunkSize := 0
+
+	for _, char := range size {
+		var digit byte
 
-		if done {
-			// No headers were parsed if this is set to true,
-			// so just break out of the loop.
-			break
+		switch {
+		case char >= '0' && char <= '9':
+			digit = char - '0'
+		case char >= 'a' && char <= 'f':
+			digit = char - 'a' + 10
+		case char >= 'A' && char <= 'F':
+			digit = char - 'A' + 10
+		default:
+			return 0, invalidChunkSizeError{string(line)}
 		}
 
-		totalSizeOfParsed += sizeOfParsed
+		chunkSize = chunkSize<<4 | int(digit)
 	}
 
-	return reqHeaders, totalSizeOfParsed + len([]byte(crlf)), nil
+	return chunkSize, nil
 }
 
-// increaseBufferSize returns a buffer that is double the capacity of the
-// input buffer with the data of the input buffer copied over to the
-// output buffer.
-func increaseBufferSize(buf []byte) []byte {
-	newBufferSize := cap(buf) + bufferSize
-	output := make([]byte, newBufferSize, newBufferSize)
-	copy(output, buf)
+// ContentType returns the parsed media type from the Content-Type header.
+// headers.ErrNoMediaType is returned if the request does not have the header.
+func (r *Request) ContentType() (headers.MediaType, error) {
+	return headers.ParseMediaType(r.Headers.Get("content-type"))
+}
 
-	return output
+// Cookies returns the cookies from the Cookie header. Invalid cookies are ignored.
+func (r *Request) Cookies() []headers.Cookie {
+	return headers.ParseCookies(r.Headers.Get("cookie"))
 }
 
-func clearParsedData(buf []byte, sizeOfParsed int) []byte {
-	output := make([]byte, cap(buf), cap(buf))
-	copy(output, buf[sizeOfParsed:])
+// Cookie returns the first cookie with the given name. false is returned if the
+// request does not have the cookie.
+func (r *Request) Cookie(name string) (headers.Cookie, bool) {
+	for _, cookie := range r.Cookies() {
+		if cookie.Name == name {
+			return cookie, true
+		}
+	}
 
-	return output
+	return headers.Cookie{}, false
 }
@@ -1,12 +1,20 @@
 package request
 
 import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
 	"io"
+	"strconv"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"http-from-tcp/internal/headers"
 )
 
 type chunkReader struct {
@@ -121,14 +129,13 @@ func TestHeadersParse(t *testing.T) {
 	assert.Equal(t, "*/*", r.Headers["accept"])
 
 	// Test: Empty Headers
+	// An HTTP/1.1 request must contain the Host header.
 	reader = &chunkReader{
 		data:            "GET /coffee HTTP/1.1\r\n\r\n\r\n",
 		numBytesPerRead: 1,
 	}
-	r, err = RequestFromReader(reader)
-	require.NoError(t, err)
-	require.NotNil(t, r)
-	assert.Empty(t, r.Headers)
+	_, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, missingHostError{})
 
 	// Test: Duplicate Headers
 	reader = &chunkReader{
@@ -226,3 +233,893 @@ func TestBodyParse(t *testing.T) {
 	require.NotNil(t, r)
 	assert.Empty(t, r.Body)
 }
+
+func TestChunkedBodyParse(t *testing.T) {
+	// Test: Standard chunked body
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"6\r\n" +
+			"hello \r\n" +
+			"7;ext=value\r\n" +
+			"world!\n\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "hello world!\n", string(r.Body))
+	assert.Empty(t, r.Trailers)
+
+	// Test: Chunked body with trailers
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"Trailer: X-Checksum\r\n" +
+			"\r\n" +
+			"1A\r\n" +
+			"abcdefghijklmnopqrstuvwxyz\r\n" +
+			"0\r\n" +
+			"X-Checksum: 12345\r\n" +
+			"\r\n",
+		numBytesPerRead: 5,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "abcdefghijklmnopqrstuvwxyz", string(r.Body))
+	assert.Equal(t, "12345", r.Trailers.Get("X-Checksum"))
+
+	// Test: Missing last chunk
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\n" +
+			"hello\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, incompleteBodyError{})
+
+	// Test: Duplicate Content-Length headers with identical values
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 5\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "hello", string(r.Body))
+}
+
+func TestRequestSmuggling(t *testing.T) {
+	testCases := []struct {
+		name    string
+		request string
+		wantErr error
+	}{
+		{
+			name: "CL.CL: conflicting Content-Length headers",
+			request: "POST / HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"Content-Length: 5\r\n" +
+				"Content-Length: 7\r\n" +
+				"\r\n" +
+				"hello",
+			wantErr: conflictingContentLengthError{"5, 7"},
+		},
+		{
+			name: "CL.CL: conflicting Content-Length values in a single header",
+			request: "POST / HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"Content-Length: 5, 0\r\n" +
+				"\r\n" +
+				"hello",
+			wantErr: conflictingContentLengthError{"5, 0"},
+		},
+		{
+			name: "Content-Length with a sign",
+			request: "POST / HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"Content-Length: +5\r\n" +
+				"\r\n" +
+				"hello",
+			wantErr: invalidContentLengthError{"+5"},
+		},
+		{
+			name: "Negative Content-Length",
+			request: "POST / HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"Content-Length: -1\r\n" +
+				"\r\n" +
+				"hello",
+			wantErr: invalidContentLengthError{"-1"},
+		},
+		{
+			name: "Content-Length in hexadecimal",
+			request: "POST / HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"Content-Length: 0x5\r\n" +
+				"\r\n" +
+				"hello",
+			wantErr: invalidContentLengthError{"0x5"},
+		},
+		{
+			name: "CL.TE: Content-Length before Transfer-Encoding",
+			request: "POST / HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"Content-Length: 13\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"\r\n" +
+				"0\r\n" +
+				"\r\n" +
+				"SMUGGLED",
+			wantErr: conflictingFramingError{},
+		},
+		{
+			name: "TE.CL: Transfer-Encoding before Content-Length",
+			request: "POST / HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"Content-Length: 3\r\n" +
+				"\r\n" +
+				"8\r\n" +
+				"SMUGGLED\r\n" +
+				"0\r\n" +
+				"\r\n",
+			wantErr: conflictingFramingError{},
+		},
+		{
+			name: "TE.TE: obfuscated transfer coding",
+			request: "POST / HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"Transfer-Encoding: xchunked\r\n" +
+				"\r\n" +
+				"0\r\n" +
+				"\r\n",
+			wantErr: unsupportedTransferEncodingError{"xchunked"},
+		},
+		{
+			name: "TE.TE: duplicate Transfer-Encoding headers",
+			request: "POST / HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"Transfer-Encoding: identity\r\n" +
+				"\r\n" +
+				"0\r\n" +
+				"\r\n",
+			wantErr: unsupportedTransferEncodingError{"chunked, identity"},
+		},
+		{
+			name: "TE.TE: Transfer-Encoding folded onto a second line",
+			request: "POST / HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"Transfer-Encoding:\r\n" +
+				" chunked\r\n" +
+				"\r\n" +
+				"0\r\n" +
+				"\r\n",
+			wantErr: obsoleteLineFoldingError{},
+		},
+		{
+			name: "TE.TE: whitespace before the first header",
+			request: "POST / HTTP/1.1\r\n" +
+				" Transfer-Encoding: chunked\r\n" +
+				"Host: localhost:42069\r\n" +
+				"\r\n" +
+				"0\r\n" +
+				"\r\n",
+			wantErr: obsoleteLineFoldingError{},
+		},
+		{
+			name: "Bare LF between headers",
+			request: "POST / HTTP/1.1\r\n" +
+				"Host: localhost:42069\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"\r\n" +
+				"0\r\n" +
+				"\r\n",
+			wantErr: bareLineFeedError{},
+		},
+		{
+			name: "Bare CR in a header value",
+			request: "POST / HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"X-Header: foo\rContent-Length: 5\r\n" +
+				"\r\n" +
+				"hello",
+			wantErr: bareLineFeedError{},
+		},
+		{
+			name: "Bare LF in the request line",
+			request: "POST / HTTP/1.1\n" +
+				"Host: localhost:42069\r\n" +
+				"\r\n",
+			wantErr: bareLineFeedError{},
+		},
+		{
+			name: "Invalid chunk size",
+			request: "POST / HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"\r\n" +
+				"0x5\r\n" +
+				"hello\r\n" +
+				"0\r\n" +
+				"\r\n",
+			wantErr: invalidChunkSizeError{"0x5"},
+		},
+		{
+			name: "Chunk size that overflows",
+			request: "POST / HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"\r\n" +
+				"10000000000000005\r\n" +
+				"hello\r\n" +
+				"0\r\n" +
+				"\r\n",
+			wantErr: invalidChunkSizeError{"10000000000000005"},
+		},
+		{
+			name: "Chunk data longer than the chunk size",
+			request: "POST / HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"\r\n" +
+				"3\r\n" +
+				"hello\r\n" +
+				"0\r\n" +
+				"\r\n",
+			wantErr: malformedChunkError{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			reader := &chunkReader{
+				data:            tc.request,
+				numBytesPerRead: 4,
+			}
+			_, err := RequestFromReader(reader)
+			require.ErrorIs(t, err, tc.wantErr)
+		})
+	}
+
+	// Test: Whitespace between the header name and the colon
+	_, err := RequestFromReader(strings.NewReader(
+		"POST / HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding : chunked\r\n" +
+			"\r\n" +
+			"0\r\n" +
+			"\r\n",
+	))
+	require.Error(t, err)
+
+	// Test: Tab between the header name and the colon
+	_, err = RequestFromReader(strings.NewReader(
+		"POST / HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length\t: 5\r\n" +
+			"\r\n" +
+			"hello",
+	))
+	require.Error(t, err)
+}
+
+func TestHostParse(t *testing.T) {
+	// Test: Host with a port
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "localhost", r.Host)
+	assert.Equal(t, 42069, r.Port)
+
+	// Test: Host without a port is normalised
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: WWW.Example.COM.\r\n\r\n"))
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "www.example.com", r.Host)
+	assert.Equal(t, 80, r.Port)
+
+	// Test: IPv6 host
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: [::1]:8080\r\n\r\n"))
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "::1", r.Host)
+	assert.Equal(t, 8080, r.Port)
+
+	// Test: The authority of an absolute-form target replaces the Host header
+	r, err = RequestFromReader(strings.NewReader("GET http://A.example:8080/path?q=1 HTTP/1.1\r\nHost: b.example\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "a.example", r.Host)
+	assert.Equal(t, 8080, r.Port)
+	assert.Equal(t, "A.example:8080", r.Headers.Get("host"))
+
+	r, err = RequestFromReader(strings.NewReader("GET https://a.example HTTP/1.1\r\nHost: b.example\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "a.example", r.Host)
+	assert.Equal(t, 443, r.Port)
+
+	_, err = RequestFromReader(strings.NewReader("GET http://user@a.example/ HTTP/1.1\r\nHost: a.example\r\n\r\n"))
+	require.ErrorIs(t, err, invalidHostError{"user@a.example"})
+
+	// Test: An empty Host is accepted for a target URI without a host
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: \r\n\r\n"))
+	require.NoError(t, err)
+	assert.Empty(t, r.Host)
+	assert.Equal(t, 80, r.Port)
+
+	_, err = RequestFromReader(strings.NewReader("GET http:///path HTTP/1.1\r\nHost: \r\n\r\n"))
+	require.ErrorIs(t, err, invalidHostError{""})
+
+	// Test: Missing Host
+	_, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nAccept: */*\r\n\r\n"))
+	require.ErrorIs(t, err, missingHostError{})
+
+	// Test: Duplicate Host
+	_, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: example.com\r\nHost: evil.com\r\n\r\n"))
+	require.ErrorIs(t, err, duplicateHostError{"example.com, evil.com"})
+
+	// Test: Invalid Hosts
+	for _, host := range []string{".", ":8080", "example.com:", "example.com:http", "example.com:65536", "exa mple.com", "[::1", "[::1]8080", "user@example.com"} {
+		_, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: " + host + "\r\n\r\n"))
+		require.ErrorIs(t, err, invalidHostError{host}, "host: %q", host)
+	}
+}
+
+func TestNewRequest(t *testing.T) {
+	requestLine := RequestLine{Method: "GET", RequestTarget: "/", HTTPVersion: "2"}
+
+	// Test: The Host header is validated and normalised
+	r, err := NewRequest(requestLine, headers.Headers{"host": "Example.com:8443"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", r.Host)
+	assert.Equal(t, 8443, r.Port)
+	assert.Empty(t, r.Body)
+	assert.NotNil(t, r.Body)
+
+	// Test: Missing and invalid Hosts
+	_, err = NewRequest(requestLine, headers.NewHeaders(), nil)
+	require.ErrorIs(t, err, missingHostError{})
+
+	_, err = NewRequest(requestLine, headers.Headers{"host": "exa mple.com"}, nil)
+	require.ErrorIs(t, err, invalidHostError{"exa mple.com"})
+}
+
+func TestLargeRequests(t *testing.T) {
+	// Test: Header line longer than the read buffer
+	longValue := strings.Repeat("a", bufferSize*2)
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\nX-Long: " + longValue + "\r\n\r\n"))
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, longValue, r.Headers.Get("X-Long"))
+
+	// Test: Header section larger than the limit
+	_, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\nX-Long: " + strings.Repeat("a", maxHeaderBytes) + "\r\n\r\n"))
+	require.ErrorIs(t, err, headerSectionTooLargeError{maxHeaderBytes})
+
+	// Test: Body larger than the preallocated size
+	body := strings.Repeat("b", preallocatedBodySize*3+1)
+	reader := &chunkReader{
+		data:            "POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body,
+		numBytesPerRead: 1000,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, body, string(r.Body))
+
+	// Test: Large Content-Length without the body
+	unlimited := NewReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 99999999999\r\n\r\nshort"))
+	defer unlimited.Release()
+
+	unlimited.SetMaxBodySize(0)
+
+	_, err = unlimited.ReadRequest()
+	require.ErrorIs(t, err, incompleteBodyError{})
+}
+
+// countingReader counts the bytes that are read from the reader.
+type countingReader struct {
+	reader io.Reader
+	read   int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.read += n
+
+	return n, err
+}
+
+func TestMaxBodySize(t *testing.T) {
+	body := strings.Repeat("b", 100_000)
+
+	// Test: A Content-Length over the limit is rejected before the body is read
+	source := &countingReader{
+		reader: strings.NewReader("POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 100000\r\n\r\n" + body),
+		read:   0,
+	}
+	reader := NewReader(source)
+	defer reader.Release()
+
+	reader.SetMaxBodySize(1000)
+
+	_, err := reader.ReadRequest()
+	require.ErrorIs(t, err, bodyTooLargeError{1000})
+	assert.Equal(t, 413, StatusCode(err))
+	assert.Less(t, source.read, len(body))
+
+	// Test: The chunks of a chunked body are rejected once they exceed the limit
+	source = &countingReader{
+		reader: strings.NewReader("POST / HTTP/1.1\r\nHost: localhost:42069\r\nTransfer-Encoding: chunked\r\n\r\n" +
+			strings.Repeat("258\r\n"+strings.Repeat("c", 600)+"\r\n", 100) + "0\r\n\r\n"),
+		read: 0,
+	}
+	chunked := NewReader(source)
+	defer chunked.Release()
+
+	chunked.SetMaxBodySize(1000)
+
+	_, err = chunked.ReadRequest()
+	require.ErrorIs(t, err, bodyTooLargeError{1000})
+	assert.Less(t, source.read, 10_000)
+
+	// Test: A body at the limit is accepted
+	exact := NewReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost:42069\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"3e8\r\n" + strings.Repeat("d", 1000) + "\r\n0\r\n\r\n"))
+	defer exact.Release()
+
+	exact.SetMaxBodySize(1000)
+
+	r, err := exact.ReadRequest()
+	require.NoError(t, err)
+	assert.Len(t, r.Body, 1000)
+}
+
+func TestReaderPipelinedRequests(t *testing.T) {
+	// Test: Consecutive requests are read from the same buffered data
+	reader := NewReader(&chunkReader{
+		data: "GET /first HTTP/1.1\r\nHost: localhost:42069\r\n\r\n" +
+			"POST /second HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\n\r\nhello" +
+			"\r\nGET /third HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 7,
+	})
+	defer reader.Release()
+
+	for _, want := range []string{"/first", "/second", "/third"} {
+		r, err := reader.ReadRequest()
+		require.NoError(t, err)
+		require.NotNil(t, r)
+		assert.Equal(t, want, r.RequestLine.RequestTarget)
+	}
+
+	_, err := reader.ReadRequest()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+const benchmarkRequest = "POST /submit HTTP/1.1\r\n" +
+	"Host: localhost:42069\r\n" +
+	"User-Agent: curl/8.13.0\r\n" +
+	"Accept: */*\r\n" +
+	"Content-Type: application/json\r\n" +
+	"Content-Length: 27\r\n" +
+	"\r\n" +
+	"{\"milk\": true, \"sugars\": 2}"
+
+func BenchmarkRequestFromReader(b *testing.B) {
+	data := []byte(benchmarkRequest)
+	reader := bytes.NewReader(data)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+
+	for b.Loop() {
+		reader.Reset(data)
+
+		if _, err := RequestFromReader(reader); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReaderPipelined(b *testing.B) {
+	const numRequests = 100
+
+	data := []byte(strings.Repeat(benchmarkRequest, numRequests))
+	source := bytes.NewReader(data)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+
+	for b.Loop() {
+		source.Reset(data)
+		reader := NewReader(source)
+
+		for range numRequests {
+			if _, err := reader.ReadRequest(); err != nil {
+				b.Fatal(err)
+			}
+		}
+
+		reader.Release()
+	}
+}
+
+func BenchmarkHeadersParse(b *testing.B) {
+	line := []byte("Content-Type: application/json\r\n")
+
+	b.ReportAllocs()
+
+	for b.Loop() {
+		h := headers.NewHeaders()
+
+		if _, _, err := h.Parse(line); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestContentType(t *testing.T) {
+	// Test: Request with a Content-Type
+	r, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Type: application/json; charset=UTF-8\r\n\r\n"))
+	require.NoError(t, err)
+	require.NotNil(t, r)
+
+	contentType, err := r.ContentType()
+	require.NoError(t, err)
+	assert.Equal(t, "application/json", contentType.Essence())
+	assert.Equal(t, "utf-8", contentType.Params["charset"])
+
+	// Test: Request without a Content-Type
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	require.NoError(t, err)
+	require.NotNil(t, r)
+
+	_, err = r.ContentType()
+	require.ErrorIs(t, err, headers.ErrNoMediaType)
+}
+
+func TestParseForm(t *testing.T) {
+	// Test: URL-encoded body and query string
+	r, err := RequestFromReader(strings.NewReader(
+		"POST /submit?name=query&page=2 HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Type: application/x-www-form-urlencoded; charset=utf-8\r\n" +
+			"Content-Length: 48\r\n" +
+			"\r\n" +
+			"name=Jane+Doe&city=Caf%C3%A9&empty=&flag&name=JD",
+	))
+	require.NoError(t, err)
+	require.NoError(t, r.ParseForm(DefaultMaxFormSize))
+	assert.Equal(t, Values{"name": {"Jane Doe", "JD"}, "city": {"Café"}, "empty": {""}, "flag": {""}}, r.PostForm)
+	assert.Equal(t, []string{"Jane Doe", "JD", "query"}, r.Form["name"])
+	assert.Equal(t, "2", r.Form.Get("page"))
+	assert.True(t, r.Form.Has("flag"))
+	assert.False(t, r.Form.Has("missing"))
+
+	// Test: The body is ignored for other content types and methods
+	r, err = RequestFromReader(strings.NewReader(
+		"POST /submit?a=1 HTTP/1.1\r\nHost: localhost:42069\r\nContent-Type: application/json\r\nContent-Length: 3\r\n\r\nb=2",
+	))
+	require.NoError(t, err)
+	require.NoError(t, r.ParseForm(DefaultMaxFormSize))
+	assert.Equal(t, Values{"a": {"1"}}, r.Form)
+	assert.Empty(t, r.PostForm)
+
+	r, err = RequestFromReader(strings.NewReader(
+		"GET /search?q=go+http&q=%2Ftcp#results HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+	))
+	require.NoError(t, err)
+	query, err := r.Query()
+	require.NoError(t, err)
+	assert.Equal(t, Values{"q": {"go http", "/tcp"}}, query)
+
+	// Test: Invalid percent-encoding
+	r, err = RequestFromReader(strings.NewReader(
+		"POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nContent-Type: application/x-www-form-urlencoded\r\nContent-Length: 9\r\n\r\nname=%zz1",
+	))
+	require.NoError(t, err)
+	err = r.ParseForm(DefaultMaxFormSize)
+	require.ErrorIs(t, err, invalidPercentEncodingError{"%zz1"})
+	assert.Equal(t, 400, StatusCode(err))
+
+	_, err = ParseQuery("a=%4")
+	require.ErrorIs(t, err, invalidPercentEncodingError{"%4"})
+
+	// Test: Body larger than the limit
+	r, err = RequestFromReader(strings.NewReader(
+		"POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nContent-Type: application/x-www-form-urlencoded\r\nContent-Length: 11\r\n\r\nname=abcdef",
+	))
+	require.NoError(t, err)
+	err = r.ParseForm(10)
+	require.ErrorIs(t, err, formTooLargeError{10})
+	assert.Equal(t, 400, StatusCode(err))
+
+	// Test: Too many fields
+	_, err = ParseQuery(strings.Repeat("a=1&", maxFormFields+1))
+	require.ErrorIs(t, err, tooManyFormFieldsError{maxFormFields})
+	assert.Equal(t, 400, StatusCode(fmt.Errorf("wrapped: %w", err)))
+}
+
+func multipartRequest(boundary, body string) string {
+	return "POST /upload HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Type: multipart/form-data; boundary=" + boundary + "\r\n" +
+		"Content-Length: " + strconv.Itoa(len(body)) + "\r\n" +
+		"\r\n" +
+		body
+}
+
+func TestMultipartReader(t *testing.T) {
+	body := "preamble to be ignored\r\n" +
+		"--XyZ\r\n" +
+		"Content-Disposition: form-data; name=\"title\"\r\n" +
+		"\r\n" +
+		"Hello\r\n--not the boundary\r\n" +
+		"--XyZ  \r\n" +
+		"Content-Disposition: form-data; name=\"upload\"; filename=\"../../etc/notes.txt\"\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		strings.Repeat("data", bufferSize) + "\r\n" +
+		"--XyZ--\r\n" +
+		"epilogue to be ignored"
+
+	r, err := RequestFromReader(strings.NewReader(multipartRequest("XyZ", body)))
+	require.NoError(t, err)
+
+	reader, err := r.MultipartReader()
+	require.NoError(t, err)
+
+	// Test: Form value part
+	part, err := reader.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "title", part.FormName())
+	assert.Empty(t, part.FileName())
+
+	content, err := io.ReadAll(part)
+	require.NoError(t, err)
+	assert.Equal(t, "Hello\r\n--not the boundary", string(content))
+
+	// Test: File part larger than the buffer with a directory in the file name
+	part, err = reader.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "upload", part.FormName())
+	assert.Equal(t, "notes.txt", part.FileName())
+	assert.Equal(t, "text/plain", part.Headers.Get("Content-Type"))
+
+	content, err = io.ReadAll(part)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("data", bufferSize), string(content))
+
+	// Test: No more parts
+	_, err = reader.NextPart()
+	require.ErrorIs(t, err, io.EOF)
+
+	// Test: Unread parts are skipped
+	r, err = RequestFromReader(strings.NewReader(multipartRequest("XyZ", body)))
+	require.NoError(t, err)
+
+	reader, err = r.MultipartReader()
+	require.NoError(t, err)
+
+	for _, want := range []string{"title", "upload"} {
+		part, err = reader.NextPart()
+		require.NoError(t, err)
+		assert.Equal(t, want, part.FormName())
+	}
+
+	_, err = reader.NextPart()
+	require.ErrorIs(t, err, io.EOF)
+
+	// Test: Missing final boundary delimiter
+	r, err = RequestFromReader(strings.NewReader(multipartRequest("XyZ", "--XyZ\r\n\r\ntruncated")))
+	require.NoError(t, err)
+
+	reader, err = r.MultipartReader()
+	require.NoError(t, err)
+
+	part, err = reader.NextPart()
+	require.NoError(t, err)
+
+	_, err = io.ReadAll(part)
+	require.ErrorIs(t, err, invalidMultipartError{"the body ended before the final boundary delimiter"})
+	assert.Equal(t, 400, StatusCode(err))
+
+	// Test: Not a multipart body
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Type: text/plain\r\n\r\n"))
+	require.NoError(t, err)
+
+	_, err = r.MultipartReader()
+	require.ErrorIs(t, err, notMultipartError{"text/plain"})
+	assert.Equal(t, 415, StatusCode(err))
+}
+
+func TestParseMultipartForm(t *testing.T) {
+	body := "--boundary\r\n" +
+		"Content-Disposition: form-data; name=\"name\"\r\n" +
+		"\r\n" +
+		"Jane\r\n" +
+		"--boundary\r\n" +
+		"Content-Disposition: form-data; name=\"small\"; filename=\"small.txt\"\r\n" +
+		"\r\n" +
+		"small file\r\n" +
+		"--boundary\r\n" +
+		"Content-Disposition: form-data; name=\"large\"; filename=\"large.bin\"\r\n" +
+		"Content-Type: application/octet-stream\r\n" +
+		"\r\n" +
+		strings.Repeat("x", 100) + "\r\n" +
+		"--boundary--"
+
+	limits := MultipartLimits{
+		MaxPartSize:  1000,
+		MaxTotalSize: 1000,
+		MaxParts:     10,
+	}
+
+	// Test: Values and files
+	r, err := RequestFromReader(strings.NewReader(multipartRequest("boundary", body)))
+	require.NoError(t, err)
+	require.NoError(t, r.ParseMultipartForm(limits))
+
+	form := r.MultipartForm
+	assert.Equal(t, Values{"name": {"Jane"}}, form.Value)
+	require.Len(t, form.File["small"], 1)
+	require.Len(t, form.File["large"], 1)
+
+	small := form.File["small"][0]
+	assert.Equal(t, "small.txt", small.Filename)
+	assert.Equal(t, int64(10), small.Size)
+
+	large := form.File["large"][0]
+	assert.Equal(t, "large.bin", large.Filename)
+	assert.Equal(t, int64(100), large.Size)
+
+	for file, want := range map[*FileHeader]string{small: "small file", large: strings.Repeat("x", 100)} {
+		opened, err := file.Open()
+		require.NoError(t, err)
+
+		content, err := io.ReadAll(opened)
+		require.NoError(t, err)
+		require.NoError(t, opened.Close())
+		assert.Equal(t, want, string(content))
+	}
+
+	// Test: Part larger than the limit
+	r, err = RequestFromReader(strings.NewReader(multipartRequest("boundary", body)))
+	require.NoError(t, err)
+
+	limits.MaxPartSize = 99
+	err = r.ParseMultipartForm(limits)
+	require.ErrorIs(t, err, multipartTooLargeError{"a part exceeds the limit of 99 bytes"})
+	assert.Equal(t, 413, StatusCode(err))
+
+	// Test: Parts larger than the total limit
+	r, err = RequestFromReader(strings.NewReader(multipartRequest("boundary", body)))
+	require.NoError(t, err)
+
+	limits.MaxPartSize = 1000
+	limits.MaxTotalSize = 110
+	err = r.ParseMultipartForm(limits)
+	require.ErrorIs(t, err, multipartTooLargeError{"a part exceeds the limit of 96 bytes"})
+
+	// Test: Too many parts
+	r, err = RequestFromReader(strings.NewReader(multipartRequest("boundary", body)))
+	require.NoError(t, err)
+
+	limits.MaxTotalSize = 1000
+	limits.MaxParts = 2
+	err = r.ParseMultipartForm(limits)
+	require.ErrorIs(t, err, multipartTooLargeError{"the body has more than 2 parts"})
+}
+
+func TestCookies(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\nCookie: session=abc123; theme=dark\r\n\r\n"))
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Len(t, r.Cookies(), 2)
+
+	cookie, ok := r.Cookie("theme")
+	assert.True(t, ok)
+	assert.Equal(t, "dark", cookie.Value)
+
+	_, ok = r.Cookie("missing")
+	assert.False(t, ok)
+}
+
+func TestDecodeBody(t *testing.T) {
+	const text = "The quick brown fox jumps over the lazy dog."
+
+	gzipped := new(bytes.Buffer)
+	gzipWriter := gzip.NewWriter(gzipped)
+	_, _ = gzipWriter.Write([]byte(text))
+	_ = gzipWriter.Close()
+
+	deflated := new(bytes.Buffer)
+	zlibWriter := zlib.NewWriter(deflated)
+	_, _ = zlibWriter.Write([]byte(text))
+	_ = zlibWriter.Close()
+
+	rawDeflated := new(bytes.Buffer)
+	flateWriter, _ := flate.NewWriter(rawDeflated, flate.DefaultCompression)
+	_, _ = flateWriter.Write([]byte(text))
+	_ = flateWriter.Close()
+
+	// deflate is applied first and gzip second.
+	both := new(bytes.Buffer)
+	gzipWriter = gzip.NewWriter(both)
+	_, _ = gzipWriter.Write(deflated.Bytes())
+	_ = gzipWriter.Close()
+
+	newRequest := func(contentEncoding string, body []byte) *Request {
+		r, err := RequestFromReader(strings.NewReader(
+			"POST /upload HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"Content-Encoding: " + contentEncoding + "\r\n" +
+				"Content-Length: " + strconv.Itoa(len(body)) + "\r\n" +
+				"\r\n" +
+				string(body),
+		))
+		require.NoError(t, err)
+		require.NotNil(t, r)
+
+		return r
+	}
+
+	// Test: Supported content codings
+	for _, tc := range []struct {
+		contentEncoding string
+		body            []byte
+	}{
+		{"gzip", gzipped.Bytes()},
+		{"deflate", deflated.Bytes()},
+		{"deflate", rawDeflated.Bytes()},
+		{"deflate, gzip", both.Bytes()},
+		{"identity", []byte(text)},
+	} {
+		r := newRequest(tc.contentEncoding, tc.body)
+		require.NoError(t, r.DecodeBody(DefaultMaxDecodedBodySize), "Content-Encoding: %s", tc.contentEncoding)
+		assert.Equal(t, text, string(r.Body))
+		assert.Empty(t, r.Headers.Get("content-encoding"))
+		assert.Equal(t, strconv.Itoa(len(text)), r.Headers.Get("content-length"))
+	}
+
+	// Test: Unsupported content coding
+	r := newRequest("br", []byte(text))
+	err := r.DecodeBody(DefaultMaxDecodedBodySize)
+	require.Error(t, err)
+	assert.Equal(t, 415, StatusCode(err))
+	assert.Equal(t, headers.Headers{"Accept-Encoding": "gzip, deflate"}, ErrorHeaders(err))
+
+	// Test: Invalid gzip body
+	r = newRequest("gzip", []byte(text))
+	err = r.DecodeBody(DefaultMaxDecodedBodySize)
+	require.Error(t, err)
+	assert.Equal(t, 400, StatusCode(err))
+	assert.Nil(t, ErrorHeaders(err))
+
+	// Test: The decoded body exceeds the limit
+	bomb := new(bytes.Buffer)
+	gzipWriter = gzip.NewWriter(bomb)
+	_, _ = gzipWriter.Write(make([]byte, 1<<20))
+	_ = gzipWriter.Close()
+
+	r = newRequest("gzip", bomb.Bytes())
+	err = r.DecodeBody(1 << 16)
+	require.Error(t, err)
+	assert.Equal(t, 413, StatusCode(err))
+}
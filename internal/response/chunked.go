@@ -3,16 +3,21 @@ package response
 import (
 	"errors"
 	"fmt"
+	"strconv"
 )
 
+// WriteChunkedBody writes the data as a single chunk of a chunked body. Nothing is
+// written if the data is empty as an empty chunk marks the end of the body.
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 	if w.state != writerStateBody {
 		return 0, errors.New("the response writer is not in the correct state to write the chunked body")
 	}
 
-	data := string(p) + "\r\n"
+	if w.encoder != nil {
+		return w.encoder.Write(p)
+	}
 
-	return w.writer.Write([]byte(data))
+	return w.writeChunk(p)
 }
 
 func (w *Writer) WriteChunkedBodyDone() (int, error) {
@@ -20,6 +25,17 @@ func (w *Writer) WriteChunkedBodyDone() (int, error) {
 		return 0, errors.New("the response writer is not in the correct state to write the chunked body")
 	}
 
+	if err := w.closeEncoder(); err != nil {
+		return 0, err
+	}
+
+	// An HTTP/2 stream ends the body with the trailers (or an empty set of them).
+	if w.stream != nil {
+		w.state = writerStateTrailers
+
+		return 0, nil
+	}
+
 	const chunkedBodyDone string = "0\r\n"
 
 	n, err := w.writer.Write([]byte(chunkedBodyDone))
@@ -31,3 +47,41 @@ func (w *Writer) WriteChunkedBodyDone() (int, error) {
 
 	return n, nil
 }
+
+// writeChunk writes the chunk size line, the data and the CRLF that ends the chunk.
+// The data is written as is to an HTTP/2 stream as it has its own framing.
+func (w *Writer) writeChunk(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if w.stream != nil {
+		return w.stream.Write(p)
+	}
+
+	sizeLine := strconv.FormatInt(int64(len(p)), 16) + "\r\n"
+
+	if _, err := w.writer.Write([]byte(sizeLine)); err != nil {
+		return 0, fmt.Errorf("error writing the chunk size: %w", err)
+	}
+
+	n, err := w.writer.Write(p)
+	if err != nil {
+		return n, fmt.Errorf("error writing the chunk: %w", err)
+	}
+
+	if _, err := w.writer.Write([]byte("\r\n")); err != nil {
+		return n, fmt.Errorf("error writing the end of the chunk: %w", err)
+	}
+
+	return n, nil
+}
+
+// chunkWriter writes each call to Write as a chunk of the response body.
+type chunkWriter struct {
+	w *Writer
+}
+
+func (c chunkWriter) Write(p []byte) (int, error) {
+	return c.w.writeChunk(p)
+}
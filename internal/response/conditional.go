@@ -0,0 +1,174 @@
+package response
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"http-from-tcp/internal/headers"
+	"http-from-tcp/internal/request"
+)
+
+// NewETag returns the entity tag (RFC 9110, section 8.8.3) for the opaque value.
+// The value must not contain any double quotes.
+func NewETag(value string, weak bool) string {
+	etag := `"` + value + `"`
+	if weak {
+		return "W/" + etag
+	}
+
+	return etag
+}
+
+// ContentETag returns a strong entity tag for the content. The tag is derived from
+// the SHA-256 hash of the content so it changes whenever the content changes.
+func ContentETag(content []byte) string {
+	sum := sha256.Sum256(content)
+
+	return NewETag(base64.RawURLEncoding.EncodeToString(sum[:16]), false)
+}
+
+// SetETag sets the ETag header.
+func SetETag(h headers.Headers, etag string) {
+	h[HeaderETag] = etag
+}
+
+// SetLastModified sets the Last-Modified header. The header is not set if the time
+// is the zero time.
+func SetLastModified(h headers.Headers, lastModified time.Time) {
+	if lastModified.IsZero() {
+		return
+	}
+
+	h[HeaderLastModified] = lastModified.UTC().Format(headers.TimeFormat)
+}
+
+// CheckPreconditions evaluates the conditional headers of the request against the
+// current entity tag and modification time of the resource in the order from RFC 9110,
+// section 13.2.2. exists is false if the resource does not have a current
+// representation (e.g. a PUT request that creates it). Either validator can be empty
+// (or the zero time) if the resource does not have it. It returns true if the request should be handled normally or false
+// with either StatusCodeNotModified or StatusCodePreconditionFailed otherwise.
+func CheckPreconditions(req *request.Request, exists bool, etag string, lastModified time.Time) (StatusCode, bool) {
+	lastModified = lastModified.UTC().Truncate(time.Second)
+
+	// Step 1 and 2: If-Match and, only without If-Match, If-Unmodified-Since.
+	if ifMatch := req.Headers.Get("if-match"); ifMatch != "" {
+		if !ETagsMatch(ifMatch, etag, exists, true) {
+			return StatusCodePreconditionFailed, false
+		}
+	} else if ifUnmodifiedSince := req.Headers.Get("if-unmodified-since"); ifUnmodifiedSince != "" {
+		date, err := headers.ParseTime(ifUnmodifiedSince)
+		if err == nil && !lastModified.IsZero() && lastModified.After(date) {
+			return StatusCodePreconditionFailed, false
+		}
+	}
+
+	safe := req.RequestLine.Method == "GET" || req.RequestLine.Method == "HEAD"
+
+	// Step 3 and 4: If-None-Match and, only without If-None-Match, If-Modified-Since.
+	if ifNoneMatch := req.Headers.Get("if-none-match"); ifNoneMatch != "" {
+		if ETagsMatch(ifNoneMatch, etag, exists, false) {
+			if safe {
+				return StatusCodeNotModified, false
+			}
+
+			return StatusCodePreconditionFailed, false
+		}
+	} else if ifModifiedSince := req.Headers.Get("if-modified-since"); ifModifiedSince != "" && safe {
+		date, err := headers.ParseTime(ifModifiedSince)
+		if err == nil && !lastModified.IsZero() && !lastModified.After(date) {
+			return StatusCodeNotModified, false
+		}
+	}
+
+	return StatusCodeOK, true
+}
+
+// WriteNotModified writes a complete 304 Not Modified response with the validators
+// of the resource.
+func (w *Writer) WriteNotModified(etag string, lastModified time.Time) error {
+	if err := w.WriteStatusLine(StatusCodeNotModified); err != nil {
+		return err
+	}
+
+	h := headers.NewHeaders()
+
+	if etag != "" {
+		SetETag(h, etag)
+	}
+
+	SetLastModified(h, lastModified)
+
+	return w.WriteHeaders(h)
+}
+
+// ETagsMatch returns true if the entity tag matches one of the tags in the list from
+// the If-Match, If-None-Match or If-Range header. The list "*" matches whenever the
+// resource exists, even if it does not have an entity tag (RFC 9110, sections 13.1.1
+// and 13.1.2). The strong comparison is used if strong is true and the weak comparison
+// is used otherwise (RFC 9110, section 8.8.3.2).
+func ETagsMatch(list, etag string, exists, strong bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return exists
+	}
+
+	if etag == "" {
+		return false
+	}
+
+	for _, candidate := range parseETags(list) {
+		if compareETags(candidate, etag, strong) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func compareETags(a, b string, strong bool) bool {
+	aOpaque, aWeak := strings.CutPrefix(a, "W/")
+	bOpaque, bWeak := strings.CutPrefix(b, "W/")
+
+	if strong && (aWeak || bWeak) {
+		return false
+	}
+
+	return aOpaque == bOpaque
+}
+
+// parseETags returns the entity tags from a comma separated list. The list is scanned
+// rather than split as an entity tag can contain a comma.
+func parseETags(list string) []string {
+	etags := make([]string, 0, 1)
+
+	for {
+		list = strings.TrimLeft(list, " \t,")
+		if list == "" {
+			return etags
+		}
+
+		start := 0
+		if strings.HasPrefix(list, "W/") {
+			start = 2
+		}
+
+		if len(list) <= start || list[start] != '"' {
+			// Skip the invalid entry.
+			_, list, _ = strings.Cut(list, ",")
+
+			continue
+		}
+
+		end := strings.IndexByte(list[start+1:], '"')
+		if end == -1 {
+			return etags
+		}
+
+		end += start + 2
+
+		etags = append(etags, list[:end])
+		list = list[end:]
+	}
+}
@@ -1,6 +1,7 @@
 package response
 
 import (
+	"fmt"
 	"strconv"
 
 	"http-from-tcp/internal/headers"
@@ -9,9 +10,20 @@ import (
 const (
 	HeaderContentLength    = "Content-Length"
 	HeaderContentType      = "Content-Type"
+	HeaderContentEncoding  = "Content-Encoding"
 	HeaderConnection       = "Connection"
 	HeaderTransferEncoding = "Transfer-Encoding"
 	HeaderTrailer          = "Trailer"
+	HeaderVary             = "Vary"
+	HeaderSetCookie        = "Set-Cookie"
+	HeaderLocation         = "Location"
+	HeaderAllow            = "Allow"
+	HeaderLastModified     = "Last-Modified"
+	HeaderAcceptRanges     = "Accept-Ranges"
+	HeaderContentRange     = "Content-Range"
+	HeaderETag             = "ETag"
+	HeaderCacheControl     = "Cache-Control"
+	HeaderUpgrade          = "Upgrade"
 )
 
 // GetDefaultHeaders returns the default response headers.
@@ -20,7 +32,24 @@ func GetDefaultHeaders(contentLen int) headers.Headers {
 
 	headers[HeaderContentLength] = strconv.Itoa(contentLen)
 	headers[HeaderContentType] = "text/plain"
-	headers[HeaderConnection] = "close"
 
 	return headers
 }
+
+// SetContentType sets the Content-Type header to the media type with the charset
+// parameter. The charset parameter is omitted if charset is empty.
+func SetContentType(h headers.Headers, mediaType, charset string) error {
+	params := make(map[string]string)
+	if charset != "" {
+		params["charset"] = charset
+	}
+
+	contentType, err := headers.NewMediaType(mediaType, params)
+	if err != nil {
+		return fmt.Errorf("error creating the media type: %w", err)
+	}
+
+	h[HeaderContentType] = contentType.String()
+
+	return nil
+}
@@ -0,0 +1,500 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"http-from-tcp/internal/headers"
+	"http-from-tcp/internal/request"
+)
+
+const (
+	crlf string = "\r\n"
+
+	// maxHeaderBytes is the maximum size of the status line and the header section,
+	// and of the trailers.
+	maxHeaderBytes int = 1 << 20
+)
+
+var (
+	// errIncompleteResponse is returned when the reader ends before the end of the
+	// response. It wraps io.ErrUnexpectedEOF so that the body readers report an
+	// early end like the other readers.
+	errIncompleteResponse = fmt.Errorf("the response is incomplete: %w", io.ErrUnexpectedEOF)
+
+	// errMalformedResponse is wrapped by the errors of the responses that cannot be
+	// parsed.
+	errMalformedResponse = errors.New("malformed response")
+)
+
+// StatusLine is the first line of a response.
+type StatusLine struct {
+	// HTTPVersion is the version without the HTTP/ prefix (e.g. 1.1).
+	HTTPVersion string
+	StatusCode  StatusCode
+
+	// Reason is the reason phrase, which can be empty.
+	Reason string
+}
+
+// Response is a response parsed by ResponseFromReader.
+type Response struct {
+	StatusLine StatusLine
+
+	// Headers have lower case keys and the values of the repeated headers are joined
+	// into a comma separated list, like the headers of a request.
+	Headers headers.Headers
+
+	// SetCookies are the values of the Set-Cookie headers in the order that they
+	// were received. They are not included in Headers as they cannot be joined into
+	// a list.
+	SetCookies []string
+
+	Body     []byte
+	Trailers headers.Headers
+
+	// Chunked is true if the body was sent with the chunked Transfer-Encoding.
+	Chunked bool
+
+	// Close is true if the connection cannot be used for another request after the
+	// response, either because the server asked for it to be closed or because the
+	// body ends when the connection is closed.
+	Close bool
+}
+
+// ResponseFromReader reads and parses a single response from the reader. The body is
+// framed by the Content-Length header, the chunked Transfer-Encoding (followed by the
+// trailers) or, if there is neither, by the end of the reader. The 1xx, 204 No Content
+// and 304 Not Modified responses never have a body. Any data read beyond the end of
+// the response is discarded.
+func ResponseFromReader(reader io.Reader) (*Response, error) {
+	return readResponse(reader, false)
+}
+
+// HeadResponseFromReader reads and parses a single response to a HEAD request, which
+// never has a body even if its headers describe one.
+func HeadResponseFromReader(reader io.Reader) (*Response, error) {
+	return readResponse(reader, true)
+}
+
+// ParseStatusLine parses a status line without the CRLF at the end of the line. Both
+// HTTP/1.1 and HTTP/1.0 are accepted.
+func ParseStatusLine(line []byte) (StatusLine, error) {
+	version, rest, ok := bytes.Cut(line, []byte(" "))
+	if !ok || (string(version) != "HTTP/1.1" && string(version) != "HTTP/1.0") {
+		return StatusLine{}, fmt.Errorf("%w: invalid status line %q", errMalformedResponse, line)
+	}
+
+	code, reason, _ := bytes.Cut(rest, []byte(" "))
+	if len(code) != 3 || code[0] < '1' || code[0] > '5' {
+		return StatusLine{}, fmt.Errorf("%w: invalid status code %q", errMalformedResponse, code)
+	}
+
+	statusCode := 0
+
+	for _, digit := range code {
+		if digit < '0' || digit > '9' {
+			return StatusLine{}, fmt.Errorf("%w: invalid status code %q", errMalformedResponse, code)
+		}
+
+		statusCode = statusCode*10 + int(digit-'0')
+	}
+
+	return StatusLine{
+		HTTPVersion: strings.TrimPrefix(string(version), "HTTP/"),
+		StatusCode:  StatusCode(statusCode),
+		Reason:      string(reason),
+	}, nil
+}
+
+// Framing is the way that the end of the body of a response is found (RFC 9112,
+// section 6.3).
+type Framing int
+
+const (
+	// FramingNone is used for the responses without a body.
+	FramingNone Framing = iota
+
+	// FramingLength is used for the bodies with a Content-Length.
+	FramingLength
+
+	// FramingChunked is used for the bodies with the chunked Transfer-Encoding.
+	FramingChunked
+
+	// FramingClose is used for the bodies that end when the connection is closed.
+	FramingClose
+)
+
+// BodyFraming returns the framing of the body of a response with the status code and
+// the headers, and its Content-Length or -1 if it does not have one. head is true for
+// the response to a HEAD request, which never has a body. The 1xx, 204 No Content and
+// 304 Not Modified responses never have a body either.
+func BodyFraming(statusCode StatusCode, h headers.Headers, head bool) (Framing, int64, error) {
+	contentLength := int64(-1)
+
+	if value, ok := h["content-length"]; ok {
+		length, err := request.ParseContentLength(value)
+		if err != nil {
+			return FramingNone, -1, fmt.Errorf("%w: %w", errMalformedResponse, err)
+		}
+
+		contentLength = int64(length)
+	}
+
+	transferEncoding, hasTransferEncoding := h["transfer-encoding"]
+
+	// The Transfer-Encoding overrides the Content-Length.
+	if hasTransferEncoding {
+		contentLength = -1
+	}
+
+	switch {
+	case head || statusCode < 200 || statusCode == 204 || statusCode == StatusCodeNotModified:
+		return FramingNone, contentLength, nil
+	case hasTransferEncoding:
+		// A body is only chunked if chunked is the last coding; otherwise it ends
+		// when the connection is closed.
+		codings := strings.Split(transferEncoding, ",")
+		if strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked") {
+			return FramingChunked, -1, nil
+		}
+
+		return FramingClose, -1, nil
+	case contentLength == 0:
+		return FramingNone, 0, nil
+	case contentLength > 0:
+		return FramingLength, contentLength, nil
+	default:
+		return FramingClose, -1, nil
+	}
+}
+
+// Reader reads responses from a connection one part at a time: the status line, the
+// header section and then the body, which is read with Read or with a ChunkedReader
+// depending on its framing. ResponseFromReader and the client both parse the
+// responses with a Reader.
+type Reader struct {
+	reader *bufio.Reader
+
+	// headerBytes is the number of bytes of the current header section that have
+	// been read.
+	headerBytes int
+}
+
+// NewReader returns a Reader that reads from the reader. A *bufio.Reader is used
+// as is so that the caller can share its buffer.
+func NewReader(reader io.Reader) *Reader {
+	return &Reader{
+		reader:      bufio.NewReader(reader),
+		headerBytes: 0,
+	}
+}
+
+// Buffered returns the number of bytes that have been read from the connection but
+// not consumed.
+func (r *Reader) Buffered() int {
+	return r.reader.Buffered()
+}
+
+// Read reads the data of a body from the buffer or the connection.
+func (r *Reader) Read(p []byte) (int, error) {
+	return r.reader.Read(p)
+}
+
+// ReadStatusLine reads and parses the status line of the next response. io.EOF is
+// returned if the reader ended before any data of the response was received.
+func (r *Reader) ReadStatusLine() (StatusLine, error) {
+	r.headerBytes = 0
+
+	line, err := r.readLine()
+	if err != nil {
+		return StatusLine{}, err
+	}
+
+	return ParseStatusLine(line[:len(line)-len(crlf)])
+}
+
+// ReadHeaders reads a header section up to and including the empty line at its end.
+// The values of the Set-Cookie headers are returned separately as they cannot be
+// joined into a list. The header section and the status line before it must not be
+// larger than 1 MiB.
+func (r *Reader) ReadHeaders() (headers.Headers, []string, error) {
+	parsed := headers.NewHeaders()
+
+	var cookies []string
+
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, nil, incompleteError(err)
+		}
+
+		if len(line) == len(crlf) {
+			return parsed, cookies, nil
+		}
+
+		if line[0] == ' ' || line[0] == '\t' {
+			return nil, nil, fmt.Errorf("%w: obsolete line folding", errMalformedResponse)
+		}
+
+		if _, _, err := parsed.Parse(line); err != nil {
+			return nil, nil, fmt.Errorf("%w: %w", errMalformedResponse, err)
+		}
+
+		if cookie, ok := parsed["set-cookie"]; ok {
+			cookies = append(cookies, cookie)
+			delete(parsed, "set-cookie")
+		}
+	}
+}
+
+// readLine reads the next line up to and including the CRLF. The line points into the
+// buffer where possible so it is only valid until the next read. io.EOF is returned if
+// the reader ended before the start of the line.
+func (r *Reader) readLine() ([]byte, error) {
+	line, err := r.reader.ReadSlice('\n')
+
+	if errors.Is(err, bufio.ErrBufferFull) {
+		// The line is longer than the buffer so it is copied to a new slice.
+		longLine := append([]byte(nil), line...)
+
+		for errors.Is(err, bufio.ErrBufferFull) && r.headerBytes+len(longLine) <= maxHeaderBytes {
+			line, err = r.reader.ReadSlice('\n')
+			longLine = append(longLine, line...)
+		}
+
+		line = longLine
+	}
+
+	r.headerBytes += len(line)
+
+	if r.headerBytes > maxHeaderBytes {
+		return nil, fmt.Errorf("%w: the header section is larger than %d bytes", errMalformedResponse, maxHeaderBytes)
+	}
+
+	if err != nil {
+		if len(line) > 0 {
+			return nil, incompleteError(err)
+		}
+
+		return nil, err
+	}
+
+	if len(line) < len(crlf) || line[len(line)-len(crlf)] != '\r' {
+		return nil, fmt.Errorf("%w: the line does not end with a CRLF", errMalformedResponse)
+	}
+
+	return line, nil
+}
+
+// ChunkedReader reads a chunked body from a Reader. Read returns io.EOF once the last
+// chunk and the trailers have been read.
+type ChunkedReader struct {
+	reader *Reader
+
+	// remaining is the number of bytes left in the current chunk.
+	remaining int64
+
+	// inChunk is true once the first chunk size line has been read.
+	inChunk bool
+
+	trailers headers.Headers
+
+	// err is returned by the reads once the body has ended or failed.
+	err error
+}
+
+// NewChunkedReader returns a ChunkedReader for the body that follows the headers that
+// have been read by the reader.
+func NewChunkedReader(reader *Reader) *ChunkedReader {
+	return &ChunkedReader{
+		reader:    reader,
+		remaining: 0,
+		inChunk:   false,
+		trailers:  nil,
+		err:       nil,
+	}
+}
+
+// Trailers returns the trailers that follow the last chunk. They are only available
+// once Read has returned io.EOF.
+func (c *ChunkedReader) Trailers() headers.Headers {
+	return c.trailers
+}
+
+func (c *ChunkedReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	if c.remaining == 0 {
+		if err := c.nextChunk(); err != nil {
+			c.err = err
+
+			return 0, err
+		}
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+
+	n, err := c.reader.reader.Read(p)
+	c.remaining -= int64(n)
+
+	if err != nil {
+		c.err = incompleteError(err)
+
+		return n, c.err
+	}
+
+	return n, nil
+}
+
+// nextChunk reads the end of the current chunk and the size of the next chunk. The
+// trailers are read after the last chunk, in which case io.EOF is returned.
+func (c *ChunkedReader) nextChunk() error {
+	r := c.reader
+
+	if c.inChunk {
+		end, err := r.reader.Peek(len(crlf))
+		if err != nil {
+			return incompleteError(err)
+		}
+
+		if string(end) != crlf {
+			return fmt.Errorf("%w: the chunk does not end with a CRLF", errMalformedResponse)
+		}
+
+		if _, err := r.reader.Discard(len(crlf)); err != nil {
+			return incompleteError(err)
+		}
+	}
+
+	// The chunk size lines do not count towards the size of the header section.
+	r.headerBytes = 0
+
+	line, err := r.readLine()
+	if err != nil {
+		return incompleteError(err)
+	}
+
+	size, err := request.ParseChunkSize(line[:len(line)-len(crlf)])
+	if err != nil {
+		return fmt.Errorf("%w: %w", errMalformedResponse, err)
+	}
+
+	c.inChunk = true
+
+	if size > 0 {
+		c.remaining = int64(size)
+
+		return nil
+	}
+
+	r.headerBytes = 0
+
+	trailers, _, err := r.ReadHeaders()
+	if err != nil {
+		return fmt.Errorf("error reading the trailers: %w", err)
+	}
+
+	c.trailers = trailers
+
+	return io.EOF
+}
+
+func readResponse(reader io.Reader, head bool) (*Response, error) {
+	r := NewReader(reader)
+
+	statusLine, err := r.ReadStatusLine()
+	if err != nil {
+		return nil, fmt.Errorf("error reading the status line: %w", incompleteError(err))
+	}
+
+	resp := &Response{
+		StatusLine: statusLine,
+		Body:       make([]byte, 0),
+		Trailers:   headers.NewHeaders(),
+	}
+
+	resp.Headers, resp.SetCookies, err = r.ReadHeaders()
+	if err != nil {
+		return nil, fmt.Errorf("error reading the headers: %w", err)
+	}
+
+	resp.Close = resp.Headers.HasToken("connection", "close") ||
+		(statusLine.HTTPVersion == "1.0" && !resp.Headers.HasToken("connection", "keep-alive"))
+
+	if err := r.readBody(resp, head); err != nil {
+		return nil, fmt.Errorf("error reading the body: %w", err)
+	}
+
+	return resp, nil
+}
+
+// readBody reads the whole body with the framing of the response.
+func (r *Reader) readBody(resp *Response, head bool) error {
+	framing, contentLength, err := BodyFraming(resp.StatusLine.StatusCode, resp.Headers, head)
+	if err != nil {
+		return err
+	}
+
+	switch framing {
+	case FramingLength:
+		resp.Body, err = readData(resp.Body, io.LimitReader(r, contentLength), contentLength)
+
+		return err
+	case FramingChunked:
+		resp.Chunked = true
+
+		chunked := NewChunkedReader(r)
+
+		resp.Body, err = readData(resp.Body, chunked, -1)
+		if err != nil {
+			return err
+		}
+
+		resp.Trailers = chunked.Trailers()
+	case FramingClose:
+		resp.Close = true
+
+		resp.Body, err = readData(resp.Body, r, -1)
+
+		return err
+	}
+
+	return nil
+}
+
+// readData reads the data until the end of the reader and appends it to data. The
+// data grows as it arrives so that a large length does not allocate a large buffer
+// before the data is received. size is the number of bytes expected, or -1 if any
+// number of bytes is accepted.
+func readData(data []byte, reader io.Reader, size int64) ([]byte, error) {
+	buf := bytes.NewBuffer(data)
+
+	n, err := buf.ReadFrom(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if size >= 0 && n < size {
+		return nil, errIncompleteResponse
+	}
+
+	return buf.Bytes(), nil
+}
+
+// incompleteError returns errIncompleteResponse if the reader ended too early.
+func incompleteError(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return errIncompleteResponse
+	}
+
+	return err
+}
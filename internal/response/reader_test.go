@@ -0,0 +1,380 @@
+package response
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"http-from-tcp/internal/headers"
+)
+
+func TestParseStatusLine(t *testing.T) {
+	testCases := []struct {
+		line    string
+		want    StatusLine
+		wantErr bool
+	}{
+		{line: "HTTP/1.1 200 OK", want: StatusLine{HTTPVersion: "1.1", StatusCode: StatusCodeOK, Reason: "OK"}},
+		{line: "HTTP/1.0 404 Not Found", want: StatusLine{HTTPVersion: "1.0", StatusCode: StatusCodeNotFound, Reason: "Not Found"}},
+		{line: "HTTP/1.1 299 ", want: StatusLine{HTTPVersion: "1.1", StatusCode: 299}},
+		{line: "HTTP/1.1 299", want: StatusLine{HTTPVersion: "1.1", StatusCode: 299}},
+		{line: "HTTP/2 200 OK", wantErr: true},
+		{line: "HTTP/1.1 20x OK", wantErr: true},
+		{line: "HTTP/1.1 2000 OK", wantErr: true},
+		{line: "HTTP/1.1 600 Unknown", wantErr: true},
+		{line: "HTTP/1.1", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.line, func(t *testing.T) {
+			statusLine, err := ParseStatusLine([]byte(tc.line))
+			if tc.wantErr {
+				require.Error(t, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, statusLine)
+		})
+	}
+}
+
+// gzipEncoder compresses the body of a response.
+func gzipEncoder(h headers.Headers, dst io.Writer) io.WriteCloser {
+	h[HeaderContentEncoding] = "gzip"
+
+	return gzip.NewWriter(dst)
+}
+
+func TestResponseFromReader(t *testing.T) {
+	lastModified := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+
+	testCases := []struct {
+		name  string
+		write func(w *Writer)
+		check func(t *testing.T, resp *Response)
+
+		// closeDelimited is true if the body ends with the reader.
+		closeDelimited bool
+	}{
+		{
+			name: "WriteError",
+			write: func(w *Writer) {
+				_ = w.WriteError(StatusCodeNotFound, "not found")
+			},
+			check: func(t *testing.T, resp *Response) {
+				assert.Equal(t, StatusLine{HTTPVersion: "1.1", StatusCode: StatusCodeNotFound, Reason: "Not Found"}, resp.StatusLine)
+				assert.Equal(t, "10", resp.Headers.Get("content-length"))
+				assert.Equal(t, "text/plain", resp.Headers.Get("content-type"))
+				assert.Equal(t, "not found\n", string(resp.Body))
+				assert.False(t, resp.Chunked)
+				assert.False(t, resp.Close)
+			},
+		},
+		{
+			name: "Cookies",
+			write: func(w *Writer) {
+				_ = w.AddCookie(headers.Cookie{Name: "session", Value: "abc", HttpOnly: true})
+				_ = w.AddSetCookie("theme=dark; Expires=Wed, 21 Oct 2015 07:28:00 GMT")
+				_ = w.WriteStatusLine(StatusCodeOK)
+				_ = w.WriteHeaders(GetDefaultHeaders(2))
+				_, _ = w.WriteBody([]byte("ok"))
+			},
+			check: func(t *testing.T, resp *Response) {
+				assert.Equal(t, []string{"session=abc; HttpOnly", "theme=dark; Expires=Wed, 21 Oct 2015 07:28:00 GMT"}, resp.SetCookies)
+				assert.NotContains(t, resp.Headers, "set-cookie")
+				assert.Equal(t, "ok", string(resp.Body))
+			},
+		},
+		{
+			name: "Chunked body with trailers",
+			write: func(w *Writer) {
+				_ = w.WriteStatusLine(StatusCodeOK)
+
+				h := headers.Headers{HeaderTransferEncoding: "chunked", HeaderTrailer: "X-Checksum, X-Length"}
+				_ = w.WriteHeaders(h)
+				_, _ = w.WriteChunkedBody([]byte("hello"))
+				_, _ = w.WriteChunkedBody([]byte(" world"))
+				_, _ = w.WriteChunkedBodyDone()
+
+				h["X-Checksum"] = "abc"
+				h["X-Length"] = "11"
+				_ = w.WriteTrailers(h)
+			},
+			check: func(t *testing.T, resp *Response) {
+				assert.True(t, resp.Chunked)
+				assert.Equal(t, "hello world", string(resp.Body))
+				assert.Equal(t, headers.Headers{"x-checksum": "abc", "x-length": "11"}, resp.Trailers)
+			},
+		},
+		{
+			name: "Chunked body without trailers",
+			write: func(w *Writer) {
+				_ = w.WriteStatusLine(StatusCodeOK)
+				_ = w.WriteHeaders(headers.Headers{HeaderTransferEncoding: "chunked"})
+				_, _ = w.ReadFrom(strings.NewReader("streamed"))
+				_, _ = w.WriteChunkedBodyDone()
+				_ = w.WriteTrailers(headers.NewHeaders())
+			},
+			check: func(t *testing.T, resp *Response) {
+				assert.True(t, resp.Chunked)
+				assert.Equal(t, "streamed", string(resp.Body))
+				assert.Empty(t, resp.Trailers)
+			},
+		},
+		{
+			name: "Encoded body",
+			write: func(w *Writer) {
+				w.SetEncoder(gzipEncoder)
+				_ = w.WriteStatusLine(StatusCodeOK)
+				_ = w.WriteHeaders(GetDefaultHeaders(len("compressed")))
+				_, _ = w.WriteBody([]byte("compressed"))
+				_ = w.Finish()
+			},
+			check: func(t *testing.T, resp *Response) {
+				assert.True(t, resp.Chunked)
+				assert.Equal(t, "gzip", resp.Headers.Get("content-encoding"))
+				assert.NotContains(t, resp.Headers, "content-length")
+
+				reader, err := gzip.NewReader(bytes.NewReader(resp.Body))
+				require.NoError(t, err)
+
+				body, err := io.ReadAll(reader)
+				require.NoError(t, err)
+				assert.Equal(t, "compressed", string(body))
+			},
+		},
+		{
+			name: "Body delimited by closing the connection",
+			write: func(w *Writer) {
+				_ = w.WriteStatusLine(StatusCodeOK)
+				_ = w.WriteHeaders(headers.Headers{HeaderContentType: "text/plain"})
+				_, _ = w.WriteBody([]byte("until the end"))
+			},
+			check: func(t *testing.T, resp *Response) {
+				assert.True(t, resp.Close)
+				assert.Equal(t, "until the end", string(resp.Body))
+			},
+			closeDelimited: true,
+		},
+		{
+			name: "Connection close",
+			write: func(w *Writer) {
+				_ = w.WriteStatusLine(StatusCodeOK)
+				_ = w.WriteHeaders(headers.Headers{HeaderContentLength: "2", HeaderConnection: "close"})
+				_, _ = w.WriteBody([]byte("ok"))
+			},
+			check: func(t *testing.T, resp *Response) {
+				assert.True(t, resp.Close)
+				assert.Equal(t, "ok", string(resp.Body))
+			},
+		},
+		{
+			name: "WriteNotModified",
+			write: func(w *Writer) {
+				_ = w.WriteNotModified(`"v1"`, lastModified)
+			},
+			check: func(t *testing.T, resp *Response) {
+				assert.Equal(t, StatusCodeNotModified, resp.StatusLine.StatusCode)
+				assert.Equal(t, `"v1"`, resp.Headers.Get("etag"))
+				assert.Equal(t, "Tue, 02 Jan 2024 03:04:05 GMT", resp.Headers.Get("last-modified"))
+				assert.Empty(t, resp.Body)
+				assert.False(t, resp.Close)
+			},
+		},
+		{
+			name: "No Content",
+			write: func(w *Writer) {
+				_ = w.WriteStatusLine(204)
+				_ = w.WriteHeaders(headers.NewHeaders())
+			},
+			check: func(t *testing.T, resp *Response) {
+				assert.Equal(t, StatusCode(204), resp.StatusLine.StatusCode)
+				assert.Empty(t, resp.StatusLine.Reason)
+				assert.Empty(t, resp.Body)
+				assert.False(t, resp.Close)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			tc.write(NewWriter(buf))
+
+			// The data after the response is not part of it.
+			raw := buf.String()
+			if !tc.closeDelimited {
+				buf.WriteString("HTTP/1.1 200 OK\r\n\r\n")
+			}
+
+			resp, err := ResponseFromReader(buf)
+			require.NoError(t, err, raw)
+			tc.check(t, resp)
+		})
+	}
+}
+
+func TestHeadResponseFromReader(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+
+	// Test: The response to a HEAD request has no body but keeps its headers
+	resp, err := HeadResponseFromReader(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, "5", resp.Headers.Get("content-length"))
+	assert.Empty(t, resp.Body)
+
+	// Test: The same response to another request is incomplete
+	_, err = ResponseFromReader(bytes.NewReader(buf.Bytes()))
+	require.ErrorIs(t, err, errIncompleteResponse)
+}
+
+func TestSwitchProtocolsResponse(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	go func() {
+		defer server.Close()
+
+		w := NewWriter(server)
+		_, _, _ = w.SwitchProtocols("websocket", headers.Headers{"Sec-WebSocket-Accept": "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="})
+	}()
+
+	// Test: The 101 response has no body even though the connection stays open
+	resp, err := ResponseFromReader(client)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCodeSwitchingProtocols, resp.StatusLine.StatusCode)
+	assert.Equal(t, "websocket", resp.Headers.Get("upgrade"))
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Headers.Get("sec-websocket-accept"))
+	assert.Empty(t, resp.Body)
+}
+
+func TestResponseFromReaderErrors(t *testing.T) {
+	testCases := []struct {
+		name       string
+		raw        string
+		incomplete bool
+	}{
+		{name: "Empty", raw: "", incomplete: true},
+		{name: "Invalid status line", raw: "HTTP/1.1 OK\r\n\r\n"},
+		{name: "Bare LF", raw: "HTTP/1.1 200 OK\nContent-Length: 0\n\n"},
+		{name: "Obsolete line folding", raw: "HTTP/1.1 200 OK\r\nX-A: 1\r\n 2\r\n\r\n"},
+		{name: "Invalid header", raw: "HTTP/1.1 200 OK\r\nX A: 1\r\n\r\n"},
+		{name: "Invalid Content-Length", raw: "HTTP/1.1 200 OK\r\nContent-Length: abc\r\n\r\n"},
+		{name: "Incomplete headers", raw: "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n", incomplete: true},
+		{name: "Incomplete body", raw: "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nshort", incomplete: true},
+		{name: "Invalid chunk size", raw: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n"},
+		{name: "Chunk without CRLF", raw: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhelloXX0\r\n\r\n"},
+		{
+			name:       "Missing last chunk",
+			raw:        "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n",
+			incomplete: true,
+		},
+		{
+			name:       "Incomplete trailers",
+			raw:        "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n0\r\nX-Checksum: abc\r\n",
+			incomplete: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ResponseFromReader(strings.NewReader(tc.raw))
+			require.Error(t, err)
+
+			if tc.incomplete {
+				require.ErrorIs(t, err, errIncompleteResponse)
+			}
+		})
+	}
+}
+
+func TestReader(t *testing.T) {
+	reader := NewReader(strings.NewReader(
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Checksum\r\n\r\n" +
+			"5\r\nhello\r\n6\r\n world\r\n0\r\nX-Checksum: abc\r\n\r\n" +
+			"HTTP/1.1 204 No Content\r\n\r\n",
+	))
+
+	// Test: The parts of a response are read one at a time
+	statusLine, err := reader.ReadStatusLine()
+	require.NoError(t, err)
+	assert.Equal(t, StatusCodeOK, statusLine.StatusCode)
+
+	h, _, err := reader.ReadHeaders()
+	require.NoError(t, err)
+
+	framing, contentLength, err := BodyFraming(statusLine.StatusCode, h, false)
+	require.NoError(t, err)
+	assert.Equal(t, FramingChunked, framing)
+	assert.Equal(t, int64(-1), contentLength)
+
+	chunked := NewChunkedReader(reader)
+	assert.Nil(t, chunked.Trailers())
+
+	body, err := io.ReadAll(chunked)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+	assert.Equal(t, headers.Headers{"x-checksum": "abc"}, chunked.Trailers())
+
+	// Test: The next response follows the body
+	statusLine, err = reader.ReadStatusLine()
+	require.NoError(t, err)
+	assert.Equal(t, StatusCode(204), statusLine.StatusCode)
+
+	h, _, err = reader.ReadHeaders()
+	require.NoError(t, err)
+
+	framing, _, err = BodyFraming(statusLine.StatusCode, h, false)
+	require.NoError(t, err)
+	assert.Equal(t, FramingNone, framing)
+
+	// Test: io.EOF is returned once there are no more responses
+	_, err = reader.ReadStatusLine()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestBodyFraming(t *testing.T) {
+	testCases := []struct {
+		name          string
+		statusCode    StatusCode
+		headers       headers.Headers
+		head          bool
+		framing       Framing
+		contentLength int64
+	}{
+		{name: "Content-Length", statusCode: 200, headers: headers.Headers{"content-length": "5"}, framing: FramingLength, contentLength: 5},
+		{name: "Empty body", statusCode: 200, headers: headers.Headers{"content-length": "0"}, framing: FramingNone, contentLength: 0},
+		{name: "HEAD", statusCode: 200, headers: headers.Headers{"content-length": "5"}, head: true, framing: FramingNone, contentLength: 5},
+		{name: "Not Modified", statusCode: 304, headers: headers.Headers{"content-length": "5"}, framing: FramingNone, contentLength: 5},
+		{name: "Interim", statusCode: 101, headers: headers.Headers{}, framing: FramingNone, contentLength: -1},
+		{name: "Chunked", statusCode: 200, headers: headers.Headers{"transfer-encoding": "gzip, chunked", "content-length": "5"}, framing: FramingChunked, contentLength: -1},
+		{name: "Not chunked", statusCode: 200, headers: headers.Headers{"transfer-encoding": "gzip"}, framing: FramingClose, contentLength: -1},
+		{name: "No length", statusCode: 200, headers: headers.Headers{}, framing: FramingClose, contentLength: -1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			framing, contentLength, err := BodyFraming(tc.statusCode, tc.headers, tc.head)
+			require.NoError(t, err)
+			assert.Equal(t, tc.framing, framing)
+			assert.Equal(t, tc.contentLength, contentLength)
+		})
+	}
+
+	// Test: An invalid Content-Length is rejected
+	_, _, err := BodyFraming(200, headers.Headers{"content-length": "1, 2"}, false)
+	require.Error(t, err)
+}
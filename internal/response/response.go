@@ -4,6 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
+	"strconv"
+	"strings"
 
 	"http-from-tcp/internal/headers"
 )
@@ -11,11 +14,57 @@ import (
 type StatusCode int
 
 const (
-	StatusCodeOK          StatusCode = 200
-	StatusCodeBadRequest  StatusCode = 400
-	StatusCodeServerError StatusCode = 500
+	StatusCodeSwitchingProtocols   StatusCode = 101
+	StatusCodeOK                   StatusCode = 200
+	StatusCodePartialContent       StatusCode = 206
+	StatusCodeMovedPermanently     StatusCode = 301
+	StatusCodeNotModified          StatusCode = 304
+	StatusCodeBadRequest           StatusCode = 400
+	StatusCodeForbidden            StatusCode = 403
+	StatusCodeNotFound             StatusCode = 404
+	StatusCodeMethodNotAllowed     StatusCode = 405
+	StatusCodeNotAcceptable        StatusCode = 406
+	StatusCodePreconditionFailed   StatusCode = 412
+	StatusCodeContentTooLarge      StatusCode = 413
+	StatusCodeUnsupportedMediaType StatusCode = 415
+	StatusCodeRangeNotSatisfiable  StatusCode = 416
+	StatusCodeUpgradeRequired      StatusCode = 426
+	StatusCodeServerError          StatusCode = 500
+	StatusCodeNotImplemented       StatusCode = 501
+	StatusCodeBadGateway           StatusCode = 502
+	StatusCodeServiceUnavailable   StatusCode = 503
+	StatusCodeGatewayTimeout       StatusCode = 504
 )
 
+var statusText = map[StatusCode]string{
+	StatusCodeSwitchingProtocols:   "Switching Protocols",
+	StatusCodeOK:                   "OK",
+	StatusCodePartialContent:       "Partial Content",
+	StatusCodeMovedPermanently:     "Moved Permanently",
+	StatusCodeNotModified:          "Not Modified",
+	StatusCodeBadRequest:           "Bad Request",
+	StatusCodeForbidden:            "Forbidden",
+	StatusCodeNotFound:             "Not Found",
+	StatusCodeMethodNotAllowed:     "Method Not Allowed",
+	StatusCodeNotAcceptable:        "Not Acceptable",
+	StatusCodePreconditionFailed:   "Precondition Failed",
+	StatusCodeContentTooLarge:      "Content Too Large",
+	StatusCodeUnsupportedMediaType: "Unsupported Media Type",
+	StatusCodeRangeNotSatisfiable:  "Range Not Satisfiable",
+	StatusCodeUpgradeRequired:      "Upgrade Required",
+	StatusCodeServerError:          "Internal Server Error",
+	StatusCodeNotImplemented:       "Not Implemented",
+	StatusCodeBadGateway:           "Bad Gateway",
+	StatusCodeServiceUnavailable:   "Service Unavailable",
+	StatusCodeGatewayTimeout:       "Gateway Timeout",
+}
+
+// StatusText returns the reason phrase for the status code. An empty string
+// is returned if the status code is unknown.
+func StatusText(statusCode StatusCode) string {
+	return statusText[statusCode]
+}
+
 type writerState int
 
 const (
@@ -23,36 +72,76 @@ const (
 	writerStateHeaders
 	writerStateBody
 	writerStateTrailers
+	writerStateDone
+	writerStateHijacked
 )
 
 type Writer struct {
-	writer io.Writer
-	state  writerState
+	writer          io.Writer
+	state           writerState
+	statusCode      StatusCode
+	contentLength   int
+	bodySize        int
+	chunked         bool
+	closeConnection bool
+	cookies         []string
+	beforeHeaders   []func()
+	encoderFunc     EncoderFunc
+	encoder         io.WriteCloser
+	buffered        func() []byte
+	stream          Stream
 }
 
+// EncoderFunc is called by WriteHeaders with the headers of the response before
+// they are written. It returns a writer that encodes the body (e.g. compresses it)
+// and writes the encoded body to dst, or nil if the body should not be encoded. It
+// can change the headers (e.g. to add the Content-Encoding header).
+type EncoderFunc func(h headers.Headers, dst io.Writer) io.WriteCloser
+
 func NewWriter(w io.Writer) *Writer {
 	return &Writer{
-		writer: w,
-		state:  writerStateInitialised,
+		writer:          w,
+		state:           writerStateInitialised,
+		statusCode:      0,
+		contentLength:   -1,
+		bodySize:        0,
+		chunked:         false,
+		closeConnection: false,
+		cookies:         make([]string, 0),
 	}
 }
 
+// NewConnWriter returns a Writer for the network connection. buffered returns the
+// data that has been read from the connection but not consumed. It is handed to the
+// handler that hijacks the connection.
+func NewConnWriter(conn net.Conn, buffered func() []byte) *Writer {
+	w := NewWriter(conn)
+	w.buffered = buffered
+
+	return w
+}
+
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
 	if w.state != writerStateInitialised {
 		return errors.New("the response writer is not in the correct state to write the status line")
 	}
 
-	statuses := map[StatusCode]string{
-		StatusCodeOK:          "HTTP/1.1 200 OK",
-		StatusCodeBadRequest:  "HTTP/1.1 400 Bad Request",
-		StatusCodeServerError: "HTTP/1.1 500 Internal Server Error",
+	// The status code of an HTTP/2 response is sent with the headers.
+	if w.stream != nil {
+		w.statusCode = statusCode
+		w.state = writerStateHeaders
+
+		return nil
 	}
 
-	_, err := w.writer.Write([]byte(statuses[statusCode] + "\r\n"))
+	statusLine := fmt.Sprintf("HTTP/1.1 %d %s\r\n", int(statusCode), StatusText(statusCode))
+
+	_, err := w.writer.Write([]byte(statusLine))
 	if err != nil {
 		return fmt.Errorf("error writing the status line: %w", err)
 	}
 
+	w.statusCode = statusCode
 	w.state = writerStateHeaders
 
 	return nil
@@ -63,6 +152,35 @@ func (w *Writer) WriteHeaders(headers headers.Headers) error {
 		return errors.New("the response writer is not in the correct state to write the headers")
 	}
 
+	// The hooks can add cookies so they are run before anything is written.
+	hooks := w.beforeHeaders
+	w.beforeHeaders = nil
+
+	for _, hook := range hooks {
+		hook()
+	}
+
+	if w.encoderFunc != nil {
+		w.startEncoder(headers)
+	}
+
+	if w.stream != nil {
+		if err := w.stream.WriteHeaders(w.statusCode, headers, w.cookies); err != nil {
+			return fmt.Errorf("error writing the headers to the stream: %w", err)
+		}
+	} else if err := w.writeHeaderLines(headers); err != nil {
+		return err
+	}
+
+	w.setFraming(headers)
+	w.state = writerStateBody
+
+	return nil
+}
+
+// writeHeaderLines writes the header lines, the Set-Cookie lines and the empty line
+// that ends the header section.
+func (w *Writer) writeHeaderLines(headers headers.Headers) error {
 	for key, value := range headers {
 		header := key + ": " + value + "\r\n"
 		_, err := w.writer.Write([]byte(header))
@@ -75,6 +193,20 @@ func (w *Writer) WriteHeaders(headers headers.Headers) error {
 		}
 	}
 
+	// Each cookie is written on its own line as the values of the Set-Cookie
+	// header cannot be combined into a comma separated list.
+	for _, cookie := range w.cookies {
+		header := HeaderSetCookie + ": " + cookie + "\r\n"
+		_, err := w.writer.Write([]byte(header))
+		if err != nil {
+			return fmt.Errorf(
+				"error writing the header %q: %w",
+				header,
+				err,
+			)
+		}
+	}
+
 	_, err := w.writer.Write([]byte("\r\n"))
 	if err != nil {
 		return fmt.Errorf(
@@ -83,8 +215,6 @@ func (w *Writer) WriteHeaders(headers headers.Headers) error {
 		)
 	}
 
-	w.state = writerStateBody
-
 	return nil
 }
 
@@ -93,5 +223,323 @@ func (w *Writer) WriteBody(p []byte) (int, error) {
 		return 0, errors.New("the response writer is not in the correct state to write the body")
 	}
 
-	return w.writer.Write(p)
+	writer := w.writer
+	if w.encoder != nil {
+		writer = w.encoder
+	}
+
+	n, err := writer.Write(p)
+	w.bodySize += n
+
+	return n, err
+}
+
+// Write writes the data to the body of the response. It is the same as WriteBody
+// and it allows the Writer to be used as an io.Writer (e.g. with io.Copy).
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.WriteBody(p)
+}
+
+// Flush sends any buffered data of the body to the client. The data that is held
+// back by the body encoder is flushed first and then the underlying writer is
+// flushed if it has a Flush method.
+func (w *Writer) Flush() error {
+	if w.state != writerStateBody {
+		return errors.New("the response writer is not in the correct state to flush the body")
+	}
+
+	if flusher, ok := w.encoder.(interface{ Flush() error }); ok {
+		if err := flusher.Flush(); err != nil {
+			return fmt.Errorf("error flushing the body encoder: %w", err)
+		}
+	}
+
+	if flusher, ok := w.writer.(interface{ Flush() error }); ok {
+		if err := flusher.Flush(); err != nil {
+			return fmt.Errorf("error flushing the body: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ReadFrom writes the data from the reader to the body of the response until EOF. If a
+// Content-Length was written, no more than the rest of that length is read. If the body
+// is written unchanged (i.e. it is not chunked or encoded) and the underlying writer
+// implements io.ReaderFrom then the copy is delegated to it (e.g. a *net.TCPConn lets
+// the kernel send a file, even behind an io.LimitedReader, straight to the socket with
+// sendfile). Otherwise the data is copied through a buffer and written as chunks if the
+// body is chunked.
+func (w *Writer) ReadFrom(r io.Reader) (int64, error) {
+	if w.state != writerStateBody {
+		return 0, errors.New("the response writer is not in the correct state to write the body")
+	}
+
+	if w.contentLength >= 0 {
+		r = io.LimitReader(r, int64(max(w.contentLength-w.bodySize, 0)))
+	}
+
+	if readerFrom, ok := w.writer.(io.ReaderFrom); ok && w.encoder == nil && !w.chunked {
+		n, err := readerFrom.ReadFrom(r)
+		w.bodySize += int(n)
+
+		return n, err
+	}
+
+	return io.Copy(bodyWriter{w}, r)
+}
+
+// bodyWriter writes to the body of the response. It hides the ReadFrom method of
+// the Writer so that io.Copy does not call it recursively.
+type bodyWriter struct {
+	w *Writer
+}
+
+func (b bodyWriter) Write(p []byte) (int, error) {
+	if b.w.chunked {
+		return b.w.WriteChunkedBody(p)
+	}
+
+	return b.w.WriteBody(p)
+}
+
+// SetEncoder sets the function that selects the encoder for the body of the response.
+// The length of an encoded body is not known in advance so the Content-Length header
+// is replaced with a chunked Transfer-Encoding and the response must be completed with
+// Finish (or with WriteChunkedBodyDone and WriteTrailers). SetEncoder must be called
+// before WriteHeaders.
+func (w *Writer) SetEncoder(encoderFunc EncoderFunc) {
+	w.encoderFunc = encoderFunc
+}
+
+// Finish completes a response with an encoded body. The encoder is flushed and the
+// chunked body is ended without any trailers. Finish does nothing if the body is not
+// encoded or if the body has already been ended.
+func (w *Writer) Finish() error {
+	if w.encoder == nil || w.state != writerStateBody {
+		return nil
+	}
+
+	if _, err := w.WriteChunkedBodyDone(); err != nil {
+		return err
+	}
+
+	return w.WriteTrailers(headers.NewHeaders())
+}
+
+// startEncoder starts encoding the body if the encoder function returns an encoder.
+func (w *Writer) startEncoder(h headers.Headers) {
+	encoder := w.encoderFunc(h, chunkWriter{w})
+	if encoder == nil {
+		return
+	}
+
+	chunked := false
+
+	for key, value := range h {
+		switch strings.ToLower(key) {
+		case strings.ToLower(HeaderContentLength):
+			delete(h, key)
+		case strings.ToLower(HeaderTransferEncoding):
+			chunked = strings.EqualFold(value, "chunked")
+		}
+	}
+
+	if !chunked {
+		h[HeaderTransferEncoding] = "chunked"
+	}
+
+	w.encoder = encoder
+}
+
+// closeEncoder flushes the remaining encoded data to the body.
+func (w *Writer) closeEncoder() error {
+	if w.encoder == nil {
+		return nil
+	}
+
+	encoder := w.encoder
+	w.encoder = nil
+
+	if err := encoder.Close(); err != nil {
+		return fmt.Errorf("error closing the body encoder: %w", err)
+	}
+
+	return nil
+}
+
+// AddCookie adds the cookie to the response. The cookie is written in a Set-Cookie
+// header with the rest of the headers so AddCookie must be called before WriteHeaders.
+func (w *Writer) AddCookie(cookie headers.Cookie) error {
+	if w.state != writerStateInitialised && w.state != writerStateHeaders {
+		return errors.New("the response writer is not in the correct state to add a cookie")
+	}
+
+	if err := cookie.Validate(); err != nil {
+		return fmt.Errorf("invalid cookie: %w", err)
+	}
+
+	w.cookies = append(w.cookies, cookie.String())
+
+	return nil
+}
+
+// AddSetCookie adds a Set-Cookie header with a value that is already formatted (e.g.
+// one that is relayed from another server). Like AddCookie, it must be called before
+// WriteHeaders.
+func (w *Writer) AddSetCookie(value string) error {
+	if w.state != writerStateInitialised && w.state != writerStateHeaders {
+		return errors.New("the response writer is not in the correct state to add a cookie")
+	}
+
+	if strings.ContainsAny(value, "\r\n") {
+		return errors.New("invalid Set-Cookie value")
+	}
+
+	w.cookies = append(w.cookies, value)
+
+	return nil
+}
+
+// BeforeWriteHeaders registers a function that is called when WriteHeaders is
+// called, just before the headers are written. Middleware use it to add cookies
+// to the response (e.g. to save a session). The functions are called in the order
+// that they are registered.
+func (w *Writer) BeforeWriteHeaders(hook func()) {
+	w.beforeHeaders = append(w.beforeHeaders, hook)
+}
+
+// Hijack lets the handler take over the connection to speak another protocol on it
+// (e.g. after a 101 Switching Protocols response or to tunnel a CONNECT request). The
+// data that the server has read from the connection but not consumed is returned with
+// the connection and must be handled before reading from the connection. The server
+// stops managing the connection once it is hijacked so the handler must close it but
+// it can keep using it after it returns. The Writer must not be used afterwards.
+//
+// Hijack fails if the Writer does not write to a network connection.
+func (w *Writer) Hijack() (net.Conn, []byte, error) {
+	if w.state == writerStateHijacked {
+		return nil, nil, errors.New("the connection has already been hijacked")
+	}
+
+	conn, ok := w.writer.(net.Conn)
+	if !ok {
+		return nil, nil, errors.New("the response writer does not write to a network connection")
+	}
+
+	var buffered []byte
+	if w.buffered != nil {
+		buffered = w.buffered()
+	}
+
+	w.state = writerStateHijacked
+
+	return conn, buffered, nil
+}
+
+// SwitchProtocols accepts an upgrade request (RFC 9110, section 7.8) by writing a
+// 101 Switching Protocols response for the protocol and hijacking the connection. The
+// headers can add the headers of the protocol (e.g. Sec-WebSocket-Accept) and can be
+// nil. It must be called before anything else is written.
+func (w *Writer) SwitchProtocols(protocol string, h headers.Headers) (net.Conn, []byte, error) {
+	if w.state != writerStateInitialised {
+		return nil, nil, errors.New("the response writer is not in the correct state to switch protocols")
+	}
+
+	// The response is not written if the connection cannot be hijacked afterwards.
+	if _, ok := w.writer.(net.Conn); !ok {
+		return nil, nil, errors.New("the response writer does not write to a network connection")
+	}
+
+	if h == nil {
+		h = headers.NewHeaders()
+	}
+
+	h[HeaderUpgrade] = protocol
+	h[HeaderConnection] = "Upgrade"
+
+	if err := w.WriteStatusLine(StatusCodeSwitchingProtocols); err != nil {
+		return nil, nil, err
+	}
+
+	if err := w.WriteHeaders(h); err != nil {
+		return nil, nil, err
+	}
+
+	return w.Hijack()
+}
+
+// StatusCode returns the status code of the response, or 0 if the status line has not
+// been written yet.
+func (w *Writer) StatusCode() StatusCode {
+	return w.statusCode
+}
+
+// Hijacked returns true if the connection has been hijacked.
+func (w *Writer) Hijacked() bool {
+	return w.state == writerStateHijacked
+}
+
+// KeepAlive returns true if a complete response has been written and the connection
+// can be used for the next request.
+func (w *Writer) KeepAlive() bool {
+	switch {
+	case w.closeConnection, w.state == writerStateHijacked:
+		return false
+	case w.chunked:
+		return w.state == writerStateDone
+	case w.state != writerStateBody:
+		return false
+	default:
+		return w.contentLength >= 0 && w.bodySize == w.contentLength
+	}
+}
+
+// setFraming notes how the body of the response is framed. A response without
+// the Content-Length header or a chunked Transfer-Encoding is delimited by closing
+// the connection unless the status code does not allow a body.
+func (w *Writer) setFraming(headers headers.Headers) {
+	// The 1xx, 204 No Content and 304 Not Modified responses never have a body
+	// (RFC 9112, section 6.3).
+	if w.statusCode < 200 || w.statusCode == 204 || w.statusCode == StatusCodeNotModified {
+		w.contentLength = 0
+	}
+
+	for key, value := range headers {
+		switch strings.ToLower(key) {
+		case strings.ToLower(HeaderContentLength):
+			contentLength, err := strconv.Atoi(value)
+			if err == nil {
+				w.contentLength = contentLength
+			}
+		case strings.ToLower(HeaderTransferEncoding):
+			w.chunked = strings.EqualFold(value, "chunked")
+		case strings.ToLower(HeaderConnection):
+			for token := range strings.SplitSeq(value, ",") {
+				if strings.EqualFold(strings.TrimSpace(token), "close") {
+					w.closeConnection = true
+				}
+			}
+		}
+	}
+}
+
+// WriteError writes a complete plain text response with the status code and the message
+// as the body.
+func (w *Writer) WriteError(statusCode StatusCode, message string) error {
+	body := []byte(message + "\n")
+
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		return err
+	}
+
+	if err := w.WriteHeaders(GetDefaultHeaders(len(body))); err != nil {
+		return err
+	}
+
+	if _, err := w.WriteBody(body); err != nil {
+		return fmt.Errorf("error writing the response body: %w", err)
+	}
+
+	return nil
 }
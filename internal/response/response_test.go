@@ -0,0 +1,470 @@
+package response
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"http-from-tcp/internal/headers"
+	"http-from-tcp/internal/request"
+)
+
+func TestWriterCookies(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+
+	// Test: Each cookie is written on its own line
+	require.NoError(t, w.AddCookie(headers.Cookie{Name: "session", Value: "abc", HttpOnly: true}))
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	require.NoError(t, w.AddCookie(headers.Cookie{Name: "theme", Value: "dark", Path: "/"}))
+
+	// Test: Invalid cookies are rejected
+	require.Error(t, w.AddCookie(headers.Cookie{Name: "bad name", Value: "value"}))
+
+	require.NoError(t, w.WriteHeaders(headers.Headers{HeaderContentLength: "0"}))
+	assert.Equal(
+		t,
+		"HTTP/1.1 200 OK\r\n"+
+			"Content-Length: 0\r\n"+
+			"Set-Cookie: session=abc; HttpOnly\r\n"+
+			"Set-Cookie: theme=dark; Path=/\r\n"+
+			"\r\n",
+		buf.String(),
+	)
+
+	// Test: Cookies cannot be added after the headers are written
+	require.Error(t, w.AddCookie(headers.Cookie{Name: "late", Value: "value"}))
+}
+
+func TestWriterKeepAlive(t *testing.T) {
+	testCases := []struct {
+		name    string
+		headers headers.Headers
+		write   func(w *Writer)
+		want    bool
+	}{
+		{
+			name:    "Complete Content-Length body",
+			headers: headers.Headers{HeaderContentLength: "5"},
+			write:   func(w *Writer) { _, _ = w.WriteBody([]byte("hello")) },
+			want:    true,
+		},
+		{
+			name:    "Incomplete Content-Length body",
+			headers: headers.Headers{HeaderContentLength: "5"},
+			write:   func(w *Writer) { _, _ = w.WriteBody([]byte("hel")) },
+			want:    false,
+		},
+		{
+			name:    "Connection close",
+			headers: headers.Headers{HeaderContentLength: "0", HeaderConnection: "close"},
+			write:   func(_ *Writer) {},
+			want:    false,
+		},
+		{
+			name:    "Body delimited by closing the connection",
+			headers: headers.Headers{},
+			write:   func(w *Writer) { _, _ = w.WriteBody([]byte("hello")) },
+			want:    false,
+		},
+		{
+			name:    "Complete chunked body",
+			headers: headers.Headers{HeaderTransferEncoding: "chunked"},
+			write: func(w *Writer) {
+				_, _ = w.WriteChunkedBodyDone()
+				_ = w.WriteTrailers(headers.Headers{})
+			},
+			want: true,
+		},
+		{
+			name:    "Incomplete chunked body",
+			headers: headers.Headers{HeaderTransferEncoding: "chunked"},
+			write:   func(w *Writer) { _, _ = w.WriteChunkedBodyDone() },
+			want:    false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := NewWriter(new(strings.Builder))
+			require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+			require.NoError(t, w.WriteHeaders(tc.headers))
+			tc.write(w)
+			assert.Equal(t, tc.want, w.KeepAlive())
+		})
+	}
+}
+
+func TestWriterChunkedBody(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	require.NoError(t, w.WriteHeaders(headers.Headers{HeaderTransferEncoding: "chunked"}))
+
+	_, err := w.WriteChunkedBody([]byte("Hello, "))
+	require.NoError(t, err)
+
+	// Test: Empty chunks are not written as they would end the body
+	_, err = w.WriteChunkedBody(nil)
+	require.NoError(t, err)
+
+	_, err = w.WriteChunkedBody([]byte("World! This chunk is longer."))
+	require.NoError(t, err)
+
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(headers.Headers{}))
+
+	assert.Equal(
+		t,
+		"HTTP/1.1 200 OK\r\n"+
+			"Transfer-Encoding: chunked\r\n"+
+			"\r\n"+
+			"7\r\nHello, \r\n"+
+			"1c\r\nWorld! This chunk is longer.\r\n"+
+			"0\r\n"+
+			"\r\n",
+		buf.String(),
+	)
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	const etag = `"v2"`
+
+	lastModified := time.Date(2025, time.March, 14, 15, 9, 26, 0, time.UTC)
+	before := lastModified.Add(-time.Hour).Format(headers.TimeFormat)
+	after := lastModified.Add(time.Hour).Format(headers.TimeFormat)
+	exact := lastModified.Format(headers.TimeFormat)
+
+	testCases := []struct {
+		name       string
+		method     string
+		headers    string
+		wantStatus StatusCode
+		wantOK     bool
+	}{
+		{"No conditional headers", "GET", "", StatusCodeOK, true},
+		{"If-Match matches", "PUT", `If-Match: "v1", "v2"`, StatusCodeOK, true},
+		{"If-Match any", "PUT", `If-Match: *`, StatusCodeOK, true},
+		{"If-Match does not match", "PUT", `If-Match: "v1"`, StatusCodePreconditionFailed, false},
+		{"If-Match with a weak tag", "PUT", `If-Match: W/"v2"`, StatusCodePreconditionFailed, false},
+		{"If-Unmodified-Since before", "PUT", "If-Unmodified-Since: " + before, StatusCodePreconditionFailed, false},
+		{"If-Unmodified-Since after", "PUT", "If-Unmodified-Since: " + after, StatusCodeOK, true},
+		{"If-Match takes precedence", "PUT", `If-Match: "v2"` + "\r\nIf-Unmodified-Since: " + before, StatusCodeOK, true},
+		{"If-None-Match matches", "GET", `If-None-Match: W/"v2"`, StatusCodeNotModified, false},
+		{"If-None-Match matches an unsafe method", "POST", `If-None-Match: *`, StatusCodePreconditionFailed, false},
+		{"If-None-Match does not match", "GET", `If-None-Match: "v1"`, StatusCodeOK, true},
+		{"If-None-Match with a comma in a tag", "GET", `If-None-Match: "a,b", "v2"`, StatusCodeNotModified, false},
+		{"If-Modified-Since exact", "GET", "If-Modified-Since: " + exact, StatusCodeNotModified, false},
+		{"If-Modified-Since before", "GET", "If-Modified-Since: " + before, StatusCodeOK, true},
+		{"If-Modified-Since obsolete format", "HEAD", "If-Modified-Since: Friday, 14-Mar-25 15:09:26 GMT", StatusCodeNotModified, false},
+		{"If-Modified-Since invalid", "GET", "If-Modified-Since: yesterday", StatusCodeOK, true},
+		{"If-Modified-Since ignored for POST", "POST", "If-Modified-Since: " + exact, StatusCodeOK, true},
+		{"If-None-Match takes precedence", "GET", `If-None-Match: "v1"` + "\r\nIf-Modified-Since: " + exact, StatusCodeOK, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			raw := tc.method + " / HTTP/1.1\r\nHost: localhost\r\n"
+			if tc.headers != "" {
+				raw += tc.headers + "\r\n"
+			}
+
+			req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+			require.NoError(t, err)
+
+			statusCode, ok := CheckPreconditions(req, true, etag, lastModified.Add(500*time.Millisecond))
+			assert.Equal(t, tc.wantStatus, statusCode)
+			assert.Equal(t, tc.wantOK, ok)
+		})
+	}
+}
+
+func TestCheckPreconditionsWithoutETag(t *testing.T) {
+	testCases := []struct {
+		name       string
+		method     string
+		headers    string
+		exists     bool
+		wantStatus StatusCode
+		wantOK     bool
+	}{
+		{"If-Match any", "PUT", "If-Match: *", true, StatusCodeOK, true},
+		{"If-Match any without a resource", "PUT", "If-Match: *", false, StatusCodePreconditionFailed, false},
+		{"If-Match a tag", "PUT", `If-Match: "v1"`, true, StatusCodePreconditionFailed, false},
+		{"If-None-Match any", "GET", "If-None-Match: *", true, StatusCodeNotModified, false},
+		{"If-None-Match any without a resource", "PUT", "If-None-Match: *", false, StatusCodeOK, true},
+		{"If-None-Match a tag", "GET", `If-None-Match: "v1"`, true, StatusCodeOK, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			raw := tc.method + " / HTTP/1.1\r\nHost: localhost\r\n" + tc.headers + "\r\n\r\n"
+
+			req, err := request.RequestFromReader(strings.NewReader(raw))
+			require.NoError(t, err)
+
+			statusCode, ok := CheckPreconditions(req, tc.exists, "", time.Time{})
+			assert.Equal(t, tc.wantStatus, statusCode)
+			assert.Equal(t, tc.wantOK, ok)
+		})
+	}
+}
+
+func TestWriteNotModified(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+
+	require.NoError(t, w.WriteNotModified(`"v2"`, time.Time{}))
+	assert.Equal(t, "HTTP/1.1 304 Not Modified\r\nETag: \"v2\"\r\n\r\n", buf.String())
+
+	// Test: The response does not have a body so the connection can be reused
+	assert.True(t, w.KeepAlive())
+}
+
+func TestWriterHijack(t *testing.T) {
+	// Test: A writer without a network connection cannot be hijacked
+	_, _, err := NewWriter(new(bytes.Buffer)).Hijack()
+	require.Error(t, err)
+
+	server, client := net.Pipe()
+	t.Cleanup(func() { _ = client.Close() })
+
+	w := NewConnWriter(server, func() []byte { return []byte("unread") })
+
+	conn, buffered, err := w.Hijack()
+	require.NoError(t, err)
+	assert.Equal(t, server, conn)
+	assert.Equal(t, "unread", string(buffered))
+	assert.True(t, w.Hijacked())
+	assert.False(t, w.KeepAlive())
+
+	// Test: The writer cannot be used after the connection is hijacked
+	require.Error(t, w.WriteStatusLine(StatusCodeOK))
+
+	_, _, err = w.Hijack()
+	require.Error(t, err)
+}
+
+// recordingStream records what a Writer sends to an HTTP/2 stream.
+type recordingStream struct {
+	statusCode StatusCode
+	headers    headers.Headers
+	cookies    []string
+	body       bytes.Buffer
+	trailers   headers.Headers
+}
+
+func (s *recordingStream) WriteHeaders(statusCode StatusCode, h headers.Headers, cookies []string) error {
+	s.statusCode = statusCode
+	s.headers = h
+	s.cookies = cookies
+
+	return nil
+}
+
+func (s *recordingStream) Write(p []byte) (int, error) {
+	return s.body.Write(p)
+}
+
+func (s *recordingStream) WriteTrailers(h headers.Headers) error {
+	s.trailers = h
+
+	return nil
+}
+
+func TestStreamWriter(t *testing.T) {
+	stream := new(recordingStream)
+	w := NewStreamWriter(stream)
+
+	// Test: The parts of a chunked response are passed to the stream without the framing
+	require.NoError(t, w.AddCookie(headers.Cookie{Name: "session", Value: "abc"}))
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	require.NoError(t, w.WriteHeaders(headers.Headers{
+		HeaderTransferEncoding: "chunked",
+		HeaderTrailer:          "X-Checksum",
+	}))
+
+	_, err := w.WriteChunkedBody([]byte("hello "))
+	require.NoError(t, err)
+
+	_, err = w.ReadFrom(strings.NewReader("world"))
+	require.NoError(t, err)
+
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(headers.Headers{HeaderTrailer: "X-Checksum", "X-Checksum": "42"}))
+
+	assert.Equal(t, StatusCodeOK, stream.statusCode)
+	assert.Equal(t, "chunked", stream.headers[HeaderTransferEncoding])
+	assert.Equal(t, []string{"session=abc"}, stream.cookies)
+	assert.Equal(t, "hello world", stream.body.String())
+	assert.Equal(t, headers.Headers{"X-Checksum": "42"}, stream.trailers)
+
+	// Test: An encoded body is ended with empty trailers
+	stream = new(recordingStream)
+	w = NewStreamWriter(stream)
+	w.SetEncoder(func(h headers.Headers, dst io.Writer) io.WriteCloser {
+		h[HeaderContentEncoding] = "identity"
+
+		return nopWriteCloser{dst}
+	})
+
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+
+	_, err = w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+
+	assert.NotContains(t, stream.headers, HeaderContentLength)
+	assert.Equal(t, "hello", stream.body.String())
+	assert.Equal(t, headers.NewHeaders(), stream.trailers)
+
+	// Test: A stream cannot be hijacked
+	_, _, err = NewStreamWriter(new(recordingStream)).SwitchProtocols("websocket", nil)
+	require.Error(t, err)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func TestWriterReadFrom(t *testing.T) {
+	// Test: A fixed length body is copied unchanged
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	require.NoError(t, w.WriteHeaders(headers.Headers{HeaderContentLength: "11"}))
+
+	n, err := w.ReadFrom(strings.NewReader("hello world"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), n)
+	assert.True(t, w.KeepAlive())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 11\r\n\r\nhello world", buf.String())
+
+	// Test: No more than the Content-Length is read
+	buf.Reset()
+	w = NewWriter(buf)
+
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	require.NoError(t, w.WriteHeaders(headers.Headers{HeaderContentLength: "11"}))
+
+	_, err = w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+
+	reader := strings.NewReader(" world and more")
+	n, err = w.ReadFrom(reader)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), n)
+	assert.Equal(t, 9, reader.Len())
+	assert.True(t, w.KeepAlive())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 11\r\n\r\nhello world", buf.String())
+
+	// Test: A chunked body is written as chunks
+	buf.Reset()
+	w = NewWriter(buf)
+
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	require.NoError(t, w.WriteHeaders(headers.Headers{HeaderTransferEncoding: "chunked"}))
+
+	_, err = w.ReadFrom(io.LimitReader(strings.NewReader("hello world"), 5))
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n", buf.String())
+
+	// Test: The body cannot be written before the headers
+	_, err = NewWriter(buf).ReadFrom(strings.NewReader("too early"))
+	require.Error(t, err)
+}
+
+const benchmarkFileSize = 16 << 20
+
+// benchmarkConn returns a TCP connection to a server that discards everything that
+// it receives and a file with benchmarkFileSize bytes.
+func benchmarkConn(b *testing.B) (net.Conn, string) {
+	b.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(b, err)
+	b.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(b, err)
+	b.Cleanup(func() { _ = conn.Close() })
+
+	path := filepath.Join(b.TempDir(), "file.bin")
+	require.NoError(b, os.WriteFile(path, bytes.Repeat([]byte("0123456789abcdef"), benchmarkFileSize/16), 0o600))
+
+	return conn, path
+}
+
+func writeFileHeaders(b *testing.B, w *Writer) {
+	b.Helper()
+
+	require.NoError(b, w.WriteStatusLine(StatusCodeOK))
+	require.NoError(b, w.WriteHeaders(headers.Headers{HeaderContentLength: strconv.Itoa(benchmarkFileSize)}))
+}
+
+// BenchmarkWriteBodyWholeFile reads the whole file into memory for every response.
+func BenchmarkWriteBodyWholeFile(b *testing.B) {
+	conn, path := benchmarkConn(b)
+
+	b.SetBytes(benchmarkFileSize)
+	b.ReportAllocs()
+
+	for b.Loop() {
+		w := NewWriter(conn)
+		writeFileHeaders(b, w)
+
+		data, err := os.ReadFile(path)
+		require.NoError(b, err)
+
+		_, err = w.WriteBody(data)
+		require.NoError(b, err)
+	}
+}
+
+// BenchmarkReadFromFile streams the file with ReadFrom which uses sendfile.
+func BenchmarkReadFromFile(b *testing.B) {
+	conn, path := benchmarkConn(b)
+
+	b.SetBytes(benchmarkFileSize)
+	b.ReportAllocs()
+
+	for b.Loop() {
+		w := NewWriter(conn)
+		writeFileHeaders(b, w)
+
+		file, err := os.Open(path)
+		require.NoError(b, err)
+
+		_, err = w.ReadFrom(file)
+		require.NoError(b, err)
+		require.NoError(b, file.Close())
+	}
+}
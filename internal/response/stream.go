@@ -0,0 +1,29 @@
+package response
+
+import "http-from-tcp/internal/headers"
+
+// Stream is an HTTP/2 stream that a response is written to (RFC 9113). The frames
+// of the stream replace the status line, the header lines and the chunked framing
+// of HTTP/1.1 so the Writer passes each part of the response to the stream instead
+// and the handlers work the same with both protocols.
+type Stream interface {
+	// WriteHeaders sends the status code and the headers of the response. Each
+	// cookie is sent as a Set-Cookie field.
+	WriteHeaders(statusCode StatusCode, h headers.Headers, cookies []string) error
+
+	// Write sends the data as part of the body of the response.
+	Write(p []byte) (int, error)
+
+	// WriteTrailers sends the trailers and ends the response. The response is ended
+	// without trailers if there are none.
+	WriteTrailers(h headers.Headers) error
+}
+
+// NewStreamWriter returns a Writer for the HTTP/2 stream. A chunked body is written
+// without the chunked framing and the connection cannot be hijacked.
+func NewStreamWriter(stream Stream) *Writer {
+	w := NewWriter(stream)
+	w.stream = stream
+
+	return w
+}
@@ -14,7 +14,14 @@ func (w *Writer) WriteTrailers(h headers.Headers) error {
 	}
 
 	// get trailers from Trailer
-	trailers := strings.Split(h[HeaderTrailer], ", ")
+	trailers := make([]string, 0)
+	if h[HeaderTrailer] != "" {
+		trailers = strings.Split(h[HeaderTrailer], ", ")
+	}
+
+	if w.stream != nil {
+		return w.writeStreamTrailers(h, trailers)
+	}
 
 	// for each trailer, write key and value
 	for idx := range trailers {
@@ -37,5 +44,24 @@ func (w *Writer) WriteTrailers(h headers.Headers) error {
 		)
 	}
 
+	w.state = writerStateDone
+
+	return nil
+}
+
+// writeStreamTrailers sends the trailers listed in the Trailer header to the HTTP/2
+// stream, which ends the stream.
+func (w *Writer) writeStreamTrailers(h headers.Headers, trailers []string) error {
+	fields := headers.NewHeaders()
+	for _, trailer := range trailers {
+		fields[trailer] = h[trailer]
+	}
+
+	if err := w.stream.WriteTrailers(fields); err != nil {
+		return fmt.Errorf("error writing the trailers to the stream: %w", err)
+	}
+
+	w.state = writerStateDone
+
 	return nil
 }
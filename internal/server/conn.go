@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// connReader reads the requests from the connection. While a handler runs, it reads
+// ahead in the background so that the context of the connection is cancelled as soon
+// as the client closes the connection, like net/http.
+type connReader struct {
+	conn   net.Conn
+	cancel context.CancelFunc
+
+	mutex *sync.Mutex
+	cond  *sync.Cond
+
+	// inRead is true while the background read is running.
+	inRead bool
+
+	// aborted is true if the background read was interrupted by the server.
+	aborted bool
+
+	// hasByte is true if the background read received the first byte of the next
+	// request, which is returned by the next read.
+	hasByte bool
+	byteBuf [1]byte
+
+	// err is the error of the background read, which is returned by the next read.
+	err error
+}
+
+func newConnReader(conn net.Conn, cancel context.CancelFunc) *connReader {
+	mutex := &sync.Mutex{}
+
+	return &connReader{
+		conn:    conn,
+		cancel:  cancel,
+		mutex:   mutex,
+		cond:    sync.NewCond(mutex),
+		inRead:  false,
+		aborted: false,
+		hasByte: false,
+		byteBuf: [1]byte{},
+		err:     nil,
+	}
+}
+
+func (r *connReader) Read(p []byte) (int, error) {
+	r.mutex.Lock()
+
+	if r.inRead {
+		r.mutex.Unlock()
+
+		return 0, errors.New("concurrent read while the connection is read in the background")
+	}
+
+	if r.err != nil {
+		err := r.err
+		r.err = nil
+		r.mutex.Unlock()
+
+		return 0, err
+	}
+
+	if r.hasByte && len(p) > 0 {
+		p[0] = r.byteBuf[0]
+		r.hasByte = false
+		r.mutex.Unlock()
+
+		return 1, nil
+	}
+
+	r.mutex.Unlock()
+
+	return r.conn.Read(p)
+}
+
+// startBackgroundRead waits for the next byte from the client in the background. The
+// context of the connection is cancelled if the connection is closed before it
+// arrives. It must only be called once all of the buffered data has been consumed.
+func (r *connReader) startBackgroundRead() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.inRead || r.hasByte || r.err != nil {
+		return
+	}
+
+	r.inRead = true
+
+	go r.backgroundRead()
+}
+
+func (r *connReader) backgroundRead() {
+	n, err := r.conn.Read(r.byteBuf[:])
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if n == 1 {
+		r.hasByte = true
+	}
+
+	// The connection is still open if the server interrupted the read.
+	if err != nil && !(r.aborted && errors.Is(err, os.ErrDeadlineExceeded)) {
+		r.err = err
+		r.cancel()
+	}
+
+	r.aborted = false
+	r.inRead = false
+	r.cond.Broadcast()
+}
+
+// abortPendingRead interrupts the background read and waits for it to stop. The byte
+// that it may have received is kept for the next read.
+func (r *connReader) abortPendingRead() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.inRead {
+		return
+	}
+
+	r.aborted = true
+
+	// A deadline in the past makes the pending read return immediately.
+	_ = r.conn.SetReadDeadline(time.Unix(1, 0))
+
+	for r.inRead {
+		r.cond.Wait()
+	}
+
+	_ = r.conn.SetReadDeadline(time.Time{})
+}
+
+// pending returns the byte received by the background read, which is no longer
+// returned by the next read. The background read must have been aborted.
+func (r *connReader) pending() []byte {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.hasByte {
+		return nil
+	}
+
+	r.hasByte = false
+
+	return []byte{r.byteBuf[0]}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"log/slog"
+	"maps"
+
+	"http-from-tcp/internal/request"
+	"http-from-tcp/internal/response"
+)
+
+// DecodeBody returns a handler that decodes the request bodies that were compressed
+// with a content coding before calling next, so that next receives the decoded body
+// (see request.Request.DecodeBody). maxSize is the maximum size of a decoded body. It
+// is request.DefaultMaxDecodedBodySize if it is 0. The requests that cannot be decoded
+// get the error response of WriteRequestError (e.g. 415 Unsupported Media Type with
+// the supported codings for an unknown coding).
+func DecodeBody(next Handler, maxSize int) Handler {
+	if maxSize == 0 {
+		maxSize = request.DefaultMaxDecodedBodySize
+	}
+
+	return func(w *response.Writer, req *request.Request) {
+		if err := req.DecodeBody(maxSize); err != nil {
+			slog.Error("error decoding the request body.", "error", err.Error())
+
+			WriteRequestError(w, err)
+
+			return
+		}
+
+		next(w, req)
+	}
+}
+
+// WriteRequestError writes the error response for a request that could not be read
+// or processed. The status code is the one that the error maps to and the response
+// includes the headers that the error requires (see request.ErrorHeaders).
+func WriteRequestError(w *response.Writer, err error) {
+	statusCode := response.StatusCode(request.StatusCode(err))
+	body := []byte(response.StatusText(statusCode) + "\n")
+
+	h := response.GetDefaultHeaders(len(body))
+	maps.Copy(h, request.ErrorHeaders(err))
+
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		slog.Error("error writing the error response.", "error", err.Error())
+
+		return
+	}
+
+	if err := w.WriteHeaders(h); err != nil {
+		slog.Error("error writing the error response.", "error", err.Error())
+
+		return
+	}
+
+	if _, err := w.WriteBody(body); err != nil {
+		slog.Error("error writing the error response.", "error", err.Error())
+	}
+}
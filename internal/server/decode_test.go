@@ -0,0 +1,62 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeBody(t *testing.T) {
+	server, err := Serve(0, DecodeBody(echoTargetHandler, 0))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = server.Close() })
+
+	send := func(t *testing.T, raw []byte) string {
+		t.Helper()
+
+		conn, err := net.Dial("tcp", server.Addr().String())
+		require.NoError(t, err)
+
+		defer conn.Close()
+
+		require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+		_, err = conn.Write(raw)
+		require.NoError(t, err)
+
+		data, err := io.ReadAll(conn)
+		require.NoError(t, err)
+
+		return string(data)
+	}
+
+	var compressed bytes.Buffer
+
+	gzipWriter := gzip.NewWriter(&compressed)
+	_, err = gzipWriter.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, gzipWriter.Close())
+
+	// Test: The handler receives the decoded body
+	data := send(t, append([]byte(
+		"POST / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\nContent-Encoding: gzip\r\n"+
+			"Content-Length: "+strconv.Itoa(compressed.Len())+"\r\n\r\n"),
+		compressed.Bytes()...,
+	))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 6\r\n\r\n/hello", data)
+
+	// Test: An unsupported coding is rejected with the supported codings
+	data = send(t, []byte(
+		"POST / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\nContent-Encoding: br\r\n"+
+			"Content-Length: 5\r\n\r\nhello",
+	))
+	assert.Contains(t, data, "HTTP/1.1 415 Unsupported Media Type\r\n")
+	assert.Contains(t, data, "Accept-Encoding: gzip, deflate\r\n")
+}
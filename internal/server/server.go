@@ -1,24 +1,101 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"maps"
 	"net"
+	"slices"
 	"sync/atomic"
+	"time"
 
 	"http-from-tcp/internal/request"
 	"http-from-tcp/internal/response"
 )
 
+// handshakeTimeout is the maximum amount of time for the TLS handshake of a new
+// connection.
+const handshakeTimeout = 10 * time.Second
+
+// lingerTimeout is the maximum amount of time to wait for the client to close
+// the connection after the server has finished writing to it.
+const lingerTimeout = 500 * time.Millisecond
+
+// http2Preface is the connection preface that an HTTP/2 client with prior knowledge
+// sends at the start of the connection (RFC 9113, section 3.4).
+const http2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+// Handler handles a request. The context of the request is cancelled when the client
+// closes the connection, when the server is closed or once the handler has returned.
 type Handler func(w *response.Writer, req *request.Request)
 
+// ConnHandler takes over a connection that does not speak HTTP/1.1. buffered is the
+// data that has already been read from the connection. The handler must close the
+// connection. The context is cancelled when the server is closed.
+type ConnHandler func(ctx context.Context, conn net.Conn, buffered []byte)
+
+// Options are the optional settings of the server.
+type Options struct {
+	// PriorKnowledge handles the connections that start with the HTTP/2 connection
+	// preface. These clients know that the server supports HTTP/2 without cleartext
+	// upgrade (RFC 9113, section 3.3). The preface is in the buffered data. The
+	// connections are handled as HTTP/1.1 connections (and fail) if it is nil.
+	PriorKnowledge ConnHandler
+
+	// TLSConfig enables TLS on every connection if it is not nil. It must have a
+	// certificate.
+	TLSConfig *tls.Config
+
+	// NextProtos handles the TLS connections that negotiated one of the protocols with
+	// ALPN (e.g. "h2" for HTTP/2 over TLS). The connections without a negotiated
+	// protocol, or that negotiated "http/1.1", are handled as HTTP/1.1 connections.
+	NextProtos map[string]ConnHandler
+
+	// MaxBodySize is the maximum size of a request body with every protocol. A
+	// larger body is rejected with 413 Content Too Large before it is read. It is
+	// request.DefaultMaxBodySize if it is 0 and the size is not limited if it is
+	// negative.
+	MaxBodySize int
+}
+
 type Server struct {
 	listener net.Listener
 	closed   *atomic.Bool
 	handler  Handler
+	options  Options
+
+	// ctx is the parent of the contexts of the connections. It is cancelled when
+	// the server is closed so that long running handlers (e.g. event streams) can
+	// stop.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type maxBodySizeKey struct{}
+
+// MaxBodySize returns the maximum size of a request body of the server that the
+// context comes from (see Options.MaxBodySize), or 0 if the size is not limited. The
+// context of a ConnHandler and of a request comes from the server. It is
+// request.DefaultMaxBodySize for the other contexts.
+func MaxBodySize(ctx context.Context) int {
+	maxBodySize, ok := ctx.Value(maxBodySizeKey{}).(int)
+	if !ok {
+		return request.DefaultMaxBodySize
+	}
+
+	return maxBodySize
 }
 
 func Serve(port int, handler Handler) (*Server, error) {
+	return ServeWithOptions(port, handler, Options{})
+}
+
+// ServeWithOptions is the same as Serve with the optional settings.
+func ServeWithOptions(port int, handler Handler, options Options) (*Server, error) {
 	address := fmt.Sprintf("localhost:%d", port)
 	listener, err := net.Listen("tcp", address)
 	if err != nil {
@@ -28,21 +105,45 @@ func Serve(port int, handler Handler) (*Server, error) {
 	closed := atomic.Bool{}
 	closed.Store(false)
 
+	if options.TLSConfig != nil {
+		// The protocols are offered in a stable order with HTTP/1.1 as the fallback.
+		options.TLSConfig = options.TLSConfig.Clone()
+		options.TLSConfig.NextProtos = append(slices.Sorted(maps.Keys(options.NextProtos)), "http/1.1")
+	}
+
+	maxBodySize := options.MaxBodySize
+	if maxBodySize == 0 {
+		maxBodySize = request.DefaultMaxBodySize
+	}
+
+	// The limit is passed to the ConnHandlers with the context so that the
+	// requests have the same limit with every protocol.
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), maxBodySizeKey{}, max(maxBodySize, 0)))
+
 	server := Server{
 		listener: listener,
 		closed:   &closed,
 		handler:  handler,
+		options:  options,
+		ctx:      ctx,
+		cancel:   cancel,
 	}
 
 	go server.listen()
 
-	slog.Info("HTTP server is now accepting web requests", "address", address)
+	slog.Info("HTTP server is now accepting web requests", "address", listener.Addr().String())
 
 	return &server, nil
 }
 
+// Addr returns the network address that the server is listening on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
 func (s *Server) Close() error {
 	s.closed.Store(true)
+	s.cancel()
 
 	if err := s.listener.Close(); err != nil {
 		return fmt.Errorf("error closing the listener: %w", err)
@@ -71,16 +172,154 @@ func (s *Server) listen() {
 }
 
 func (s *Server) handle(conn net.Conn) {
+	hijacked := false
+
+	// A hijacked connection belongs to the handler.
+	defer func() {
+		if !hijacked {
+			closeConnection(conn)
+		}
+	}()
+
+	if s.options.TLSConfig != nil {
+		tlsConn, ok := s.handshake(conn)
+		if !ok {
+			return
+		}
+
+		conn = tlsConn
+
+		if handler, ok := s.options.NextProtos[tlsConn.ConnectionState().NegotiatedProtocol]; ok {
+			hijacked = true
+
+			handler(s.ctx, conn, nil)
+
+			return
+		}
+	}
+
+	// The context of the requests is cancelled when the client closes the
+	// connection or once the connection is no longer served.
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+
+	connReader := newConnReader(conn, cancel)
+
+	reader := request.NewReader(connReader)
+	defer reader.Release()
+
+	reader.SetMaxBodySize(MaxBodySize(s.ctx))
+
+	// The handler that hijacks the connection gets the data that was read ahead.
+	buffered := func() []byte {
+		connReader.abortPendingRead()
+
+		return append(reader.Buffered(), connReader.pending()...)
+	}
+
+	if s.options.PriorKnowledge != nil && hasHTTP2Preface(reader) {
+		hijacked = true
+
+		s.options.PriorKnowledge(s.ctx, conn, reader.Buffered())
+
+		return
+	}
+
+	// Requests are handled one at a time in the order that they are received
+	// so that the responses to pipelined requests are sent in the same order.
+	for {
+		resp := response.NewConnWriter(conn, buffered)
+
+		req, err := reader.ReadRequest()
+		if err != nil {
+			// The client closed the connection.
+			if errors.Is(err, io.EOF) {
+				return
+			}
+
+			slog.Error("error parsing the request.", "error", err.Error())
+
+			WriteRequestError(resp, err)
+
+			return
+		}
+
+		req.RemoteAddr = conn.RemoteAddr().String()
+
+		// The client can only be seen closing the connection if the next request
+		// has not been received yet.
+		if len(reader.Buffered()) == 0 {
+			connReader.startBackgroundRead()
+		}
+
+		s.handler(resp, req.WithContext(ctx))
+
+		connReader.abortPendingRead()
+
+		// The connection no longer speaks HTTP once it has been hijacked.
+		if resp.Hijacked() {
+			hijacked = true
+
+			return
+		}
+
+		if req.Close || !resp.KeepAlive() {
+			return
+		}
+	}
+}
+
+// handshake performs the TLS handshake of a new connection. The connection is closed
+// by the caller if the handshake fails.
+func (s *Server) handshake(conn net.Conn) (*tls.Conn, bool) {
+	tlsConn := tls.Server(conn, s.options.TLSConfig)
+
+	ctx, cancel := context.WithTimeout(s.ctx, handshakeTimeout)
+	defer cancel()
+
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		slog.Error("error performing the TLS handshake.", "error", err.Error())
+
+		return nil, false
+	}
+
+	return tlsConn, true
+}
+
+// hasHTTP2Preface returns true if the connection starts with the HTTP/2 connection
+// preface. The start of the preface is checked first so that a short HTTP/1.1 request
+// is not held up waiting for the full length of the preface.
+func hasHTTP2Preface(reader *request.Reader) bool {
+	start, err := reader.Peek(4)
+	if err != nil || string(start) != http2Preface[:4] {
+		return false
+	}
+
+	preface, err := reader.Peek(len(http2Preface))
+
+	return err == nil && string(preface) == http2Preface
+}
+
+// closeConnection closes the connection after giving the client a chance to read
+// the last response. Closing a TCP connection that still has unread data from the
+// client (e.g. pipelined requests after a bad request) causes a reset which can
+// discard the response before the client reads it.
+func closeConnection(conn net.Conn) {
 	defer conn.Close()
 
-	req, err := request.RequestFromReader(conn)
-	if err != nil {
-		slog.Error("error parsing the request.", "error", err.Error())
+	// A TLS connection sends a close_notify alert instead of closing the TCP stream.
+	writeCloser, ok := conn.(interface{ CloseWrite() error })
+	if !ok {
+		return
+	}
 
+	if err := writeCloser.CloseWrite(); err != nil {
 		return
 	}
 
-	resp := response.NewWriter(conn)
+	if err := conn.SetReadDeadline(time.Now().Add(lingerTimeout)); err != nil {
+		return
+	}
 
-	s.handler(resp, req)
+	_, _ = io.Copy(io.Discard, conn)
 }
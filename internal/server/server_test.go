@@ -0,0 +1,233 @@
+package server
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"http-from-tcp/internal/headers"
+	"http-from-tcp/internal/request"
+	"http-from-tcp/internal/response"
+)
+
+// echoTargetHandler responds with the request target and the body of the request.
+func echoTargetHandler(w *response.Writer, req *request.Request) {
+	body := req.RequestLine.RequestTarget + string(req.Body)
+
+	_ = w.WriteStatusLine(response.StatusCodeOK)
+
+	h := headers.NewHeaders()
+	h[response.HeaderContentLength] = strconv.Itoa(len(body))
+
+	_ = w.WriteHeaders(h)
+	_, _ = w.WriteBody([]byte(body))
+}
+
+// startServer starts the server on a random port and returns a connection to the server.
+func startServer(t *testing.T, handler Handler) net.Conn {
+	t.Helper()
+
+	server, err := Serve(0, handler)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = server.Close() })
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	return conn
+}
+
+func TestPipelinedRequests(t *testing.T) {
+	conn := startServer(t, echoTargetHandler)
+
+	// Test: Several pipelined requests in a single write
+	_, err := conn.Write([]byte(
+		"GET /first HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+			"POST /second HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello" +
+			"POST /third HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n" +
+			"GET /fourth HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n",
+	))
+	require.NoError(t, err)
+
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		"HTTP/1.1 200 OK\r\nContent-Length: 6\r\n\r\n/first"+
+			"HTTP/1.1 200 OK\r\nContent-Length: 12\r\n\r\n/secondhello"+
+			"HTTP/1.1 200 OK\r\nContent-Length: 9\r\n\r\n/thirdabc"+
+			"HTTP/1.1 200 OK\r\nContent-Length: 7\r\n\r\n/fourth",
+		string(data),
+	)
+}
+
+func TestPipelinedRequestsWithBadRequest(t *testing.T) {
+	conn := startServer(t, echoTargetHandler)
+
+	// Test: The connection is closed after the response to an invalid request
+	// and the requests after it are not handled.
+	_, err := conn.Write([]byte(
+		"GET /first HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+			"GET /second HTTP/1.1\r\n\r\n" +
+			"GET /third HTTP/1.1\r\nHost: localhost\r\n\r\n",
+	))
+	require.NoError(t, err)
+
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "HTTP/1.1 200 OK\r\nContent-Length: 6\r\n\r\n/first")
+	assert.Contains(t, string(data), "HTTP/1.1 400 Bad Request\r\n")
+	assert.NotContains(t, string(data), "/third")
+}
+
+func TestConnectionClosedAfterUndelimitedResponse(t *testing.T) {
+	conn := startServer(t, func(w *response.Writer, _ *request.Request) {
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+		_ = w.WriteHeaders(headers.NewHeaders())
+		_, _ = w.WriteBody([]byte("no length"))
+	})
+
+	// Test: A response without a Content-Length is delimited by closing the connection
+	_, err := conn.Write([]byte(
+		"GET /first HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+			"GET /second HTTP/1.1\r\nHost: localhost\r\n\r\n",
+	))
+	require.NoError(t, err)
+
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n\r\nno length", string(data))
+}
+
+func TestHijack(t *testing.T) {
+	conn := startServer(t, func(w *response.Writer, _ *request.Request) {
+		hijacked, buffered, err := w.Hijack()
+		if err != nil {
+			return
+		}
+
+		// The connection is used after the handler returns.
+		go func() {
+			defer hijacked.Close()
+
+			_, _ = hijacked.Write([]byte("buffered: " + string(buffered) + "\n"))
+
+			data := make([]byte, 5)
+			if _, err := io.ReadFull(hijacked, data); err != nil {
+				return
+			}
+
+			_, _ = hijacked.Write([]byte("read: " + string(data) + "\n"))
+		}()
+	})
+
+	// Test: The data after the request is handed to the handler
+	_, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\nearly"))
+	require.NoError(t, err)
+
+	buf := make([]byte, len("buffered: early\n"))
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "buffered: early\n", string(buf))
+
+	// Test: The server does not close the hijacked connection
+	time.Sleep(50 * time.Millisecond)
+
+	_, err = conn.Write([]byte("later"))
+	require.NoError(t, err)
+
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "read: later\n", string(data))
+}
+
+func TestContextCancelledOnDisconnect(t *testing.T) {
+	cancelled := make(chan struct{})
+
+	conn := startServer(t, func(_ *response.Writer, req *request.Request) {
+		select {
+		case <-req.Context().Done():
+			close(cancelled)
+		case <-time.After(5 * time.Second):
+		}
+	})
+
+	// Test: The context of the request is cancelled when the client closes the
+	// connection while the handler runs
+	_, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, conn.Close())
+
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the context of the request was not cancelled")
+	}
+}
+
+func TestPipelinedRequestReadInBackground(t *testing.T) {
+	received := make(chan struct{})
+
+	conn := startServer(t, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/first" {
+			// The next request arrives while the handler runs.
+			<-received
+		}
+
+		assert.NoError(t, req.Context().Err())
+
+		echoTargetHandler(w, req)
+	})
+
+	// Test: The data read in the background while a handler runs is the start of
+	// the next request
+	_, err := conn.Write([]byte("GET /first HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	time.Sleep(50 * time.Millisecond)
+
+	_, err = conn.Write([]byte("GET /second HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+
+	time.Sleep(50 * time.Millisecond)
+	close(received)
+
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		"HTTP/1.1 200 OK\r\nContent-Length: 6\r\n\r\n/first"+
+			"HTTP/1.1 200 OK\r\nContent-Length: 7\r\n\r\n/second",
+		string(data),
+	)
+}
+
+func TestMaxBodySize(t *testing.T) {
+	server, err := ServeWithOptions(0, echoTargetHandler, Options{MaxBodySize: 10})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = server.Close() })
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	// Test: A body over the limit of the server is rejected
+	_, err = conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 11\r\n\r\nhello world"))
+	require.NoError(t, err)
+
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "HTTP/1.1 413 Content Too Large\r\n")
+}
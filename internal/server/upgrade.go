@@ -0,0 +1,69 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"http-from-tcp/internal/request"
+	"http-from-tcp/internal/response"
+)
+
+// UpgradeMux dispatches the requests that ask to switch the connection to another
+// protocol (RFC 9110, section 7.8) to the handler registered for the protocol. The
+// handler accepts the upgrade with response.Writer.SwitchProtocols or refuses it with
+// a normal response. The requests without an Upgrade header, or for protocols without
+// a handler, are handled by the next handler as a server can ignore the Upgrade header.
+type UpgradeMux struct {
+	protocols map[string]Handler
+	next      Handler
+}
+
+// NewUpgradeMux returns a new UpgradeMux. If the next handler is nil then a 404 Not
+// Found response is written for the requests that are not upgraded.
+func NewUpgradeMux(next Handler) *UpgradeMux {
+	return &UpgradeMux{
+		protocols: make(map[string]Handler),
+		next:      next,
+	}
+}
+
+// Handle registers the handler for the protocol (e.g. websocket or h2c). The protocol
+// is compared case-insensitively and can include a version (e.g. HTTP/2.0).
+func (m *UpgradeMux) Handle(protocol string, handler Handler) error {
+	if handler == nil {
+		return fmt.Errorf("no handler specified for the protocol %q", protocol)
+	}
+
+	protocol = strings.ToLower(strings.TrimSpace(protocol))
+	if protocol == "" || strings.ContainsAny(protocol, ", \t") {
+		return fmt.Errorf("invalid protocol %q", protocol)
+	}
+
+	m.protocols[protocol] = handler
+
+	return nil
+}
+
+// Dispatch sends the request to the handler of the first protocol in the Upgrade
+// header that has a handler. The client lists the protocols in the order of its
+// preference. The Connection header must have the upgrade option as the Upgrade
+// header is otherwise meant for another hop.
+func (m *UpgradeMux) Dispatch(w *response.Writer, req *request.Request) {
+	if req.Headers.HasToken("connection", "upgrade") {
+		for protocol := range strings.SplitSeq(req.Headers.Get("upgrade"), ",") {
+			if handler, ok := m.protocols[strings.ToLower(strings.TrimSpace(protocol))]; ok {
+				handler(w, req)
+
+				return
+			}
+		}
+	}
+
+	if m.next == nil {
+		notFoundHandler(w, req)
+
+		return
+	}
+
+	m.next(w, req)
+}
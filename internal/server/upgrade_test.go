@@ -0,0 +1,134 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"http-from-tcp/internal/request"
+	"http-from-tcp/internal/response"
+)
+
+// lineEchoHandler switches to a protocol that echoes each line back in upper case.
+func lineEchoHandler(w *response.Writer, _ *request.Request) {
+	conn, buffered, err := w.SwitchProtocols("echo/1", nil)
+	if err != nil {
+		return
+	}
+
+	defer conn.Close()
+
+	_, _ = conn.Write([]byte("buffered: " + string(buffered) + "\n"))
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return
+	}
+
+	_, _ = conn.Write([]byte("echo: " + line))
+}
+
+func TestUpgradeMux(t *testing.T) {
+	mux := NewUpgradeMux(echoTargetHandler)
+	require.NoError(t, mux.Handle("Echo/1", lineEchoHandler))
+
+	// Test: Invalid protocols
+	require.Error(t, mux.Handle("", lineEchoHandler))
+	require.Error(t, mux.Handle("a, b", lineEchoHandler))
+	require.Error(t, mux.Handle("other", nil))
+
+	// Test: The first supported protocol in the Upgrade header is selected
+	conn := startServer(t, mux.Dispatch)
+
+	_, err := conn.Write([]byte("GET /chat HTTP/1.1\r\nHost: localhost\r\n" +
+		"Connection: keep-alive, Upgrade\r\nUpgrade: unknown, echo/1\r\n\r\nearly"))
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+
+	resp, err := http.ReadResponse(reader, &http.Request{Method: "GET"})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	assert.Equal(t, "echo/1", resp.Header.Get("Upgrade"))
+	assert.Equal(t, "Upgrade", resp.Header.Get("Connection"))
+
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "buffered: early\n", line)
+
+	_, err = conn.Write([]byte("hello\n"))
+	require.NoError(t, err)
+
+	rest, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "echo: hello\n", string(rest))
+
+	// Test: Requests that are not upgraded
+	for _, upgradeHeaders := range []string{
+		"",
+		"Connection: Upgrade\r\nUpgrade: unknown\r\n",
+		"Upgrade: echo/1\r\n",
+	} {
+		conn := startServer(t, mux.Dispatch)
+
+		_, err := conn.Write([]byte("GET /plain HTTP/1.1\r\nHost: localhost\r\n" + upgradeHeaders + "\r\n"))
+		require.NoError(t, err)
+
+		resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "GET"})
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "/plain", string(body))
+	}
+}
+
+func TestPriorKnowledge(t *testing.T) {
+	server, err := ServeWithOptions(0, echoTargetHandler, Options{
+		PriorKnowledge: func(_ context.Context, conn net.Conn, buffered []byte) {
+			defer conn.Close()
+
+			_, _ = conn.Write([]byte("h2: " + string(buffered)))
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = server.Close() })
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", server.Addr().String())
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = conn.Close() })
+
+		require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+		return conn
+	}
+
+	// Test: A connection that starts with the preface is handed over
+	conn := dial()
+
+	_, err = conn.Write([]byte(http2Preface + "frames"))
+	require.NoError(t, err)
+
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "h2: "+http2Preface+"frames", string(data))
+
+	// Test: HTTP/1.1 requests on the same listener
+	conn = dial()
+
+	_, err = conn.Write([]byte("GET /h1 HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+
+	data, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\n/h1", string(data))
+}
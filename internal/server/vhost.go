@@ -0,0 +1,99 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"http-from-tcp/internal/request"
+	"http-from-tcp/internal/response"
+)
+
+// HostMux dispatches requests to handlers based on the host of the request.
+// A pattern is either an exact host name (e.g. example.com) or a wildcard
+// (e.g. *.example.com) that matches any subdomain of the host name.
+type HostMux struct {
+	hosts          map[string]Handler
+	wildcards      map[string]Handler
+	defaultHandler Handler
+}
+
+// NewHostMux returns a new HostMux. The default handler handles the requests for
+// hosts that do not match any of the registered patterns. If the default handler
+// is nil then a 404 Not Found response is written for these requests.
+func NewHostMux(defaultHandler Handler) *HostMux {
+	return &HostMux{
+		hosts:          make(map[string]Handler),
+		wildcards:      make(map[string]Handler),
+		defaultHandler: defaultHandler,
+	}
+}
+
+// Handle registers the handler for the host pattern.
+func (m *HostMux) Handle(pattern string, handler Handler) error {
+	if handler == nil {
+		return fmt.Errorf("no handler specified for the host pattern %q", pattern)
+	}
+
+	host := normaliseHost(pattern)
+
+	if suffix, ok := strings.CutPrefix(host, "*."); ok {
+		if suffix == "" || strings.Contains(suffix, "*") {
+			return fmt.Errorf("invalid wildcard host pattern %q", pattern)
+		}
+
+		m.wildcards["."+suffix] = handler
+
+		return nil
+	}
+
+	if host == "" || strings.Contains(host, "*") {
+		return fmt.Errorf("invalid host pattern %q", pattern)
+	}
+
+	m.hosts[host] = handler
+
+	return nil
+}
+
+// Dispatch sends the request to the handler registered for the host of the request.
+// An exact match takes precedence over a wildcard match and the longest matching
+// wildcard takes precedence over the shorter ones.
+func (m *HostMux) Dispatch(w *response.Writer, req *request.Request) {
+	m.handler(req.Host)(w, req)
+}
+
+func (m *HostMux) handler(host string) Handler {
+	if handler, ok := m.hosts[host]; ok {
+		return handler
+	}
+
+	// Strip the labels from the left of the host one at a time so that the
+	// most specific wildcard is found first.
+	for idx := strings.Index(host, "."); idx != -1; idx = strings.Index(host, ".") {
+		if handler, ok := m.wildcards[host[idx:]]; ok {
+			return handler
+		}
+
+		host = host[idx+1:]
+	}
+
+	if m.defaultHandler != nil {
+		return m.defaultHandler
+	}
+
+	return notFoundHandler
+}
+
+func notFoundHandler(w *response.Writer, _ *request.Request) {
+	if err := w.WriteError(
+		response.StatusCodeNotFound,
+		response.StatusText(response.StatusCodeNotFound),
+	); err != nil {
+		slog.Error("error writing the error response.", "error", err.Error())
+	}
+}
+
+func normaliseHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"http-from-tcp/internal/request"
+	"http-from-tcp/internal/response"
+)
+
+func TestHostMux(t *testing.T) {
+	handlerFor := func(name string) Handler {
+		return func(w *response.Writer, _ *request.Request) {
+			_, _ = w.WriteBody([]byte(name))
+		}
+	}
+
+	mux := NewHostMux(handlerFor("default"))
+	require.NoError(t, mux.Handle("example.com", handlerFor("example")))
+	require.NoError(t, mux.Handle("*.example.com", handlerFor("wildcard")))
+	require.NoError(t, mux.Handle("*.api.example.com", handlerFor("api-wildcard")))
+	require.NoError(t, mux.Handle("Docs.Example.com.", handlerFor("docs")))
+
+	// Test: Invalid patterns
+	require.Error(t, mux.Handle("", handlerFor("empty")))
+	require.Error(t, mux.Handle("*.", handlerFor("empty-wildcard")))
+	require.Error(t, mux.Handle("www.*.com", handlerFor("inner-wildcard")))
+	require.Error(t, mux.Handle("example.org", nil))
+
+	testCases := map[string]string{
+		"example.com":           "example",
+		"docs.example.com":      "docs",
+		"www.example.com":       "wildcard",
+		"a.b.example.com":       "wildcard",
+		"v1.api.example.com":    "api-wildcard",
+		"api.example.com":       "wildcard",
+		"example.org":           "default",
+		"notexample.com":        "default",
+		"www.example.com.extra": "default",
+		"":                      "default",
+	}
+
+	for host, want := range testCases {
+		buf := new(bytes.Buffer)
+		w := response.NewWriter(buf)
+		require.NoError(t, w.WriteStatusLine(response.StatusCodeOK))
+		require.NoError(t, w.WriteHeaders(nil))
+
+		mux.Dispatch(w, &request.Request{Host: host})
+		assert.Equal(t, "HTTP/1.1 200 OK\r\n\r\n"+want, buf.String(), "host: %s", host)
+	}
+
+	// Test: The authority of an absolute-form target is used instead of the Host header
+	req, err := request.RequestFromReader(strings.NewReader("GET http://docs.example.com/ HTTP/1.1\r\nHost: example.org\r\n\r\n"))
+	require.NoError(t, err)
+
+	buf := new(bytes.Buffer)
+	w := response.NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(response.StatusCodeOK))
+	require.NoError(t, w.WriteHeaders(nil))
+
+	mux.Dispatch(w, req)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n\r\ndocs", buf.String())
+
+	// Test: No default handler
+	mux = NewHostMux(nil)
+	buf = new(bytes.Buffer)
+	mux.Dispatch(response.NewWriter(buf), &request.Request{Host: "example.com"})
+	assert.Contains(t, buf.String(), "HTTP/1.1 404 Not Found\r\n")
+}
@@ -0,0 +1,167 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const minSigningKeySize = 32
+
+var errInvalidCookie = errors.New("invalid session cookie")
+
+// cookiePayload is the data in the session cookie. ID is only set when the
+// session data is kept in a Store.
+type cookiePayload struct {
+	ID      string            `json:"id,omitempty"`
+	Values  map[string]string `json:"v,omitempty"`
+	Expires int64             `json:"e"`
+}
+
+func encodePayload(payload cookiePayload) ([]byte, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding the session: %w", err)
+	}
+
+	return data, nil
+}
+
+func decodePayload(data []byte) (cookiePayload, error) {
+	var payload cookiePayload
+
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return cookiePayload{}, errInvalidCookie
+	}
+
+	return payload, nil
+}
+
+// codec protects the data in the session cookie. The name of the cookie is bound
+// to the value so that a value cannot be moved to a cookie with a different name.
+type codec interface {
+	encode(name string, data []byte) (string, error)
+	decode(name, value string) ([]byte, error)
+}
+
+// signingCodec signs the cookie with HMAC-SHA256. The value of the cookie is
+// the base64 encoded data and the base64 encoded signature separated by a dot.
+type signingCodec struct {
+	keys [][]byte
+}
+
+func newSigningCodec(keys [][]byte) (signingCodec, error) {
+	for idx := range keys {
+		if len(keys[idx]) < minSigningKeySize {
+			return signingCodec{}, fmt.Errorf(
+				"the session key at index %d is too short: want at least %d bytes, got %d bytes",
+				idx,
+				minSigningKeySize,
+				len(keys[idx]),
+			)
+		}
+	}
+
+	return signingCodec{keys: keys}, nil
+}
+
+func (c signingCodec) encode(name string, data []byte) (string, error) {
+	encoded := base64.RawURLEncoding.EncodeToString(data)
+
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(c.sign(c.keys[0], name, encoded)), nil
+}
+
+func (c signingCodec) decode(name, value string) ([]byte, error) {
+	encoded, encodedSignature, found := strings.Cut(value, ".")
+	if !found {
+		return nil, errInvalidCookie
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return nil, errInvalidCookie
+	}
+
+	for _, key := range c.keys {
+		if hmac.Equal(signature, c.sign(key, name, encoded)) {
+			data, err := base64.RawURLEncoding.DecodeString(encoded)
+			if err != nil {
+				return nil, errInvalidCookie
+			}
+
+			return data, nil
+		}
+	}
+
+	return nil, errInvalidCookie
+}
+
+func (c signingCodec) sign(key []byte, name, encoded string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name + "|" + encoded))
+
+	return mac.Sum(nil)
+}
+
+// encryptionCodec encrypts the cookie with AES-GCM. The value of the cookie is
+// the base64 encoded nonce followed by the ciphertext.
+type encryptionCodec struct {
+	aeads []cipher.AEAD
+}
+
+func newEncryptionCodec(keys [][]byte) (encryptionCodec, error) {
+	aeads := make([]cipher.AEAD, len(keys))
+
+	for idx := range keys {
+		block, err := aes.NewCipher(keys[idx])
+		if err != nil {
+			return encryptionCodec{}, fmt.Errorf("invalid session key at index %d: %w", idx, err)
+		}
+
+		aeads[idx], err = cipher.NewGCM(block)
+		if err != nil {
+			return encryptionCodec{}, fmt.Errorf("error creating the cipher for the session key at index %d: %w", idx, err)
+		}
+	}
+
+	return encryptionCodec{aeads: aeads}, nil
+}
+
+func (c encryptionCodec) encode(name string, data []byte) (string, error) {
+	aead := c.aeads[0]
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("error generating the nonce: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, data, []byte(name))), nil
+}
+
+func (c encryptionCodec) decode(name, value string) ([]byte, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errInvalidCookie
+	}
+
+	for _, aead := range c.aeads {
+		if len(sealed) < aead.NonceSize() {
+			continue
+		}
+
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+
+		if data, err := aead.Open(nil, nonce, ciphertext, []byte(name)); err == nil {
+			return data, nil
+		}
+	}
+
+	return nil, errInvalidCookie
+}
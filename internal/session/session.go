@@ -0,0 +1,350 @@
+// Package session implements sessions that are kept in a signed or an encrypted
+// cookie. The session data is either stored in the cookie itself or, if a Store is
+// configured, on the server with only the session ID in the cookie.
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"http-from-tcp/internal/headers"
+	"http-from-tcp/internal/request"
+	"http-from-tcp/internal/response"
+	"http-from-tcp/internal/server"
+)
+
+const (
+	defaultCookieName = "session"
+	defaultMaxAge     = 24 * time.Hour
+
+	// maxCookieSize is the maximum size of a cookie that clients are required
+	// to support (RFC 6265, section 6.1).
+	maxCookieSize = 4096
+)
+
+// Options are the options for the session cookie.
+type Options struct {
+	// CookieName is the name of the session cookie. The default name is "session".
+	CookieName string
+
+	Domain   string
+	Path     string
+	Secure   bool
+	SameSite headers.SameSite
+
+	// MaxAge is how long a session lasts after it was last saved. The default
+	// is 24 hours.
+	MaxAge time.Duration
+
+	// Encrypt encrypts the cookie with AES-GCM instead of signing it with
+	// HMAC-SHA256. The data in a signed cookie can be read by the client.
+	Encrypt bool
+
+	// Store stores the session data on the server. The data is stored in the
+	// cookie if Store is nil.
+	Store Store
+}
+
+// Session is the session of a client.
+type Session struct {
+	id        string
+	values    map[string]string
+	modified  bool
+	destroyed bool
+	renew     bool
+}
+
+// ID returns the ID of the session. The ID is empty if the session data is
+// stored in the cookie.
+func (s *Session) ID() string {
+	return s.id
+}
+
+// Get returns the value for the key. false is returned if the session does not
+// have the key.
+func (s *Session) Get(key string) (string, bool) {
+	value, ok := s.values[key]
+
+	return value, ok
+}
+
+// Set sets the value for the key.
+func (s *Session) Set(key, value string) {
+	s.values[key] = value
+	s.modified = true
+}
+
+// Delete deletes the key from the session.
+func (s *Session) Delete(key string) {
+	if _, ok := s.values[key]; !ok {
+		return
+	}
+
+	delete(s.values, key)
+	s.modified = true
+}
+
+// Destroy deletes all of the session data and asks the client to delete the
+// session cookie.
+func (s *Session) Destroy() {
+	clear(s.values)
+	s.destroyed = true
+}
+
+// RenewID asks for the session to be saved with a new ID. The previous ID is
+// deleted from the store so that an ID that was known before a login or a change
+// of privileges cannot be used after it. RenewID has no effect if the session
+// data is stored in the cookie.
+func (s *Session) RenewID() {
+	s.renew = true
+	s.modified = true
+}
+
+type contextKey struct{}
+
+// FromRequest returns the session of the request. nil is returned if the request
+// was not handled by the Manager's middleware.
+func FromRequest(req *request.Request) *Session {
+	session, _ := req.Context().Value(contextKey{}).(*Session)
+
+	return session
+}
+
+// Manager loads and saves the sessions.
+type Manager struct {
+	codec   codec
+	options Options
+	now     func() time.Time
+}
+
+// NewManager returns a new Manager. The first key is used to sign or encrypt new
+// cookies and all of the keys are used to verify or decrypt the received cookies so
+// that the keys can be rotated without ending the existing sessions. The keys must be
+// at least 32 bytes for signing and exactly 16, 24 or 32 bytes for encryption.
+func NewManager(keys [][]byte, options Options) (*Manager, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no session keys specified")
+	}
+
+	var (
+		sessionCodec codec
+		err          error
+	)
+
+	if options.Encrypt {
+		sessionCodec, err = newEncryptionCodec(keys)
+	} else {
+		sessionCodec, err = newSigningCodec(keys)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if options.CookieName == "" {
+		options.CookieName = defaultCookieName
+	}
+
+	if options.Path == "" {
+		options.Path = "/"
+	}
+
+	if options.MaxAge <= 0 {
+		options.MaxAge = defaultMaxAge
+	}
+
+	// Validate the cookie options once here rather than for every response.
+	cookie := headers.Cookie{
+		Name:     options.CookieName,
+		Domain:   options.Domain,
+		Path:     options.Path,
+		Secure:   options.Secure,
+		SameSite: options.SameSite,
+	}
+
+	if err := cookie.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid session cookie options: %w", err)
+	}
+
+	return &Manager{
+		codec:   sessionCodec,
+		options: options,
+		now:     time.Now,
+	}, nil
+}
+
+// Middleware loads the session for each request and saves it before the headers of
+// the response are written. The handler gets the session with FromRequest.
+func (m *Manager) Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		session := m.Load(req)
+
+		w.BeforeWriteHeaders(func() {
+			if err := m.Save(w, session); err != nil {
+				slog.Error("error saving the session.", "error", err.Error())
+			}
+		})
+
+		next(w, req.WithContext(context.WithValue(req.Context(), contextKey{}, session)))
+	}
+}
+
+// Load returns the session from the session cookie of the request. A new, empty
+// session is returned if the cookie is missing, invalid or has expired.
+func (m *Manager) Load(req *request.Request) *Session {
+	session := &Session{
+		id:        "",
+		values:    make(map[string]string),
+		modified:  false,
+		destroyed: false,
+	}
+
+	cookie, ok := req.Cookie(m.options.CookieName)
+	if !ok {
+		return session
+	}
+
+	data, err := m.codec.decode(m.options.CookieName, cookie.Value)
+	if err != nil {
+		return session
+	}
+
+	payload, err := decodePayload(data)
+	if err != nil || !m.now().Before(time.Unix(payload.Expires, 0)) {
+		return session
+	}
+
+	if m.options.Store == nil {
+		if payload.Values != nil {
+			session.values = payload.Values
+		}
+
+		return session
+	}
+
+	values, ok, err := m.options.Store.Load(payload.ID)
+	if err != nil {
+		slog.Error("error loading the session from the store.", "error", err.Error())
+
+		return session
+	}
+
+	if ok {
+		session.id = payload.ID
+		session.values = values
+	}
+
+	return session
+}
+
+// Save adds the session cookie to the response if the session was modified or
+// destroyed. Save must be called before the headers of the response are written.
+func (m *Manager) Save(w *response.Writer, session *Session) error {
+	switch {
+	case session.destroyed:
+		return m.destroy(w, session)
+	case !session.modified:
+		return nil
+	}
+
+	expires := m.now().Add(m.options.MaxAge)
+	payload := cookiePayload{ID: "", Values: nil, Expires: expires.Unix()}
+
+	if m.options.Store == nil {
+		payload.Values = session.values
+	} else {
+		// The ID only changes when it is renewed so that the concurrent requests
+		// of a client keep using the same session.
+		id := session.id
+		if id == "" || session.renew {
+			var err error
+
+			id, err = newSessionID()
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := m.options.Store.Save(id, session.values, expires); err != nil {
+			return fmt.Errorf("error saving the session to the store: %w", err)
+		}
+
+		if session.id != "" && session.id != id {
+			if err := m.options.Store.Delete(session.id); err != nil {
+				return fmt.Errorf("error deleting the previous session from the store: %w", err)
+			}
+		}
+
+		session.id = id
+		payload.ID = id
+	}
+
+	data, err := encodePayload(payload)
+	if err != nil {
+		return err
+	}
+
+	value, err := m.codec.encode(m.options.CookieName, data)
+	if err != nil {
+		return err
+	}
+
+	cookie := m.cookie(value)
+	cookie.Expires = expires
+	cookie.MaxAge = int(m.options.MaxAge / time.Second)
+
+	if size := len(cookie.String()); size > maxCookieSize {
+		return fmt.Errorf("the session cookie is too large (%d bytes)", size)
+	}
+
+	session.modified = false
+	session.renew = false
+
+	return w.AddCookie(cookie)
+}
+
+func (m *Manager) destroy(w *response.Writer, session *Session) error {
+	if m.options.Store != nil && session.id != "" {
+		if err := m.options.Store.Delete(session.id); err != nil {
+			return fmt.Errorf("error deleting the session from the store: %w", err)
+		}
+
+		session.id = ""
+	}
+
+	cookie := m.cookie("")
+	cookie.MaxAge = -1
+
+	return w.AddCookie(cookie)
+}
+
+func (m *Manager) cookie(value string) headers.Cookie {
+	return headers.Cookie{
+		Name:        m.options.CookieName,
+		Value:       value,
+		Domain:      m.options.Domain,
+		Path:        m.options.Path,
+		Expires:     time.Time{},
+		MaxAge:      0,
+		Secure:      m.options.Secure,
+		HttpOnly:    true,
+		Partitioned: false,
+		SameSite:    m.options.SameSite,
+	}
+}
+
+// newSessionID returns a random session ID with 256 bits of entropy.
+func newSessionID() (string, error) {
+	id := make([]byte, 32)
+
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("error generating the session ID: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(id), nil
+}
@@ -0,0 +1,272 @@
+package session
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"http-from-tcp/internal/request"
+	"http-from-tcp/internal/response"
+	"http-from-tcp/internal/server"
+)
+
+var (
+	testKey    = bytes.Repeat([]byte("k"), 32)
+	testOldKey = bytes.Repeat([]byte("o"), 32)
+)
+
+// serve runs the handler through the session middleware for a request with the
+// given session cookie and returns the value of the Set-Cookie header from the
+// response. An empty string is returned if the response does not set a cookie.
+func serve(t *testing.T, manager *Manager, cookie string, handler server.Handler) string {
+	t.Helper()
+
+	raw := "GET / HTTP/1.1\r\nHost: localhost\r\n"
+	if cookie != "" {
+		raw += "Cookie: session=" + cookie + "\r\n"
+	}
+
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+
+	buf := new(bytes.Buffer)
+	w := response.NewWriter(buf)
+
+	manager.Middleware(func(w *response.Writer, req *request.Request) {
+		handler(w, req)
+		require.NoError(t, w.WriteStatusLine(response.StatusCodeOK))
+		require.NoError(t, w.WriteHeaders(response.GetDefaultHeaders(0)))
+	})(w, req)
+
+	for line := range strings.SplitSeq(buf.String(), "\r\n") {
+		if value, ok := strings.CutPrefix(line, "Set-Cookie: "); ok {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// cookieValue returns the value of the cookie from the Set-Cookie header value.
+func cookieValue(setCookie string) string {
+	pair, _, _ := strings.Cut(setCookie, ";")
+	_, value, _ := strings.Cut(pair, "=")
+
+	return value
+}
+
+func TestSessions(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		name := "Signed"
+		if encrypt {
+			name = "Encrypted"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			manager, err := NewManager([][]byte{testKey}, Options{Encrypt: encrypt})
+			require.NoError(t, err)
+
+			// Test: A new session is saved when it is modified
+			setCookie := serve(t, manager, "", func(_ *response.Writer, req *request.Request) {
+				FromRequest(req).Set("user", "alice")
+			})
+			require.NotEmpty(t, setCookie)
+			assert.Contains(t, setCookie, "; Path=/")
+			assert.Contains(t, setCookie, "; Max-Age=86400")
+			assert.Contains(t, setCookie, "; HttpOnly")
+
+			value := cookieValue(setCookie)
+
+			if encrypt {
+				assert.NotContains(t, value, ".")
+			}
+
+			// Test: The session is loaded from the cookie and an unmodified
+			// session is not saved
+			setCookie = serve(t, manager, value, func(_ *response.Writer, req *request.Request) {
+				user, ok := FromRequest(req).Get("user")
+				assert.True(t, ok)
+				assert.Equal(t, "alice", user)
+			})
+			assert.Empty(t, setCookie)
+
+			// Test: A tampered cookie is ignored
+			tampered := []byte(value)
+			tampered[len(tampered)/2] ^= 0x01
+
+			serve(t, manager, string(tampered), func(_ *response.Writer, req *request.Request) {
+				_, ok := FromRequest(req).Get("user")
+				assert.False(t, ok)
+			})
+
+			// Test: An expired cookie is ignored
+			manager.now = func() time.Time { return time.Now().Add(25 * time.Hour) }
+
+			serve(t, manager, value, func(_ *response.Writer, req *request.Request) {
+				_, ok := FromRequest(req).Get("user")
+				assert.False(t, ok)
+			})
+
+			manager.now = time.Now
+
+			// Test: A destroyed session deletes the cookie
+			setCookie = serve(t, manager, value, func(_ *response.Writer, req *request.Request) {
+				FromRequest(req).Destroy()
+			})
+			assert.True(t, strings.HasPrefix(setCookie, "session=;"))
+			assert.Contains(t, setCookie, "; Max-Age=0")
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		oldManager, err := NewManager([][]byte{testOldKey}, Options{Encrypt: encrypt})
+		require.NoError(t, err)
+
+		value := cookieValue(serve(t, oldManager, "", func(_ *response.Writer, req *request.Request) {
+			FromRequest(req).Set("user", "bob")
+		}))
+
+		// Test: A cookie that was created with the old key is still accepted.
+		manager, err := NewManager([][]byte{testKey, testOldKey}, Options{Encrypt: encrypt})
+		require.NoError(t, err)
+
+		serve(t, manager, value, func(_ *response.Writer, req *request.Request) {
+			user, _ := FromRequest(req).Get("user")
+			assert.Equal(t, "bob", user)
+		})
+
+		// Test: The cookie is rejected once the old key is removed.
+		manager, err = NewManager([][]byte{testKey}, Options{Encrypt: encrypt})
+		require.NoError(t, err)
+
+		serve(t, manager, value, func(_ *response.Writer, req *request.Request) {
+			_, ok := FromRequest(req).Get("user")
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+
+	manager, err := NewManager([][]byte{testKey}, Options{Store: store})
+	require.NoError(t, err)
+
+	var firstID string
+
+	value := cookieValue(serve(t, manager, "", func(_ *response.Writer, req *request.Request) {
+		session := FromRequest(req)
+		session.Set("user", "carol")
+		firstID = session.ID()
+	}))
+	assert.Empty(t, firstID)
+	assert.Equal(t, 1, store.Len())
+
+	// Test: The cookie only contains the session ID
+	assert.NotContains(t, value, "carol")
+
+	// Test: The session is loaded from the store and keeps its ID when it is
+	// saved again
+	value = cookieValue(serve(t, manager, value, func(_ *response.Writer, req *request.Request) {
+		session := FromRequest(req)
+		firstID = session.ID()
+
+		user, _ := session.Get("user")
+		assert.Equal(t, "carol", user)
+
+		session.Set("theme", "dark")
+	}))
+	assert.NotEmpty(t, firstID)
+	assert.Equal(t, 1, store.Len())
+
+	// Test: A previous cookie is still valid after the session was saved, like
+	// the cookie of a concurrent request
+	previous := value
+
+	value = cookieValue(serve(t, manager, value, func(_ *response.Writer, req *request.Request) {
+		session := FromRequest(req)
+		assert.Equal(t, firstID, session.ID())
+
+		theme, _ := session.Get("theme")
+		assert.Equal(t, "dark", theme)
+
+		// Test: Renewing the ID deletes the previous ID from the store
+		session.RenewID()
+	}))
+	assert.Equal(t, 1, store.Len())
+
+	serve(t, manager, previous, func(_ *response.Writer, req *request.Request) {
+		session := FromRequest(req)
+		assert.Empty(t, session.ID())
+
+		_, ok := session.Get("theme")
+		assert.False(t, ok)
+	})
+
+	serve(t, manager, value, func(_ *response.Writer, req *request.Request) {
+		session := FromRequest(req)
+		assert.NotEqual(t, firstID, session.ID())
+
+		theme, _ := session.Get("theme")
+		assert.Equal(t, "dark", theme)
+
+		// Test: Destroying the session removes it from the store
+		session.Destroy()
+	})
+	assert.Equal(t, 0, store.Len())
+
+	serve(t, manager, value, func(_ *response.Writer, req *request.Request) {
+		_, ok := FromRequest(req).Get("user")
+		assert.False(t, ok)
+	})
+}
+
+func TestMemoryStoreSweep(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+
+	require.NoError(t, store.Save("a", map[string]string{}, now.Add(time.Second)))
+	require.NoError(t, store.Save("b", map[string]string{}, now.Add(time.Hour)))
+
+	// Test: An expired session cannot be loaded before it is swept
+	now = now.Add(2 * time.Second)
+
+	_, ok, err := store.Load("a")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, store.Save("c", map[string]string{}, now.Add(time.Hour)))
+	assert.Equal(t, 3, store.Len())
+
+	// Test: The expired sessions are removed once the sweep interval has passed
+	now = now.Add(sweepInterval)
+
+	require.NoError(t, store.Save("c", map[string]string{}, now.Add(time.Hour)))
+	assert.Equal(t, 2, store.Len())
+}
+
+func TestNewManager(t *testing.T) {
+	// Test: Invalid keys
+	_, err := NewManager(nil, Options{})
+	require.Error(t, err)
+
+	_, err = NewManager([][]byte{[]byte("short")}, Options{})
+	require.Error(t, err)
+
+	_, err = NewManager([][]byte{bytes.Repeat([]byte("k"), 20)}, Options{Encrypt: true})
+	require.Error(t, err)
+
+	// Test: Invalid cookie options
+	_, err = NewManager([][]byte{testKey}, Options{CookieName: "__Host-session"})
+	require.Error(t, err)
+
+	_, err = NewManager([][]byte{testKey}, Options{CookieName: "__Host-session", Secure: true})
+	require.NoError(t, err)
+}
@@ -0,0 +1,108 @@
+package session
+
+import (
+	"maps"
+	"sync"
+	"time"
+)
+
+// Store stores the session data on the server.
+type Store interface {
+	// Load returns the data of the session. false is returned if the session
+	// does not exist or has expired.
+	Load(id string) (map[string]string, bool, error)
+
+	// Save saves the data of the session until the expiry time.
+	Save(id string, values map[string]string, expires time.Time) error
+
+	// Delete deletes the session.
+	Delete(id string) error
+}
+
+// sweepInterval is the minimum time between two removals of the expired sessions
+// from a MemoryStore.
+const sweepInterval = time.Minute
+
+type memoryEntry struct {
+	values  map[string]string
+	expires time.Time
+}
+
+// MemoryStore is a Store that keeps the sessions in memory. The sessions are lost
+// when the server stops. Expired sessions are removed when the store is saved to,
+// at most once per minute.
+type MemoryStore struct {
+	mutex    sync.Mutex
+	sessions map[string]memoryEntry
+	now      func() time.Time
+
+	// nextSweep is the time after which the next save removes the expired
+	// sessions.
+	nextSweep time.Time
+}
+
+// NewMemoryStore returns a new MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		mutex:     sync.Mutex{},
+		sessions:  make(map[string]memoryEntry),
+		now:       time.Now,
+		nextSweep: time.Time{},
+	}
+}
+
+func (s *MemoryStore) Load(id string) (map[string]string, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry, ok := s.sessions[id]
+	if !ok || !s.now().Before(entry.expires) {
+		return nil, false, nil
+	}
+
+	// Return a copy so that the changes to the session are only stored when
+	// the session is saved.
+	return maps.Clone(entry.values), true, nil
+}
+
+func (s *MemoryStore) Save(id string, values map[string]string, expires time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// The sessions are only swept from time to time so that a save does not
+	// have to go through all of the sessions.
+	if now := s.now(); !now.Before(s.nextSweep) {
+		for key, entry := range s.sessions {
+			if !now.Before(entry.expires) {
+				delete(s.sessions, key)
+			}
+		}
+
+		s.nextSweep = now.Add(sweepInterval)
+	}
+
+	s.sessions[id] = memoryEntry{
+		values:  maps.Clone(values),
+		expires: expires,
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) Delete(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.sessions, id)
+
+	return nil
+}
+
+// Len returns the number of sessions in the store including the expired sessions
+// that have not been removed yet.
+func (s *MemoryStore) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.sessions)
+}
@@ -0,0 +1,208 @@
+// Package sse streams Server-Sent Events (text/event-stream) to the client as
+// described in the HTML Living Standard (section 9.2).
+package sse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"http-from-tcp/internal/headers"
+	"http-from-tcp/internal/request"
+	"http-from-tcp/internal/response"
+)
+
+// DefaultHeartbeat is the default interval between the heartbeat comments. The
+// comments stop proxies from closing an idle stream and let the server notice
+// that the client has disconnected.
+const DefaultHeartbeat = 15 * time.Second
+
+// Event is a single event in the stream. Empty fields are omitted.
+type Event struct {
+	// ID sets the last event ID of the client. The client sends it back in the
+	// Last-Event-ID header when it reconnects.
+	ID string
+
+	// Event is the type of the event. The client dispatches an event without a
+	// type as a message event.
+	Event string
+
+	// Data is the payload of the event. It can span several lines.
+	Data string
+
+	// Retry tells the client how long to wait before reconnecting.
+	Retry time.Duration
+}
+
+// Stream writes events to the client.
+type Stream struct {
+	w           *response.Writer
+	lastEventID string
+	closed      bool
+}
+
+// NewStream writes the status line and the headers of the event stream and returns
+// the stream. The body is chunked so that the connection can be reused after the
+// stream is closed.
+func NewStream(w *response.Writer, req *request.Request) (*Stream, error) {
+	if err := w.WriteStatusLine(response.StatusCodeOK); err != nil {
+		return nil, fmt.Errorf("error writing the status line: %w", err)
+	}
+
+	h := headers.NewHeaders()
+	h[response.HeaderContentType] = "text/event-stream"
+	h[response.HeaderCacheControl] = "no-cache"
+	h[response.HeaderTransferEncoding] = "chunked"
+
+	if err := w.WriteHeaders(h); err != nil {
+		return nil, fmt.Errorf("error writing the headers: %w", err)
+	}
+
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	return &Stream{
+		w:           w,
+		lastEventID: req.Headers.Get("last-event-id"),
+		closed:      false,
+	}, nil
+}
+
+// LastEventID returns the ID of the last event that the client received before it
+// reconnected. It is empty for a new stream.
+func (s *Stream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Send writes the event and flushes it to the client.
+func (s *Stream) Send(event Event) error {
+	if strings.ContainsAny(event.ID, "\r\n\x00") {
+		return errors.New("the event ID must not contain a line break or a NUL character")
+	}
+
+	if strings.ContainsAny(event.Event, "\r\n") {
+		return errors.New("the event type must not contain a line break")
+	}
+
+	var builder strings.Builder
+
+	if event.ID != "" {
+		builder.WriteString("id: " + event.ID + "\n")
+	}
+
+	if event.Event != "" {
+		builder.WriteString("event: " + event.Event + "\n")
+	}
+
+	if event.Retry > 0 {
+		builder.WriteString("retry: " + strconv.FormatInt(event.Retry.Milliseconds(), 10) + "\n")
+	}
+
+	// Each line of the data is sent in its own data field. The client joins
+	// the lines with a line feed. CRLF and CR are line breaks as well.
+	if event.Data != "" || (event.ID == "" && event.Event == "" && event.Retry <= 0) {
+		data := strings.ReplaceAll(event.Data, "\r\n", "\n")
+		data = strings.ReplaceAll(data, "\r", "\n")
+
+		for line := range strings.SplitSeq(data, "\n") {
+			builder.WriteString("data: " + line + "\n")
+		}
+	}
+
+	builder.WriteString("\n")
+
+	return s.write(builder.String())
+}
+
+// Comment writes a comment which the client ignores. The comment is split over
+// several lines if it contains line breaks.
+func (s *Stream) Comment(text string) error {
+	var builder strings.Builder
+
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+
+	for line := range strings.SplitSeq(text, "\n") {
+		builder.WriteString(": " + line + "\n")
+	}
+
+	builder.WriteString("\n")
+
+	return s.write(builder.String())
+}
+
+// Run sends the events from the channel until the channel is closed, the context is
+// done or a write fails. A heartbeat comment is sent whenever no events have been sent
+// for the heartbeat interval. A write fails once the client has disconnected so the
+// heartbeat also bounds how long it takes to notice the disconnect. The stream is
+// closed when Run returns unless a write failed. The error from the failed write is
+// returned.
+func (s *Stream) Run(ctx context.Context, events <-chan Event, heartbeat time.Duration) error {
+	if heartbeat <= 0 {
+		heartbeat = DefaultHeartbeat
+	}
+
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return s.Close()
+		case event, ok := <-events:
+			if !ok {
+				return s.Close()
+			}
+
+			if err := s.Send(event); err != nil {
+				return err
+			}
+
+			ticker.Reset(heartbeat)
+		case <-ticker.C:
+			if err := s.Comment("heartbeat"); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Close ends the event stream. The client reconnects after a closed stream unless
+// it is told not to (e.g. with a 204 No Content response to the next request).
+func (s *Stream) Close() error {
+	if s.closed {
+		return nil
+	}
+
+	s.closed = true
+
+	if _, err := s.w.WriteChunkedBodyDone(); err != nil {
+		return err
+	}
+
+	return s.w.WriteTrailers(headers.NewHeaders())
+}
+
+func (s *Stream) write(data string) error {
+	if s.closed {
+		return errors.New("the event stream is closed")
+	}
+
+	if _, err := s.w.WriteChunkedBody([]byte(data)); err != nil {
+		s.closed = true
+
+		return fmt.Errorf("error writing to the event stream: %w", err)
+	}
+
+	if err := s.w.Flush(); err != nil {
+		s.closed = true
+
+		return err
+	}
+
+	return nil
+}
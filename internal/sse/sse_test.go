@@ -0,0 +1,181 @@
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"http-from-tcp/internal/request"
+	"http-from-tcp/internal/response"
+	"http-from-tcp/internal/server"
+)
+
+// newTestStream returns a stream that writes to the buffer. The status line and the
+// headers are removed from the buffer.
+func newTestStream(t *testing.T, rawHeaders string) (*Stream, *bytes.Buffer) {
+	t.Helper()
+
+	req, err := request.RequestFromReader(strings.NewReader("GET /events HTTP/1.1\r\nHost: localhost\r\n" + rawHeaders + "\r\n"))
+	require.NoError(t, err)
+
+	buf := new(bytes.Buffer)
+
+	stream, err := NewStream(response.NewWriter(buf), req)
+	require.NoError(t, err)
+
+	head, _, found := strings.Cut(buf.String(), "\r\n\r\n")
+	require.True(t, found)
+
+	head += "\r\n"
+	assert.Contains(t, head, "Content-Type: text/event-stream\r\n")
+	assert.Contains(t, head, "Cache-Control: no-cache\r\n")
+	assert.Contains(t, head, "Transfer-Encoding: chunked\r\n")
+
+	buf.Reset()
+
+	return stream, buf
+}
+
+// chunkData returns the data of the chunks in the chunked body.
+func chunkData(t *testing.T, body string) string {
+	t.Helper()
+
+	var builder strings.Builder
+
+	for body != "" {
+		sizeLine, rest, found := strings.Cut(body, "\r\n")
+		require.True(t, found)
+
+		size, err := strconv.ParseInt(sizeLine, 16, 64)
+		require.NoError(t, err)
+
+		if size == 0 {
+			break
+		}
+
+		builder.WriteString(rest[:size])
+		body = strings.TrimPrefix(rest[size:], "\r\n")
+	}
+
+	return builder.String()
+}
+
+func TestSend(t *testing.T) {
+	stream, buf := newTestStream(t, "Last-Event-ID: 41\r\n")
+	assert.Equal(t, "41", stream.LastEventID())
+
+	require.NoError(t, stream.Send(Event{ID: "42", Event: "update", Data: "line 1\nline 2\r\nline 3", Retry: 3 * time.Second}))
+	require.NoError(t, stream.Send(Event{Data: "message"}))
+	require.NoError(t, stream.Send(Event{Retry: time.Second}))
+	require.NoError(t, stream.Comment("keep\nalive"))
+
+	assert.Equal(
+		t,
+		"id: 42\nevent: update\nretry: 3000\ndata: line 1\ndata: line 2\ndata: line 3\n\n"+
+			"data: message\n\n"+
+			"retry: 1000\n\n"+
+			": keep\n: alive\n\n",
+		chunkData(t, buf.String()),
+	)
+
+	// Test: Invalid fields
+	require.Error(t, stream.Send(Event{ID: "4\n2"}))
+	require.Error(t, stream.Send(Event{Event: "up\rdate"}))
+
+	// Test: The stream cannot be used after it is closed
+	require.NoError(t, stream.Close())
+	require.NoError(t, stream.Close())
+	assert.True(t, strings.HasSuffix(buf.String(), "0\r\n\r\n"))
+	require.Error(t, stream.Send(Event{Data: "late"}))
+}
+
+func TestRun(t *testing.T) {
+	stream, buf := newTestStream(t, "")
+
+	events := make(chan Event)
+	done := make(chan error)
+
+	go func() {
+		done <- stream.Run(context.Background(), events, 20*time.Millisecond)
+	}()
+
+	events <- Event{Data: "first"}
+
+	time.Sleep(50 * time.Millisecond)
+
+	events <- Event{Data: "second"}
+
+	// Test: Closing the channel closes the stream
+	close(events)
+	require.NoError(t, <-done)
+
+	data := chunkData(t, buf.String())
+	assert.True(t, strings.HasPrefix(data, "data: first\n\n: heartbeat\n\n"))
+	assert.True(t, strings.HasSuffix(data, "data: second\n\n"))
+
+	// Test: Cancelling the context closes the stream
+	stream, buf = newTestStream(t, "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	require.NoError(t, stream.Run(ctx, make(chan Event), time.Minute))
+	assert.Equal(t, "0\r\n\r\n", buf.String())
+}
+
+func TestRunStopsWhenClientDisconnects(t *testing.T) {
+	done := make(chan error, 1)
+
+	srv, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		stream, err := NewStream(w, req)
+		if err != nil {
+			done <- err
+
+			return
+		}
+
+		if err := stream.Send(Event{Data: "hello"}); err != nil {
+			done <- err
+
+			return
+		}
+
+		done <- stream.Run(req.Context(), make(chan Event), 10*time.Millisecond)
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = srv.Close() })
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	require.NoError(t, err)
+
+	_, err = conn.Write([]byte("GET /events HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+
+		if strings.HasPrefix(line, "data: hello") {
+			break
+		}
+	}
+
+	require.NoError(t, conn.Close())
+
+	select {
+	case err := <-done:
+		require.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream did not stop after the client disconnected")
+	}
+}
@@ -0,0 +1,210 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"http-from-tcp/internal/headers"
+)
+
+// maxResponseHeaderBytes is the maximum size of the status line and the headers of
+// the handshake response.
+const maxResponseHeaderBytes int = 64 << 10
+
+// DialOptions are the options of the client side of the handshake.
+type DialOptions struct {
+	// Subprotocols are the subprotocols that the client offers.
+	Subprotocols []string
+
+	// EnableCompression offers the permessage-deflate extension to the server.
+	EnableCompression bool
+
+	// MaxMessageSize is the maximum size of a received message. DefaultMaxMessageSize
+	// is used if it is zero.
+	MaxMessageSize int64
+
+	// Headers are the additional headers of the handshake request (e.g. Origin).
+	Headers headers.Headers
+}
+
+// Dial connects to the WebSocket server at the ws:// URL and performs the client side
+// of the opening handshake. The context bounds the connection and the handshake.
+func Dial(ctx context.Context, rawURL string, options DialOptions) (*Conn, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the URL: %w", err)
+	}
+
+	if target.Scheme != "ws" {
+		return nil, fmt.Errorf("unsupported URL scheme %q", target.Scheme)
+	}
+
+	address := target.Host
+	if target.Port() == "" {
+		address = net.JoinHostPort(target.Hostname(), "80")
+	}
+
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to the server: %w", err)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	// The connection is closed if the context is done during the handshake.
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Unix(1, 0))
+	})
+
+	wsConn, err := handshake(conn, target, options)
+
+	if !stop() || err != nil {
+		_ = conn.Close()
+
+		if err == nil {
+			err = ctx.Err()
+		}
+
+		return nil, err
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+
+	return wsConn, nil
+}
+
+func handshake(conn net.Conn, target *url.URL, options DialOptions) (*Conn, error) {
+	nonce := make([]byte, 16)
+	_, _ = rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	var builder strings.Builder
+
+	builder.WriteString("GET " + target.RequestURI() + " HTTP/1.1\r\n")
+	builder.WriteString("Host: " + target.Host + "\r\n")
+	builder.WriteString("Upgrade: websocket\r\n")
+	builder.WriteString("Connection: Upgrade\r\n")
+	builder.WriteString(headerSecWebSocketKey + ": " + key + "\r\n")
+	builder.WriteString(headerSecWebSocketVersion + ": " + protocolVersion + "\r\n")
+
+	if len(options.Subprotocols) > 0 {
+		builder.WriteString(headerSecWebSocketProtocol + ": " + strings.Join(options.Subprotocols, ", ") + "\r\n")
+	}
+
+	if options.EnableCompression {
+		builder.WriteString(headerSecWebSocketExtensions + ": " + deflateAgreedValue + "\r\n")
+	}
+
+	for name, value := range options.Headers {
+		builder.WriteString(name + ": " + value + "\r\n")
+	}
+
+	builder.WriteString("\r\n")
+
+	if _, err := conn.Write([]byte(builder.String())); err != nil {
+		return nil, fmt.Errorf("error writing the handshake request: %w", err)
+	}
+
+	reader := bufio.NewReader(conn)
+
+	statusCode, respHeaders, err := readHandshakeResponse(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode != "101" {
+		return nil, fmt.Errorf("the server did not switch protocols: got the status code %s", statusCode)
+	}
+
+	switch {
+	case !respHeaders.HasToken("upgrade", "websocket"):
+		return nil, errors.New("the Upgrade header of the handshake response is not websocket")
+	case !respHeaders.HasToken("connection", "upgrade"):
+		return nil, errors.New("the Connection header of the handshake response is not upgrade")
+	case respHeaders.Get(headerSecWebSocketAccept) != acceptKey(key):
+		return nil, errors.New("the Sec-WebSocket-Accept header of the handshake response is invalid")
+	}
+
+	subprotocol := respHeaders.Get(headerSecWebSocketProtocol)
+	if subprotocol != "" && selectSubprotocol(subprotocol, options.Subprotocols) == "" {
+		return nil, fmt.Errorf("the server selected a subprotocol that was not offered: %s", subprotocol)
+	}
+
+	compression := false
+
+	if extensions := respHeaders.Get(headerSecWebSocketExtensions); extensions != "" {
+		if !options.EnableCompression || !checkDeflateResponse(extensions) {
+			return nil, fmt.Errorf("the server selected an extension that was not offered: %s", extensions)
+		}
+
+		compression = true
+	}
+
+	// The reader is kept as it can already hold the first frames from the server.
+	return newConn(conn, reader, true, subprotocol, compression, options.MaxMessageSize), nil
+}
+
+// readHandshakeResponse reads the status line and the headers of the handshake response
+// and returns the status code and the headers.
+func readHandshakeResponse(reader *bufio.Reader) (string, headers.Headers, error) {
+	size := 0
+
+	readLine := func() ([]byte, error) {
+		line, err := reader.ReadSlice('\n')
+		size += len(line)
+
+		switch {
+		case errors.Is(err, bufio.ErrBufferFull) || size > maxResponseHeaderBytes:
+			return nil, errors.New("the handshake response headers are too large")
+		case err != nil:
+			return nil, fmt.Errorf("error reading the handshake response: %w", err)
+		}
+
+		return line, nil
+	}
+
+	statusLine, err := readLine()
+	if err != nil {
+		return "", nil, err
+	}
+
+	version, rest, _ := strings.Cut(strings.TrimRight(string(statusLine), "\r\n"), " ")
+	statusCode, _, _ := strings.Cut(rest, " ")
+
+	if version != "HTTP/1.1" || len(statusCode) != 3 {
+		return "", nil, fmt.Errorf("invalid status line in the handshake response: %q", statusLine)
+	}
+
+	respHeaders := headers.NewHeaders()
+
+	for {
+		line, err := readLine()
+		if err != nil {
+			return "", nil, err
+		}
+
+		n, done, err := respHeaders.Parse(line)
+
+		switch {
+		case err != nil:
+			return "", nil, fmt.Errorf("error parsing the handshake response headers: %w", err)
+		case done:
+			return statusCode, respHeaders, nil
+		case n == 0:
+			// The line does not end with a CRLF.
+			return "", nil, fmt.Errorf("invalid header line in the handshake response: %q", line)
+		}
+	}
+}
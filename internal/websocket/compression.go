@@ -0,0 +1,128 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"strings"
+	"sync"
+)
+
+// The permessage-deflate extension (RFC 7692) compresses each message on its own. The
+// context takeover is disabled in both directions so that no compression state is
+// kept between the messages. This costs some compression but the memory of an idle
+// connection stays small and the compressors can be shared.
+const (
+	deflateExtension   = "permessage-deflate"
+	deflateAgreedValue = deflateExtension + "; server_no_context_takeover; client_no_context_takeover"
+)
+
+// deflateTail is appended to the compressed payload before it is decompressed. The
+// sender removes the empty stored block (0x00 0x00 0xFF 0xFF) at the end of the
+// payload and the final empty block tells the decompressor that the data has ended.
+var deflateTail = []byte{0x00, 0x00, 0xFF, 0xFF, 0x01, 0x00, 0x00, 0xFF, 0xFF}
+
+var (
+	flateWriterPool = sync.Pool{
+		New: func() any {
+			writer, _ := flate.NewWriter(io.Discard, flate.DefaultCompression)
+
+			return writer
+		},
+	}
+
+	flateReaderPool = sync.Pool{
+		New: func() any {
+			return flate.NewReader(bytes.NewReader(nil))
+		},
+	}
+)
+
+// acceptDeflateOffer returns true if the server can accept the permessage-deflate
+// offer from the Sec-WebSocket-Extensions header. An offer that limits the window of
+// the server is declined as the compressor always uses the full window.
+func acceptDeflateOffer(offer string) bool {
+	name, params, _ := strings.Cut(offer, ";")
+	if !strings.EqualFold(strings.TrimSpace(name), deflateExtension) {
+		return false
+	}
+
+	seen := make(map[string]bool)
+
+	for param := range strings.SplitSeq(params, ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+
+		if key == "" {
+			continue
+		}
+
+		if seen[key] {
+			return false
+		}
+
+		seen[key] = true
+
+		switch key {
+		case "server_no_context_takeover", "client_no_context_takeover", "client_max_window_bits":
+		case "server_max_window_bits":
+			if value != "15" {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// checkDeflateResponse returns true if the permessage-deflate parameters that the
+// server agreed to can be used by the client. The server must not use the context
+// takeover as each message is decompressed on its own.
+func checkDeflateResponse(value string) bool {
+	name, params, _ := strings.Cut(value, ";")
+	if !strings.EqualFold(strings.TrimSpace(name), deflateExtension) {
+		return false
+	}
+
+	serverNoContextTakeover := false
+
+	for param := range strings.SplitSeq(params, ";") {
+		key, _, _ := strings.Cut(strings.TrimSpace(param), "=")
+
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "":
+		case "server_no_context_takeover":
+			serverNoContextTakeover = true
+		case "client_no_context_takeover", "server_max_window_bits":
+		default:
+			return false
+		}
+	}
+
+	return serverNoContextTakeover
+}
+
+// decompress decompresses the payload of a message. An error is returned if the
+// decompressed message is larger than maxSize.
+func decompress(payload []byte, maxSize int64) ([]byte, error) {
+	reader, _ := flateReaderPool.Get().(io.ReadCloser)
+	defer flateReaderPool.Put(reader)
+
+	if err := reader.(flate.Resetter).Reset(io.MultiReader(bytes.NewReader(payload), bytes.NewReader(deflateTail)), nil); err != nil {
+		return nil, newProtocolError(CloseInvalidPayloadData, "invalid compressed message")
+	}
+
+	data, err := io.ReadAll(io.LimitReader(reader, maxSize+1))
+	if err != nil {
+		return nil, newProtocolError(CloseInvalidPayloadData, "invalid compressed message")
+	}
+
+	if int64(len(data)) > maxSize {
+		return nil, newProtocolError(CloseMessageTooBig, "the message is too big")
+	}
+
+	return data, nil
+}
@@ -0,0 +1,611 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// MessageType is the type of a data message.
+type MessageType int
+
+const (
+	TextMessage   = MessageType(opText)
+	BinaryMessage = MessageType(opBinary)
+)
+
+const (
+	// closeTimeout is the maximum amount of time to wait for the close frame of the
+	// peer after the close frame has been sent.
+	closeTimeout = 5 * time.Second
+
+	// frameSize is the maximum size of the payload of the frames that are written by
+	// the writer from NextWriter.
+	frameSize int = 16 << 10
+
+	// readChunkSize is the largest part of a payload that is allocated before it is
+	// received so that a large payload length alone cannot make the server allocate
+	// a lot of memory.
+	readChunkSize int64 = 64 << 10
+
+	// maxRetainedWriteBuffer is the largest write buffer that is kept for the next frame.
+	maxRetainedWriteBuffer int = 64 << 10
+)
+
+// Conn is a WebSocket connection. One goroutine can read messages while another
+// goroutine writes messages. The control frames can be written from any goroutine.
+type Conn struct {
+	conn           net.Conn
+	reader         *bufio.Reader
+	isClient       bool
+	subprotocol    string
+	compression    bool
+	maxMessageSize int64
+
+	// readMu is held while a message is read. Close uses it to find out if another
+	// goroutine will receive the close frame of the peer.
+	readMu        sync.Mutex
+	readErr       error
+	pongHandler   func(data []byte)
+	closeReceived chan struct{}
+
+	writeMu   sync.Mutex
+	writeBuf  []byte
+	closeSent bool
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func newConn(
+	conn net.Conn,
+	reader *bufio.Reader,
+	isClient bool,
+	subprotocol string,
+	compression bool,
+	maxMessageSize int64,
+) *Conn {
+	if maxMessageSize <= 0 {
+		maxMessageSize = DefaultMaxMessageSize
+	}
+
+	return &Conn{
+		conn:           conn,
+		reader:         reader,
+		isClient:       isClient,
+		subprotocol:    subprotocol,
+		compression:    compression,
+		maxMessageSize: maxMessageSize,
+		readErr:        nil,
+		pongHandler:    nil,
+		closeReceived:  make(chan struct{}),
+		writeBuf:       make([]byte, 0, maxFrameHeaderSize),
+		closeSent:      false,
+	}
+}
+
+// Subprotocol returns the subprotocol that was agreed in the handshake. It is empty
+// if no subprotocol was agreed.
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// Compression returns true if the permessage-deflate extension was agreed in the
+// handshake.
+func (c *Conn) Compression() bool {
+	return c.compression
+}
+
+// RemoteAddr returns the network address of the peer.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetReadDeadline sets the deadline for reading from the network connection.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline for writing to the network connection.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// SetPongHandler sets the function that is called with the payload of each pong frame
+// that is received. It must be called before the messages are read.
+func (c *Conn) SetPongHandler(handler func(data []byte)) {
+	c.pongHandler = handler
+}
+
+// ReadMessage reads the next data message. The fragments of the message are joined
+// together and a compressed message is decompressed. The control frames that arrive
+// in the meantime are handled: a ping frame is answered with a pong frame and a
+// close frame is answered with a close frame after which a *CloseError is returned.
+// The connection is failed if the peer violates the protocol.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	return c.readMessage()
+}
+
+func (c *Conn) readMessage() (MessageType, []byte, error) {
+	if c.readErr != nil {
+		return 0, nil, c.readErr
+	}
+
+	messageType, data, err := c.nextMessage()
+	if err != nil {
+		c.readErr = err
+
+		var protoErr protocolError
+		if errors.As(err, &protoErr) {
+			_ = c.writeClose(protoErr.code, "")
+			_ = c.closeConn()
+		}
+
+		return 0, nil, err
+	}
+
+	return messageType, data, nil
+}
+
+func (c *Conn) nextMessage() (MessageType, []byte, error) {
+	var (
+		messageType MessageType
+		compressed  bool
+		fragmented  bool
+		payload     []byte
+	)
+
+	for {
+		header, err := readFrameHeader(c.reader)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if err := c.checkFrameHeader(header, fragmented); err != nil {
+			return 0, nil, err
+		}
+
+		if header.opcode.isControl() {
+			data, err := c.readPayload(nil, header)
+			if err != nil {
+				return 0, nil, err
+			}
+
+			if err := c.handleControlFrame(header.opcode, data); err != nil {
+				return 0, nil, err
+			}
+
+			continue
+		}
+
+		if !fragmented {
+			messageType = MessageType(header.opcode)
+			compressed = header.rsv1
+			fragmented = true
+		}
+
+		if int64(len(payload))+header.length > c.maxMessageSize {
+			return 0, nil, newProtocolError(CloseMessageTooBig, "the message is too big")
+		}
+
+		payload, err = c.readPayload(payload, header)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if header.fin {
+			break
+		}
+	}
+
+	if compressed {
+		var err error
+
+		payload, err = decompress(payload, c.maxMessageSize)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+
+	if messageType == TextMessage && !utf8.Valid(payload) {
+		return 0, nil, newProtocolError(CloseInvalidPayloadData, "the text message is not valid UTF-8")
+	}
+
+	return messageType, payload, nil
+}
+
+// checkFrameHeader checks the header of a frame against the state of the message
+// that is being read.
+func (c *Conn) checkFrameHeader(header frameHeader, fragmented bool) error {
+	switch {
+	case !header.opcode.isValid():
+		return newProtocolError(CloseProtocolError, fmt.Sprintf("unknown opcode %#x", byte(header.opcode)))
+	case !c.isClient && !header.masked:
+		return newProtocolError(CloseProtocolError, "the frame from the client is not masked")
+	case c.isClient && header.masked:
+		return newProtocolError(CloseProtocolError, "the frame from the server is masked")
+	case header.opcode.isControl() && (!header.fin || header.length > int64(maxControlPayloadSize)):
+		return newProtocolError(CloseProtocolError, "the control frame is fragmented or too big")
+	case header.rsv1 && (!c.compression || header.opcode == opContinuation || header.opcode.isControl()):
+		return newProtocolError(CloseProtocolError, "the reserved bit RSV1 must not be set")
+	case header.opcode == opContinuation && !fragmented:
+		return newProtocolError(CloseProtocolError, "received a continuation frame without a message")
+	case (header.opcode == opText || header.opcode == opBinary) && fragmented:
+		return newProtocolError(CloseProtocolError, "received a new message before the end of the fragmented message")
+	default:
+		return nil
+	}
+}
+
+// readPayload reads the payload of the frame, unmasks it and appends it to buf.
+func (c *Conn) readPayload(buf []byte, header frameHeader) ([]byte, error) {
+	start := len(buf)
+
+	for remaining := header.length; remaining > 0; {
+		n := int(min(remaining, readChunkSize))
+		buf = slices.Grow(buf, n)
+
+		if _, err := io.ReadFull(c.reader, buf[len(buf):len(buf)+n]); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+
+		buf = buf[:len(buf)+n]
+		remaining -= int64(n)
+	}
+
+	if header.masked {
+		maskBytes(header.maskKey, 0, buf[start:])
+	}
+
+	return buf, nil
+}
+
+func (c *Conn) handleControlFrame(op opcode, data []byte) error {
+	switch op {
+	case opPing:
+		if err := c.writeFrame(frameHeader{fin: true, opcode: opPong}, data); err != nil && !errors.Is(err, errCloseSent) {
+			return err
+		}
+	case opPong:
+		if c.pongHandler != nil {
+			c.pongHandler(data)
+		}
+	case opClose:
+		closeErr, err := parseClosePayload(data)
+		if err != nil {
+			return err
+		}
+
+		close(c.closeReceived)
+
+		// The status code is echoed back unless this side has started the
+		// closing handshake. The connection is then closed.
+		_ = c.writeClose(closeErr.Code, "")
+		_ = c.closeConn()
+
+		return closeErr
+	}
+
+	return nil
+}
+
+func parseClosePayload(data []byte) (*CloseError, error) {
+	switch {
+	case len(data) == 0:
+		return &CloseError{Code: CloseNoStatusReceived, Reason: ""}, nil
+	case len(data) == 1:
+		return nil, newProtocolError(CloseProtocolError, "the close frame has a one byte payload")
+	}
+
+	code := int(binary.BigEndian.Uint16(data))
+	if !validCloseCode(code) {
+		return nil, newProtocolError(CloseProtocolError, fmt.Sprintf("invalid close status code %d", code))
+	}
+
+	if !utf8.Valid(data[2:]) {
+		return nil, newProtocolError(CloseInvalidPayloadData, "the close reason is not valid UTF-8")
+	}
+
+	return &CloseError{Code: code, Reason: string(data[2:])}, nil
+}
+
+// WriteMessage writes the data as a single message. The message is compressed if the
+// permessage-deflate extension was agreed.
+func (c *Conn) WriteMessage(messageType MessageType, data []byte) error {
+	if !c.compression {
+		if messageType != TextMessage && messageType != BinaryMessage {
+			return fmt.Errorf("invalid message type %d", messageType)
+		}
+
+		return c.writeFrame(frameHeader{fin: true, opcode: opcode(messageType)}, data)
+	}
+
+	writer, err := c.NextWriter(messageType)
+	if err != nil {
+		return err
+	}
+
+	if _, err := writer.Write(data); err != nil {
+		return err
+	}
+
+	return writer.Close()
+}
+
+// NextWriter returns a writer for the next message. The message is written in
+// fragments as the data is written and the last fragment is written when the writer
+// is closed. Only one message can be written at a time but the control frames can be
+// written between the fragments.
+func (c *Conn) NextWriter(messageType MessageType) (io.WriteCloser, error) {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return nil, fmt.Errorf("invalid message type %d", messageType)
+	}
+
+	writer := messageWriter{
+		conn:     c,
+		opcode:   opcode(messageType),
+		rsv1:     false,
+		buf:      make([]byte, 0, frameSize),
+		flate:    nil,
+		holdBack: 0,
+		closed:   false,
+		err:      nil,
+	}
+
+	if c.compression {
+		writer.rsv1 = true
+		writer.holdBack = 4
+
+		writer.flate, _ = flateWriterPool.Get().(*flate.Writer)
+		writer.flate.Reset(payloadWriter{&writer})
+	}
+
+	return &writer, nil
+}
+
+// Ping writes a ping frame with the data. The peer answers with a pong frame.
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > maxControlPayloadSize {
+		return errors.New("the payload of the ping frame is too big")
+	}
+
+	return c.writeFrame(frameHeader{fin: true, opcode: opPing}, data)
+}
+
+// Close closes the connection with the normal closure status code.
+func (c *Conn) Close() error {
+	return c.CloseWithReason(CloseNormalClosure, "")
+}
+
+// CloseWithReason performs the closing handshake (RFC 6455, section 7). The close
+// frame is sent and the network connection is closed after the close frame of the
+// peer is received or after a timeout. If another goroutine is reading messages it
+// receives the close frame (ReadMessage returns a *CloseError) otherwise the messages
+// that arrive before the close frame are discarded.
+func (c *Conn) CloseWithReason(code int, reason string) error {
+	if !validCloseCode(code) {
+		return errInvalidCode
+	}
+
+	if len(reason) > maxControlPayloadSize-2 {
+		return errors.New("the close reason is too long")
+	}
+
+	err := c.writeClose(code, reason)
+
+	switch {
+	case errors.Is(err, errCloseSent):
+		// The closing handshake was started earlier.
+	case err != nil:
+		_ = c.closeConn()
+
+		return err
+	default:
+		c.waitForClose()
+	}
+
+	return c.closeConn()
+}
+
+// waitForClose waits for the close frame of the peer.
+func (c *Conn) waitForClose() {
+	deadline := time.Now().Add(closeTimeout)
+
+	if c.readMu.TryLock() {
+		defer c.readMu.Unlock()
+
+		_ = c.conn.SetReadDeadline(deadline)
+
+		for {
+			if _, _, err := c.readMessage(); err != nil {
+				return
+			}
+		}
+	}
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case <-c.closeReceived:
+	case <-timer.C:
+	}
+}
+
+func (c *Conn) closeConn() error {
+	c.closeOnce.Do(func() {
+		c.closeErr = c.conn.Close()
+	})
+
+	return c.closeErr
+}
+
+// writeClose writes a close frame. The frame does not have a payload if the status
+// code is CloseNoStatusReceived.
+func (c *Conn) writeClose(code int, reason string) error {
+	var payload []byte
+
+	if code != CloseNoStatusReceived {
+		payload = binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(reason)), uint16(code))
+		payload = append(payload, reason...)
+	}
+
+	return c.writeFrame(frameHeader{fin: true, opcode: opClose}, payload)
+}
+
+// writeFrame writes a frame with the payload. A client masks the payload with a new
+// random key. No more frames can be written after the close frame.
+func (c *Conn) writeFrame(header frameHeader, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.closeSent {
+		return errCloseSent
+	}
+
+	if header.opcode == opClose {
+		c.closeSent = true
+	}
+
+	header.length = int64(len(payload))
+
+	var err error
+
+	if c.isClient {
+		header.masked = true
+		_, _ = rand.Read(header.maskKey[:])
+
+		buf := appendFrameHeader(c.writeBuf[:0], header)
+		start := len(buf)
+		buf = append(buf, payload...)
+		maskBytes(header.maskKey, 0, buf[start:])
+
+		_, err = c.conn.Write(buf)
+
+		if cap(buf) <= maxRetainedWriteBuffer {
+			c.writeBuf = buf[:0]
+		}
+	} else {
+		// The payload is written straight from the slice of the caller.
+		buf := appendFrameHeader(c.writeBuf[:0], header)
+		buffers := net.Buffers{buf, payload}
+
+		_, err = buffers.WriteTo(c.conn)
+		c.writeBuf = buf[:0]
+	}
+
+	if err != nil {
+		return fmt.Errorf("error writing the frame: %w", err)
+	}
+
+	return nil
+}
+
+// messageWriter writes a message in fragments. The payload of a compressed message
+// must not end with the last 4 bytes of the compressed data (RFC 7692, section
+// 7.2.1) so these bytes are held back until the writer is closed.
+type messageWriter struct {
+	conn     *Conn
+	opcode   opcode
+	rsv1     bool
+	buf      []byte
+	flate    *flate.Writer
+	holdBack int
+	closed   bool
+	err      error
+}
+
+func (w *messageWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("the message writer is closed")
+	}
+
+	if w.flate != nil {
+		return w.flate.Write(p)
+	}
+
+	return w.writePayload(p)
+}
+
+func (w *messageWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+
+	w.closed = true
+
+	if w.flate != nil {
+		err := w.flate.Flush()
+
+		w.flate.Reset(io.Discard)
+		flateWriterPool.Put(w.flate)
+		w.flate = nil
+
+		if err != nil {
+			return err
+		}
+
+		if w.err == nil {
+			w.buf = bytes.TrimSuffix(w.buf, deflateTail[:4])
+		}
+	}
+
+	if w.err != nil {
+		return w.err
+	}
+
+	return w.writeFrame(w.buf, true)
+}
+
+// writePayload adds the data to the payload and writes the complete frames.
+func (w *messageWriter) writePayload(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	w.buf = append(w.buf, p...)
+
+	for len(w.buf) > frameSize+w.holdBack {
+		if err := w.writeFrame(w.buf[:frameSize], false); err != nil {
+			w.err = err
+
+			return 0, err
+		}
+
+		w.buf = append(w.buf[:0], w.buf[frameSize:]...)
+	}
+
+	return len(p), nil
+}
+
+func (w *messageWriter) writeFrame(payload []byte, fin bool) error {
+	header := frameHeader{fin: fin, rsv1: w.rsv1, opcode: w.opcode}
+
+	// Only the first frame of the message has the opcode and the RSV1 bit.
+	w.opcode = opContinuation
+	w.rsv1 = false
+
+	return w.conn.writeFrame(header, payload)
+}
+
+// payloadWriter receives the compressed data from the compressor.
+type payloadWriter struct {
+	w *messageWriter
+}
+
+func (p payloadWriter) Write(data []byte) (int, error) {
+	return p.w.writePayload(data)
+}
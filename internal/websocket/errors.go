@@ -0,0 +1,87 @@
+package websocket
+
+import (
+	"errors"
+	"strconv"
+
+	"http-from-tcp/internal/response"
+)
+
+// The status codes of the close frame (RFC 6455, section 7.4.1).
+const (
+	CloseNormalClosure      = 1000
+	CloseGoingAway          = 1001
+	CloseProtocolError      = 1002
+	CloseUnsupportedData    = 1003
+	CloseNoStatusReceived   = 1005
+	CloseAbnormalClosure    = 1006
+	CloseInvalidPayloadData = 1007
+	ClosePolicyViolation    = 1008
+	CloseMessageTooBig      = 1009
+	CloseMandatoryExtension = 1010
+	CloseInternalError      = 1011
+)
+
+var (
+	errCloseSent   = errors.New("the close frame has already been sent")
+	errInvalidCode = errors.New("invalid close status code")
+)
+
+// CloseError is returned by ReadMessage when the connection is closed with a close
+// frame. Code is CloseNoStatusReceived if the close frame did not have a status code.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	message := "the WebSocket connection was closed with the status code " + strconv.Itoa(e.Code)
+	if e.Reason != "" {
+		message += ": " + e.Reason
+	}
+
+	return message
+}
+
+// protocolError is an error in the data that the peer sent. The connection is
+// failed by sending a close frame with the status code.
+type protocolError struct {
+	code   int
+	reason string
+}
+
+func newProtocolError(code int, reason string) protocolError {
+	return protocolError{code: code, reason: reason}
+}
+
+func (e protocolError) Error() string {
+	return "received invalid WebSocket data: " + e.reason
+}
+
+// handshakeError is an invalid opening handshake from the client.
+type handshakeError struct {
+	statusCode response.StatusCode
+	reason     string
+}
+
+func (e handshakeError) Error() string {
+	return "received an invalid WebSocket handshake: " + e.reason
+}
+
+func (e handshakeError) StatusCode() int {
+	return int(e.statusCode)
+}
+
+// validCloseCode returns true if the status code can be sent in a close frame. The
+// codes 1005, 1006 and 1015 are only used locally and the other unassigned codes
+// below 3000 are reserved.
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,172 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+)
+
+// opcode is the type of a frame (RFC 6455, section 5.2).
+type opcode byte
+
+const (
+	opContinuation opcode = 0x0
+	opText         opcode = 0x1
+	opBinary       opcode = 0x2
+	opClose        opcode = 0x8
+	opPing         opcode = 0x9
+	opPong         opcode = 0xA
+)
+
+// isControl returns true if the frame is a control frame. Control frames can be
+// sent in the middle of a fragmented message.
+func (o opcode) isControl() bool {
+	return o&0x8 != 0
+}
+
+func (o opcode) isValid() bool {
+	switch o {
+	case opContinuation, opText, opBinary, opClose, opPing, opPong:
+		return true
+	default:
+		return false
+	}
+}
+
+const (
+	finBit  byte = 0x80
+	rsv1Bit byte = 0x40
+	rsv2Bit byte = 0x20
+	rsv3Bit byte = 0x10
+	maskBit byte = 0x80
+
+	// maxControlPayloadSize is the maximum size of the payload of a control frame.
+	maxControlPayloadSize int = 125
+
+	// maxFrameHeaderSize is the size of the header of a masked frame with a 64-bit
+	// payload length.
+	maxFrameHeaderSize int = 14
+)
+
+// frameHeader is the header of a frame. rsv1 marks the first frame of a compressed
+// message when the permessage-deflate extension is used.
+type frameHeader struct {
+	fin     bool
+	rsv1    bool
+	opcode  opcode
+	masked  bool
+	maskKey [4]byte
+	length  int64
+}
+
+// readFrameHeader reads the header of the next frame. The payload length must be
+// encoded with the minimal number of bytes.
+func readFrameHeader(reader *bufio.Reader) (frameHeader, error) {
+	var buf [8]byte
+
+	if _, err := io.ReadFull(reader, buf[:2]); err != nil {
+		return frameHeader{}, err
+	}
+
+	header := frameHeader{
+		fin:     buf[0]&finBit != 0,
+		rsv1:    buf[0]&rsv1Bit != 0,
+		opcode:  opcode(buf[0] & 0x0F),
+		masked:  buf[1]&maskBit != 0,
+		maskKey: [4]byte{},
+		length:  int64(buf[1] &^ maskBit),
+	}
+
+	if buf[0]&(rsv2Bit|rsv3Bit) != 0 {
+		return frameHeader{}, newProtocolError(CloseProtocolError, "the reserved bits RSV2 and RSV3 must not be set")
+	}
+
+	switch header.length {
+	case 126:
+		if _, err := io.ReadFull(reader, buf[:2]); err != nil {
+			return frameHeader{}, unexpectedEOF(err)
+		}
+
+		header.length = int64(binary.BigEndian.Uint16(buf[:2]))
+		if header.length < 126 {
+			return frameHeader{}, newProtocolError(CloseProtocolError, "the payload length is not minimally encoded")
+		}
+	case 127:
+		if _, err := io.ReadFull(reader, buf[:8]); err != nil {
+			return frameHeader{}, unexpectedEOF(err)
+		}
+
+		length := binary.BigEndian.Uint64(buf[:8])
+		if length>>63 != 0 {
+			return frameHeader{}, newProtocolError(CloseProtocolError, "the most significant bit of the payload length is set")
+		}
+
+		header.length = int64(length)
+		if header.length <= 0xFFFF {
+			return frameHeader{}, newProtocolError(CloseProtocolError, "the payload length is not minimally encoded")
+		}
+	}
+
+	if header.masked {
+		if _, err := io.ReadFull(reader, header.maskKey[:]); err != nil {
+			return frameHeader{}, unexpectedEOF(err)
+		}
+	}
+
+	return header, nil
+}
+
+// appendFrameHeader appends the encoded frame header to buf.
+func appendFrameHeader(buf []byte, header frameHeader) []byte {
+	first := byte(header.opcode)
+	if header.fin {
+		first |= finBit
+	}
+
+	if header.rsv1 {
+		first |= rsv1Bit
+	}
+
+	second := byte(0)
+	if header.masked {
+		second = maskBit
+	}
+
+	switch {
+	case header.length < 126:
+		buf = append(buf, first, second|byte(header.length))
+	case header.length <= 0xFFFF:
+		buf = append(buf, first, second|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(header.length))
+	default:
+		buf = append(buf, first, second|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(header.length))
+	}
+
+	if header.masked {
+		buf = append(buf, header.maskKey[:]...)
+	}
+
+	return buf
+}
+
+// maskBytes masks (or unmasks) the data in place with the key (RFC 6455, section 5.3).
+// pos is the position of the data in the payload and the position after the data is
+// returned so that a payload can be masked in several parts.
+func maskBytes(key [4]byte, pos int, data []byte) int {
+	for idx := range data {
+		data[idx] ^= key[pos&3]
+		pos++
+	}
+
+	return pos & 3
+}
+
+// unexpectedEOF turns an EOF in the middle of a frame into io.ErrUnexpectedEOF.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
@@ -0,0 +1,215 @@
+// Package websocket implements the WebSocket protocol (RFC 6455) on top of the
+// server. Upgrade performs the opening handshake on the server side and Dial performs
+// it on the client side. The permessage-deflate extension (RFC 7692) is supported.
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"http-from-tcp/internal/headers"
+	"http-from-tcp/internal/request"
+	"http-from-tcp/internal/response"
+)
+
+// DefaultMaxMessageSize is the default maximum size of a received message.
+const DefaultMaxMessageSize int64 = 16 << 20
+
+const (
+	// acceptGUID is appended to the key of the client to compute the
+	// Sec-WebSocket-Accept header (RFC 6455, section 1.3).
+	acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	protocolVersion = "13"
+
+	headerSecWebSocketKey        = "Sec-WebSocket-Key"
+	headerSecWebSocketAccept     = "Sec-WebSocket-Accept"
+	headerSecWebSocketVersion    = "Sec-WebSocket-Version"
+	headerSecWebSocketProtocol   = "Sec-WebSocket-Protocol"
+	headerSecWebSocketExtensions = "Sec-WebSocket-Extensions"
+)
+
+// Options are the options of the server side of the handshake.
+type Options struct {
+	// Subprotocols are the subprotocols that the server supports in the order of
+	// preference. The first one that the client also supports is selected.
+	Subprotocols []string
+
+	// EnableCompression accepts the permessage-deflate extension if the client
+	// offers it.
+	EnableCompression bool
+
+	// MaxMessageSize is the maximum size of a received message. A larger message
+	// fails the connection. DefaultMaxMessageSize is used if it is zero.
+	MaxMessageSize int64
+
+	// CheckOrigin returns true if the handshake from the origin in the Origin
+	// header is allowed. Browsers do not restrict WebSocket connections to the
+	// same origin so, if it is nil, the handshake is only allowed without the
+	// Origin header or if the host of the origin matches the Host header.
+	CheckOrigin func(req *request.Request) bool
+}
+
+// Upgrade performs the server side of the opening handshake and returns the WebSocket
+// connection. The connection is hijacked from the response writer so the caller must
+// close the WebSocket connection. If the handshake is invalid an error response is
+// written and the error is returned.
+func Upgrade(w *response.Writer, req *request.Request, options Options) (*Conn, error) {
+	key, err := checkHandshake(req, options)
+	if err != nil {
+		writeHandshakeError(w, err)
+
+		return nil, err
+	}
+
+	h := headers.NewHeaders()
+	h[headerSecWebSocketAccept] = acceptKey(key)
+
+	subprotocol := selectSubprotocol(req.Headers.Get(headerSecWebSocketProtocol), options.Subprotocols)
+	if subprotocol != "" {
+		h[headerSecWebSocketProtocol] = subprotocol
+	}
+
+	compression := false
+
+	if options.EnableCompression {
+		for offer := range strings.SplitSeq(req.Headers.Get(headerSecWebSocketExtensions), ",") {
+			if acceptDeflateOffer(offer) {
+				compression = true
+				h[headerSecWebSocketExtensions] = deflateAgreedValue
+
+				break
+			}
+		}
+	}
+
+	conn, buffered, err := w.SwitchProtocols("websocket", h)
+	if err != nil {
+		return nil, fmt.Errorf("error switching to the WebSocket protocol: %w", err)
+	}
+
+	// The client is supposed to wait for the handshake response before it sends
+	// any frames but the frames that were sent early are not lost.
+	reader := bufio.NewReader(io.MultiReader(bytes.NewReader(buffered), conn))
+
+	return newConn(conn, reader, false, subprotocol, compression, options.MaxMessageSize), nil
+}
+
+// IsUpgrade returns true if the request asks to upgrade the connection to the
+// WebSocket protocol.
+func IsUpgrade(req *request.Request) bool {
+	return req.Headers.HasToken("connection", "upgrade") && req.Headers.HasToken("upgrade", "websocket")
+}
+
+// checkHandshake checks the opening handshake from the client (RFC 6455, section 4.2.1)
+// and returns the key of the client.
+func checkHandshake(req *request.Request, options Options) (string, error) {
+	if req.RequestLine.Method != "GET" {
+		return "", handshakeError{response.StatusCodeMethodNotAllowed, "the method is not GET"}
+	}
+
+	if !IsUpgrade(req) {
+		return "", handshakeError{response.StatusCodeBadRequest, "the request does not ask to upgrade to the WebSocket protocol"}
+	}
+
+	if req.Headers.Get(headerSecWebSocketVersion) != protocolVersion {
+		return "", handshakeError{response.StatusCodeUpgradeRequired, "unsupported WebSocket version"}
+	}
+
+	key := req.Headers.Get(headerSecWebSocketKey)
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return "", handshakeError{response.StatusCodeBadRequest, "invalid Sec-WebSocket-Key header"}
+	}
+
+	checkOrigin := options.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+
+	if !checkOrigin(req) {
+		return "", handshakeError{response.StatusCodeForbidden, "the origin is not allowed"}
+	}
+
+	return key, nil
+}
+
+func writeHandshakeError(w *response.Writer, err error) {
+	var handshakeErr handshakeError
+	if !errors.As(err, &handshakeErr) {
+		return
+	}
+
+	body := []byte(response.StatusText(handshakeErr.statusCode) + "\n")
+	h := response.GetDefaultHeaders(len(body))
+
+	switch handshakeErr.statusCode {
+	case response.StatusCodeMethodNotAllowed:
+		h[response.HeaderAllow] = "GET"
+	case response.StatusCodeUpgradeRequired:
+		h[response.HeaderUpgrade] = "websocket"
+		h[headerSecWebSocketVersion] = protocolVersion
+	}
+
+	if w.WriteStatusLine(handshakeErr.statusCode) != nil || w.WriteHeaders(h) != nil {
+		return
+	}
+
+	_, _ = w.WriteBody(body)
+}
+
+// acceptKey returns the value of the Sec-WebSocket-Accept header for the key.
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// selectSubprotocol returns the first supported subprotocol that the client offers.
+func selectSubprotocol(offered string, supported []string) string {
+	for _, subprotocol := range supported {
+		for offer := range strings.SplitSeq(offered, ",") {
+			if strings.TrimSpace(offer) == subprotocol {
+				return subprotocol
+			}
+		}
+	}
+
+	return ""
+}
+
+// sameOrigin returns true if the request does not have the Origin header or if the
+// host and the port of the origin match the Host header.
+func sameOrigin(req *request.Request) bool {
+	origin := req.Headers.Get("origin")
+	if origin == "" {
+		return true
+	}
+
+	originURL, err := url.Parse(origin)
+	if err != nil || originURL.Host == "" {
+		return false
+	}
+
+	port := originURL.Port()
+
+	if port == "" {
+		switch originURL.Scheme {
+		case "http":
+			port = "80"
+		case "https":
+			port = "443"
+		default:
+			return false
+		}
+	}
+
+	return strings.EqualFold(strings.TrimSuffix(originURL.Hostname(), "."), req.Host) && port == strconv.Itoa(req.Port)
+}
@@ -0,0 +1,421 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"http-from-tcp/internal/headers"
+	"http-from-tcp/internal/request"
+	"http-from-tcp/internal/response"
+	"http-from-tcp/internal/server"
+)
+
+// testKey is the key from the example in RFC 6455, section 1.3.
+const testKey = "dGhlIHNhbXBsZSBub25jZQ=="
+
+// startEchoServer starts a server that echoes the messages back to the client and
+// returns the address of the server.
+func startEchoServer(t *testing.T, options Options) string {
+	t.Helper()
+
+	srv, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		conn, err := Upgrade(w, req, options)
+		if err != nil {
+			return
+		}
+
+		defer conn.Close()
+
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			if err := conn.WriteMessage(messageType, data); err != nil {
+				return
+			}
+		}
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = srv.Close() })
+
+	return srv.Addr().String()
+}
+
+func dial(t *testing.T, address string, options DialOptions) *Conn {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := Dial(ctx, "ws://"+address+"/echo", options)
+	require.NoError(t, err)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+	return conn
+}
+
+// rawHandshake performs the handshake over a plain TCP connection so that the test
+// can write the frames itself.
+func rawHandshake(t *testing.T, address string, early ...byte) (net.Conn, *bufio.Reader) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	_, err = conn.Write([]byte("GET /echo HTTP/1.1\r\nHost: " + address + "\r\n" +
+		"Upgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + testKey + "\r\nSec-WebSocket-Version: 13\r\n\r\n" + string(early)))
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+
+	resp, err := http.ReadResponse(reader, &http.Request{Method: "GET"})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Header.Get("Sec-WebSocket-Accept"))
+
+	return conn, reader
+}
+
+// writeRawFrame writes a masked frame.
+func writeRawFrame(t *testing.T, conn net.Conn, fin bool, op opcode, payload []byte) {
+	t.Helper()
+
+	header := frameHeader{fin: fin, opcode: op, masked: true, maskKey: [4]byte{1, 2, 3, 4}, length: int64(len(payload))}
+
+	frame := appendFrameHeader(nil, header)
+	start := len(frame)
+	frame = append(frame, payload...)
+	maskBytes(header.maskKey, 0, frame[start:])
+
+	_, err := conn.Write(frame)
+	require.NoError(t, err)
+}
+
+// readRawFrame reads an unmasked frame.
+func readRawFrame(t *testing.T, reader *bufio.Reader) (opcode, []byte) {
+	t.Helper()
+
+	header, err := readFrameHeader(reader)
+	require.NoError(t, err)
+	require.False(t, header.masked)
+
+	payload := make([]byte, header.length)
+	_, err = io.ReadFull(reader, payload)
+	require.NoError(t, err)
+
+	return header.opcode, payload
+}
+
+func TestHandshake(t *testing.T) {
+	address := startEchoServer(t, Options{Subprotocols: []string{"chat.v2", "chat.v1"}})
+
+	// Test: The preferred subprotocol of the server is selected
+	conn := dial(t, address, DialOptions{Subprotocols: []string{"chat.v1", "chat.v2"}})
+	assert.Equal(t, "chat.v2", conn.Subprotocol())
+	assert.False(t, conn.Compression())
+	require.NoError(t, conn.Close())
+
+	// Test: No subprotocol if the client does not support any of them
+	conn = dial(t, address, DialOptions{Subprotocols: []string{"other"}})
+	assert.Empty(t, conn.Subprotocol())
+	require.NoError(t, conn.Close())
+
+	// Test: Same origin
+	conn = dial(t, address, DialOptions{Headers: headers.Headers{"Origin": "http://" + address}})
+	require.NoError(t, conn.Close())
+
+	// Test: Invalid handshakes
+	testCases := []struct {
+		name       string
+		request    string
+		statusCode int
+		header     string
+		value      string
+	}{
+		{
+			name:       "Not GET",
+			request:    "POST /echo HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: " + testKey + "\r\nSec-WebSocket-Version: 13\r\n\r\n",
+			statusCode: http.StatusMethodNotAllowed,
+			header:     "Allow",
+			value:      "GET",
+		},
+		{
+			name:       "No Upgrade header",
+			request:    "GET /echo HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade\r\nSec-WebSocket-Key: " + testKey + "\r\nSec-WebSocket-Version: 13\r\n\r\n",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "Unsupported version",
+			request:    "GET /echo HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: " + testKey + "\r\nSec-WebSocket-Version: 8\r\n\r\n",
+			statusCode: http.StatusUpgradeRequired,
+			header:     "Sec-WebSocket-Version",
+			value:      "13",
+		},
+		{
+			name:       "Invalid key",
+			request:    "GET /echo HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: abc\r\nSec-WebSocket-Version: 13\r\n\r\n",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "Cross origin",
+			request:    "GET /echo HTTP/1.1\r\nHost: localhost\r\nOrigin: http://evil.example\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: " + testKey + "\r\nSec-WebSocket-Version: 13\r\n\r\n",
+			statusCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", address)
+			require.NoError(t, err)
+
+			defer conn.Close()
+
+			require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+			_, err = conn.Write([]byte(tc.request))
+			require.NoError(t, err)
+
+			resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "GET"})
+			require.NoError(t, err)
+			assert.Equal(t, tc.statusCode, resp.StatusCode)
+
+			if tc.header != "" {
+				assert.Equal(t, tc.value, resp.Header.Get(tc.header))
+			}
+		})
+	}
+}
+
+func TestMessages(t *testing.T) {
+	for _, compression := range []bool{false, true} {
+		address := startEchoServer(t, Options{EnableCompression: true, MaxMessageSize: 1 << 20})
+
+		conn := dial(t, address, DialOptions{EnableCompression: compression})
+		assert.Equal(t, compression, conn.Compression())
+
+		// Test: Text and binary messages
+		require.NoError(t, conn.WriteMessage(TextMessage, []byte("Hello, World!")))
+		require.NoError(t, conn.WriteMessage(BinaryMessage, []byte{0, 1, 2, 0xFF}))
+		require.NoError(t, conn.WriteMessage(TextMessage, nil))
+
+		messageType, data, err := conn.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, TextMessage, messageType)
+		assert.Equal(t, "Hello, World!", string(data))
+
+		messageType, data, err = conn.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, BinaryMessage, messageType)
+		assert.Equal(t, []byte{0, 1, 2, 0xFF}, data)
+
+		messageType, data, err = conn.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, TextMessage, messageType)
+		assert.Empty(t, data)
+
+		// Test: A fragmented message
+		large := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 5000)
+
+		writer, err := conn.NextWriter(TextMessage)
+		require.NoError(t, err)
+
+		for idx := 0; idx < len(large); idx += 1000 {
+			_, err := writer.Write([]byte(large[idx:min(idx+1000, len(large))]))
+			require.NoError(t, err)
+		}
+
+		require.NoError(t, writer.Close())
+
+		_, data, err = conn.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, large, string(data))
+
+		// Test: Ping and pong
+		pongs := make(chan string, 1)
+		conn.SetPongHandler(func(data []byte) { pongs <- string(data) })
+
+		require.NoError(t, conn.Ping([]byte("ping")))
+		require.NoError(t, conn.WriteMessage(TextMessage, []byte("after the ping")))
+
+		_, data, err = conn.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, "after the ping", string(data))
+		assert.Equal(t, "ping", <-pongs)
+
+		// Test: The closing handshake
+		require.NoError(t, conn.Close())
+
+		_, _, err = conn.ReadMessage()
+		require.Error(t, err)
+		require.Error(t, conn.WriteMessage(TextMessage, []byte("closed")))
+	}
+}
+
+func TestServerClose(t *testing.T) {
+	srv, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		conn, err := Upgrade(w, req, Options{})
+		if err != nil {
+			return
+		}
+
+		_ = conn.WriteMessage(TextMessage, []byte("bye"))
+		_ = conn.CloseWithReason(CloseGoingAway, "shutting down")
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = srv.Close() })
+
+	conn := dial(t, srv.Addr().String(), DialOptions{})
+
+	_, data, err := conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "bye", string(data))
+
+	_, _, err = conn.ReadMessage()
+
+	var closeErr *CloseError
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, CloseGoingAway, closeErr.Code)
+	assert.Equal(t, "shutting down", closeErr.Reason)
+}
+
+func TestFrames(t *testing.T) {
+	address := startEchoServer(t, Options{MaxMessageSize: 1024})
+
+	// Test: A frame that is sent with the handshake is not lost
+	early := []byte{0x81, 0x85, 0, 0, 0, 0, 'e', 'a', 'r', 'l', 'y'}
+	_, reader := rawHandshake(t, address, early...)
+
+	op, payload := readRawFrame(t, reader)
+	assert.Equal(t, opText, op)
+	assert.Equal(t, "early", string(payload))
+
+	// Test: A fragmented message with a ping between the fragments
+	conn, reader := rawHandshake(t, address)
+
+	writeRawFrame(t, conn, false, opText, []byte("Hel"))
+	writeRawFrame(t, conn, true, opPing, []byte("in between"))
+	writeRawFrame(t, conn, false, opContinuation, []byte("lo, "))
+	writeRawFrame(t, conn, true, opContinuation, []byte("World!"))
+
+	op, payload = readRawFrame(t, reader)
+	assert.Equal(t, opPong, op)
+	assert.Equal(t, "in between", string(payload))
+
+	op, payload = readRawFrame(t, reader)
+	assert.Equal(t, opText, op)
+	assert.Equal(t, "Hello, World!", string(payload))
+
+	// Test: The close frame is echoed
+	writeRawFrame(t, conn, true, opClose, binary.BigEndian.AppendUint16(nil, CloseNormalClosure))
+
+	op, payload = readRawFrame(t, reader)
+	assert.Equal(t, opClose, op)
+	assert.Equal(t, binary.BigEndian.AppendUint16(nil, CloseNormalClosure), payload)
+
+	// Test: Protocol violations fail the connection with a close frame
+	testCases := []struct {
+		name  string
+		write func(conn net.Conn)
+		code  uint16
+	}{
+		{
+			name: "Unmasked frame",
+			write: func(conn net.Conn) {
+				_, _ = conn.Write(appendFrameHeader(nil, frameHeader{fin: true, opcode: opText, length: 0}))
+			},
+			code: CloseProtocolError,
+		},
+		{
+			name:  "Unknown opcode",
+			write: func(conn net.Conn) { writeRawFrame(t, conn, true, opcode(0x3), nil) },
+			code:  CloseProtocolError,
+		},
+		{
+			name:  "Fragmented control frame",
+			write: func(conn net.Conn) { writeRawFrame(t, conn, false, opPing, nil) },
+			code:  CloseProtocolError,
+		},
+		{
+			name:  "Continuation without a message",
+			write: func(conn net.Conn) { writeRawFrame(t, conn, true, opContinuation, []byte("abc")) },
+			code:  CloseProtocolError,
+		},
+		{
+			name: "New message in a fragmented message",
+			write: func(conn net.Conn) {
+				writeRawFrame(t, conn, false, opText, []byte("abc"))
+				writeRawFrame(t, conn, true, opText, []byte("def"))
+			},
+			code: CloseProtocolError,
+		},
+		{
+			name:  "Compressed message without the extension",
+			write: func(conn net.Conn) { _, _ = conn.Write([]byte{0xC1, 0x80, 0, 0, 0, 0}) },
+			code:  CloseProtocolError,
+		},
+		{
+			name:  "Invalid UTF-8",
+			write: func(conn net.Conn) { writeRawFrame(t, conn, true, opText, []byte{0xFF, 0xFE}) },
+			code:  CloseInvalidPayloadData,
+		},
+		{
+			name:  "Message too big",
+			write: func(conn net.Conn) { writeRawFrame(t, conn, true, opBinary, make([]byte, 1025)) },
+			code:  CloseMessageTooBig,
+		},
+		{
+			name:  "Invalid close code",
+			write: func(conn net.Conn) { writeRawFrame(t, conn, true, opClose, []byte{0x03, 0xED}) },
+			code:  CloseProtocolError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, reader := rawHandshake(t, address)
+
+			tc.write(conn)
+
+			op, payload := readRawFrame(t, reader)
+			require.Equal(t, opClose, op)
+			require.GreaterOrEqual(t, len(payload), 2)
+			assert.Equal(t, tc.code, binary.BigEndian.Uint16(payload))
+
+			// The server closes the connection after the close frame.
+			_, err := reader.ReadByte()
+			assert.ErrorIs(t, err, io.EOF)
+		})
+	}
+}
+
+func TestAcceptDeflateOffer(t *testing.T) {
+	for offer, want := range map[string]bool{
+		"permessage-deflate":                                                         true,
+		"permessage-deflate; client_max_window_bits":                                 true,
+		"permessage-deflate; server_no_context_takeover":                             true,
+		"permessage-deflate; server_max_window_bits=15":                              true,
+		`permessage-deflate; server_max_window_bits="15"`:                            true,
+		"permessage-deflate; server_max_window_bits=10":                              false,
+		"permessage-deflate; unknown":                                                false,
+		"permessage-deflate; client_no_context_takeover; client_no_context_takeover": false,
+		"x-webkit-deflate-frame":                                                     false,
+	} {
+		assert.Equal(t, want, acceptDeflateOffer(offer), offer)
+	}
+}